@@ -0,0 +1,57 @@
+// Renders the thumbnail and medium renditions of every stored image and upload whose
+// renditions were made with other specs, or never made.
+//
+// Run it after changing RENDITION_THUMBNAIL_LONG_EDGE or RENDITION_MEDIUM_LONG_EDGE, with
+// the same settings as the API. Until then, existing images keep serving their previous
+// renditions. Renditions replaced by the run are deleted; running it again only picks up
+// images that failed.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ner-studio/api/internal/config"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/service"
+	"github.com/ner-studio/api/internal/storage"
+)
+
+func main() {
+	cfg := config.Load()
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	repo, err := repository.NewRepository(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("❌ Failed to connect: %v", err)
+	}
+	defer repo.Close()
+
+	if err := repo.Ping(ctx); err != nil {
+		log.Fatalf("❌ Failed to ping: %v", err)
+	}
+
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize storage: %v", err)
+	}
+
+	renditions := service.NewRenditionService(repo, store, storage.NewLinks(store, cfg.Storage.Private, cfg.Storage.URLTTL), cfg.Renditions, cfg.UploadImages.MaxPixels)
+	rendered, failed, err := renditions.RegenerateStale(ctx, 100)
+	if err != nil {
+		log.Fatalf("❌ Stopped after rendering %d images (%d failed): %v", rendered, failed, err)
+	}
+
+	fmt.Printf("✅ Rendered %d images", rendered)
+	if failed > 0 {
+		fmt.Printf(", %d failed (see the log above)", failed)
+	}
+	fmt.Println()
+}
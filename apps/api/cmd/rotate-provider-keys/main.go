@@ -0,0 +1,53 @@
+// Re-encrypts every stored provider API key with the current encryption secret.
+//
+// To rotate, set PROVIDER_KEY_ENCRYPTION_SECRET and PROVIDER_KEY_ENCRYPTION_KEY_ID to the new
+// secret, list the old one in PROVIDER_KEY_ENCRYPTION_PREVIOUS (e.g. "v1:old-secret"), run this
+// tool, then remove the previous secret once every instance uses the new one. Keys still stored
+// as plaintext are encrypted by the same run.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ner-studio/api/internal/config"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+func main() {
+	cfg := config.Load()
+	if cfg.DatabaseURL == "" {
+		log.Fatal("DATABASE_URL not set")
+	}
+
+	keyring, err := cfg.ProviderKeyring()
+	if err != nil {
+		log.Fatalf("❌ Invalid encryption config: %v", err)
+	}
+	if keyring == nil {
+		log.Fatal("❌ PROVIDER_KEY_ENCRYPTION_SECRET not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	repo, err := repository.NewRepository(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("❌ Failed to connect: %v", err)
+	}
+	defer repo.Close()
+	repo.SetKeyring(keyring)
+
+	if err := repo.Ping(ctx); err != nil {
+		log.Fatalf("❌ Failed to ping: %v", err)
+	}
+
+	rotated, err := repo.RotateProviderKeys(ctx)
+	if err != nil {
+		log.Fatalf("❌ Rotation failed, no keys were changed: %v", err)
+	}
+
+	fmt.Printf("✅ Re-encrypted %d provider keys with key %s\n", rotated, keyring.CurrentKeyID())
+}
@@ -3,21 +3,26 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
 	"github.com/ner-studio/api/internal/config"
-	"github.com/ner-studio/api/internal/external"
+	"github.com/ner-studio/api/internal/events"
 	"github.com/ner-studio/api/internal/handler"
+	"github.com/ner-studio/api/internal/mailer"
 	"github.com/ner-studio/api/internal/middleware"
-	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/oidc"
 	"github.com/ner-studio/api/internal/provider"
 	"github.com/ner-studio/api/internal/repository"
 	"github.com/ner-studio/api/internal/service"
+	"github.com/ner-studio/api/internal/storage"
+	"github.com/ner-studio/api/internal/worker"
 )
 
 func main() {
@@ -31,6 +36,19 @@ func main() {
 	}
 	defer repo.Close()
 
+	// Encrypt provider API keys at rest
+	keyring, err := cfg.ProviderKeyring()
+	if err != nil {
+		log.Fatalf("Invalid provider key encryption config: %v", err)
+	}
+	if keyring != nil {
+		repo.SetKeyring(keyring)
+	} else if cfg.IsDevelopment() {
+		log.Println("Warning: PROVIDER_KEY_ENCRYPTION_SECRET not set, provider API keys are stored unencrypted")
+	} else {
+		log.Fatal("PROVIDER_KEY_ENCRYPTION_SECRET is required outside development")
+	}
+
 	// Test database connection
 	if err := repo.Ping(context.Background()); err != nil {
 		log.Printf("Warning: Database ping failed: %v", err)
@@ -38,37 +56,132 @@ func main() {
 		log.Println("✅ Database connected successfully!")
 	}
 
-	// Initialize R2 client
-	r2Client, err := external.NewR2Client(external.R2Config{
-		AccountID:       cfg.R2AccountID,
-		AccessKeyID:     cfg.R2AccessKeyID,
-		SecretAccessKey: cfg.R2SecretAccessKey,
-		BucketName:      cfg.R2BucketName,
-		PublicURL:       cfg.R2PublicURL,
-	})
+	// Initialize object storage
+	store, err := storage.New(cfg.Storage)
 	if err != nil {
-		log.Fatalf("Failed to initialize R2 client: %v", err)
+		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	links := storage.NewLinks(store, cfg.Storage.Private, cfg.Storage.URLTTL)
 
-	// Initialize provider factory and load providers
+	// Initialize provider factory and load providers from the database
 	factory := provider.NewFactory()
-	loadProviders(factory, cfg)
+	providerService := service.NewProviderService(repo, factory, map[string]string{
+		"kieai":  cfg.KieAIAPIKey,
+		"openai": cfg.OpenAIAPIKey,
+		"gemini": cfg.GeminiAPIKey,
+	})
+	if err := providerService.Reload(context.Background()); err != nil {
+		log.Printf("Warning: Failed to load providers: %v", err)
+	}
+
+	// Pick up provider changes made by other instances
+	providerReloader := worker.NewReconciler("Provider reload", func(ctx context.Context) (int, error) {
+		return 0, providerService.Reload(ctx)
+	}, cfg.ProviderReloadInterval)
+	providerReloader.Start(context.Background())
 
 	// Initialize services
-	authService := service.NewAuthService(repo)
-	generationService := service.NewGenerationService(repo, factory, cfg.CallbackBaseURL)
-	uploadService := service.NewUploadService(r2Client)
+	authService := service.NewAuthService(repo, cfg.RefreshTokenTTL)
+	var oidcProviders []*oidc.Provider
+	oidcClient := &http.Client{Timeout: 10 * time.Second}
+	for _, p := range cfg.OIDCProviders {
+		oidcProviders = append(oidcProviders, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       p.Scopes,
+		}, oidcClient))
+	}
+	ssoService := service.NewSSOService(repo, authService, oidcProviders)
+	renditionService := service.NewRenditionService(repo, store, links, cfg.Renditions, cfg.UploadImages.MaxPixels)
+	generationService := service.NewGenerationService(repo, factory, store, links, cfg.CallbackBaseURL, cfg.CreditHoldTTL, cfg.Limits, renditionService)
+	if err := cfg.UploadImages.Validate(); err != nil {
+		log.Fatalf("Invalid upload image settings: %v", err)
+	}
+	uploadService := service.NewUploadService(repo, store, cfg.UploadMaxBytes, cfg.UploadURLTTL, cfg.UploadImages, renditionService)
+
+	m, err := mailer.New(cfg.MailerDriver, cfg.MailerDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	memberService := service.NewMemberService(repo, m, cfg.AppBaseURL, cfg.InvitationTTL)
+	creditService := service.NewCreditService(repo)
+	galleryService := service.NewGalleryService(repo, store, renditionService)
+
+	// Start generation workers (set WORKER_CONCURRENCY=0 to run an API-only instance)
+	var workerPool *worker.Pool
+	if cfg.WorkerConcurrency > 0 {
+		workerPool = worker.NewPool(repo, generationService, worker.Config{
+			WorkerID:     cfg.WorkerID,
+			Concurrency:  cfg.WorkerConcurrency,
+			PollInterval: cfg.WorkerPollInterval,
+			LeaseTimeout: cfg.WorkerLeaseTimeout,
+		})
+		workerPool.Start(context.Background())
+	}
+
+	// Poll providers for images whose callback never arrived
+	reconciler := worker.NewReconciler("Callback reconciler", func(ctx context.Context) (int, error) {
+		return generationService.ReconcileImages(ctx, service.ReconcileConfig{
+			CallbackDeadline: cfg.CallbackDeadline,
+			PollInterval:     cfg.ReconcileInterval,
+			TaskTimeout:      cfg.ImageTaskTimeout,
+			BatchSize:        50,
+		})
+	}, cfg.ReconcileInterval)
+	reconciler.Start(context.Background())
+
+	// Release credit holds that were never settled
+	holdSweeper := worker.NewReconciler("Credit hold sweep", func(ctx context.Context) (int, error) {
+		return repo.ExpireCreditHolds(ctx, 100)
+	}, cfg.CreditHoldSweepInterval)
+	holdSweeper.Start(context.Background())
+
+	// Remove sessions that expired or were revoked a week ago, abandoned SSO logins, idle
+	// rate limit buckets and expired idempotency keys
+	sessionSweeper := worker.NewReconciler("Session cleanup", func(ctx context.Context) (int, error) {
+		n, err := repo.DeleteStaleSessions(ctx, time.Now().Add(-7*24*time.Hour))
+		if err != nil {
+			return 0, err
+		}
+		states, err := repo.DeleteExpiredOIDCLoginStates(ctx)
+		if err != nil {
+			return int(n), err
+		}
+		buckets, err := repo.DeleteIdleRateLimitBuckets(ctx, time.Now().Add(-time.Hour))
+		if err != nil {
+			return int(n + states), err
+		}
+		keys, err := repo.DeleteExpiredIdempotencyKeys(ctx)
+		return int(n + states + buckets + keys), err
+	}, time.Hour)
+	sessionSweeper.Start(context.Background())
+
+	// Delete uploads that were presigned but never completed
+	uploadSweeper := worker.NewReconciler("Upload cleanup", uploadService.CleanupAbandonedUploads, time.Hour)
+	uploadSweeper.Start(context.Background())
+
+	// Fan out generation progress to SSE streams on this instance
+	eventBroker := events.NewBroker(repo.ListenGenerationEvents)
+	eventBroker.Start(context.Background())
 
 	// Initialize handlers
-	authHandler := handler.NewAuthHandler(authService, cfg.JWTSecret)
-	generationHandler := handler.NewGenerationHandler(generationService)
+	authHandler := handler.NewAuthHandler(authService, ssoService, cfg.JWTSecret, cfg.AccessTokenTTL)
+	generationHandler := handler.NewGenerationHandler(generationService, eventBroker)
 	uploadHandler := handler.NewUploadHandler(uploadService)
+	providerHandler := handler.NewProviderHandler(providerService)
+	memberHandler := handler.NewMemberHandler(memberService)
+	creditHandler := handler.NewCreditHandler(creditService)
+	galleryHandler := handler.NewGalleryHandler(galleryService)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:               "NER Studio API",
 		ErrorHandler:          errorHandler,
 		ReadBufferSize:        8192,  // Increase header size limit
+		BodyLimit:             int(cfg.UploadMaxBytes) + 1024*1024, // Uploads plus multipart overhead
 		WriteBufferSize:       8192,
 		DisableStartupMessage: false,
 	})
@@ -90,6 +203,13 @@ func main() {
 		})
 	})
 
+	// Objects of the local store, behind the signed URLs it hands out
+	if local, ok := store.(*storage.LocalStore); ok {
+		storageHandler := handler.NewStorageHandler(local)
+		app.Get(storage.LocalRoute+"*", storageHandler.Get)
+		app.Put(storage.LocalRoute+"*", storageHandler.Put)
+	}
+
 	// API routes
 	api := app.Group("/api/v1")
 
@@ -97,6 +217,10 @@ func main() {
 	api.Post("/auth/login", authHandler.Login)
 	api.Post("/auth/register", authHandler.Register)
 	api.Post("/auth/refresh", authHandler.RefreshToken)
+	api.Post("/auth/logout", authHandler.Logout)
+	api.Get("/auth/oidc/providers", authHandler.ListSSOProviders)
+	api.Post("/auth/oidc/:provider", authHandler.StartSSO)
+	api.Post("/auth/callback", authHandler.AuthCallback)
 
 	// Protected routes
 	protected := api.Group("")
@@ -104,19 +228,40 @@ func main() {
 		JWTSecret: cfg.JWTSecret,
 	}))
 	protected.Use(middleware.ProfileMiddleware(repo))
+	protected.Use(middleware.RateLimitMiddleware(repo, cfg.Limits))
+
+	// Retries of requests carrying an Idempotency-Key get the first response back
+	idempotent := middleware.IdempotencyMiddleware(repo, cfg.IdempotencyKeyTTL)
+
+	// Sessions of the signed-in user
+	protected.Get("/sessions", authHandler.ListSessions)
+	protected.Delete("/sessions", authHandler.RevokeAllSessions)
+	protected.Delete("/sessions/:id", authHandler.RevokeSession)
+
+	// Invitations for users who already have an account
+	protected.Post("/invitations/accept", authHandler.AcceptInvitation)
 
 	// Generation routes
-	protected.Post("/generations", generationHandler.CreateGeneration)
+	protected.Post("/generations", idempotent, generationHandler.CreateGeneration)
 	protected.Get("/generations", generationHandler.ListGenerations)
 	protected.Get("/generations/:id", generationHandler.GetGeneration)
+	protected.Get("/generations/:id/events", generationHandler.StreamEvents)
 
 	// Gallery routes
-	protected.Get("/gallery", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"images": []interface{}{}})
-	})
+	protected.Get("/gallery", galleryHandler.ListImages)
+	protected.Put("/gallery/:id/favorite", galleryHandler.AddFavorite)
+	protected.Delete("/gallery/:id/favorite", galleryHandler.RemoveFavorite)
+	protected.Put("/gallery/:id/tags", galleryHandler.SetTags)
+	protected.Post("/gallery/delete", galleryHandler.DeleteImages)
+	protected.Post("/gallery/download", galleryHandler.DownloadZip)
+
+	// Credit balance, including credits held for generations in progress
+	protected.Get("/credits", creditHandler.GetBalance)
 
 	// Upload routes
 	protected.Post("/uploads", uploadHandler.UploadImage)
+	protected.Post("/uploads/presigned", uploadHandler.GetUploadURL)
+	protected.Post("/uploads/:id/complete", uploadHandler.CompleteUpload)
 
 	// Callback routes (public - no auth)
 	api.Post("/callbacks/:provider", generationHandler.HandleCallback)
@@ -126,35 +271,23 @@ func main() {
 	admin.Get("/organization", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"organization": nil})
 	})
-	admin.Get("/members", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"members": []interface{}{}})
-	})
-	admin.Post("/members/invite", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"invitation": nil})
-	})
-	admin.Get("/credits/history", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"transactions": []interface{}{}})
-	})
-	admin.Post("/credits", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"success": true})
-	})
+	admin.Get("/members", memberHandler.ListMembers)
+	admin.Patch("/members/:id", memberHandler.UpdateMember)
+	admin.Delete("/members/:id", memberHandler.RemoveMember)
+	admin.Post("/members/invite", memberHandler.InviteMember)
+	admin.Get("/invitations", memberHandler.ListInvitations)
+	admin.Delete("/invitations/:id", memberHandler.RevokeInvitation)
+	admin.Get("/credits", creditHandler.GetBalance)
+	admin.Post("/credits", idempotent, creditHandler.ChangeCredits)
+	admin.Post("/credits/refunds", creditHandler.RefundGeneration)
+	admin.Get("/credits/history", creditHandler.ListHistory)
 
 	// Provider admin routes
-	admin.Get("/providers", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"providers": []interface{}{}})
-	})
-	admin.Post("/providers", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"provider": nil})
-	})
-	admin.Patch("/providers/:id", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"provider": nil})
-	})
-	admin.Delete("/providers/:id", func(c *fiber.Ctx) error {
-		return c.SendStatus(fiber.StatusNoContent)
-	})
-	admin.Post("/providers/:slug/test", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"success": true})
-	})
+	admin.Get("/providers", providerHandler.ListProviders)
+	admin.Post("/providers", providerHandler.CreateProvider)
+	admin.Patch("/providers/:slug", providerHandler.UpdateProvider)
+	admin.Delete("/providers/:slug", providerHandler.DeleteProvider)
+	admin.Post("/providers/:slug/test", providerHandler.TestProvider)
 
 	// Public provider list (for users)
 	protected.Get("/providers", func(c *fiber.Ctx) error {
@@ -181,66 +314,20 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	// End open event streams first so the server can drain
+	eventBroker.Stop()
 	if err := app.Shutdown(); err != nil {
 		log.Printf("Error during shutdown: %v", err)
 	}
-	log.Println("Server stopped")
-}
-
-// loadProviders initializes providers from environment or DB
-func loadProviders(factory *provider.Factory, cfg *config.Config) {
-	// Register OpenAI vision provider
-	if cfg.OpenAIAPIKey != "" && cfg.OpenAIAPIKey != "..." {
-		factory.RegisterVisionProvider(provider.NewOpenAIVisionProvider(cfg.OpenAIAPIKey, ""))
-		log.Println("Registered OpenAI vision provider")
-	}
-
-	// Register KieAI providers
-	if cfg.KieAIAPIKey != "" {
-		// LLM providers via KieAI
-		kieGemini3 := provider.NewKieAIProvider("kieai-gemini3", cfg.KieAIAPIKey, "", "gemini-3.0", model.ProviderConfig{
-			TimeoutMs:  60000,
-			MaxRetries: 3,
-		})
-		factory.RegisterLLMProvider(model.Provider{
-			Slug:     "kieai-gemini3",
-			Name:     "Kie.ai Gemini 3.0",
-			Priority: 0,
-		}, kieGemini3)
-
-		kieGemini25 := provider.NewKieAIProvider("kieai-gemini25", cfg.KieAIAPIKey, "", "gemini-2.5", model.ProviderConfig{
-			TimeoutMs:  60000,
-			MaxRetries: 3,
-		})
-		factory.RegisterLLMProvider(model.Provider{
-			Slug:     "kieai-gemini25",
-			Name:     "Kie.ai Gemini 2.5",
-			Priority: 1,
-		}, kieGemini25)
-
-		// Image generation providers
-		factory.RegisterImageProvider("kieai-seedream", provider.NewKieAIProvider("kieai-seedream", cfg.KieAIAPIKey, "", "seedream-v1", model.ProviderConfig{
-			TimeoutMs: 120000,
-		}))
-		factory.RegisterImageProvider("kieai-nano", provider.NewKieAIProvider("kieai-nano", cfg.KieAIAPIKey, "", "nano-banana-pro", model.ProviderConfig{
-			TimeoutMs: 120000,
-		}))
-
-		log.Println("Registered KieAI providers")
-	}
-
-	// Register Google Gemini (direct) as fallback
-	if cfg.GeminiAPIKey != "" && cfg.GeminiAPIKey != "..." {
-		gemini := provider.NewGeminiProvider("google-gemini", cfg.GeminiAPIKey, "", "gemini-2.0-flash", model.ProviderConfig{
-			TimeoutMs: 60000,
-		})
-		factory.RegisterLLMProvider(model.Provider{
-			Slug:     "google-gemini",
-			Name:     "Google Gemini (Direct)",
-			Priority: 2,
-		}, gemini)
-		log.Println("Registered Google Gemini provider")
+	reconciler.Stop()
+	providerReloader.Stop()
+	holdSweeper.Stop()
+	sessionSweeper.Stop()
+	uploadSweeper.Stop()
+	if workerPool != nil {
+		workerPool.Stop()
 	}
+	log.Println("Server stopped")
 }
 
 func errorHandler(c *fiber.Ctx, err error) error {
@@ -1,10 +1,19 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/ner-studio/api/internal/imaging"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/secret"
+	"github.com/ner-studio/api/internal/storage"
 )
 
 // Config holds all application configuration
@@ -19,21 +28,77 @@ type Config struct {
 	// JWT
 	JWTSecret string
 
+	// Sessions: access tokens are short-lived; refresh tokens rotate on every use
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
 	// Provider API Keys
 	KieAIAPIKey  string
 	OpenAIAPIKey string
 	GeminiAPIKey string
 
-	// R2 Storage
-	R2AccountID       string
-	R2AccessKeyID     string
-	R2SecretAccessKey string
-	R2BucketName      string
-	R2PublicURL       string
+	// Object storage for generated images and uploads: r2, s3 or local
+	Storage storage.Config
 
 	// App
 	CallbackBaseURL             string
 	ProviderKeyEncryptionSecret string
+	ProviderKeyEncryptionKeyID  string
+	// Previous secrets still needed to decrypt during rotation, as "id:secret,id:secret"
+	ProviderKeyEncryptionPrevious string
+
+	// Generation workers
+	WorkerID           string
+	WorkerConcurrency  int
+	WorkerPollInterval time.Duration
+	WorkerLeaseTimeout time.Duration
+
+	// Callback reconciliation
+	ReconcileInterval time.Duration
+	CallbackDeadline  time.Duration
+	ImageTaskTimeout  time.Duration
+
+	// Providers
+	ProviderReloadInterval time.Duration
+
+	// Credit holds
+	CreditHoldTTL           time.Duration
+	CreditHoldSweepInterval time.Duration
+
+	// Usage limits for organizations without their own; 0 means unlimited
+	Limits model.OrganizationLimits
+
+	// Uploads: the largest image accepted, and how long presigned upload URLs stay valid
+	UploadMaxBytes int64
+	UploadURLTTL   time.Duration
+	// How uploaded images are validated and re-encoded
+	UploadImages imaging.Options
+
+	// Smaller copies stored next to each generated and uploaded image
+	Renditions []imaging.RenditionSpec
+
+	// How long responses to requests with an Idempotency-Key are replayed
+	IdempotencyKeyTTL time.Duration
+
+	// Email and invitations
+	AppBaseURL    string
+	MailerDriver  string
+	MailerDir     string
+	InvitationTTL time.Duration
+
+	// OpenID Connect sign-in; the redirect URL is a page of the web app that posts the
+	// code and state to /auth/callback
+	OIDCProviders   []OIDCProviderConfig
+	OIDCRedirectURL string
+}
+
+// OIDCProviderConfig is an OpenID Connect issuer users can sign in with
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
 }
 
 // Load loads configuration from environment variables
@@ -48,20 +113,64 @@ func Load() *Config {
 		DatabaseURL: getEnv("DATABASE_URL", ""),
 		JWTSecret:   getEnv("JWT_SECRET", "default-secret-change-in-production"),
 
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
 		KieAIAPIKey:  getEnv("KIE_AI_API_KEY", ""),
 		OpenAIAPIKey: getEnv("OPENAI_API_KEY", ""),
 		GeminiAPIKey: getEnv("GEMINI_API_KEY", ""),
 
-		R2AccountID:       getEnv("R2_ACCOUNT_ID", ""),
-		R2AccessKeyID:     getEnv("R2_ACCESS_KEY_ID", ""),
-		R2SecretAccessKey: getEnv("R2_SECRET_ACCESS_KEY", ""),
-		R2BucketName:      getEnv("R2_BUCKET_NAME", "ner-storage"),
-		R2PublicURL:       getEnv("R2_PUBLIC_URL", ""),
-
 		CallbackBaseURL:             getEnv("CALLBACK_BASE_URL", "http://localhost:8080"),
 		ProviderKeyEncryptionSecret: getEnv("PROVIDER_KEY_ENCRYPTION_SECRET", ""),
+		ProviderKeyEncryptionKeyID:  getEnv("PROVIDER_KEY_ENCRYPTION_KEY_ID", "v1"),
+
+		ProviderKeyEncryptionPrevious: getEnv("PROVIDER_KEY_ENCRYPTION_PREVIOUS", ""),
+
+		WorkerID:           getEnv("WORKER_ID", defaultWorkerID()),
+		WorkerConcurrency:  getEnvInt("WORKER_CONCURRENCY", 4),
+		WorkerPollInterval: getEnvDuration("WORKER_POLL_INTERVAL", time.Second),
+		WorkerLeaseTimeout: getEnvDuration("WORKER_LEASE_TIMEOUT", 10*time.Minute),
+
+		ReconcileInterval: getEnvDuration("RECONCILE_INTERVAL", time.Minute),
+		CallbackDeadline:  getEnvDuration("CALLBACK_DEADLINE", 10*time.Minute),
+		ImageTaskTimeout:  getEnvDuration("IMAGE_TASK_TIMEOUT", 2*time.Hour),
+
+		ProviderReloadInterval: getEnvDuration("PROVIDER_RELOAD_INTERVAL", time.Minute),
+
+		CreditHoldTTL:           getEnvDuration("CREDIT_HOLD_TTL", 24*time.Hour),
+		CreditHoldSweepInterval: getEnvDuration("CREDIT_HOLD_SWEEP_INTERVAL", 5*time.Minute),
+
+		AppBaseURL:    getEnv("APP_BASE_URL", "http://localhost:3000"),
+		MailerDriver:  getEnv("MAILER_DRIVER", "log"),
+		MailerDir:     getEnv("MAILER_DIR", "tmp/mail"),
+		InvitationTTL: getEnvDuration("INVITATION_TTL", 7*24*time.Hour),
+
+		UploadMaxBytes: int64(getEnvInt("UPLOAD_MAX_BYTES", 20*1024*1024)),
+		UploadURLTTL:   getEnvDuration("UPLOAD_URL_TTL", 15*time.Minute),
+		UploadImages: imaging.Options{
+			MaxPixels:   getEnvInt("UPLOAD_MAX_PIXELS", 40_000_000),
+			MaxLongEdge: getEnvInt("UPLOAD_MAX_LONG_EDGE", 4096),
+			Format:      getEnv("UPLOAD_IMAGE_FORMAT", ""),
+		},
+		Renditions: imaging.DefaultRenditions(
+			getEnvInt("RENDITION_THUMBNAIL_LONG_EDGE", 320),
+			getEnvInt("RENDITION_MEDIUM_LONG_EDGE", 1280),
+		),
+
+		IdempotencyKeyTTL: getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+
+		Limits: model.OrganizationLimits{
+			RequestsPerMinute:        getEnvInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 600),
+			UserRequestsPerMinute:    getEnvInt("RATE_LIMIT_USER_REQUESTS_PER_MINUTE", 120),
+			MaxConcurrentGenerations: getEnvInt("RATE_LIMIT_MAX_CONCURRENT_GENERATIONS", 5),
+			MaxImagesPerDay:          getEnvInt("RATE_LIMIT_MAX_IMAGES_PER_DAY", 500),
+		},
 	}
 
+	cfg.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", strings.TrimSuffix(cfg.AppBaseURL, "/")+"/auth/callback")
+	cfg.OIDCProviders = loadOIDCProviders()
+	cfg.Storage = loadStorage(cfg.CallbackBaseURL)
+
 	// Validate required config
 	if cfg.DatabaseURL == "" {
 		log.Println("Warning: DATABASE_URL not set")
@@ -80,6 +189,127 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+		log.Printf("Warning: invalid integer for %s, using default %d", key, defaultValue)
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Warning: invalid duration for %s, using default %s", key, defaultValue)
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+		log.Printf("Warning: invalid boolean for %s, using default %t", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// loadStorage reads the settings of the driver chosen by STORAGE_DRIVER: R2_* for r2,
+// S3_* for s3 and STORAGE_LOCAL_* for local. Local objects are served by the API, at
+// apiBaseURL unless STORAGE_LOCAL_BASE_URL says otherwise. With STORAGE_PRIVATE, clients
+// get URLs that expire after STORAGE_URL_TTL.
+func loadStorage(apiBaseURL string) storage.Config {
+	cfg := storage.Config{
+		Driver:  strings.ToLower(getEnv("STORAGE_DRIVER", storage.DriverR2)),
+		Private: getEnvBool("STORAGE_PRIVATE", false),
+		URLTTL:  getEnvDuration("STORAGE_URL_TTL", time.Hour),
+	}
+	switch cfg.Driver {
+	case storage.DriverR2:
+		cfg.R2AccountID = getEnv("R2_ACCOUNT_ID", "")
+		cfg.AccessKeyID = getEnv("R2_ACCESS_KEY_ID", "")
+		cfg.SecretAccessKey = getEnv("R2_SECRET_ACCESS_KEY", "")
+		cfg.Bucket = getEnv("R2_BUCKET_NAME", "ner-storage")
+		cfg.PublicURL = getEnv("R2_PUBLIC_URL", "")
+	case storage.DriverS3:
+		cfg.Endpoint = getEnv("S3_ENDPOINT", "")
+		cfg.Region = getEnv("S3_REGION", "us-east-1")
+		cfg.AccessKeyID = getEnv("S3_ACCESS_KEY_ID", "")
+		cfg.SecretAccessKey = getEnv("S3_SECRET_ACCESS_KEY", "")
+		cfg.Bucket = getEnv("S3_BUCKET", "ner-storage")
+		cfg.PublicURL = getEnv("S3_PUBLIC_URL", "")
+		cfg.PathStyle = getEnvBool("S3_FORCE_PATH_STYLE", false)
+	case storage.DriverLocal:
+		cfg.LocalDir = getEnv("STORAGE_LOCAL_DIR", "tmp/storage")
+		cfg.LocalBaseURL = getEnv("STORAGE_LOCAL_BASE_URL", apiBaseURL)
+		cfg.LocalSecret = getEnv("STORAGE_LOCAL_SECRET", "")
+	}
+	return cfg
+}
+
+// defaultOIDCIssuers are used when OIDC_<NAME>_ISSUER is not set
+var defaultOIDCIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS, e.g. "google,okta", each
+// configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and optional _SCOPES
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", defaultOIDCIssuers[name]),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(strings.ReplaceAll(getEnv(prefix+"SCOPES", ""), ",", " ")),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Printf("Warning: OIDC provider %s needs %sISSUER and %sCLIENT_ID; skipping it", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// defaultWorkerID names this process when WORKER_ID is unset. The random suffix keeps
+// lock IDs of several instances on one host apart.
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "worker"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Fatalf("Failed to generate worker ID: %v", err)
+	}
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// ProviderKeyring builds the keyring used to encrypt provider API keys at rest.
+// It returns nil when no encryption secret is configured.
+func (c *Config) ProviderKeyring() (*secret.Keyring, error) {
+	if c.ProviderKeyEncryptionSecret == "" {
+		return nil, nil
+	}
+	previous, err := secret.ParsePrevious(c.ProviderKeyEncryptionPrevious)
+	if err != nil {
+		return nil, err
+	}
+	return secret.NewKeyring(c.ProviderKeyEncryptionKeyID, c.ProviderKeyEncryptionSecret, previous)
+}
+
 // IsProduction returns true if running in production
 func (c *Config) IsProduction() bool {
 	return c.Env == "production"
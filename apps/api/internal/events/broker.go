@@ -0,0 +1,154 @@
+// Package events fans out generation progress notifications to the SSE streams open on this
+// instance. Notifications come from Postgres LISTEN/NOTIFY, so events written by any instance
+// reach every stream.
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// ListenFunc blocks delivering notifications to fn until ctx is done or the connection fails
+type ListenFunc func(ctx context.Context, fn func(generationID uuid.UUID, eventID int64)) error
+
+// Subscription signals on C whenever new events may be available for one generation.
+// Signals are coalesced, so a receiver must read every event after the last one it saw.
+type Subscription struct {
+	C <-chan struct{}
+
+	c            chan struct{}
+	generationID uuid.UUID
+	broker       *Broker
+}
+
+// Done is closed when the broker stops, telling streams to end
+func (s *Subscription) Done() <-chan struct{} {
+	return s.broker.done
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker keeps one listening connection and wakes the subscribers of each generation
+type Broker struct {
+	listen ListenFunc
+
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[*Subscription]struct{}
+
+	done     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewBroker creates a broker that receives notifications through listen
+func NewBroker(listen ListenFunc) *Broker {
+	return &Broker{
+		listen: listen,
+		subs:   make(map[uuid.UUID]map[*Subscription]struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start launches the listen loop, reconnecting with backoff when the connection drops
+func (b *Broker) Start(ctx context.Context) {
+	ctx, b.cancel = context.WithCancel(ctx)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		delay := minRetryDelay
+		for {
+			started := time.Now()
+			err := b.listen(ctx, b.notify)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Generation event listener stopped: %v", err)
+
+			// Notifications sent while disconnected are lost; let every stream re-read
+			b.wakeAll()
+
+			if time.Since(started) > maxRetryDelay {
+				delay = minRetryDelay
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, maxRetryDelay)
+		}
+	}()
+}
+
+// Stop ends the listen loop and signals open streams to finish
+func (b *Broker) Stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// Subscribe starts receiving wake-ups for a generation
+func (b *Broker) Subscribe(generationID uuid.UUID) *Subscription {
+	c := make(chan struct{}, 1)
+	sub := &Subscription{C: c, c: c, generationID: generationID, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[generationID] == nil {
+		b.subs[generationID] = make(map[*Subscription]struct{})
+	}
+	b.subs[generationID][sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs[sub.generationID], sub)
+	if len(b.subs[sub.generationID]) == 0 {
+		delete(b.subs, sub.generationID)
+	}
+}
+
+func (b *Broker) notify(generationID uuid.UUID, _ int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[generationID] {
+		wake(sub)
+	}
+}
+
+func (b *Broker) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subs := range b.subs {
+		for sub := range subs {
+			wake(sub)
+		}
+	}
+}
+
+// wake signals a subscriber without blocking; a pending signal already covers this one
+func wake(sub *Subscription) {
+	select {
+	case sub.c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerWakesSubscribersOfTheGeneration(t *testing.T) {
+	notifications := make(chan uuid.UUID)
+	broker := NewBroker(func(ctx context.Context, fn func(uuid.UUID, int64)) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case id := <-notifications:
+				fn(id, 1)
+			}
+		}
+	})
+	broker.Start(context.Background())
+	defer broker.Stop()
+
+	watched, other := uuid.New(), uuid.New()
+	sub := broker.Subscribe(watched)
+	defer sub.Close()
+
+	notifications <- other
+	notifications <- watched
+	// Coalesced: a second notification before the first is read does not block
+	notifications <- watched
+
+	select {
+	case <-sub.C:
+	case <-time.After(time.Second):
+		t.Fatal("subscriber was not woken")
+	}
+}
+
+func TestBrokerWakesEveryoneAfterReconnect(t *testing.T) {
+	calls := 0
+	broker := NewBroker(func(ctx context.Context, fn func(uuid.UUID, int64)) error {
+		calls++
+		if calls == 1 {
+			return errors.New("connection lost")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	sub := broker.Subscribe(uuid.New())
+	broker.Start(context.Background())
+	defer broker.Stop()
+
+	select {
+	case <-sub.C:
+	case <-time.After(time.Second):
+		t.Fatal("subscriber was not woken after the listener failed")
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	broker := NewBroker(nil)
+	id := uuid.New()
+	sub := broker.Subscribe(id)
+	sub.Close()
+
+	broker.notify(id, 1)
+	assert.Empty(t, broker.subs)
+	assert.Len(t, sub.C, 0)
+}
@@ -0,0 +1,111 @@
+package external
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// imageExtensions maps sniffed image content types to file extensions
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// DownloadedFile is a remote image spooled to a local temporary file
+type DownloadedFile struct {
+	File        *os.File
+	ContentType string
+	Extension   string
+	Size        int64
+	SHA256      string
+}
+
+// Close closes and removes the temporary file
+func (d *DownloadedFile) Close() error {
+	name := d.File.Name()
+	err := d.File.Close()
+	os.Remove(name)
+	return err
+}
+
+// Errors returned by DownloadImage for content that can never be stored
+var (
+	ErrNotImage = errors.New("downloaded file is not a supported image")
+	ErrTooLarge = errors.New("downloaded file is too large")
+)
+
+// DownloadStatusError is returned when the remote server answers with a non-200 status
+type DownloadStatusError struct {
+	StatusCode int
+}
+
+func (e *DownloadStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d", e.StatusCode)
+}
+
+// DownloadImage streams an image from url into a temporary file without holding it in memory.
+// The content type is sniffed from the data itself rather than trusted from the server, and
+// the size and SHA-256 checksum are computed on the way through. The caller must Close the result.
+func DownloadImage(ctx context.Context, client *http.Client, url string, maxBytes int64) (*DownloadedFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download URL: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &DownloadStatusError{StatusCode: resp.StatusCode}
+	}
+
+	body := bufio.NewReaderSize(io.LimitReader(resp.Body, maxBytes+1), 512)
+	head, err := body.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read download: %w", err)
+	}
+
+	contentType := http.DetectContentType(head)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w (detected %s)", ErrNotImage, contentType)
+	}
+
+	tmp, err := os.CreateTemp("", "ner-download-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	file := &DownloadedFile{File: tmp, ContentType: contentType, Extension: ext}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), body)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	if size > maxBytes {
+		file.Close()
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, maxBytes)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to rewind temp file: %w", err)
+	}
+
+	file.Size = size
+	file.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return file, nil
+}
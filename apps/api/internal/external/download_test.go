@@ -0,0 +1,74 @@
+package external
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Minimal PNG signature followed by padding; enough for content sniffing
+var pngBytes = append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 600)...)
+
+func TestDownloadImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			// Deliberately wrong header: the type must be sniffed from the bytes
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(pngBytes)
+		case "/pdf":
+			w.Write([]byte("%PDF-1.4 not an image"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	t.Run("Streams image to temp file", func(t *testing.T) {
+		file, err := DownloadImage(ctx, server.Client(), server.URL+"/image", 1<<20)
+		require.NoError(t, err)
+		name := file.File.Name()
+
+		sum := sha256.Sum256(pngBytes)
+		assert.Equal(t, "image/png", file.ContentType)
+		assert.Equal(t, ".png", file.Extension)
+		assert.Equal(t, int64(len(pngBytes)), file.Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), file.SHA256)
+
+		data, err := io.ReadAll(file.File)
+		require.NoError(t, err)
+		assert.Equal(t, pngBytes, data)
+
+		require.NoError(t, file.Close())
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err), "temp file should be removed on Close")
+	})
+
+	t.Run("Rejects non-image content", func(t *testing.T) {
+		_, err := DownloadImage(ctx, server.Client(), server.URL+"/pdf", 1<<20)
+		assert.ErrorIs(t, err, ErrNotImage)
+	})
+
+	t.Run("Rejects oversized files", func(t *testing.T) {
+		_, err := DownloadImage(ctx, server.Client(), server.URL+"/image", 100)
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("Reports HTTP status", func(t *testing.T) {
+		_, err := DownloadImage(ctx, server.Client(), server.URL+"/expired", 1<<20)
+		var statusErr *DownloadStatusError
+		require.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	})
+}
@@ -1,23 +1,66 @@
 package handler
 
 import (
+	"errors"
+	"log"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/model"
 	"github.com/ner-studio/api/internal/service"
 )
 
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
-	authService *service.AuthService
-	jwtSecret   string
+	authService    *service.AuthService
+	ssoService     *service.SSOService
+	jwtSecret      string
+	accessTokenTTL time.Duration
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(authService *service.AuthService, jwtSecret string) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, ssoService *service.SSOService, jwtSecret string, accessTokenTTL time.Duration) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		jwtSecret:   jwtSecret,
+		authService:    authService,
+		ssoService:     ssoService,
+		jwtSecret:      jwtSecret,
+		accessTokenTTL: accessTokenTTL,
+	}
+}
+
+// startSession signs the user in to orgID and returns the token fields of the response
+func (h *AuthHandler) startSession(c *fiber.Ctx, userID, orgID uuid.UUID) (fiber.Map, error) {
+	issued, err := h.authService.StartSession(c.UserContext(), userID, &orgID, service.SessionMeta{
+		UserAgent: c.Get("User-Agent"),
+		IPAddress: c.IP(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.tokenResponse(issued)
+}
+
+// tokenResponse signs an access token for an issued session
+func (h *AuthHandler) tokenResponse(issued *service.IssuedSession) (fiber.Map, error) {
+	token, err := middleware.GenerateJWT(
+		issued.UserID.String(),
+		issued.OrganizationID,
+		issued.Role,
+		issued.Session.ID.String(),
+		h.jwtSecret,
+		h.accessTokenTTL,
+	)
+	if err != nil {
+		return nil, err
 	}
+	return fiber.Map{
+		"token":         token,
+		"token_type":    "Bearer",
+		"expires_in":    int(h.accessTokenTTL.Seconds()),
+		"refresh_token": issued.RefreshToken,
+	}, nil
 }
 
 // LoginRequest request body
@@ -36,7 +79,7 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	}
 
 	// Authenticate user
-	user, err := h.authService.AuthenticateUser(c.Context(), req.Email, req.Password)
+	user, err := h.authService.AuthenticateUser(c.UserContext(), req.Email, req.Password)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid credentials",
@@ -44,44 +87,36 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	}
 
 	// Get user's organization and role
-	profile, err := h.authService.GetUserProfile(c.Context(), user.ID.String())
+	profile, err := h.authService.GetUserProfile(c.UserContext(), user.ID.String())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get user profile",
 		})
 	}
 
-	// Generate JWT
-	token, err := middleware.GenerateJWT(
-		user.ID.String(),
-		profile.OrganizationID.String(),
-		profile.Role,
-		h.jwtSecret,
-		24, // 24 hours expiry
-	)
+	resp, err := h.startSession(c, user.ID, profile.OrganizationID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"token": token,
-		"user": fiber.Map{
-			"id":    user.ID,
-			"email": user.Email,
-			"name":  profile.FullName,
-			"role":  profile.Role,
-		},
-	})
+	resp["user"] = fiber.Map{
+		"id":    user.ID,
+		"email": user.Email,
+		"name":  profile.FullName,
+		"role":  profile.Role,
+	}
+	return c.JSON(resp)
 }
 
 // RegisterRequest request body
 type RegisterRequest struct {
-	Email      string `json:"email" validate:"required,email"`
-	Password   string `json:"password" validate:"required,min=8"`
-	FullName   string `json:"full_name" validate:"required"`
-	OrgName    string `json:"org_name" validate:"required"`
+	Email       string `json:"email" validate:"required,email"`
+	Password    string `json:"password" validate:"required,min=8"`
+	FullName    string `json:"full_name" validate:"required"`
+	OrgName     string `json:"org_name"`     // required unless joining via invite_token
+	InviteToken string `json:"invite_token"` // joins the inviting organization instead of creating one
 }
 
 // Register handles user registration
@@ -93,72 +128,326 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	// Create organization and user
-	org, profile, err := h.authService.CreateOrganizationAndProfile(
-		c.Context(),
-		req.Email,
-		req.FullName,
-		req.OrgName,
-		req.Password,
-	)
+	// Create user and either join the inviting organization or create a new one
+	var org *model.Organization
+	var profile *model.Profile
+	var err error
+	if req.InviteToken != "" {
+		org, profile, err = h.authService.RegisterWithInvitation(
+			c.UserContext(),
+			req.Email,
+			req.FullName,
+			req.Password,
+			req.InviteToken,
+		)
+	} else {
+		if req.OrgName == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "org_name is required",
+			})
+		}
+		org, profile, err = h.authService.CreateOrganizationAndProfile(
+			c.UserContext(),
+			req.Email,
+			req.FullName,
+			req.OrgName,
+			req.Password,
+		)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	// Generate JWT
-	token, err := middleware.GenerateJWT(
-		profile.UserID.String(),
-		org.ID.String(),
-		profile.Role,
-		h.jwtSecret,
-		24,
-	)
+	resp, err := h.startSession(c, profile.UserID, org.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"token": token,
-		"user": fiber.Map{
-			"id":    profile.UserID,
-			"email": req.Email,
-			"name":  profile.FullName,
-			"role":  profile.Role,
-		},
-		"organization": fiber.Map{
-			"id":   org.ID,
-			"name": org.Name,
-			"slug": org.Slug,
-		},
-	})
+	resp["user"] = fiber.Map{
+		"id":    profile.UserID,
+		"email": req.Email,
+		"name":  profile.FullName,
+		"role":  profile.Role,
+	}
+	resp["organization"] = fiber.Map{
+		"id":   org.ID,
+		"name": org.Name,
+		"slug": org.Slug,
+	}
+	return c.Status(fiber.StatusCreated).JSON(resp)
 }
 
-// RefreshToken handles token refresh
+// RefreshTokenRequest request body
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a rotated refresh token
 func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
-	// Get current user from context
-	userID := middleware.GetUserID(c)
-	orgID := middleware.GetOrganizationID(c)
-	role := middleware.GetRole(c)
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
 
-	if userID == "" {
+	issued, err := h.authService.RefreshSession(c.UserContext(), req.RefreshToken)
+	if errors.Is(err, service.ErrUnauthorized) {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Not authenticated",
+			"error": "Invalid refresh token",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to refresh session",
 		})
 	}
 
-	// Generate new token
-	token, err := middleware.GenerateJWT(userID, orgID, role, h.jwtSecret, 24)
+	resp, err := h.tokenResponse(issued)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to generate token",
 		})
 	}
+	return c.JSON(resp)
+}
+
+// Logout revokes the session of a refresh token
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	var req RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.Logout(c.UserContext(), req.RefreshToken); err != nil {
+		if service.IsValidationError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListSessions lists the caller's active sessions
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
+		})
+	}
+
+	sessions, err := h.authService.ListSessions(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list sessions",
+		})
+	}
 
 	return c.JSON(fiber.Map{
-		"token": token,
+		"sessions": sessions,
+		"current":  middleware.GetSessionID(c),
+	})
+}
+
+// RevokeSession signs the caller out of one of their sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
+		})
+	}
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+
+	if err := h.authService.RevokeSession(c.UserContext(), userID, sessionID); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeAllSessions signs the caller out everywhere
+func (h *AuthHandler) RevokeAllSessions(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
+		})
+	}
+
+	revoked, err := h.authService.RevokeAllSessions(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+	return c.JSON(fiber.Map{"revoked": revoked})
+}
+
+// AcceptInvitationRequest request body
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	FullName string `json:"full_name"`
+}
+
+// AcceptInvitation adds the signed-in user to the organization that invited them
+func (h *AuthHandler) AcceptInvitation(c *fiber.Ctx) error {
+	var req AcceptInvitationRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "token is required",
+		})
+	}
+
+	org, profile, err := h.authService.JoinOrganization(c.UserContext(), middleware.GetUserID(c), req.FullName, req.Token)
+	if err != nil {
+		if service.IsValidationError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept invitation",
+		})
+	}
+
+	// Start a session in the joined organization; the current one keeps its organization
+	resp, err := h.startSession(c, profile.UserID, org.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	resp["organization"] = fiber.Map{
+		"id":   org.ID,
+		"name": org.Name,
+		"slug": org.Slug,
+	}
+	resp["role"] = profile.Role
+	return c.JSON(resp)
+}
+
+// ListSSOProviders lists the OpenID Connect providers users can sign in with
+func (h *AuthHandler) ListSSOProviders(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"providers": h.ssoService.Providers()})
+}
+
+// StartSSORequest request body
+type StartSSORequest struct {
+	InviteToken string `json:"invite_token"` // join the inviting organization on first login
+	OrgName     string `json:"org_name"`     // name of the organization created on first login
+}
+
+// StartSSO begins a login with an OpenID Connect provider. The client sends the user to
+// authorization_url; the provider redirects back to the web app with code and state,
+// which it posts to /auth/callback.
+func (h *AuthHandler) StartSSO(c *fiber.Ctx) error {
+	var req StartSSORequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	authURL, err := h.ssoService.StartLogin(c.UserContext(), c.Params("provider"), service.SSOLoginOptions{
+		InviteToken: req.InviteToken,
+		OrgName:     req.OrgName,
+	})
+	if errors.Is(err, service.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Unknown sign-in provider",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to start sign-in",
+		})
+	}
+
+	return c.JSON(fiber.Map{"authorization_url": authURL})
+}
+
+// AuthCallbackRequest request body
+type AuthCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// AuthCallback completes a provider login with the code and state from the redirect and
+// starts a session
+func (h *AuthHandler) AuthCallback(c *fiber.Ctx) error {
+	var req AuthCallbackRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" || req.State == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "code and state are required",
+		})
+	}
+
+	login, err := h.ssoService.CompleteLogin(c.UserContext(), req.Code, req.State, service.SessionMeta{
+		UserAgent: c.Get("User-Agent"),
+		IPAddress: c.IP(),
 	})
+	if err != nil {
+		switch {
+		case service.IsValidationError(err):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, service.ErrUnauthorized):
+			log.Printf("SSO sign-in rejected: %v", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Sign-in failed",
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to complete sign-in",
+			})
+		}
+	}
+
+	resp, err := h.tokenResponse(login.Session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	resp["user"] = fiber.Map{
+		"id":    login.User.ID,
+		"email": login.User.Email,
+		"name":  login.Profile.FullName,
+		"role":  login.Profile.Role,
+	}
+	resp["organization"] = fiber.Map{
+		"id":   login.Organization.ID,
+		"name": login.Organization.Name,
+		"slug": login.Organization.Slug,
+	}
+	resp["new_user"] = login.NewUser
+	return c.JSON(resp)
 }
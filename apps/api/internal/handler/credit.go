@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/service"
+)
+
+// CreditHandler handles credit balance and administration endpoints
+type CreditHandler struct {
+	creditService *service.CreditService
+}
+
+// NewCreditHandler creates a new credit handler
+func NewCreditHandler(creditService *service.CreditService) *CreditHandler {
+	return &CreditHandler{
+		creditService: creditService,
+	}
+}
+
+// CreditChangeRequest request body for top-ups and adjustments
+type CreditChangeRequest struct {
+	Type   string `json:"type"`   // topup or adjustment
+	Amount int64  `json:"amount"` // adjustments may be negative
+	Reason string `json:"reason"` // required for adjustments
+}
+
+// RefundRequest request body
+type RefundRequest struct {
+	GenerationID string `json:"generation_id"`
+	Amount       int64  `json:"amount"` // omit to refund everything not yet refunded
+	Reason       string `json:"reason"`
+}
+
+// GetBalance returns the organization's available and held credits
+func (h *CreditHandler) GetBalance(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+
+	balance, err := h.creditService.GetBalance(c.UserContext(), orgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get balance",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"credits":   balance.Available,
+		"available": balance.Available,
+		"held":      balance.Held,
+		"total":     balance.Total,
+	})
+}
+
+// ChangeCredits tops up or adjusts the organization's balance
+func (h *CreditHandler) ChangeCredits(c *fiber.Ctx) error {
+	orgID, userID, ok := adminContext(c)
+	if !ok {
+		return nil
+	}
+
+	var req CreditChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	var err error
+	var entry *model.CreditLedger
+	switch req.Type {
+	case "", "topup":
+		entry, err = h.creditService.TopUp(c.UserContext(), orgID, userID, req.Amount, req.Reason)
+	case "adjustment":
+		entry, err = h.creditService.Adjust(c.UserContext(), orgID, userID, req.Amount, req.Reason)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "type must be topup or adjustment",
+		})
+	}
+	if err != nil {
+		return creditError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"transaction": entry})
+}
+
+// RefundGeneration refunds credits charged for a generation
+func (h *CreditHandler) RefundGeneration(c *fiber.Ctx) error {
+	orgID, userID, ok := adminContext(c)
+	if !ok {
+		return nil
+	}
+
+	var req RefundRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	generationID, err := uuid.Parse(req.GenerationID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "generation_id must be a UUID",
+		})
+	}
+
+	entry, err := h.creditService.RefundGeneration(c.UserContext(), orgID, userID, generationID, req.Amount, req.Reason)
+	if err != nil {
+		return creditError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"transaction": entry})
+}
+
+// ListHistory lists ledger entries filtered by user, type, date range and generation
+func (h *CreditHandler) ListHistory(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+
+	q := service.LedgerQuery{
+		UserID:       c.Query("user_id"),
+		Type:         c.Query("type"),
+		GenerationID: c.Query("generation_id"),
+		From:         c.Query("from"),
+		To:           c.Query("to"),
+		Limit:        c.QueryInt("limit", 50),
+		Offset:       c.QueryInt("offset", 0),
+	}
+
+	entries, total, err := h.creditService.ListHistory(c.UserContext(), orgID, q)
+	if err != nil {
+		return creditError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"transactions": entries,
+		"total":        total,
+		"limit":        q.Limit,
+		"offset":       q.Offset,
+	})
+}
+
+// adminContext extracts the organization and acting user, writing an error response if missing
+func adminContext(c *fiber.Ctx) (orgID, userID uuid.UUID, ok bool) {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No organization"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err = uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Not authenticated"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, userID, true
+}
+
+// creditError maps service errors to HTTP responses
+func creditError(c *fiber.Ctx, err error) error {
+	switch {
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInsufficientCredits):
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Generation not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+}
@@ -50,7 +50,7 @@ const openAPISpec = `{
   "openapi": "3.1.0",
   "info": {
     "title": "NER Studio API",
-    "description": "AI Image Generation Platform for Creative Teams",
+    "description": "AI Image Generation Platform for Creative Teams. Authenticated requests are rate limited per organization and per member; over the limit the API responds 429 with a Retry-After header in seconds. Image URLs in responses may be signed and expire (when storage is private); fetch the resource again for fresh URLs rather than storing them.",
     "version": "1.0.0",
     "contact": {
       "name": "NER Studio Team"
@@ -75,6 +75,10 @@ const openAPISpec = `{
       "name": "Gallery",
       "description": "User gallery and images"
     },
+    {
+      "name": "Credits",
+      "description": "Credit balance"
+    },
     {
       "name": "Uploads",
       "description": "File upload endpoints"
@@ -120,12 +124,13 @@ const openAPISpec = `{
             "application/json": {
               "schema": {
                 "type": "object",
-                "required": ["email", "password", "full_name", "org_name"],
+                "required": ["email", "password", "full_name"],
                 "properties": {
                   "email": { "type": "string", "format": "email", "example": "user@example.com" },
                   "password": { "type": "string", "minLength": 8, "example": "password123" },
                   "full_name": { "type": "string", "example": "John Doe" },
-                  "org_name": { "type": "string", "example": "Acme Corp" }
+                  "org_name": { "type": "string", "example": "Acme Corp", "description": "Required unless invite_token is set" },
+                  "invite_token": { "type": "string", "description": "Join the inviting organization instead of creating one" }
                 }
               }
             }
@@ -139,7 +144,10 @@ const openAPISpec = `{
                 "schema": {
                   "type": "object",
                   "properties": {
-                    "token": { "type": "string" },
+                    "token": { "type": "string", "description": "Short-lived access token" },
+                    "token_type": { "type": "string", "example": "Bearer" },
+                    "expires_in": { "type": "integer", "description": "Access token lifetime in seconds" },
+                    "refresh_token": { "type": "string", "description": "Exchange at /auth/refresh; rotated on every use" },
                     "user": {
                       "type": "object",
                       "properties": {
@@ -171,7 +179,7 @@ const openAPISpec = `{
     "/api/v1/auth/login": {
       "post": {
         "summary": "User login",
-        "description": "Authenticate user and get JWT token",
+        "description": "Authenticate user and start a session with an access token and a refresh token",
         "tags": ["Auth"],
         "requestBody": {
           "required": true,
@@ -196,7 +204,10 @@ const openAPISpec = `{
                 "schema": {
                   "type": "object",
                   "properties": {
-                    "token": { "type": "string" },
+                    "token": { "type": "string", "description": "Short-lived access token" },
+                    "token_type": { "type": "string", "example": "Bearer" },
+                    "expires_in": { "type": "integer", "description": "Access token lifetime in seconds" },
+                    "refresh_token": { "type": "string", "description": "Exchange at /auth/refresh; rotated on every use" },
                     "user": {
                       "type": "object",
                       "properties": {
@@ -219,56 +230,199 @@ const openAPISpec = `{
     },
     "/api/v1/auth/refresh": {
       "post": {
-        "summary": "Refresh JWT token",
-        "description": "Get a new JWT token using current token",
+        "summary": "Refresh session",
+        "description": "Exchange a refresh token for a new access token and a new refresh token; the old refresh token stops working. Organization and role claims are reloaded from the current membership. Presenting a refresh token that was already used revokes its session.",
         "tags": ["Auth"],
-        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["refresh_token"],
+                "properties": {
+                  "refresh_token": { "type": "string" }
+                }
+              }
+            }
+          }
+        },
         "responses": {
           "200": {
-            "description": "New token generated",
+            "description": "New tokens",
             "content": {
               "application/json": {
                 "schema": {
                   "type": "object",
                   "properties": {
-                    "token": { "type": "string" }
+                    "token": { "type": "string" },
+                    "token_type": { "type": "string", "example": "Bearer" },
+                    "expires_in": { "type": "integer" },
+                    "refresh_token": { "type": "string" }
                   }
                 }
               }
             }
+          },
+          "401": { "description": "Unknown, expired, revoked or reused refresh token" }
+        }
+      }
+    },
+    "/api/v1/auth/logout": {
+      "post": {
+        "summary": "Log out",
+        "description": "Revoke the session of a refresh token. Access tokens already issued stay valid until they expire.",
+        "tags": ["Auth"],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["refresh_token"],
+                "properties": {
+                  "refresh_token": { "type": "string" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "204": { "description": "Logged out" },
+          "400": { "description": "refresh_token missing" }
+        }
+      }
+    },
+    "/api/v1/auth/oidc/providers": {
+      "get": {
+        "summary": "List sign-in providers",
+        "description": "Names of the configured OpenID Connect providers, e.g. google",
+        "tags": ["Auth"],
+        "responses": {
+          "200": { "description": "providers" }
+        }
+      }
+    },
+    "/api/v1/auth/oidc/{provider}": {
+      "post": {
+        "summary": "Start provider sign-in",
+        "description": "Starts an authorization code login with PKCE. Send the user to authorization_url; the provider redirects back to the web app's callback page with code and state, which it posts to /auth/callback within 10 minutes.",
+        "tags": ["Auth"],
+        "parameters": [
+          { "name": "provider", "in": "path", "required": true, "schema": { "type": "string" } }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "invite_token": { "type": "string", "description": "Join the inviting organization on first login" },
+                  "org_name": { "type": "string", "description": "Name of the organization created for a user without one" }
+                }
+              }
+            }
           }
+        },
+        "responses": {
+          "200": { "description": "authorization_url" },
+          "404": { "description": "Unknown provider" },
+          "502": { "description": "The provider could not be reached" }
+        }
+      }
+    },
+    "/api/v1/auth/callback": {
+      "post": {
+        "summary": "Complete provider sign-in",
+        "description": "Redeems the code from the provider redirect and starts a session. The user is found by provider identity or linked to an existing account by verified email; new users are created. A user without an organization joins the inviting one or gets a new one. Returns the same tokens as login, plus organization and new_user.",
+        "tags": ["Auth"],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["code", "state"],
+                "properties": {
+                  "code": { "type": "string" },
+                  "state": { "type": "string" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": { "description": "Signed in" },
+          "400": { "description": "Missing code or state, or the invitation is invalid" },
+          "401": { "description": "Expired or reused state, rejected code, invalid ID token or unverified email" }
+        }
+      }
+    },
+    "/api/v1/sessions": {
+      "get": {
+        "summary": "List sessions",
+        "description": "Active sessions of the signed-in user; current is the session of the access token",
+        "tags": ["Auth"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": {
+          "200": { "description": "sessions and current" }
+        }
+      },
+      "delete": {
+        "summary": "Log out everywhere",
+        "description": "Revoke every session of the signed-in user",
+        "tags": ["Auth"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": {
+          "200": { "description": "Number of revoked sessions" }
+        }
+      }
+    },
+    "/api/v1/sessions/{id}": {
+      "delete": {
+        "summary": "Revoke session",
+        "tags": ["Auth"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }
+        ],
+        "responses": {
+          "204": { "description": "Session revoked" },
+          "404": { "description": "No active session with this ID" }
         }
       }
     },
     "/api/v1/generations": {
       "get": {
         "summary": "List generations",
-        "description": "Get all generations for the current user",
+        "description": "Newest first. Members see their own generations; admins see the whole organization unless scope=mine. Each generation includes up to four thumbnail URLs of its completed images; an image whose thumbnail rendition is not rendered yet is shown by its original. Pass next_cursor from the response as cursor to get the next page; it is empty on the last page.",
         "tags": ["Generations"],
         "security": [{ "bearerAuth": [] }],
         "parameters": [
-          {
-            "name": "limit",
-            "in": "query",
-            "schema": { "type": "integer", "default": 20 }
-          },
-          {
-            "name": "offset",
-            "in": "query",
-            "schema": { "type": "integer", "default": 0 }
-          }
+          { "name": "scope", "in": "query", "schema": { "type": "string", "enum": ["mine", "org"] } },
+          { "name": "user_id", "in": "query", "description": "Admins only", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "status", "in": "query", "schema": { "type": "string", "enum": ["pending", "processing", "completed", "failed"] } },
+          { "name": "provider_id", "in": "query", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "from", "in": "query", "description": "Inclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "to", "in": "query", "description": "Exclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "q", "in": "query", "description": "Case-insensitive prompt text", "schema": { "type": "string" } },
+          { "name": "cursor", "in": "query", "schema": { "type": "string" } },
+          { "name": "limit", "in": "query", "schema": { "type": "integer", "default": 20, "maximum": 100 } }
         ],
         "responses": {
           "200": {
-            "description": "List of generations"
-          }
+            "description": "A page of generations and next_cursor"
+          },
+          "400": { "description": "Invalid filter or cursor" },
+          "403": { "description": "A member filtered by another user" }
         }
       },
       "post": {
         "summary": "Create generation",
-        "description": "Start a new image generation job",
+        "description": "Start a new image generation job. The estimated cost is held from the available balance until the generation finishes, when it is settled to the actual cost.",
         "tags": ["Generations"],
         "security": [{ "bearerAuth": [] }],
+        "parameters": [{ "$ref": "#/components/parameters/IdempotencyKey" }],
         "requestBody": {
           "required": true,
           "content": {
@@ -278,8 +432,8 @@ const openAPISpec = `{
                 "required": ["base_prompt", "provider_id"],
                 "properties": {
                   "base_prompt": { "type": "string", "description": "Main prompt for image generation" },
-                  "reference_images": { "type": "array", "items": { "type": "string" } },
-                  "product_images": { "type": "array", "items": { "type": "string" } },
+                  "reference_upload_ids": { "type": "array", "items": { "type": "string", "format": "uuid" }, "description": "Completed uploads to analyze for style" },
+                  "product_upload_ids": { "type": "array", "items": { "type": "string", "format": "uuid" }, "description": "Completed uploads of the product" },
                   "provider_id": { "type": "string", "format": "uuid" },
                   "num_variations": { "type": "integer", "minimum": 1, "maximum": 10, "default": 4 }
                 }
@@ -290,14 +444,18 @@ const openAPISpec = `{
         "responses": {
           "202": {
             "description": "Generation started"
-          }
+          },
+          "400": { "description": "Invalid request" },
+          "402": { "description": "Insufficient available credits; the Idempotency-Key can be retried" },
+          "409": { "description": "The Idempotency-Key was used for a different request, or its first request is still in progress" },
+          "429": { "description": "Too many generations in progress, or the daily image limit would be exceeded; see Retry-After" }
         }
       }
     },
     "/api/v1/generations/{id}": {
       "get": {
         "summary": "Get generation",
-        "description": "Get a specific generation by ID",
+        "description": "Get a specific generation of the caller's organization by ID",
         "tags": ["Generations"],
         "security": [{ "bearerAuth": [] }],
         "parameters": [
@@ -310,28 +468,140 @@ const openAPISpec = `{
         ],
         "responses": {
           "200": {
-            "description": "Generation details"
+            "description": "Generation details. Completed images include renditions, a map of thumbnail, medium and original URLs; a rendition not rendered yet points to the original. Deleted images have no URLs."
+          },
+          "404": {
+            "description": "Generation not found, including generations of other organizations"
           }
         }
       }
     },
+    "/api/v1/generations/{id}/events": {
+      "get": {
+        "summary": "Stream generation progress",
+        "description": "Server-Sent Events stream of workflow stages (vision_analysis, prompt_generation, submission), image completions and failures. Event types: stage, image.completed, image.failed, generation.completed, generation.failed; the stream closes after a generation.* event. image.completed carries the image's image_url and renditions, signed afresh whenever the event is sent. Each event's id can be sent back as Last-Event-ID (or the last_event_id query parameter) to resume after a reconnect. Send the bearer token in the Authorization header, e.g. with a fetch-based SSE client.",
+        "tags": ["Generations"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "schema": { "type": "string", "format": "uuid" }
+          },
+          {
+            "name": "Last-Event-ID",
+            "in": "header",
+            "schema": { "type": "integer" }
+          },
+          {
+            "name": "last_event_id",
+            "in": "query",
+            "schema": { "type": "integer" }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Event stream",
+            "content": { "text/event-stream": { "schema": { "type": "string" } } }
+          },
+          "404": { "description": "Generation not found" }
+        }
+      }
+    },
     "/api/v1/gallery": {
       "get": {
         "summary": "Get gallery",
-        "description": "Get user's generated images",
+        "description": "Completed images, newest first. Members see their own images; admins see the whole organization and can filter by member. Pass next_cursor as cursor for the next page.",
         "tags": ["Gallery"],
         "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "user_id", "in": "query", "description": "Admins only", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "provider_id", "in": "query", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "from", "in": "query", "description": "Inclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "to", "in": "query", "description": "Exclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "q", "in": "query", "description": "Case-insensitive prompt text", "schema": { "type": "string" } },
+          { "name": "tag", "in": "query", "schema": { "type": "string" } },
+          { "name": "favorites", "in": "query", "description": "Only the caller's favorites", "schema": { "type": "boolean" } },
+          { "name": "cursor", "in": "query", "schema": { "type": "string" } },
+          { "name": "limit", "in": "query", "schema": { "type": "integer", "default": 30, "maximum": 100 } }
+        ],
         "responses": {
           "200": {
-            "description": "List of images"
+            "description": "A page of images and next_cursor. Each image includes renditions, a map of thumbnail, medium and original URLs."
+          },
+          "400": { "description": "Invalid filter or cursor" },
+          "403": { "description": "A member filtered by another member" }
+        }
+      }
+    },
+    "/api/v1/gallery/{id}/favorite": {
+      "put": {
+        "summary": "Favorite an image",
+        "tags": ["Gallery"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }],
+        "responses": { "200": { "description": "Favorited" }, "404": { "description": "Image not found" } }
+      },
+      "delete": {
+        "summary": "Unfavorite an image",
+        "tags": ["Gallery"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }],
+        "responses": { "200": { "description": "Removed from favorites" }, "404": { "description": "Image not found" } }
+      }
+    },
+    "/api/v1/gallery/{id}/tags": {
+      "put": {
+        "summary": "Replace an image's tags",
+        "description": "Tags are trimmed, lowercased and deduplicated; at most 20 tags of 40 characters.",
+        "tags": ["Gallery"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": { "tags": { "type": "array", "items": { "type": "string" } } }
+              }
+            }
           }
+        },
+        "responses": { "200": { "description": "The stored tags" }, "404": { "description": "Image not found" } }
+      }
+    },
+    "/api/v1/gallery/delete": {
+      "post": {
+        "summary": "Delete images",
+        "description": "Deletes up to 100 images and their stored objects. Images that are not found or not the caller's (for members) are returned in failed.",
+        "tags": ["Gallery"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": { "$ref": "#/components/requestBodies/ImageIDs" },
+        "responses": { "200": { "description": "deleted and failed image IDs" } }
+      }
+    },
+    "/api/v1/gallery/download": {
+      "post": {
+        "summary": "Download images as ZIP",
+        "description": "Streams up to 100 images as a ZIP archive. Images the caller cannot access are skipped.",
+        "tags": ["Gallery"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": { "$ref": "#/components/requestBodies/ImageIDs" },
+        "responses": {
+          "200": {
+            "description": "ZIP archive",
+            "content": { "application/zip": { "schema": { "type": "string", "format": "binary" } } }
+          },
+          "404": { "description": "None of the images were found" }
         }
       }
     },
     "/api/v1/uploads": {
       "post": {
         "summary": "Upload image",
-        "description": "Upload an image to R2 storage",
+        "description": "Upload an image through the API (max 10MB). The image is decoded and re-encoded: it is turned upright from its EXIF orientation, scaled down to UPLOAD_MAX_LONG_EDGE and stored without metadata, so the stored type may differ from the upload. The response includes the upload id to use in generations. Prefer direct uploads with /uploads/presigned for large files.",
         "tags": ["Uploads"],
         "security": [{ "bearerAuth": [] }],
         "requestBody": {
@@ -349,15 +619,376 @@ const openAPISpec = `{
         },
         "responses": {
           "200": {
-            "description": "Upload successful"
+            "description": "Upload successful, with the renditions of the stored image"
+          },
+          "400": { "description": "Missing file, unsupported type, content not matching the extension, an undecodable image or one over UPLOAD_MAX_PIXELS" }
+        }
+      }
+    },
+    "/api/v1/uploads/presigned": {
+      "post": {
+        "summary": "Start a direct upload",
+        "description": "Reserves a key under the organization's prefix and returns a presigned URL. PUT the file to upload_url with the returned headers; the size and content type are signed, so a different file is refused by storage. Then complete the upload by its upload.id.",
+        "tags": ["Uploads"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["filename", "content_type", "size"],
+                "properties": {
+                  "filename": { "type": "string" },
+                  "content_type": { "type": "string", "enum": ["image/jpeg", "image/png", "image/webp", "image/gif"] },
+                  "size": { "type": "integer", "description": "Exact size in bytes, up to UPLOAD_MAX_BYTES" },
+                  "folder": { "type": "string", "enum": ["references", "products", "uploads"], "default": "uploads" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": { "description": "upload, upload_url, method, headers and expires_at" },
+          "400": { "description": "Invalid folder, filename, content type or size" }
+        }
+      }
+    },
+    "/api/v1/uploads/{id}/complete": {
+      "post": {
+        "summary": "Complete a direct upload",
+        "description": "Checks that the object exists, has the announced size, is an image of the announced type by its magic bytes and decodes within UPLOAD_MAX_PIXELS. The image is then normalized like direct API uploads and stored under a new key, returned with its width, height, format and perceptual hash (phash). A file failing the checks is deleted. Completing twice returns the upload unchanged.",
+        "tags": ["Uploads"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "description": "The upload ID returned when the upload was started", "schema": { "type": "string", "format": "uuid" } }
+        ],
+        "responses": {
+          "200": { "description": "The completed upload, with the renditions of the stored image" },
+          "400": { "description": "Invalid upload ID, not uploaded yet, or the file failed the checks" },
+          "404": { "description": "Unknown upload" }
+        }
+      }
+    },
+    "/api/v1/admin/members": {
+      "get": {
+        "summary": "List members",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": { "200": { "description": "Organization members" } }
+      }
+    },
+    "/api/v1/admin/members/{id}": {
+      "patch": {
+        "summary": "Change member role",
+        "description": "Set role to admin or member. The last admin cannot be demoted.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }
+        ],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": { "type": "object", "properties": { "role": { "type": "string", "enum": ["admin", "member"] } } }
+            }
+          }
+        },
+        "responses": {
+          "200": { "description": "Role updated" },
+          "409": { "description": "Would leave the organization without an admin" }
+        }
+      },
+      "delete": {
+        "summary": "Remove member",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }
+        ],
+        "responses": {
+          "204": { "description": "Member removed" },
+          "409": { "description": "Would leave the organization without an admin" }
+        }
+      }
+    },
+    "/api/v1/admin/members/invite": {
+      "post": {
+        "summary": "Invite member",
+        "description": "Email a single-use invitation link. Re-inviting an email replaces its open invitation.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["email"],
+                "properties": {
+                  "email": { "type": "string", "format": "email" },
+                  "role": { "type": "string", "enum": ["admin", "member"], "default": "member" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": { "description": "Invitation sent" },
+          "409": { "description": "Already a member" }
+        }
+      }
+    },
+    "/api/v1/admin/invitations": {
+      "get": {
+        "summary": "List invitations",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": { "200": { "description": "Invitations with status pending, accepted, revoked or expired" } }
+      }
+    },
+    "/api/v1/admin/invitations/{id}": {
+      "delete": {
+        "summary": "Revoke invitation",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string", "format": "uuid" } }
+        ],
+        "responses": { "204": { "description": "Invitation revoked" } }
+      }
+    },
+    "/api/v1/invitations/accept": {
+      "post": {
+        "summary": "Accept invitation",
+        "description": "Join the inviting organization with an existing account. New users pass invite_token to /auth/register instead.",
+        "tags": ["Auth"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["token"],
+                "properties": {
+                  "token": { "type": "string" },
+                  "full_name": { "type": "string" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": { "description": "Joined; starts a session in the organization and returns its token and refresh_token" },
+          "400": { "description": "Invitation invalid, expired or for another email" }
+        }
+      }
+    },
+    "/api/v1/credits": {
+      "get": {
+        "summary": "Get credit balance",
+        "description": "Available credits can be spent; held credits are reserved for generations in progress.",
+        "tags": ["Credits"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": { "200": { "$ref": "#/components/responses/CreditBalance" } }
+      }
+    },
+    "/api/v1/admin/credits": {
+      "get": {
+        "summary": "Get credit balance",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "responses": { "200": { "$ref": "#/components/responses/CreditBalance" } }
+      },
+      "post": {
+        "summary": "Top up or adjust credits",
+        "description": "Top-ups add credits. Adjustments may be negative and require a reason. Balances cannot go below zero.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [{ "$ref": "#/components/parameters/IdempotencyKey" }],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["amount"],
+                "properties": {
+                  "type": { "type": "string", "enum": ["topup", "adjustment"], "default": "topup" },
+                  "amount": { "type": "integer" },
+                  "reason": { "type": "string" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": { "description": "Ledger entry recorded" },
+          "400": { "description": "Invalid amount or missing reason" },
+          "402": { "description": "The adjustment would take the balance below zero; the Idempotency-Key can be retried" },
+          "409": { "description": "The Idempotency-Key was used for a different request, or its first request is still in progress" }
+        }
+      }
+    },
+    "/api/v1/admin/credits/refunds": {
+      "post": {
+        "summary": "Refund a generation",
+        "description": "Credit back what a generation was charged. Omit amount to refund everything not yet refunded.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["generation_id"],
+                "properties": {
+                  "generation_id": { "type": "string", "format": "uuid" },
+                  "amount": { "type": "integer" },
+                  "reason": { "type": "string" }
+                }
+              }
+            }
           }
+        },
+        "responses": {
+          "201": { "description": "Refund recorded" },
+          "400": { "description": "Refund exceeds the amount charged" },
+          "404": { "description": "Generation not found" }
+        }
+      }
+    },
+    "/api/v1/admin/credits/history": {
+      "get": {
+        "summary": "Credit ledger history",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "user_id", "in": "query", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "type", "in": "query", "schema": { "type": "string", "enum": ["generation", "refund", "purchase", "adjustment", "topup", "hold", "release"] } },
+          { "name": "generation_id", "in": "query", "schema": { "type": "string", "format": "uuid" } },
+          { "name": "from", "in": "query", "description": "Inclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "to", "in": "query", "description": "Exclusive; RFC 3339 or YYYY-MM-DD", "schema": { "type": "string" } },
+          { "name": "limit", "in": "query", "schema": { "type": "integer", "default": 50, "maximum": 200 } },
+          { "name": "offset", "in": "query", "schema": { "type": "integer", "default": 0 } }
+        ],
+        "responses": { "200": { "description": "Ledger entries, newest first, with total count" } }
+      }
+    },
+    "/api/v1/admin/providers": {
+      "get": {
+        "summary": "List providers",
+        "description": "List all providers, including inactive ones. API keys are returned only as a masked fingerprint.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          {
+            "name": "category",
+            "in": "query",
+            "schema": { "type": "string", "enum": ["image_generation", "llm", "vision"] }
+          }
+        ],
+        "responses": {
+          "200": { "description": "List of providers" }
+        }
+      },
+      "post": {
+        "summary": "Create provider",
+        "description": "Add a provider. It is available without a redeploy.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "requestBody": {
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["slug", "name", "category", "driver", "model"],
+                "properties": {
+                  "slug": { "type": "string" },
+                  "name": { "type": "string" },
+                  "category": { "type": "string", "enum": ["image_generation", "llm", "vision"] },
+                  "driver": { "type": "string", "enum": ["gemini", "kieai", "openai"] },
+                  "api_key": { "type": "string" },
+                  "base_url": { "type": "string" },
+                  "model": { "type": "string" },
+                  "config": { "type": "object" },
+                  "priority": { "type": "integer" },
+                  "is_active": { "type": "boolean", "default": true },
+                  "cost_per_use": { "type": "integer" }
+                }
+              }
+            }
+          }
+        },
+        "responses": {
+          "201": { "description": "Provider created" },
+          "400": { "description": "Invalid input" },
+          "409": { "description": "Slug already exists" }
+        }
+      }
+    },
+    "/api/v1/admin/providers/{slug}": {
+      "patch": {
+        "summary": "Update provider",
+        "description": "Update name, driver, api_key, base_url, model, config, priority, is_active or cost_per_use. Slug and category cannot be changed.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "slug", "in": "path", "required": true, "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "200": { "description": "Provider updated" },
+          "400": { "description": "Invalid input" },
+          "404": { "description": "Provider not found" }
+        }
+      },
+      "delete": {
+        "summary": "Delete provider",
+        "description": "Only providers that were never used can be deleted; deactivate a used provider with is_active instead.",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "slug", "in": "path", "required": true, "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "204": { "description": "Provider deleted" },
+          "404": { "description": "Provider not found" },
+          "409": { "description": "Provider has been used in generations" }
+        }
+      }
+    },
+    "/api/v1/admin/providers/{slug}/test": {
+      "post": {
+        "summary": "Test provider",
+        "description": "Run a cheap request against the provider and report latency and error category (auth, rate_limit, timeout, network, server_error, bad_request, config, unknown).",
+        "tags": ["Admin"],
+        "security": [{ "bearerAuth": [] }],
+        "parameters": [
+          { "name": "slug", "in": "path", "required": true, "schema": { "type": "string" } }
+        ],
+        "responses": {
+          "200": {
+            "description": "Check result",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "success": { "type": "boolean" },
+                    "latency_ms": { "type": "integer" },
+                    "error_category": { "type": "string" },
+                    "error": { "type": "string" }
+                  }
+                }
+              }
+            }
+          },
+          "404": { "description": "Provider not found" }
         }
       }
     },
     "/api/v1/callbacks/{provider}": {
       "post": {
         "summary": "Provider callback",
-        "description": "Webhook endpoint for AI providers",
+        "description": "Webhook endpoint for AI providers. Each submitted task gets its own callback URL carrying a secret token; callbacks without a valid token are rejected. Repeated deliveries for a task that was already processed are acknowledged and ignored.",
         "tags": ["Callbacks"],
         "parameters": [
           {
@@ -365,6 +996,13 @@ const openAPISpec = `{
             "in": "path",
             "required": true,
             "schema": { "type": "string" }
+          },
+          {
+            "name": "token",
+            "in": "query",
+            "required": true,
+            "description": "Per-task token issued in the callback URL",
+            "schema": { "type": "string" }
           }
         ],
         "requestBody": {
@@ -383,8 +1021,11 @@ const openAPISpec = `{
         },
         "responses": {
           "200": {
-            "description": "Callback processed"
-          }
+            "description": "Callback processed, or a replay of one that was"
+          },
+          "400": { "description": "Malformed payload" },
+          "401": { "description": "Missing or invalid callback token" },
+          "500": { "description": "The result could not be applied; the provider may retry" }
         }
       }
     }
@@ -397,6 +1038,49 @@ const openAPISpec = `{
         "bearerFormat": "JWT",
         "description": "JWT token obtained from /auth/login or /auth/register"
       }
+    },
+    "parameters": {
+      "IdempotencyKey": {
+        "name": "Idempotency-Key",
+        "in": "header",
+        "required": false,
+        "description": "Unique key, at most 255 characters, making the request safe to retry. A retry with the same key and request within 24 hours gets the first response back with an Idempotent-Replayed header instead of running again. Keys are scoped to the organization.",
+        "schema": { "type": "string", "maxLength": 255 }
+      }
+    },
+    "requestBodies": {
+      "ImageIDs": {
+        "required": true,
+        "content": {
+          "application/json": {
+            "schema": {
+              "type": "object",
+              "required": ["image_ids"],
+              "properties": {
+                "image_ids": { "type": "array", "maxItems": 100, "items": { "type": "string", "format": "uuid" } }
+              }
+            }
+          }
+        }
+      }
+    },
+    "responses": {
+      "CreditBalance": {
+        "description": "Current balance",
+        "content": {
+          "application/json": {
+            "schema": {
+              "type": "object",
+              "properties": {
+                "credits": { "type": "integer", "description": "Same as available; kept for older clients" },
+                "available": { "type": "integer", "description": "Credits that can be spent" },
+                "held": { "type": "integer", "description": "Credits reserved for generations in progress" },
+                "total": { "type": "integer", "description": "available + held" }
+              }
+            }
+          }
+        }
+      }
     }
   }
 }`
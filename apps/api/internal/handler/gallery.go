@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/service"
+)
+
+// zipDownloadTimeout bounds how long a single archive may take to stream
+const zipDownloadTimeout = 30 * time.Minute
+
+// GalleryHandler handles gallery endpoints
+type GalleryHandler struct {
+	galleryService *service.GalleryService
+}
+
+// NewGalleryHandler creates a new gallery handler
+func NewGalleryHandler(galleryService *service.GalleryService) *GalleryHandler {
+	return &GalleryHandler{
+		galleryService: galleryService,
+	}
+}
+
+// ImageIDsRequest request body for bulk operations
+type ImageIDsRequest struct {
+	ImageIDs []string `json:"image_ids"`
+}
+
+// TagsRequest request body
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// ListImages lists completed images, filtered by member (admins only), provider, date,
+// prompt text, tag and favorites
+func (h *GalleryHandler) ListImages(c *fiber.Ctx) error {
+	viewer, ok := galleryViewer(c)
+	if !ok {
+		return nil
+	}
+
+	q := service.GalleryQuery{
+		UserID:        c.Query("user_id"),
+		ProviderID:    c.Query("provider_id"),
+		From:          c.Query("from"),
+		To:            c.Query("to"),
+		Query:         c.Query("q"),
+		Tag:           c.Query("tag"),
+		FavoritesOnly: c.QueryBool("favorites", false),
+		Cursor:        c.Query("cursor"),
+		Limit:         c.QueryInt("limit", 30),
+	}
+
+	images, next, err := h.galleryService.ListImages(c.UserContext(), viewer, q)
+	if err != nil {
+		return galleryError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"images":      images,
+		"next_cursor": next,
+	})
+}
+
+// AddFavorite marks an image as a favorite of the current user
+func (h *GalleryHandler) AddFavorite(c *fiber.Ctx) error {
+	return h.setFavorite(c, true)
+}
+
+// RemoveFavorite removes an image from the current user's favorites
+func (h *GalleryHandler) RemoveFavorite(c *fiber.Ctx) error {
+	return h.setFavorite(c, false)
+}
+
+func (h *GalleryHandler) setFavorite(c *fiber.Ctx, favorite bool) error {
+	viewer, ok := galleryViewer(c)
+	if !ok {
+		return nil
+	}
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	if err := h.galleryService.SetFavorite(c.UserContext(), viewer, imageID, favorite); err != nil {
+		return galleryError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"id": imageID, "favorite": favorite})
+}
+
+// SetTags replaces an image's tags
+func (h *GalleryHandler) SetTags(c *fiber.Ctx) error {
+	viewer, ok := galleryViewer(c)
+	if !ok {
+		return nil
+	}
+	imageID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid image ID",
+		})
+	}
+
+	var req TagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	tags, err := h.galleryService.SetTags(c.UserContext(), viewer, imageID, req.Tags)
+	if err != nil {
+		return galleryError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"id": imageID, "tags": tags})
+}
+
+// DeleteImages deletes images and their stored objects
+func (h *GalleryHandler) DeleteImages(c *fiber.Ctx) error {
+	viewer, ok := galleryViewer(c)
+	if !ok {
+		return nil
+	}
+	ids, ok := parseImageIDs(c)
+	if !ok {
+		return nil
+	}
+
+	result, err := h.galleryService.DeleteImages(c.UserContext(), viewer, ids)
+	if err != nil {
+		return galleryError(c, err)
+	}
+
+	return c.JSON(result)
+}
+
+// DownloadZip streams the selected images as a ZIP archive
+func (h *GalleryHandler) DownloadZip(c *fiber.Ctx) error {
+	viewer, ok := galleryViewer(c)
+	if !ok {
+		return nil
+	}
+	ids, ok := parseImageIDs(c)
+	if !ok {
+		return nil
+	}
+
+	images, err := h.galleryService.PrepareDownload(c.UserContext(), viewer, ids)
+	if err != nil {
+		return galleryError(c, err)
+	}
+
+	filename := fmt.Sprintf("gallery-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	c.Set("Content-Type", "application/zip")
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The archive is written after the handler returns, while the response is being sent
+	scope := c.UserContext()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(scope, zipDownloadTimeout)
+		defer cancel()
+
+		if err := h.galleryService.WriteZip(ctx, w, images); err != nil {
+			// Headers are already sent; the client sees a truncated archive
+			log.Printf("Failed to stream gallery ZIP: %v", err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// galleryViewer reads the caller's organization, user and role, writing an error response if missing
+func galleryViewer(c *fiber.Ctx) (service.Viewer, bool) {
+	orgID, userID, ok := adminContext(c)
+	if !ok {
+		return service.Viewer{}, false
+	}
+	return service.Viewer{
+		OrganizationID: orgID,
+		UserID:         userID,
+		Role:           middleware.GetRole(c),
+	}, true
+}
+
+// parseImageIDs reads the image_ids body field, writing an error response if it is invalid
+func parseImageIDs(c *fiber.Ctx) ([]uuid.UUID, bool) {
+	var req ImageIDsRequest
+	if err := c.BodyParser(&req); err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return nil, false
+	}
+
+	ids := make([]uuid.UUID, 0, len(req.ImageIDs))
+	for _, raw := range req.ImageIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "image_ids must be UUIDs"})
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// galleryError maps service errors to HTTP responses
+func galleryError(c *fiber.Ctx, err error) error {
+	switch {
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admins can browse other members' images"})
+	case errors.Is(err, service.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Image not found"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+}
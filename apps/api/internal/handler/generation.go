@@ -1,33 +1,51 @@
 package handler
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/events"
 	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/model"
 	"github.com/ner-studio/api/internal/service"
 )
 
 // GenerationHandler handles generation endpoints
 type GenerationHandler struct {
 	generationService *service.GenerationService
+	broker            *events.Broker
 }
 
 // NewGenerationHandler creates a new generation handler
-func NewGenerationHandler(genService *service.GenerationService) *GenerationHandler {
+func NewGenerationHandler(genService *service.GenerationService, broker *events.Broker) *GenerationHandler {
 	return &GenerationHandler{
 		generationService: genService,
+		broker:            broker,
 	}
 }
 
 // CreateGenerationRequest request body
 type CreateGenerationRequest struct {
-	BasePrompt      string   `json:"base_prompt" validate:"required"`
+	BasePrompt         string   `json:"base_prompt" validate:"required"`
+	ReferenceUploadIDs []string `json:"reference_upload_ids"`
+	ProductUploadIDs   []string `json:"product_upload_ids"`
+	ProviderID         string   `json:"provider_id" validate:"required"`
+	// Image URLs are no longer accepted; they are only decoded to reject them clearly
 	ReferenceImages []string `json:"reference_images"`
 	ProductImages   []string `json:"product_images"`
-	ProviderID      string   `json:"provider_id" validate:"required"`
 	NumVariations   int      `json:"num_variations"`
+	AspectRatio     string   `json:"aspect_ratio"`
+	Quality         string   `json:"quality"`
+	Seed            *int64   `json:"seed"`
+	NegativePrompt  string   `json:"negative_prompt"`
 }
 
 // CreateGeneration starts a new image generation
@@ -50,28 +68,58 @@ func (h *GenerationHandler) CreateGeneration(c *fiber.Ctx) error {
 			"error": "provider_id is required",
 		})
 	}
+	if len(req.ReferenceImages) > 0 || len(req.ProductImages) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "reference_images and product_images are not supported; upload the images and pass reference_upload_ids and product_upload_ids",
+		})
+	}
 
-	userID := middleware.GetUserID(c)
-	orgID := middleware.GetOrganizationID(c)
-
-	// TODO: Get orgID from user's profile (need middleware to set this)
-	// For now, we'll need to get it from the database
-
-	gen, err := h.generationService.CreateGeneration(c.Context(), service.CreateGenerationRequest{
-		UserID:          userID,
-		OrganizationID:  orgID, // This might be empty, need to handle
-		BasePrompt:      req.BasePrompt,
-		ReferenceImages: req.ReferenceImages,
-		ProductImages:   req.ProductImages,
-		ProviderID:      req.ProviderID,
-		NumVariations:   req.NumVariations,
-	})
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
 		})
 	}
 
+	gen, err := h.generationService.CreateGeneration(c.UserContext(), service.CreateGenerationRequest{
+		UserID:             userID.String(),
+		OrganizationID:     orgID.String(),
+		BasePrompt:         req.BasePrompt,
+		ReferenceUploadIDs: req.ReferenceUploadIDs,
+		ProductUploadIDs:   req.ProductUploadIDs,
+		ProviderID:         req.ProviderID,
+		NumVariations:      req.NumVariations,
+		Options: model.GenerationOptions{
+			AspectRatio:    req.AspectRatio,
+			Quality:        req.Quality,
+			Seed:           req.Seed,
+			NegativePrompt: req.NegativePrompt,
+		},
+	})
+	var limitErr *service.RateLimitError
+	switch {
+	case err == nil:
+	case errors.As(err, &limitErr):
+		return middleware.TooManyRequests(c, limitErr.Message, limitErr.RetryAfter)
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No organization"})
+	case errors.Is(err, service.ErrUnauthorized):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Not authenticated"})
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInsufficientCredits):
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{"error": "Insufficient credits"})
+	default:
+		log.Printf("Failed to create generation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create generation"})
+	}
+
 	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
 		"id":      gen.ID,
 		"status":  gen.Status,
@@ -79,26 +127,48 @@ func (h *GenerationHandler) CreateGeneration(c *fiber.Ctx) error {
 	})
 }
 
-// ListGenerations lists user's generations
+// ListGenerations lists generations, the caller's own for members and the organization's
+// for admins, filtered by status, provider, user, date range and prompt text
 func (h *GenerationHandler) ListGenerations(c *fiber.Ctx) error {
-	userID := middleware.GetUserID(c)
-	_ = userID
-
-	// Get pagination
-	limit, _ := strconv.Atoi(c.Query("limit", "20"))
-	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
+		})
+	}
 
-	if limit > 100 {
-		limit = 100
+	q := service.GenerationQuery{
+		Scope:      c.Query("scope"),
+		UserID:     c.Query("user_id"),
+		Status:     c.Query("status"),
+		ProviderID: c.Query("provider_id"),
+		From:       c.Query("from"),
+		To:         c.Query("to"),
+		Query:      c.Query("q"),
+		Cursor:     c.Query("cursor"),
+		Limit:      c.QueryInt("limit", 20),
 	}
 
-	// TODO: Get orgID from user's profile
-	// generations, err := h.generationService.ListGenerations(c.Context(), orgID, limit, offset)
+	generations, next, err := h.generationService.ListGenerations(c.UserContext(), orgID, userID, middleware.GetRole(c), q)
+	switch {
+	case err == nil:
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admins can list other users' generations"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list generations"})
+	}
 
 	return c.JSON(fiber.Map{
-		"generations": []interface{}{},
-		"limit":       limit,
-		"offset":      offset,
+		"generations": generations,
+		"next_cursor": next,
 	})
 }
 
@@ -112,12 +182,17 @@ func (h *GenerationHandler) GetGeneration(c *fiber.Ctx) error {
 		})
 	}
 
-	gen, images, err := h.generationService.GetGeneration(c.Context(), genID)
-	if err != nil {
+	gen, images, err := h.generationService.GetGeneration(c.UserContext(), genID)
+	if errors.Is(err, service.ErrNotFound) {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Generation not found",
 		})
 	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load generation",
+		})
+	}
 
 	return c.JSON(fiber.Map{
 		"generation": gen,
@@ -125,16 +200,120 @@ func (h *GenerationHandler) GetGeneration(c *fiber.Ctx) error {
 	})
 }
 
-// HandleCallback handles provider callbacks
+// StreamEvents streams a generation's progress as Server-Sent Events. Clients resume from
+// the Last-Event-ID header, or the last_event_id query parameter, after reconnecting.
+func (h *GenerationHandler) StreamEvents(c *fiber.Ctx) error {
+	genID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid generation ID",
+		})
+	}
+
+	gen, _, err := h.generationService.GetGeneration(c.UserContext(), genID)
+	if err != nil || gen.OrganizationID.String() != middleware.GetOrganizationID(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Generation not found",
+		})
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	afterID, _ := strconv.ParseInt(lastEventID, 10, 64)
+	finished := gen.Status == "completed" || gen.Status == "failed"
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// Subscribe before reading the backlog so no event falls in between
+	sub := h.broker.Subscribe(genID)
+	ctx := c.UserContext()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+		h.writeEvents(ctx, w, sub, genID, afterID, finished)
+	})
+	return nil
+}
+
+const (
+	sseHeartbeatInterval = 15 * time.Second
+	sseMaxDuration       = 30 * time.Minute
+	sseBatchSize         = 100
+)
+
+// writeEvents sends every event after afterID, then waits for more until the generation
+// finishes, the client goes away or the stream reaches its maximum duration
+func (h *GenerationHandler) writeEvents(ctx context.Context, w *bufio.Writer, sub *events.Subscription, genID uuid.UUID, afterID int64, finished bool) {
+	ctx, cancel := context.WithTimeout(ctx, sseMaxDuration)
+	defer cancel()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	for first := true; ; first = false {
+		batch, err := h.generationService.ListEvents(ctx, genID, afterID, sseBatchSize)
+		if err != nil {
+			log.Printf("Failed to load events for generation %s: %v", genID, err)
+			return
+		}
+		for _, e := range batch {
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			afterID = e.ID
+			if e.IsFinal() {
+				w.Flush()
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if len(batch) == sseBatchSize {
+			continue
+		}
+		// A generation that finished without a final event has nothing more to send
+		if first && finished {
+			return
+		}
+
+		select {
+		case <-sub.C:
+		case <-heartbeat.C:
+			// Also re-reads events in case a notification was missed
+			if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-sub.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleCallback handles provider callbacks, authenticated by the per-task token in the URL
 func (h *GenerationHandler) HandleCallback(c *fiber.Ctx) error {
 	providerSlug := c.Params("provider")
-	
+
 	body := c.Body()
-	
-	if err := h.generationService.HandleCallback(c.Context(), providerSlug, body); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
+
+	err := h.generationService.HandleCallback(c.UserContext(), providerSlug, c.Query("token"), body)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUnauthorized):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid callback token",
 		})
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		log.Printf("Failed to handle %s callback: %v", providerSlug, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to handle callback"})
 	}
 
 	return c.SendStatus(fiber.StatusOK)
@@ -2,13 +2,18 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/service"
+	"github.com/ner-studio/api/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,12 +61,17 @@ func TestAuthCallback_Validation(t *testing.T) {
 		{
 			name:       "Empty body",
 			body:       map[string]string{},
-			wantStatus: http.StatusOK, // Handler accepts empty body currently
+			wantStatus: http.StatusBadRequest,
 		},
 		{
-			name:       "With access token",
-			body:       map[string]string{"access_token": "test_token"},
-			wantStatus: http.StatusOK,
+			name:       "Missing state",
+			body:       map[string]string{"code": "test_code"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Missing code",
+			body:       map[string]string{"state": "test_state"},
+			wantStatus: http.StatusBadRequest,
 		},
 	}
 
@@ -78,6 +88,25 @@ func TestAuthCallback_Validation(t *testing.T) {
 	}
 }
 
+func TestStartSSO_UnknownProvider(t *testing.T) {
+	app := setupTestApp()
+
+	handler := &AuthHandler{ssoService: service.NewSSOService(nil, nil, nil)}
+	app.Get("/api/v1/auth/oidc/providers", handler.ListSSOProviders)
+	app.Post("/api/v1/auth/oidc/:provider", handler.StartSSO)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/oidc/unknown", nil)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/providers", nil)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"providers":[]}`, string(body))
+}
+
 func TestCreateGeneration_Validation(t *testing.T) {
 	app := setupTestApp()
 	
@@ -102,13 +131,23 @@ func TestCreateGeneration_Validation(t *testing.T) {
 			wantStatus: http.StatusBadRequest,
 			wantErr:    true,
 		},
+		{
+			name: "Image URLs instead of uploads",
+			body: map[string]interface{}{
+				"base_prompt":    "test",
+				"provider_id":    "550e8400-e29b-41d4-a716-446655440000",
+				"product_images": []string{"https://example.com/shoe.png"},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantErr:    true,
+		},
 		{
 			name: "Valid request",
 			body: map[string]interface{}{
 				"base_prompt": "A beautiful sunset",
 				"provider_id": "550e8400-e29b-41d4-a716-446655440000",
 			},
-			wantStatus: http.StatusBadRequest, // Will fail due to missing service
+			wantStatus: http.StatusForbidden, // No organization in context
 			wantErr:    true,
 		},
 	}
@@ -122,6 +161,7 @@ func TestCreateGeneration_Validation(t *testing.T) {
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 			
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
 			if tt.wantErr {
 				assert.NotEqual(t, http.StatusOK, resp.StatusCode)
 			}
@@ -224,3 +264,73 @@ func TestJWTValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageRoutes(t *testing.T) {
+	store, err := storage.NewLocalStore(t.TempDir(), "", "secret")
+	assert.NoError(t, err)
+	handler := NewStorageHandler(store)
+
+	app := setupTestApp()
+	app.Get(storage.LocalRoute+"*", handler.Get)
+	app.Put(storage.LocalRoute+"*", handler.Put)
+
+	key := "org-1/references/1_a b.png"
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	uploadURL, err := store.PresignPut(context.Background(), key, "image/png", int64(len(png)), time.Minute)
+	assert.NoError(t, err)
+
+	put := func(url, contentType string, body []byte) int {
+		req := httptest.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusForbidden, put(uploadURL, "image/jpeg", png))
+	assert.Equal(t, http.StatusForbidden, put(uploadURL, "image/png", append(png, '0')))
+	assert.Equal(t, http.StatusForbidden, put(store.URL(key), "image/png", png))
+	assert.Equal(t, http.StatusOK, put(uploadURL, "image/png", png))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, store.URL(key), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Cache-Control"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, png, body)
+
+	// Private objects are served through expiring URLs, and cached no longer than that
+	privateURL, err := store.PresignGet(context.Background(), key, time.Minute)
+	assert.NoError(t, err)
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, privateURL, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Cache-Control"), "private, max-age=")
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, store.URL("org-1/references/other.png"), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, storage.LocalRoute+"org-1/references/1_a%20b.png", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestCompleteUpload_InvalidID(t *testing.T) {
+	app := setupTestApp()
+	handler := &UploadHandler{}
+	app.Post("/api/v1/uploads/:id/complete", func(c *fiber.Ctx) error {
+		c.Locals(string(middleware.OrganizationIDKey), "11111111-1111-1111-1111-111111111111")
+		c.Locals(string(middleware.UserIDKey), "22222222-2222-2222-2222-222222222222")
+		return c.Next()
+	}, handler.CompleteUpload)
+
+	// Uploads are completed by the ID returned when they were started, not by key
+	resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/api/v1/uploads/org%2Fproducts%2F1_shoe.png/complete", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodPost, "/api/v1/uploads/org/products/1_shoe.png/complete", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/middleware"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/service"
+)
+
+// MemberHandler handles organization member and invitation endpoints
+type MemberHandler struct {
+	memberService *service.MemberService
+}
+
+// NewMemberHandler creates a new member handler
+func NewMemberHandler(memberService *service.MemberService) *MemberHandler {
+	return &MemberHandler{
+		memberService: memberService,
+	}
+}
+
+// InviteMemberRequest request body
+type InviteMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"` // admin or member, defaults to member
+}
+
+// UpdateMemberRequest request body
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// ListMembers lists the members of the admin's organization
+func (h *MemberHandler) ListMembers(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+
+	members, err := h.memberService.ListMembers(c.UserContext(), orgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list members",
+		})
+	}
+
+	return c.JSON(fiber.Map{"members": members})
+}
+
+// UpdateMember changes a member's role
+func (h *MemberHandler) UpdateMember(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	profileID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid member ID",
+		})
+	}
+
+	var req UpdateMemberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.memberService.UpdateMemberRole(c.UserContext(), orgID, profileID, req.Role); err != nil {
+		return memberError(c, err, "Member not found")
+	}
+
+	return c.JSON(fiber.Map{"id": profileID, "role": req.Role})
+}
+
+// RemoveMember removes a member from the organization
+func (h *MemberHandler) RemoveMember(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	profileID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid member ID",
+		})
+	}
+
+	if err := h.memberService.RemoveMember(c.UserContext(), orgID, profileID); err != nil {
+		return memberError(c, err, "Member not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// InviteMember sends an invitation to join the organization
+func (h *MemberHandler) InviteMember(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	userID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Not authenticated",
+		})
+	}
+
+	var req InviteMemberRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	inv, err := h.memberService.InviteMember(c.UserContext(), orgID, userID, req.Email, req.Role)
+	if err != nil {
+		return memberError(c, err, "Organization not found")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"invitation": invitationResponse(inv)})
+}
+
+// ListInvitations lists the organization's invitations
+func (h *MemberHandler) ListInvitations(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+
+	invitations, err := h.memberService.ListInvitations(c.UserContext(), orgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list invitations",
+		})
+	}
+
+	resp := make([]fiber.Map, 0, len(invitations))
+	for _, inv := range invitations {
+		resp = append(resp, invitationResponse(inv))
+	}
+	return c.JSON(fiber.Map{"invitations": resp})
+}
+
+// RevokeInvitation revokes an open invitation
+func (h *MemberHandler) RevokeInvitation(c *fiber.Ctx) error {
+	orgID, err := uuid.Parse(middleware.GetOrganizationID(c))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "No organization",
+		})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invitation ID",
+		})
+	}
+
+	if err := h.memberService.RevokeInvitation(c.UserContext(), orgID, id); err != nil {
+		return memberError(c, err, "Open invitation not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func invitationResponse(inv *model.Invitation) fiber.Map {
+	return fiber.Map{
+		"id":         inv.ID,
+		"email":      inv.Email,
+		"role":       inv.Role,
+		"status":     inv.Status(),
+		"invited_by": inv.InvitedBy,
+		"expires_at": inv.ExpiresAt,
+		"created_at": inv.CreatedAt,
+	}
+}
+
+// memberError maps service errors to HTTP responses
+func memberError(c *fiber.Ctx, err error, notFound string) error {
+	switch {
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": notFound})
+	case errors.Is(err, service.ErrConflict):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+}
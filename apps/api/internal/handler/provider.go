@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/service"
+)
+
+// ProviderHandler handles admin provider management endpoints
+type ProviderHandler struct {
+	providerService *service.ProviderService
+}
+
+// NewProviderHandler creates a new provider handler
+func NewProviderHandler(providerService *service.ProviderService) *ProviderHandler {
+	return &ProviderHandler{
+		providerService: providerService,
+	}
+}
+
+// CreateProviderRequest request body
+type CreateProviderRequest struct {
+	Slug       string               `json:"slug"`
+	Name       string               `json:"name"`
+	Category   string               `json:"category"`
+	Driver     string               `json:"driver"`
+	APIKey     string               `json:"api_key"`
+	BaseURL    string               `json:"base_url"`
+	Model      string               `json:"model"`
+	Config     model.ProviderConfig `json:"config"`
+	Priority   int                  `json:"priority"`
+	IsActive   *bool                `json:"is_active"`
+	CostPerUse int64                `json:"cost_per_use"`
+}
+
+// UpdateProviderRequest request body; omitted fields are left unchanged
+type UpdateProviderRequest struct {
+	Name       *string               `json:"name"`
+	Driver     *string               `json:"driver"`
+	APIKey     *string               `json:"api_key"`
+	BaseURL    *string               `json:"base_url"`
+	Model      *string               `json:"model"`
+	Config     *model.ProviderConfig `json:"config"`
+	Priority   *int                  `json:"priority"`
+	IsActive   *bool                 `json:"is_active"`
+	CostPerUse *int64                `json:"cost_per_use"`
+}
+
+// ListProviders lists all providers, optionally filtered by category
+func (h *ProviderHandler) ListProviders(c *fiber.Ctx) error {
+	providers, err := h.providerService.ListProviders(c.UserContext(), c.Query("category"), false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list providers",
+		})
+	}
+
+	return c.JSON(fiber.Map{"providers": providers})
+}
+
+// CreateProvider adds a new provider
+func (h *ProviderHandler) CreateProvider(c *fiber.Ctx) error {
+	var req CreateProviderRequest
+	if err := decodeStrict(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	p, err := h.providerService.CreateProvider(c.UserContext(), service.CreateProviderRequest{
+		Slug:       req.Slug,
+		Name:       req.Name,
+		Category:   req.Category,
+		Driver:     req.Driver,
+		APIKey:     req.APIKey,
+		BaseURL:    req.BaseURL,
+		Model:      req.Model,
+		Config:     req.Config,
+		Priority:   req.Priority,
+		IsActive:   isActive,
+		CostPerUse: req.CostPerUse,
+	})
+	if err != nil {
+		return providerError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"provider": p})
+}
+
+// UpdateProvider changes the whitelisted fields of a provider
+func (h *ProviderHandler) UpdateProvider(c *fiber.Ctx) error {
+	var req UpdateProviderRequest
+	if err := decodeStrict(c.Body(), &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body: " + err.Error(),
+		})
+	}
+
+	p, err := h.providerService.UpdateProvider(c.UserContext(), c.Params("slug"), service.UpdateProviderRequest{
+		Name:       req.Name,
+		Driver:     req.Driver,
+		APIKey:     req.APIKey,
+		BaseURL:    req.BaseURL,
+		Model:      req.Model,
+		Config:     req.Config,
+		Priority:   req.Priority,
+		IsActive:   req.IsActive,
+		CostPerUse: req.CostPerUse,
+	})
+	if err != nil {
+		return providerError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"provider": p})
+}
+
+// DeleteProvider removes a provider that has never been used
+func (h *ProviderHandler) DeleteProvider(c *fiber.Ctx) error {
+	if err := h.providerService.DeleteProvider(c.UserContext(), c.Params("slug")); err != nil {
+		return providerError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// TestProvider runs a connectivity check against a provider
+func (h *ProviderHandler) TestProvider(c *fiber.Ctx) error {
+	result, err := h.providerService.TestProvider(c.UserContext(), c.Params("slug"))
+	if err != nil {
+		return providerError(c, err)
+	}
+
+	return c.JSON(result)
+}
+
+// providerError maps service errors to HTTP responses
+func providerError(c *fiber.Ctx, err error) error {
+	switch {
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Provider not found"})
+	case errors.Is(err, service.ErrConflict):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+	}
+}
+
+// decodeStrict decodes a JSON body and rejects unknown fields, so typos and immutable
+// fields such as slug are reported instead of silently ignored
+func decodeStrict(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
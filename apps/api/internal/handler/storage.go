@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ner-studio/api/internal/storage"
+)
+
+// StorageHandler serves the objects of a local store, behind the signed URLs the store
+// hands out
+type StorageHandler struct {
+	store *storage.LocalStore
+}
+
+// NewStorageHandler creates a new storage handler
+func NewStorageHandler(store *storage.LocalStore) *StorageHandler {
+	return &StorageHandler{
+		store: store,
+	}
+}
+
+// verify returns the key and query of a request whose signature is valid for its method,
+// and how long the signature stays valid (0 if it does not expire), or responds 403
+func (h *StorageHandler) verify(c *fiber.Ctx) (string, url.Values, time.Duration, bool) {
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid key",
+		})
+		return "", nil, 0, false
+	}
+	var remaining time.Duration
+	params, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err == nil {
+		remaining, err = h.store.Verify(c.Method(), key, params, time.Now())
+	}
+	if err != nil {
+		c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Invalid or expired signature",
+		})
+		return "", nil, 0, false
+	}
+	return key, params, remaining, true
+}
+
+// Get serves an object. Objects read through an expiring URL may be cached privately
+// until the URL expires.
+func (h *StorageHandler) Get(c *fiber.Ctx) error {
+	key, _, remaining, ok := h.verify(c)
+	if !ok {
+		return nil
+	}
+
+	info, err := h.store.Stat(c.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Object not found",
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to read object %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read object",
+		})
+	}
+	body, err := h.store.Get(c.Context(), key)
+	if err != nil {
+		log.Printf("Failed to read object %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to read object",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, info.ContentType)
+	if remaining > 0 {
+		c.Set(fiber.HeaderCacheControl, storage.CacheControl(remaining))
+	}
+	return c.SendStream(body, int(info.Size))
+}
+
+// Put stores an object sent to a presigned upload URL. The body must have the signed
+// content type and size.
+func (h *StorageHandler) Put(c *fiber.Ctx) error {
+	key, params, _, ok := h.verify(c)
+	if !ok {
+		return nil
+	}
+
+	contentType := params.Get("content_type")
+	if c.Get(fiber.HeaderContentType) != contentType {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Content-Type does not match the signed upload",
+		})
+	}
+	body := c.Body()
+	if strconv.Itoa(len(body)) != params.Get("size") {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Size does not match the signed upload",
+		})
+	}
+
+	if _, err := h.store.Put(c.Context(), key, bytes.NewReader(body), contentType); err != nil {
+		log.Printf("Failed to store object %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store object",
+		})
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"errors"
+	"log"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/ner-studio/api/internal/middleware"
+	"github.com/google/uuid"
 	"github.com/ner-studio/api/internal/service"
 )
 
@@ -18,16 +21,11 @@ func NewUploadHandler(uploadService *service.UploadService) *UploadHandler {
 	}
 }
 
-// UploadImage handles image uploads
+// UploadImage handles image uploads sent through the API
 func (h *UploadHandler) UploadImage(c *fiber.Ctx) error {
-	_ = middleware.GetUserID(c)
-	orgID := middleware.GetOrganizationID(c)
-
-	// TODO: Get orgID from profile
-	if orgID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Organization not found",
-		})
+	orgID, userID, ok := adminContext(c)
+	if !ok {
+		return nil
 	}
 
 	// Get folder type
@@ -61,25 +59,89 @@ func (h *UploadHandler) UploadImage(c *fiber.Ctx) error {
 	defer fileReader.Close()
 
 	// Upload
-	result, err := h.uploadService.UploadImage(c.Context(), orgID, folder, file.Filename, fileReader, file.Size)
+	result, err := h.uploadService.UploadImage(c.UserContext(), orgID, userID, folder, file.Filename, fileReader, file.Size)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return uploadError(c, err)
 	}
 
 	return c.JSON(fiber.Map{
-		"url":      result.URL,
-		"key":      result.Key,
-		"filename": result.Filename,
+		"id":         result.ID,
+		"url":        result.URL,
+		"key":        result.Key,
+		"filename":   result.Filename,
+		"renditions": result.Renditions,
 	})
 }
 
+// GetUploadURLRequest describes the image a client is about to upload
+type GetUploadURLRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Folder      string `json:"folder"`
+}
+
 // GetUploadURL returns a presigned URL for direct upload
 func (h *UploadHandler) GetUploadURL(c *fiber.Ctx) error {
-	// TODO: Implement presigned URL generation
-	return c.JSON(fiber.Map{
-		"upload_url": "",
-		"fields":     fiber.Map{},
+	orgID, userID, ok := adminContext(c)
+	if !ok {
+		return nil
+	}
+
+	var req GetUploadURLRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	presigned, err := h.uploadService.PresignUpload(c.UserContext(), service.PresignUploadRequest{
+		OrganizationID: orgID,
+		UserID:         userID,
+		Folder:         req.Folder,
+		Filename:       req.Filename,
+		ContentType:    req.ContentType,
+		Size:           req.Size,
 	})
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(presigned)
+}
+
+// CompleteUpload checks an image uploaded with a presigned URL and makes it usable in
+// generations
+func (h *UploadHandler) CompleteUpload(c *fiber.Ctx) error {
+	orgID, _, ok := adminContext(c)
+	if !ok {
+		return nil
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid upload ID",
+		})
+	}
+
+	upload, err := h.uploadService.CompleteUpload(c.UserContext(), orgID, id)
+	if err != nil {
+		return uploadError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"upload": upload})
+}
+
+// uploadError maps service errors to HTTP responses
+func uploadError(c *fiber.Ctx, err error) error {
+	switch {
+	case service.IsValidationError(err):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Upload not found"})
+	default:
+		log.Printf("Upload failed: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Upload failed"})
+	}
 }
@@ -0,0 +1,204 @@
+// Package imaging validates and normalizes uploaded images before they are stored.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+
+	// Register the remaining accepted formats with image.Decode
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// jpegQuality is used whenever an image is written as JPEG
+const jpegQuality = 90
+
+// Output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for data that is not a JPEG, PNG, GIF or WebP image
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrInvalidImage is returned for an image that cannot be decoded
+	ErrInvalidImage = errors.New("invalid image")
+	// ErrTooLarge is returned for an image with more pixels than allowed
+	ErrTooLarge = errors.New("image dimensions exceed the limit")
+)
+
+// Options controls how images are validated and normalized
+type Options struct {
+	// MaxPixels is the largest width × height decoded; larger images are rejected from
+	// their header alone, before any pixel data is read
+	MaxPixels int
+	// MaxLongEdge scales images down so that their longer side fits; 0 keeps the size
+	MaxLongEdge int
+	// Format is the format images are written in: FormatJPEG, FormatPNG, or "" to keep
+	// JPEG images as JPEG and write every other format as PNG. There is no pure-Go WebP
+	// encoder, so WebP uploads are decoded but never written back as WebP.
+	Format string
+}
+
+// Validate checks that the options can be used
+func (o Options) Validate() error {
+	switch o.Format {
+	case "", FormatJPEG, FormatPNG:
+	default:
+		return fmt.Errorf("unsupported output format %q (use jpeg, png or leave empty)", o.Format)
+	}
+	if o.MaxPixels < 0 || o.MaxLongEdge < 0 {
+		return errors.New("pixel limits cannot be negative")
+	}
+	return nil
+}
+
+// Image is a normalized image
+type Image struct {
+	Data        []byte
+	ContentType string
+	Format      string // jpeg, png
+	Width       int
+	Height      int
+	// PHash is a perceptual hash of the image: visually similar images have hashes a small
+	// Hamming distance apart
+	PHash string
+}
+
+// Normalize decodes an image, applies its EXIF orientation, scales it down to the
+// configured long edge and encodes it again. Re-encoding drops all metadata, including
+// EXIF and GPS tags.
+func Normalize(data []byte, opts Options) (*Image, error) {
+	img, format, err := decode(data, opts.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	img = resize(img, opts.MaxLongEdge)
+
+	outFormat := opts.Format
+	if outFormat == "" {
+		outFormat = FormatPNG
+		if format == "jpeg" {
+			outFormat = FormatJPEG
+		}
+	}
+	out, err := encode(img, outFormat, jpegQuality)
+	if err != nil {
+		return nil, err
+	}
+	out.PHash = fmt.Sprintf("%016x", DHash(img))
+	return out, nil
+}
+
+// decode reads an image upright, refusing images of more than maxPixels from their header
+func decode(data []byte, maxPixels int) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", fmt.Errorf("%w: empty image", ErrInvalidImage)
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, "", fmt.Errorf("%w: %dx%d is more than %d pixels", ErrTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// encode writes an image in one of the output formats
+func encode(img image.Image, format string, quality int) (*Image, error) {
+	var buf bytes.Buffer
+	var contentType string
+	var err error
+	switch format {
+	case FormatJPEG:
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		contentType = "image/png"
+		err = png.Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	b := img.Bounds()
+	return &Image{
+		Data:        buf.Bytes(),
+		ContentType: contentType,
+		Format:      format,
+		Width:       b.Dx(),
+		Height:      b.Dy(),
+	}, nil
+}
+
+// resize scales img down so that its longer side is at most longEdge
+func resize(img image.Image, longEdge int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if longEdge <= 0 || (w <= longEdge && h <= longEdge) {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*longEdge/w)
+		w = longEdge
+	} else {
+		w = max(1, w*longEdge/h)
+		h = longEdge
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// flatten puts an image with transparency on a white background, as JPEG has no alpha
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// DHash computes the difference hash of an image: the image is reduced to 9×8 grey pixels
+// and each bit tells whether a pixel is brighter than its right neighbour
+func DHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
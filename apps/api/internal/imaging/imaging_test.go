@@ -0,0 +1,193 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gradient draws an image whose pixels differ, so scaling and rotation are observable
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+// withOrientation inserts an EXIF segment carrying an orientation and a GPS marker right
+// after the start of a JPEG image
+func withOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0x00, 0x01) // one entry
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], exifOrientationTag)
+	binary.BigEndian.PutUint16(entry[2:], 3) // SHORT
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, []byte("GPS 48.8584N 2.2945E")...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// pngHeader returns the start of a PNG image claiming the given dimensions
+func pngHeader(w, h uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+
+	chunk := []byte{0, 0, 0, 13}
+	chunk = append(chunk, "IHDR"...)
+	chunk = append(chunk, ihdr...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+	return append([]byte("\x89PNG\r\n\x1a\n"), chunk...)
+}
+
+func TestNormalize_RejectsNonImages(t *testing.T) {
+	_, err := Normalize([]byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n"), Options{})
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	truncated := encodePNG(t, gradient(20, 20))
+	_, err = Normalize(truncated[:len(truncated)/2], Options{})
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestNormalize_RejectsTooManyPixelsBeforeDecoding(t *testing.T) {
+	// Only the header exists: the image must be refused without reading pixel data
+	_, err := Normalize(pngHeader(20000, 20000), Options{MaxPixels: 40_000_000})
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestNormalize_ScalesDownToLongEdge(t *testing.T) {
+	img, err := Normalize(encodePNG(t, gradient(400, 200)), Options{MaxLongEdge: 100})
+	require.NoError(t, err)
+	assert.Equal(t, 100, img.Width)
+	assert.Equal(t, 50, img.Height)
+	assert.Equal(t, FormatPNG, img.Format)
+	assert.Equal(t, "image/png", img.ContentType)
+
+	cfg, err := png.DecodeConfig(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Width)
+	assert.Equal(t, 50, cfg.Height)
+
+	small, err := Normalize(encodePNG(t, gradient(40, 30)), Options{MaxLongEdge: 100})
+	require.NoError(t, err)
+	assert.Equal(t, 40, small.Width, "smaller images are not enlarged")
+}
+
+func TestNormalize_ConvertsToJPEG(t *testing.T) {
+	src := gradient(64, 64)
+	src.Set(0, 0, color.NRGBA{}) // transparent pixel
+
+	img, err := Normalize(encodePNG(t, src), Options{Format: FormatJPEG})
+	require.NoError(t, err)
+	assert.Equal(t, FormatJPEG, img.Format)
+	assert.Equal(t, "image/jpeg", img.ContentType)
+
+	_, err = jpeg.Decode(bytes.NewReader(img.Data))
+	assert.NoError(t, err)
+}
+
+func TestNormalize_StripsEXIFAndAppliesOrientation(t *testing.T) {
+	data := withOrientation(encodeJPEG(t, gradient(40, 20)), 6)
+	require.Equal(t, 6, jpegOrientation(data))
+
+	img, err := Normalize(data, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, FormatJPEG, img.Format)
+	assert.Equal(t, 20, img.Width)
+	assert.Equal(t, 40, img.Height)
+	assert.NotContains(t, string(img.Data), "Exif")
+	assert.NotContains(t, string(img.Data), "GPS")
+	assert.Equal(t, 1, jpegOrientation(img.Data))
+}
+
+func TestJPEGOrientation_IgnoresMalformedMetadata(t *testing.T) {
+	assert.Equal(t, 1, jpegOrientation(nil))
+	assert.Equal(t, 1, jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}))
+	assert.Equal(t, 1, jpegOrientation(withOrientation(encodeJPEG(t, gradient(4, 4)), 42)))
+}
+
+func TestOrient(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		want        [][]color.NRGBA // rows
+	}{
+		{1, [][]color.NRGBA{{red, blue}}},
+		{2, [][]color.NRGBA{{blue, red}}},
+		{3, [][]color.NRGBA{{blue, red}}},
+		{4, [][]color.NRGBA{{red, blue}}},
+		{5, [][]color.NRGBA{{red}, {blue}}},
+		{6, [][]color.NRGBA{{red}, {blue}}},
+		{7, [][]color.NRGBA{{blue}, {red}}},
+		{8, [][]color.NRGBA{{blue}, {red}}},
+	}
+
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		require.Equal(t, len(tt.want), got.Bounds().Dy(), "orientation %d", tt.orientation)
+		for y, row := range tt.want {
+			require.Equal(t, len(row), got.Bounds().Dx(), "orientation %d", tt.orientation)
+			for x, want := range row {
+				assert.Equal(t, want, color.NRGBAModel.Convert(got.At(x, y)), "orientation %d at %d,%d", tt.orientation, x, y)
+			}
+		}
+	}
+}
+
+func TestDHash(t *testing.T) {
+	original := gradient(200, 100)
+	original.Set(10, 10, color.NRGBA{A: 255})
+
+	a, err := Normalize(encodePNG(t, original), Options{})
+	require.NoError(t, err)
+	scaled, err := Normalize(encodePNG(t, original), Options{MaxLongEdge: 50, Format: FormatJPEG})
+	require.NoError(t, err)
+	assert.Equal(t, a.PHash, scaled.PHash, "a smaller copy hashes the same")
+	assert.Len(t, a.PHash, 16)
+
+	mirrored := orient(original, 2)
+	assert.NotEqual(t, DHash(original), DHash(mirrored))
+}
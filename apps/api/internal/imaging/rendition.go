@@ -0,0 +1,80 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rendition names; the original is served as stored
+const (
+	RenditionThumbnail = "thumbnail"
+	RenditionMedium    = "medium"
+	RenditionOriginal  = "original"
+)
+
+// RenditionSpec describes a smaller copy of an image
+type RenditionSpec struct {
+	Name     string
+	LongEdge int
+	Format   string
+	Quality  int // JPEG only
+}
+
+// DefaultRenditions returns the thumbnail and medium renditions with the given long edges
+func DefaultRenditions(thumbnailEdge, mediumEdge int) []RenditionSpec {
+	return []RenditionSpec{
+		{Name: RenditionThumbnail, LongEdge: thumbnailEdge, Format: FormatJPEG, Quality: 80},
+		{Name: RenditionMedium, LongEdge: mediumEdge, Format: FormatJPEG, Quality: 85},
+	}
+}
+
+// String identifies everything that affects the rendition's pixels
+func (s RenditionSpec) String() string {
+	if s.Format == FormatJPEG {
+		return fmt.Sprintf("%s-%d-q%d", s.Name, s.LongEdge, s.Quality)
+	}
+	return fmt.Sprintf("%s-%d", s.Name, s.LongEdge)
+}
+
+// Key returns where the rendition of the image stored at original goes: next to it, under
+// a name that changes with the spec, so a changed spec never serves a cached old file
+func (s RenditionSpec) Key(original string) string {
+	ext := ".png"
+	if s.Format == FormatJPEG {
+		ext = ".jpg"
+	}
+	return strings.TrimSuffix(original, path.Ext(original)) + "." + s.String() + ext
+}
+
+// RenditionsVersion fingerprints a set of specs; images rendered with another version are
+// due for regeneration
+func RenditionsVersion(specs []RenditionSpec) string {
+	h := sha256.New()
+	for _, s := range specs {
+		h.Write([]byte(s.String()))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Render decodes an image once and encodes each rendition of it, by name. Images are
+// scaled down to the rendition's long edge but never enlarged.
+func Render(data []byte, specs []RenditionSpec, maxPixels int) (map[string]*Image, error) {
+	img, _, err := decode(data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	renditions := make(map[string]*Image, len(specs))
+	for _, spec := range specs {
+		out, err := encode(resize(img, spec.LongEdge), spec.Format, spec.Quality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", spec.Name, err)
+		}
+		renditions[spec.Name] = out
+	}
+	return renditions, nil
+}
@@ -0,0 +1,54 @@
+package imaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenditionKey(t *testing.T) {
+	specs := DefaultRenditions(320, 1280)
+	original := "org/generations/gen/img.png"
+
+	assert.Equal(t, "org/generations/gen/img.thumbnail-320-q80.jpg", specs[0].Key(original))
+	assert.Equal(t, "org/generations/gen/img.medium-1280-q85.jpg", specs[1].Key(original))
+	assert.Equal(t, specs[0].Key(original), specs[0].Key(original), "keys are deterministic")
+
+	png := RenditionSpec{Name: "preview", LongEdge: 64, Format: FormatPNG}
+	assert.Equal(t, "uploads/shoe.preview-64.png", png.Key("uploads/shoe.jpg"))
+}
+
+func TestRenditionsVersion(t *testing.T) {
+	v := RenditionsVersion(DefaultRenditions(320, 1280))
+	assert.Len(t, v, 12)
+	assert.Equal(t, v, RenditionsVersion(DefaultRenditions(320, 1280)))
+	assert.NotEqual(t, v, RenditionsVersion(DefaultRenditions(400, 1280)))
+
+	changed := DefaultRenditions(320, 1280)
+	changed[1].Quality = 90
+	assert.NotEqual(t, v, RenditionsVersion(changed))
+}
+
+func TestRender(t *testing.T) {
+	renditions, err := Render(encodePNG(t, gradient(2000, 1000)), DefaultRenditions(320, 1280), 0)
+	require.NoError(t, err)
+	require.Len(t, renditions, 2)
+
+	thumb := renditions[RenditionThumbnail]
+	assert.Equal(t, 320, thumb.Width)
+	assert.Equal(t, 160, thumb.Height)
+	assert.Equal(t, "image/jpeg", thumb.ContentType)
+
+	medium := renditions[RenditionMedium]
+	assert.Equal(t, 1280, medium.Width)
+	assert.Equal(t, 640, medium.Height)
+
+	// Small images are not enlarged
+	renditions, err = Render(encodePNG(t, gradient(100, 80)), DefaultRenditions(320, 1280), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 100, renditions[RenditionMedium].Width)
+
+	_, err = Render([]byte("not an image"), DefaultRenditions(320, 1280), 0)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
@@ -0,0 +1,85 @@
+// Package mailer delivers transactional email such as organization invitations.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the mailer selected by driver: "log" writes messages to the application log,
+// "file" writes each message to a .eml file in dir
+func New(driver, dir string) (Mailer, error) {
+	switch driver {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		return NewFileMailer(dir)
+	default:
+		return nil, fmt.Errorf("unknown mailer driver: %s", driver)
+	}
+}
+
+// LogMailer prints messages to the log instead of sending them; intended for local development
+type LogMailer struct{}
+
+// Send logs the message
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("📧 To: %s | Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes each message to its own file so it can be opened in a mail client
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer creates a file mailer writing into dir, creating it if needed
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if dir == "" {
+		dir = "tmp/mail"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+// Send writes the message as an .eml file
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o644)
+}
+
+// sanitize keeps a recipient address usable as part of a file name
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New("file", dir)
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), Message{
+		To:      "new.member@example.com",
+		Subject: "You're invited",
+		Body:    "Join us: https://app.example.com/invite?token=abc",
+	})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Contains(t, filepath.Base(files[0]), "new.member@example.com")
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: You're invited")
+	assert.Contains(t, string(data), "token=abc")
+}
+
+func TestNew_UnknownDriver(t *testing.T) {
+	_, err := New("carrier-pigeon", "")
+	assert.Error(t, err)
+}
@@ -16,6 +16,7 @@ const (
 	UserIDKey         contextKey = "user_id"
 	OrganizationIDKey contextKey = "organization_id"
 	RoleKey           contextKey = "role"
+	SessionIDKey      contextKey = "session_id"
 	JWTSecretKey      contextKey = "jwt_secret"
 )
 
@@ -80,6 +81,9 @@ func NewAuthMiddleware(config AuthConfig) fiber.Handler {
 		if role, ok := claims["role"].(string); ok {
 			c.Locals(string(RoleKey), role)
 		}
+		if sid, ok := claims["sid"].(string); ok {
+			c.Locals(string(SessionIDKey), sid)
+		}
 
 		return c.Next()
 	}
@@ -127,6 +131,15 @@ func GetRole(c *fiber.Ctx) string {
 	return role
 }
 
+// GetSessionID extracts the session the access token was issued for
+func GetSessionID(c *fiber.Ctx) string {
+	sessionID, ok := c.Locals(string(SessionIDKey)).(string)
+	if !ok {
+		return ""
+	}
+	return sessionID
+}
+
 // RequireAdmin ensures the user is an admin
 func RequireAdmin() fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -140,13 +153,14 @@ func RequireAdmin() fiber.Handler {
 	}
 }
 
-// GenerateJWT creates a new JWT token for a user
-func GenerateJWT(userID, orgID, role string, secret string, expiryHours int) (string, error) {
+// GenerateJWT creates a short-lived access token for a user's session
+func GenerateJWT(userID, orgID, role, sessionID string, secret string, ttl time.Duration) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":    userID,
 		"org_id": orgID,
 		"role":   role,
-		"exp":    time.Now().Add(time.Hour * time.Duration(expiryHours)).Unix(),
+		"sid":    sessionID,
+		"exp":    time.Now().Add(ttl).Unix(),
 		"iat":    time.Now().Unix(),
 	}
 
@@ -14,7 +14,7 @@ func NewCORS(allowOrigins string) fiber.Handler {
 	return cors.New(cors.Config{
 		AllowOrigins:     allowOrigins,
 		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Last-Event-ID",
 		AllowCredentials: true,
 		MaxAge:           86400,
 	})
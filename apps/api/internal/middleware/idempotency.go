@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// IdempotencyKeyHeader lets clients retry a request without running it twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength leaves room for UUIDs and most client-generated keys
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyMiddleware makes a route safe to retry. The first request with an
+// Idempotency-Key runs and its response is stored for the organization; a retry with the
+// same key and request from the same user gets that response back, and the key with a
+// different request or from another user is rejected with 409. Responses that changed nothing and may succeed on retry (server
+// errors, 402 and 429) are not stored.
+// It runs after ProfileMiddleware; requests without the header are passed through.
+func IdempotencyMiddleware(repo *repository.Repository, ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Idempotency-Key must be at most 255 characters",
+			})
+		}
+		orgID, err := uuid.Parse(GetOrganizationID(c))
+		if err != nil {
+			return c.Next()
+		}
+		var userID *uuid.UUID
+		if uid, err := uuid.Parse(GetUserID(c)); err == nil {
+			userID = &uid
+		}
+
+		ctx := c.UserContext()
+		existing, err := repo.ClaimIdempotencyKey(ctx, orgID, key, requestFingerprint(c), userID, ttl)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check idempotency key",
+			})
+		}
+		if existing != nil {
+			return replay(c, existing)
+		}
+
+		if err := c.Next(); err != nil {
+			if releaseErr := repo.ReleaseIdempotencyKey(ctx, orgID, key); releaseErr != nil {
+				log.Printf("Idempotency: failed to release key %q: %v", key, releaseErr)
+			}
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if !storeResponse(status) {
+			if err := repo.ReleaseIdempotencyKey(ctx, orgID, key); err != nil {
+				log.Printf("Idempotency: failed to release key %q: %v", key, err)
+			}
+			return nil
+		}
+
+		// The response buffer is reused once the request is done
+		body := append([]byte(nil), c.Response().Body()...)
+		contentType := string(c.Response().Header.ContentType())
+		if err := repo.CompleteIdempotencyKey(ctx, orgID, key, status, contentType, body); err != nil {
+			// The key stays in progress, so retries are refused rather than run twice
+			log.Printf("Idempotency: failed to store response for key %q: %v", key, err)
+		}
+		return nil
+	}
+}
+
+// storeResponse reports whether a response is final for its key. Server errors, a lack
+// of credits and rate limits depend on conditions that change, so the key is released
+// and a retry runs the request again.
+func storeResponse(status int) bool {
+	return status < fiber.StatusInternalServerError &&
+		status != fiber.StatusPaymentRequired &&
+		status != fiber.StatusTooManyRequests
+}
+
+// replay answers a retry from the request that first used its key
+func replay(c *fiber.Ctx, existing *model.IdempotencyKey) error {
+	if existing.RequestHash != requestFingerprint(c) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Idempotency-Key was already used for a different request",
+		})
+	}
+	if existing.Status != "completed" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A request with this Idempotency-Key is still in progress",
+		})
+	}
+
+	c.Set("Idempotent-Replayed", "true")
+	if existing.ResponseContentType != "" {
+		c.Set(fiber.HeaderContentType, existing.ResponseContentType)
+	}
+	return c.Status(existing.ResponseStatus).Send(existing.ResponseBody)
+}
+
+// requestFingerprint identifies a request by its user, method, URL and body, so a key
+// is never replayed to another member of the organization
+func requestFingerprint(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(GetUserID(c)))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.OriginalURL()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
@@ -15,8 +17,7 @@ func TestAuthMiddleware_PublicRoutes(t *testing.T) {
 	
 	// Setup auth middleware with test config
 	authConfig := AuthConfig{
-		SupabaseURL:     "http://localhost:54321",
-		SupabaseAnonKey: "test-secret-key-for-jwt-validation-in-tests",
+		JWTSecret: "test-secret-key-for-jwt-validation-in-tests",
 	}
 	app.Use(NewAuthMiddleware(authConfig))
 	
@@ -68,8 +69,7 @@ func TestAuthMiddleware_ProtectedRoutes(t *testing.T) {
 	
 	secret := []byte("test-secret-key-for-jwt-validation-in-tests")
 	authConfig := AuthConfig{
-		SupabaseURL:     "http://localhost:54321",
-		SupabaseAnonKey: string(secret),
+		JWTSecret: string(secret),
 	}
 	app.Use(NewAuthMiddleware(authConfig))
 	
@@ -113,6 +113,39 @@ func TestAuthMiddleware_ProtectedRoutes(t *testing.T) {
 	}
 }
 
+func TestAuthMiddleware_AccessTokenClaims(t *testing.T) {
+	const secret = "test-secret-key-for-jwt-validation-in-tests"
+	app := fiber.New()
+	app.Use(NewAuthMiddleware(AuthConfig{JWTSecret: secret}))
+	app.Get("/protected", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"user_id":    GetUserID(c),
+			"org_id":     GetOrganizationID(c),
+			"role":       GetRole(c),
+			"session_id": GetSessionID(c),
+		})
+	})
+
+	token, err := GenerateJWT("user-1", "org-1", "admin", "session-1", secret, time.Minute)
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"user_id":"user-1","org_id":"org-1","role":"admin","session_id":"session-1"}`, string(body))
+
+	// Expired access tokens are rejected
+	expired, err := GenerateJWT("user-1", "org-1", "admin", "session-1", secret, -time.Minute)
+	assert.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+expired)
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 func TestCORSMiddleware(t *testing.T) {
 	app := fiber.New()
 	app.Use(NewCORS("http://localhost:5173,http://localhost:3000"))
@@ -229,3 +262,85 @@ func TestGetUserID(t *testing.T) {
 		})
 	}
 }
+
+func TestTooManyRequests(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return TooManyRequests(c, "Rate limit exceeded, slow down", 1500*time.Millisecond)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	// Waits are rounded up, so clients never retry too early
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, 1, retryAfterSeconds(0))
+	assert.Equal(t, 1, retryAfterSeconds(200*time.Millisecond))
+	assert.Equal(t, 30, retryAfterSeconds(30*time.Second))
+	assert.Equal(t, 31, retryAfterSeconds(30*time.Second+time.Millisecond))
+}
+
+func TestIdempotencyMiddleware_WithoutKey(t *testing.T) {
+	app := fiber.New()
+	// Requests the middleware passes through never touch the repository
+	app.Post("/generations", IdempotencyMiddleware(nil, time.Hour), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusAccepted)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/generations", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	// Without an organization there is nothing to scope the key to
+	req := httptest.NewRequest("POST", "/generations", nil)
+	req.Header.Set(IdempotencyKeyHeader, "retry-1")
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	req = httptest.NewRequest("POST", "/generations", nil)
+	req.Header.Set(IdempotencyKeyHeader, strings.Repeat("k", 256))
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStoreResponse(t *testing.T) {
+	assert.True(t, storeResponse(http.StatusAccepted))
+	assert.True(t, storeResponse(http.StatusBadRequest))
+	assert.False(t, storeResponse(http.StatusPaymentRequired))
+	assert.False(t, storeResponse(http.StatusTooManyRequests))
+	assert.False(t, storeResponse(http.StatusInternalServerError))
+	assert.False(t, storeResponse(http.StatusServiceUnavailable))
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	app := fiber.New()
+	var fingerprints []string
+	app.All("/*", func(c *fiber.Ctx) error {
+		c.Locals(string(UserIDKey), c.Get("X-Test-User"))
+		fingerprints = append(fingerprints, requestFingerprint(c))
+		return nil
+	})
+
+	send := func(user, method, url, body string) {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("X-Test-User", user)
+		_, err := app.Test(req)
+		assert.NoError(t, err)
+	}
+	send("user-1", "POST", "/generations", `{"base_prompt":"a"}`)
+	send("user-1", "POST", "/generations", `{"base_prompt":"a"}`)
+	send("user-1", "POST", "/generations", `{"base_prompt":"b"}`)
+	send("user-1", "PUT", "/generations", `{"base_prompt":"a"}`)
+	send("user-1", "POST", "/admin/credits", `{"base_prompt":"a"}`)
+	send("user-2", "POST", "/generations", `{"base_prompt":"a"}`)
+
+	assert.Equal(t, fingerprints[0], fingerprints[1])
+	for _, other := range fingerprints[2:] {
+		assert.NotEqual(t, fingerprints[0], other)
+	}
+}
@@ -1,7 +1,12 @@
 package middleware
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
 	"github.com/ner-studio/api/internal/repository"
 )
 
@@ -13,17 +18,39 @@ func ProfileMiddleware(repo *repository.Repository) fiber.Handler {
 			return c.Next() // Skip if no user
 		}
 
-		// Load profile
-		profile, err := repo.GetProfileByUserID(c.Context(), userID)
-		if err != nil {
-			// User exists but no profile - let them complete onboarding
+		// Load the profile for the organization in the token, so users who belong to
+		// several organizations act in the one they signed in to
+		var profile *model.Profile
+		var err error
+		uid, uidErr := uuid.Parse(userID)
+		orgID, orgErr := uuid.Parse(GetOrganizationID(c))
+		if uidErr == nil && orgErr == nil {
+			profile, err = repo.GetProfileInOrganization(c.Context(), uid, orgID)
+		} else {
+			profile, err = repo.GetProfileByUserID(c.Context(), userID)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			// No membership (never onboarded, or removed from the org): drop the org and
+			// role claims so a stale token cannot keep acting in the organization
+			c.Locals(string(OrganizationIDKey), "")
+			c.Locals(string(RoleKey), "")
+			// Scope to no organization, so organization data stays out of reach
+			c.SetUserContext(repository.WithOrganization(c.UserContext(), uuid.Nil))
 			return c.Next()
 		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to load profile",
+			})
+		}
 
 		// Set org and role in context
 		c.Locals(string(OrganizationIDKey), profile.OrganizationID.String())
 		c.Locals(string(RoleKey), profile.Role)
 
+		// Scope repository calls made with c.UserContext() to the organization
+		c.SetUserContext(repository.WithOrganization(c.UserContext(), profile.OrganizationID))
+
 		return c.Next()
 	}
 }
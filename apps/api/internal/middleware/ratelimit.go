@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// RateLimitMiddleware limits the requests of an organization and of each of its members per
+// minute, using the organization's limits or defaults. It runs after ProfileMiddleware.
+func RateLimitMiddleware(repo *repository.Repository, defaults model.OrganizationLimits) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := GetUserID(c)
+		if userID == "" {
+			return c.Next()
+		}
+
+		var buckets []repository.RateBucket
+		if orgID, err := uuid.Parse(GetOrganizationID(c)); err == nil {
+			limits, err := repo.GetOrganizationLimits(c.UserContext(), orgID, defaults)
+			if err != nil {
+				log.Printf("Rate limit: failed to load limits of organization %s: %v", orgID, err)
+				return c.Next()
+			}
+			buckets = []repository.RateBucket{
+				{Key: "org:" + orgID.String(), PerMinute: limits.RequestsPerMinute, Limit: repository.LimitRequestsPerMinute},
+				{Key: "org:" + orgID.String() + ":user:" + userID, PerMinute: limits.UserRequestsPerMinute, Limit: repository.LimitUserRequestsPerMinute},
+			}
+		} else {
+			buckets = []repository.RateBucket{
+				{Key: "user:" + userID, PerMinute: defaults.UserRequestsPerMinute, Limit: repository.LimitUserRequestsPerMinute},
+			}
+		}
+
+		err := repo.TakeRateTokens(c.UserContext(), buckets)
+		var limitErr *repository.LimitError
+		if errors.As(err, &limitErr) {
+			return TooManyRequests(c, "Rate limit exceeded, slow down", limitErr.RetryAfter)
+		}
+		if err != nil {
+			// Limits protect the providers, not the API itself; keep serving when they
+			// cannot be checked
+			log.Printf("Rate limit: failed to take tokens for user %s: %v", userID, err)
+		}
+
+		return c.Next()
+	}
+}
+
+// TooManyRequests responds with 429 and a Retry-After header in whole seconds
+func TooManyRequests(c *fiber.Ctx, message string, retryAfter time.Duration) error {
+	seconds := retryAfterSeconds(retryAfter)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, at least one
+func retryAfterSeconds(d time.Duration) int {
+	if d <= time.Second {
+		return 1
+	}
+	return int(math.Ceil(d.Seconds()))
+}
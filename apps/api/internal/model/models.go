@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,7 +35,9 @@ type Provider struct {
 	Slug        string          `json:"slug" db:"slug"`
 	Name        string          `json:"name" db:"name"`
 	Category    string          `json:"category" db:"category"` // image_generation, llm, vision
-	APIKey      string          `json:"-" db:"api_key"`         // encrypted
+	Driver      string          `json:"driver" db:"driver"`     // client implementation, e.g. kieai, gemini, openai
+	APIKey      string          `json:"-" db:"api_key"`         // encrypted at rest, plaintext in memory
+	APIKeyFingerprint string    `json:"api_key_fingerprint,omitempty" db:"-"` // masked, safe to return
 	BaseURL     string          `json:"base_url" db:"base_url"`
 	Model       string          `json:"model" db:"model"`
 	Config      ProviderConfig  `json:"config" db:"config"`
@@ -51,6 +54,15 @@ type ProviderConfig struct {
 	MaxRetries           int      `json:"max_retries,omitempty"`
 	ErrorCodeForFallback []string `json:"error_code_for_fallback,omitempty"`
 	Headers              map[string]string `json:"headers,omitempty"`
+
+	// Image generation capabilities
+	AspectRatios           []string `json:"aspect_ratios,omitempty"` // e.g. "1:1", "16:9"; empty means 1:1 only
+	Qualities              []string `json:"qualities,omitempty"`     // e.g. "standard", "hd"
+	SupportsSeed           bool     `json:"supports_seed,omitempty"`
+	SupportsNegativePrompt bool     `json:"supports_negative_prompt,omitempty"`
+	SupportsImageToImage   bool     `json:"supports_image_to_image,omitempty"` // accepts product images as input
+	MaxInputImages         int      `json:"max_input_images,omitempty"`        // 0 means no limit
+	SupportsStyleReference bool     `json:"supports_style_reference,omitempty"` // takes reference images as style guidance
 }
 
 // Generation represents an image generation request
@@ -62,13 +74,27 @@ type Generation struct {
 	BasePrompt      string    `json:"base_prompt" db:"base_prompt"`
 	ReferenceImages []string  `json:"reference_images" db:"reference_images"`
 	ProductImages   []string  `json:"product_images" db:"product_images"`
+	ReferenceUploadIDs []uuid.UUID `json:"reference_upload_ids" db:"reference_upload_ids"`
+	ProductUploadIDs   []uuid.UUID `json:"product_upload_ids" db:"product_upload_ids"`
 	ProviderID      uuid.UUID `json:"provider_id" db:"provider_id"`
+	Options         GenerationOptions `json:"options" db:"options"`
 	EstimatedCost   int64     `json:"estimated_cost" db:"estimated_cost"`
 	ActualCost      int64     `json:"actual_cost" db:"actual_cost"`
+	NumImages       int       `json:"num_images" db:"num_images"`
 	ErrorMessage    string    `json:"error_message,omitempty" db:"error_message"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
 	CompletedAt     *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	Thumbnails      []string   `json:"thumbnails,omitempty" db:"-"` // thumbnail URLs of completed images, set in listings
+	ThumbnailKeys   []string   `json:"-" db:"-"`                    // keys behind Thumbnails: the thumbnail rendition, or the original when not rendered
+}
+
+// GenerationOptions holds the user-selected image options for a generation
+type GenerationOptions struct {
+	AspectRatio    string `json:"aspect_ratio,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	Seed           *int64 `json:"seed,omitempty"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
 }
 
 // GenerationImage represents a single generated image
@@ -80,35 +106,192 @@ type GenerationImage struct {
 	R2Key         string    `json:"r2_key" db:"r2_key"`
 	Status        string    `json:"status" db:"status"` // pending, processing, completed, failed
 	TaskID        string    `json:"task_id" db:"task_id"` // provider task ID
+	ProviderSlug  string    `json:"provider_slug,omitempty" db:"provider_slug"`
+	SubmittedAt   *time.Time `json:"submitted_at,omitempty" db:"submitted_at"`
+	ContentType   string    `json:"content_type,omitempty" db:"content_type"`
+	SizeBytes     int64     `json:"size_bytes,omitempty" db:"size_bytes"`
+	Checksum      string    `json:"checksum_sha256,omitempty" db:"checksum_sha256"`
 	ErrorMessage  string    `json:"error_message,omitempty" db:"error_message"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 	CompletedAt   *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	RenditionKeys map[string]string `json:"-" db:"renditions"`
+	RenditionsVersion string        `json:"-" db:"renditions_version"`
+	Renditions    map[string]string `json:"renditions,omitempty" db:"-"` // URLs by rendition name
+}
+
+// Generation event types streamed to clients
+const (
+	EventStage               = "stage"                // workflow stage started; data: {"stage"}
+	EventImageCompleted      = "image.completed"      // data: {"image_url", "renditions"}, minted when read
+	EventImageFailed         = "image.failed"         // data: {"error"}
+	EventGenerationCompleted = "generation.completed" // data: {"status", "actual_cost"}
+	EventGenerationFailed    = "generation.failed"    // data: {"status", "error"}
+)
+
+// GenerationEvent is a progress update for a generation
+type GenerationEvent struct {
+	ID           int64           `json:"id" db:"id"`
+	GenerationID uuid.UUID       `json:"generation_id" db:"generation_id"`
+	Type         string          `json:"type" db:"type"`
+	ImageID      *uuid.UUID      `json:"image_id,omitempty" db:"image_id"`
+	Data         json.RawMessage `json:"data" db:"data"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+}
+
+// IsFinal reports whether no more events follow this one
+func (e *GenerationEvent) IsFinal() bool {
+	return e.Type == EventGenerationCompleted || e.Type == EventGenerationFailed
+}
+
+// CallbackInboxEntry is a provider callback as received, kept for deduplication and auditing
+type CallbackInboxEntry struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	ProviderSlug   string     `json:"provider_slug" db:"provider_slug"`
+	TaskID         string     `json:"task_id" db:"task_id"`
+	ImageID        *uuid.UUID `json:"image_id,omitempty" db:"image_id"`
+	Status         string     `json:"status" db:"status"` // received, processed, failed
+	Deliveries     int        `json:"deliveries" db:"deliveries"`
+	ReceivedAt     time.Time  `json:"received_at" db:"received_at"`
+	LastReceivedAt time.Time  `json:"last_received_at" db:"last_received_at"`
+	ProcessedAt    *time.Time `json:"processed_at,omitempty" db:"processed_at"`
+}
+
+// GalleryImage is a completed image as shown in the gallery, with its generation's context
+type GalleryImage struct {
+	ID            uuid.UUID         `json:"id" db:"id"`
+	GenerationID  uuid.UUID         `json:"generation_id" db:"generation_id"`
+	UserID        uuid.UUID         `json:"user_id" db:"user_id"`
+	ProviderID    uuid.UUID         `json:"provider_id" db:"provider_id"`
+	Prompt        string            `json:"prompt" db:"prompt"`
+	BasePrompt    string            `json:"base_prompt" db:"base_prompt"`
+	ImageURL      string            `json:"image_url" db:"image_url"`
+	R2Key         string            `json:"-" db:"r2_key"`
+	ContentType   string            `json:"content_type,omitempty" db:"content_type"`
+	SizeBytes     int64             `json:"size_bytes,omitempty" db:"size_bytes"`
+	Favorite      bool              `json:"favorite" db:"-"` // for the requesting user
+	Tags          []string          `json:"tags" db:"-"`
+	CreatedAt     time.Time         `json:"created_at" db:"created_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty" db:"completed_at"`
+	RenditionKeys map[string]string `json:"-" db:"renditions"`
+	Renditions    map[string]string `json:"renditions,omitempty" db:"-"` // URLs by rendition name
 }
 
 // CreditLedger tracks all credit transactions
 type CreditLedger struct {
-	ID             uuid.UUID `json:"id" db:"id"`
-	OrganizationID uuid.UUID `json:"organization_id" db:"organization_id"`
-	UserID         uuid.UUID `json:"user_id" db:"user_id"`
-	Amount         int64     `json:"amount" db:"amount"` // positive = add, negative = deduct
-	Type           string    `json:"type" db:"type"`     // generation, refund, purchase
-	Description    string    `json:"description" db:"description"`
+	ID             uuid.UUID  `json:"id" db:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id" db:"organization_id"`
+	UserID         *uuid.UUID `json:"user_id,omitempty" db:"user_id"` // nil for system entries
+	Amount         int64      `json:"amount" db:"amount"`             // positive = add, negative = deduct
+	Type           string     `json:"type" db:"type"`                 // generation, refund, purchase, adjustment, topup, hold, release
+	Description    string     `json:"description" db:"description"`
 	GenerationID   *uuid.UUID `json:"generation_id,omitempty" db:"generation_id"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	BalanceAfter   *int64     `json:"balance_after,omitempty" db:"balance_after"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreditHold reserves credits for a generation until it finishes
+type CreditHold struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id" db:"organization_id"`
+	GenerationID   uuid.UUID  `json:"generation_id" db:"generation_id"`
+	UserID         *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Amount         int64      `json:"amount" db:"amount"`
+	Status         string     `json:"status" db:"status"` // held, settled, released, expired
+	SettledAmount  *int64     `json:"settled_amount,omitempty" db:"settled_amount"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Upload is an image a user uploaded for use in generations
+type Upload struct {
+	ID                uuid.UUID         `json:"id" db:"id"`
+	OrganizationID    uuid.UUID         `json:"organization_id" db:"organization_id"`
+	UserID            *uuid.UUID        `json:"user_id,omitempty" db:"user_id"`
+	Key               string            `json:"key" db:"key"`
+	Folder            string            `json:"folder" db:"folder"` // references, products, uploads
+	Filename          string            `json:"filename" db:"filename"`
+	ContentType       string            `json:"content_type" db:"content_type"`
+	SizeBytes         int64             `json:"size_bytes" db:"size_bytes"`
+	Status            string            `json:"status" db:"status"` // pending, completed
+	CreatedAt         time.Time         `json:"created_at" db:"created_at"`
+	ExpiresAt         time.Time         `json:"expires_at" db:"expires_at"`
+	CompletedAt       *time.Time        `json:"completed_at,omitempty" db:"completed_at"`
+	SourceKey         *string           `json:"-" db:"source_key"` // key a presigned upload was sent to
+	Width             int               `json:"width,omitempty" db:"width"`
+	Height            int               `json:"height,omitempty" db:"height"`
+	Format            string            `json:"format,omitempty" db:"format"` // jpeg, png
+	PHash             string            `json:"phash,omitempty" db:"phash"`
+	RenditionKeys     map[string]string `json:"-" db:"renditions"`
+	RenditionsVersion string            `json:"-" db:"renditions_version"`
+	URL               string            `json:"url,omitempty" db:"-"`        // set for completed uploads
+	Renditions        map[string]string `json:"renditions,omitempty" db:"-"` // URLs by rendition name
+}
+
+// OrganizationLimits caps an organization's usage; zero means unlimited
+type OrganizationLimits struct {
+	RequestsPerMinute        int `json:"requests_per_minute"`      // across all members
+	UserRequestsPerMinute    int `json:"user_requests_per_minute"` // per member
+	MaxConcurrentGenerations int `json:"max_concurrent_generations"`
+	MaxImagesPerDay          int `json:"max_images_per_day"` // per UTC day
+}
+
+// IdempotencyKey records a request sent with an Idempotency-Key header and, once it has
+// finished, the response to replay for retries
+type IdempotencyKey struct {
+	OrganizationID      uuid.UUID  `json:"organization_id" db:"organization_id"`
+	Key                 string     `json:"key" db:"key"`
+	RequestHash         string     `json:"-" db:"request_hash"`
+	UserID              *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Status              string     `json:"status" db:"status"` // in_progress, completed
+	ResponseStatus      int        `json:"response_status" db:"response_status"`
+	ResponseContentType string     `json:"-" db:"response_content_type"`
+	ResponseBody        []byte     `json:"-" db:"response_body"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt         *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt           time.Time  `json:"expires_at" db:"expires_at"`
 }
 
 // Invitation represents a pending org invitation
 type Invitation struct {
-	ID             uuid.UUID `json:"id" db:"id"`
-	OrganizationID uuid.UUID `json:"organization_id" db:"organization_id"`
-	Email          string    `json:"email" db:"email"`
-	Role           string    `json:"role" db:"role"`
-	InvitedBy      uuid.UUID `json:"invited_by" db:"invited_by"`
-	Token          string    `json:"-" db:"token"`
-	ExpiresAt      time.Time `json:"expires_at" db:"expires_at"`
+	ID             uuid.UUID  `json:"id" db:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id" db:"organization_id"`
+	Email          string     `json:"email" db:"email"`
+	Role           string     `json:"role" db:"role"`
+	InvitedBy      uuid.UUID  `json:"invited_by" db:"invited_by"`
+	TokenHash      string     `json:"-" db:"token_hash"` // SHA-256 of the emailed token
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
 	UsedAt         *time.Time `json:"used_at,omitempty" db:"used_at"`
-	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	AcceptedBy     *uuid.UUID `json:"accepted_by,omitempty" db:"accepted_by"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Status returns pending, accepted, revoked or expired
+func (i *Invitation) Status() string {
+	switch {
+	case i.UsedAt != nil:
+		return "accepted"
+	case i.RevokedAt != nil:
+		return "revoked"
+	case time.Now().After(i.ExpiresAt):
+		return "expired"
+	default:
+		return "pending"
+	}
+}
+
+// Member is a profile joined with its user's email, as listed to org admins
+type Member struct {
+	ID        uuid.UUID `json:"id"` // profile ID
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	FullName  string    `json:"full_name"`
+	AvatarURL string    `json:"avatar_url"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // User represents an application user
@@ -120,8 +303,61 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Session is a sign-in backing a refresh token
+type Session struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	UserID         uuid.UUID  `json:"user_id" db:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty" db:"organization_id"`
+	UserAgent      string     `json:"user_agent,omitempty" db:"user_agent"`
+	IPAddress      string     `json:"ip_address,omitempty" db:"ip_address"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt     time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	RevokedReason  string     `json:"revoked_reason,omitempty" db:"revoked_reason"`
+}
+
+// Active reports whether the session can still be refreshed at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// OIDCLoginState is an OpenID Connect login waiting for the provider's callback
+type OIDCLoginState struct {
+	StateHash       string    `json:"-" db:"state_hash"`
+	Provider        string    `json:"provider" db:"provider"`
+	Nonce           string    `json:"-" db:"nonce"`
+	CodeVerifier    string    `json:"-" db:"code_verifier"`
+	InviteTokenHash string    `json:"-" db:"invite_token_hash"` // invitation to accept on first login
+	OrgName         string    `json:"org_name,omitempty" db:"org_name"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt       time.Time `json:"expires_at" db:"expires_at"`
+}
+
 // VisionAnalysisResult holds the output from vision analysis
 type VisionAnalysisResult struct {
 	Description string `json:"description"`
 	StyleNotes  string `json:"style_notes"`
 }
+
+// GenerationJob is a durable unit of work driving a generation through the workflow
+type GenerationJob struct {
+	ID           uuid.UUID          `json:"id" db:"id"`
+	GenerationID uuid.UUID          `json:"generation_id" db:"generation_id"`
+	Status       string             `json:"status" db:"status"` // queued, running, completed, failed
+	Stage        string             `json:"stage" db:"stage"`   // vision_analysis, prompt_generation, submission, done
+	State        GenerationJobState `json:"state" db:"state"`
+	Attempts     int                `json:"attempts" db:"attempts"`
+	MaxAttempts  int                `json:"max_attempts" db:"max_attempts"`
+	RunAt        time.Time          `json:"run_at" db:"run_at"`
+	LockedBy     string             `json:"locked_by,omitempty" db:"locked_by"`
+	LockedAt     *time.Time         `json:"locked_at,omitempty" db:"locked_at"`
+	LastError    string             `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt    time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" db:"updated_at"`
+}
+
+// GenerationJobState holds intermediate workflow results so a job can resume
+type GenerationJobState struct {
+	VisionResults []*VisionAnalysisResult `json:"vision_results,omitempty"`
+}
@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ner-studio/api/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var alice = oidctest.User{Subject: "alice-sub", Email: "Alice@Example.com", EmailVerified: true, Name: "Alice"}
+
+func newProvider(iss *oidctest.Issuer) *Provider {
+	return NewProvider(Config{
+		Name:         "mock",
+		Issuer:       iss.URL(),
+		ClientID:     iss.ClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/auth/callback",
+	}, nil)
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	iss := oidctest.NewIssuer("client-1")
+	defer iss.Close()
+	p := newProvider(iss)
+	ctx := context.Background()
+
+	verifier, err := NewCodeVerifier()
+	require.NoError(t, err)
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", CodeChallenge(verifier))
+	require.NoError(t, err)
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, iss.URL()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+
+	code, state, err := iss.Authorize(authURL, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", state)
+
+	idToken, err := p.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+	claims, err := p.VerifyIDToken(ctx, idToken, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice-sub", claims.Subject)
+	assert.Equal(t, "alice@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, "Alice", claims.Name)
+
+	// Codes are single use
+	_, err = p.Exchange(ctx, code, verifier)
+	assert.Error(t, err)
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	iss := oidctest.NewIssuer("client-1")
+	defer iss.Close()
+	p := newProvider(iss)
+	ctx := context.Background()
+
+	verifier, _ := NewCodeVerifier()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", CodeChallenge(verifier))
+	require.NoError(t, err)
+	code, _, err := iss.Authorize(authURL, alice)
+	require.NoError(t, err)
+
+	other, _ := NewCodeVerifier()
+	_, err = p.Exchange(ctx, code, other)
+	assert.ErrorContains(t, err, "PKCE")
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	iss := oidctest.NewIssuer("client-1")
+	defer iss.Close()
+	p := newProvider(iss)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"nonce mismatch", iss.IDToken(alice, "client-1", "nonce-1", time.Hour), "nonce-2"},
+		{"missing nonce", iss.IDToken(alice, "client-1", "", time.Hour), "nonce-1"},
+		{"other audience", iss.IDToken(alice, "client-2", "nonce-1", time.Hour), "nonce-1"},
+		{"expired", iss.IDToken(alice, "client-1", "nonce-1", -time.Hour), "nonce-1"},
+		{"garbage", "not.a.token", "nonce-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.VerifyIDToken(ctx, tt.token, tt.nonce)
+			assert.Error(t, err)
+		})
+	}
+
+	// A token signed by another issuer's key is rejected
+	other := oidctest.NewIssuer("client-1")
+	defer other.Close()
+	_, err := p.VerifyIDToken(ctx, other.IDToken(alice, "client-1", "nonce-1", time.Hour), "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestVerifyIDTokenFetchesRotatedKeys(t *testing.T) {
+	iss := oidctest.NewIssuer("client-1")
+	defer iss.Close()
+	p := newProvider(iss)
+	ctx := context.Background()
+
+	_, err := p.VerifyIDToken(ctx, iss.IDToken(alice, "client-1", "n", time.Hour), "n")
+	require.NoError(t, err)
+
+	// Refetching is rate limited, so a key rotated right after the last fetch is unknown
+	iss.RotateKey()
+	_, err = p.VerifyIDToken(ctx, iss.IDToken(alice, "client-1", "n", time.Hour), "n")
+	assert.ErrorContains(t, err, "unknown signing key")
+
+	p.keysFetched = time.Now().Add(-minKeyRefreshInterval)
+	_, err = p.VerifyIDToken(ctx, iss.IDToken(alice, "client-1", "n", time.Hour), "n")
+	assert.NoError(t, err)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// BASE64URL(SHA256(verifier)) without padding
+	assert.Equal(t, "cAWvsHA4FBv-swKNYxplVVwTps6C3EB0AVsHYJbm7aw",
+		CodeChallenge("dBjftJeZ4CVP-mB92K9uz6ZGIzzAdiEhWzyBuz3v0Jg"))
+}
@@ -0,0 +1,218 @@
+// Package oidctest runs a local OpenID Connect issuer for tests of the login flow
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// User is the identity the issuer signs in
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Issuer is a mock OIDC issuer backed by an httptest server. It serves discovery, a key
+// set and a token endpoint that enforces PKCE; Authorize stands in for the consent screen.
+type Issuer struct {
+	Server   *httptest.Server
+	ClientID string
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	codes  map[string]grant
+	serial int
+}
+
+type grant struct {
+	user          User
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// NewIssuer starts an issuer that accepts clientID; close it with Close
+func NewIssuer(clientID string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	iss := &Issuer{ClientID: clientID, key: key, keyID: "test-key-1", codes: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/jwks", iss.jwks)
+	mux.HandleFunc("/token", iss.token)
+	iss.Server = httptest.NewServer(mux)
+	return iss
+}
+
+// URL returns the issuer identifier
+func (iss *Issuer) URL() string {
+	return iss.Server.URL
+}
+
+// Close shuts the server down
+func (iss *Issuer) Close() {
+	iss.Server.Close()
+}
+
+// RotateKey replaces the signing key, as issuers do periodically
+func (iss *Issuer) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.serial++
+	iss.key = key
+	iss.keyID = "test-key-" + strconv.Itoa(iss.serial+1)
+}
+
+// Authorize signs user in to the authorization URL built by the client and returns the
+// code and state the issuer would redirect back with
+func (iss *Issuer) Authorize(authURL string, user User) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+
+	code = base64.RawURLEncoding.EncodeToString(randomBytes(16))
+	iss.mu.Lock()
+	iss.codes[code] = grant{
+		user:          user,
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	iss.mu.Unlock()
+	return code, q.Get("state"), nil
+}
+
+// IDToken signs an ID token for user directly, for tests of token verification
+func (iss *Issuer) IDToken(user User, audience, nonce string, ttl time.Duration) string {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	return iss.sign(user, audience, nonce, ttl)
+}
+
+func (iss *Issuer) sign(user User, audience, nonce string, ttl time.Duration) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            iss.Server.URL,
+		"sub":            user.Subject,
+		"aud":            audience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(ttl).Unix(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = iss.keyID
+	signed, err := token.SignedString(iss.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (iss *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                iss.Server.URL,
+		"authorization_endpoint":                iss.Server.URL + "/authorize",
+		"token_endpoint":                        iss.Server.URL + "/token",
+		"jwks_uri":                              iss.Server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_post"},
+	})
+}
+
+func (iss *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	pub := iss.key.PublicKey
+	kid := iss.keyID
+	iss.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (iss *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	// Codes are single use
+	code := r.PostForm.Get("code")
+	g, ok := iss.codes[code]
+	delete(iss.codes, code)
+	if !ok || g.clientID != iss.ClientID || r.PostForm.Get("client_id") != iss.ClientID ||
+		r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": base64.RawURLEncoding.EncodeToString(randomBytes(16)),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     iss.sign(g.user, g.clientID, g.nonce, time.Hour),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
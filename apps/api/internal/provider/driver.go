@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ner-studio/api/internal/model"
+)
+
+// Driver builds a client for a provider row. The returned value must implement the
+// interface matching the row's category (VisionProvider, LLMProvider or ImageGenerationProvider).
+type Driver func(p model.Provider) (interface{}, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]Driver{
+		"kieai": func(p model.Provider) (interface{}, error) {
+			return NewKieAIProvider(p.Slug, p.APIKey, p.BaseURL, p.Model, p.Config), nil
+		},
+		"gemini": func(p model.Provider) (interface{}, error) {
+			return NewGeminiProvider(p.Slug, p.APIKey, p.BaseURL, p.Model, p.Config), nil
+		},
+		"openai": func(p model.Provider) (interface{}, error) {
+			return NewOpenAIVisionProvider(p.APIKey, p.BaseURL), nil
+		},
+	}
+)
+
+// RegisterDriver makes a driver available to provider rows whose driver column is name
+func RegisterDriver(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = driver
+}
+
+// Drivers returns the names of all registered drivers
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupDriver(name string) (Driver, bool) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	driver, ok := drivers[name]
+	return driver, ok
+}
@@ -3,48 +3,119 @@ package provider
 import (
 	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/ner-studio/api/internal/model"
 )
 
-// Factory creates provider instances from configuration
+// Factory creates provider instances from configuration. It is safe for concurrent use and
+// can be swapped wholesale with Replace when provider configuration changes.
 type Factory struct {
-	visionProvider  VisionProvider
-	llmProviders    []LLMProviderWithPriority
-	imageProviders  map[string]ImageGenerationProvider
+	mu             sync.RWMutex
+	visionProvider VisionProvider
+	llmProviders   []LLMProviderWithPriority
+	imageProviders map[string]ImageGenerationProvider
+	// inactiveImageProviders still receive the results of tasks submitted before they were
+	// deactivated
+	inactiveImageProviders map[string]ImageGenerationProvider
 }
 
 // NewFactory creates a new provider factory
 func NewFactory() *Factory {
 	return &Factory{
-		imageProviders: make(map[string]ImageGenerationProvider),
+		imageProviders:         make(map[string]ImageGenerationProvider),
+		inactiveImageProviders: make(map[string]ImageGenerationProvider),
 	}
 }
 
+// BuildFactory creates a factory from provider rows. Inactive rows are only kept for the
+// tasks image providers are still running; rows that cannot be instantiated are skipped and
+// reported so one bad entry cannot take down the rest.
+func BuildFactory(providers []*model.Provider) (*Factory, []error) {
+	f := NewFactory()
+	var errs []error
+
+	for _, p := range providers {
+		if !p.IsActive && p.Category != "image_generation" {
+			continue
+		}
+		client, err := CreateProviderFromConfig(*p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("provider %s: %w", p.Slug, err))
+			continue
+		}
+		if !p.IsActive {
+			f.inactiveImageProviders[p.Slug] = client.(ImageGenerationProvider)
+			continue
+		}
+
+		switch p.Category {
+		case "vision":
+			// Rows arrive ordered by priority; the first vision provider wins
+			if f.visionProvider == nil {
+				f.RegisterVisionProvider(client.(VisionProvider))
+			}
+		case "llm":
+			f.RegisterLLMProvider(*p, client.(LLMProvider))
+		case "image_generation":
+			f.RegisterImageProvider(p.Slug, client.(ImageGenerationProvider))
+		}
+	}
+
+	return f, errs
+}
+
+// Replace atomically swaps in the providers registered on next
+func (f *Factory) Replace(next *Factory) {
+	next.mu.RLock()
+	vision, llms, images, inactive := next.visionProvider, next.llmProviders, next.imageProviders, next.inactiveImageProviders
+	next.mu.RUnlock()
+
+	f.mu.Lock()
+	f.visionProvider = vision
+	f.llmProviders = llms
+	f.imageProviders = images
+	f.inactiveImageProviders = inactive
+	f.mu.Unlock()
+}
+
 // RegisterVisionProvider registers a vision provider
 func (f *Factory) RegisterVisionProvider(provider VisionProvider) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.visionProvider = provider
 }
 
 // RegisterLLMProvider registers an LLM provider
 func (f *Factory) RegisterLLMProvider(p model.Provider, client LLMProvider) {
-	f.llmProviders = append(f.llmProviders, LLMProviderWithPriority{
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Copy so slices handed out by GetLLMProviders are never modified
+	llms := make([]LLMProviderWithPriority, 0, len(f.llmProviders)+1)
+	llms = append(llms, f.llmProviders...)
+	llms = append(llms, LLMProviderWithPriority{
 		Provider: p,
 		Client:   client,
 	})
 	// Sort by priority
-	sort.Slice(f.llmProviders, func(i, j int) bool {
-		return f.llmProviders[i].Provider.Priority < f.llmProviders[j].Provider.Priority
+	sort.SliceStable(llms, func(i, j int) bool {
+		return llms[i].Provider.Priority < llms[j].Provider.Priority
 	})
+	f.llmProviders = llms
 }
 
 // RegisterImageProvider registers an image generation provider
 func (f *Factory) RegisterImageProvider(providerID string, provider ImageGenerationProvider) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.imageProviders[providerID] = provider
 }
 
 // GetVisionProvider returns the registered vision provider
 func (f *Factory) GetVisionProvider() (VisionProvider, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	if f.visionProvider == nil {
 		return nil, fmt.Errorf("no vision provider registered")
 	}
@@ -53,11 +124,15 @@ func (f *Factory) GetVisionProvider() (VisionProvider, error) {
 
 // GetLLMProviders returns all registered LLM providers sorted by priority
 func (f *Factory) GetLLMProviders() []LLMProviderWithPriority {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	return f.llmProviders
 }
 
 // GetImageGenerationProvider returns an image generation provider by ID
 func (f *Factory) GetImageGenerationProvider(providerID string) (ImageGenerationProvider, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 	provider, ok := f.imageProviders[providerID]
 	if !ok {
 		return nil, fmt.Errorf("image provider not found: %s", providerID)
@@ -65,33 +140,47 @@ func (f *Factory) GetImageGenerationProvider(providerID string) (ImageGeneration
 	return provider, nil
 }
 
-// CreateProviderFromConfig creates a provider instance from database config
+// GetTaskProvider returns the image generation provider tasks were submitted to, even if
+// it has been deactivated since, so their callbacks and status polls are still handled
+func (f *Factory) GetTaskProvider(providerID string) (ImageGenerationProvider, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if provider, ok := f.imageProviders[providerID]; ok {
+		return provider, nil
+	}
+	if provider, ok := f.inactiveImageProviders[providerID]; ok {
+		return provider, nil
+	}
+	return nil, fmt.Errorf("image provider not found: %s", providerID)
+}
+
+// CreateProviderFromConfig creates a provider instance from database config using the
+// driver registered under p.Driver, and checks it can serve the row's category
 func CreateProviderFromConfig(p model.Provider) (interface{}, error) {
+	driver, ok := lookupDriver(p.Driver)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider driver: %q", p.Driver)
+	}
+
+	client, err := driver(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var supported bool
 	switch p.Category {
 	case "vision":
-		if p.Slug == "openai-gpt4o" {
-			return NewOpenAIVisionProvider(p.APIKey, p.BaseURL), nil
-		}
-		return nil, fmt.Errorf("unknown vision provider: %s", p.Slug)
-
+		_, supported = client.(VisionProvider)
 	case "llm":
-		if p.Slug == "google-gemini" {
-			return NewGeminiProvider(p.Slug, p.APIKey, p.BaseURL, p.Model, p.Config), nil
-		}
-		// kie.ai LLM providers
-		if p.Slug == "kieai-gemini3" || p.Slug == "kieai-gemini25" {
-			return NewKieAIProvider(p.Slug, p.APIKey, p.BaseURL, p.Model, p.Config), nil
-		}
-		return nil, fmt.Errorf("unknown LLM provider: %s", p.Slug)
-
+		_, supported = client.(LLMProvider)
 	case "image_generation":
-		// kie.ai image generation
-		if p.Slug == "kieai-seedream" || p.Slug == "kieai-nano" {
-			return NewKieAIProvider(p.Slug, p.APIKey, p.BaseURL, p.Model, p.Config), nil
-		}
-		return nil, fmt.Errorf("unknown image generation provider: %s", p.Slug)
-
+		_, supported = client.(ImageGenerationProvider)
 	default:
 		return nil, fmt.Errorf("unknown provider category: %s", p.Category)
 	}
+	if !supported {
+		return nil, fmt.Errorf("driver %s does not support category %s", p.Driver, p.Category)
+	}
+
+	return client, nil
 }
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// HealthChecker is implemented by providers that can verify their endpoint and credentials
+// with a cheap request that does not consume generation credits
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// APIError is returned when a provider answers with an unexpected HTTP status
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// Error categories reported by connectivity checks. timeout and rate_limit match the
+// codes used in ProviderConfig.ErrorCodeForFallback.
+const (
+	ErrorCategoryAuth      = "auth"
+	ErrorCategoryRateLimit = "rate_limit"
+	ErrorCategoryTimeout   = "timeout"
+	ErrorCategoryNetwork   = "network"
+	ErrorCategoryServer    = "server_error"
+	ErrorCategoryRequest   = "bad_request"
+	ErrorCategoryUnknown   = "unknown"
+)
+
+// ErrorCategory classifies an error returned by a provider call
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return ErrorCategoryAuth
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return ErrorCategoryRateLimit
+		case apiErr.StatusCode == http.StatusRequestTimeout || apiErr.StatusCode == http.StatusGatewayTimeout:
+			return ErrorCategoryTimeout
+		case apiErr.StatusCode >= 500:
+			return ErrorCategoryServer
+		case apiErr.StatusCode >= 400:
+			return ErrorCategoryRequest
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorCategoryTimeout
+	}
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || errors.As(err, &urlErr) {
+		return ErrorCategoryNetwork
+	}
+
+	return ErrorCategoryUnknown
+}
+
+// checkGET performs a GET request and treats any 2xx response as healthy
+func checkGET(ctx context.Context, client *http.Client, name, url string, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{Provider: name, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// CheckHealth lists models on the kie.ai API, which validates the key without generating anything
+func (p *KieAIProvider) CheckHealth(ctx context.Context) error {
+	return checkGET(ctx, p.client, "kie.ai", p.baseURL+"/v1/models", map[string]string{
+		"Authorization": "Bearer " + p.apiKey,
+	})
+}
+
+// CheckHealth lists models on the OpenAI API
+func (p *OpenAIVisionProvider) CheckHealth(ctx context.Context) error {
+	return checkGET(ctx, p.client, "OpenAI", p.baseURL+"/v1/models", map[string]string{
+		"Authorization": "Bearer " + p.apiKey,
+	})
+}
+
+// CheckHealth fetches the configured model's metadata from the Gemini API
+func (p *GeminiProvider) CheckHealth(ctx context.Context) error {
+	return checkGET(ctx, p.client, "Gemini", fmt.Sprintf("%s/v1beta/models/%s", p.baseURL, p.model), map[string]string{
+		"x-goog-api-key": p.apiKey,
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/ner-studio/api/internal/model"
@@ -118,6 +119,24 @@ func (p *KieAIProvider) GenerateImage(ctx context.Context, prompt string, cfg Im
 		"height":       cfg.Height,
 		"callback_url": cfg.CallbackURL,
 	}
+	if cfg.AspectRatio != "" {
+		reqBody["aspect_ratio"] = cfg.AspectRatio
+	}
+	if cfg.Quality != "" {
+		reqBody["quality"] = cfg.Quality
+	}
+	if cfg.Seed != nil {
+		reqBody["seed"] = *cfg.Seed
+	}
+	if cfg.NegativePrompt != "" {
+		reqBody["negative_prompt"] = cfg.NegativePrompt
+	}
+	if len(cfg.InputImageURLs) > 0 {
+		reqBody["image_urls"] = cfg.InputImageURLs
+	}
+	if len(cfg.ReferenceImageURLs) > 0 {
+		reqBody["reference_image_urls"] = cfg.ReferenceImageURLs
+	}
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -162,11 +181,39 @@ func (p *KieAIProvider) GenerateImage(ctx context.Context, prompt string, cfg Im
 	}, nil
 }
 
+// GetTaskStatus polls the current state of an image generation task
+func (p *KieAIProvider) GetTaskStatus(ctx context.Context, taskID string) (*CallbackData, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/v1/images/generations/"+url.PathEscape(taskID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kie.ai task status error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kie.ai task status API error %d: %s", resp.StatusCode, string(body))
+	}
+
+	// The status endpoint returns the same document as the callback
+	return p.ParseCallback(body)
+}
+
 // ParseCallback parses the callback payload from KieAI
 func (p *KieAIProvider) ParseCallback(payload []byte) (*CallbackData, error) {
 	var result struct {
 		TaskID       string `json:"task_id"`
-		Status       string `json:"status"` // success, failed
+		Status       string `json:"status"` // pending, processing, success, failed
 		ImageURL     string `json:"image_url,omitempty"`
 		ErrorCode    string `json:"error_code,omitempty"`
 		ErrorMessage string `json:"error_message,omitempty"`
@@ -177,8 +224,11 @@ func (p *KieAIProvider) ParseCallback(payload []byte) (*CallbackData, error) {
 	}
 
 	status := result.Status
-	if status == "success" {
+	switch status {
+	case "success":
 		status = "completed"
+	case "pending", "queued", "running":
+		status = "processing"
 	}
 
 	return &CallbackData{
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/ner-studio/api/internal/model"
 )
@@ -26,7 +27,7 @@ func NewOpenAIVisionProvider(apiKey, baseURL string) *OpenAIVisionProvider {
 	return &OpenAIVisionProvider{
 		apiKey:  apiKey,
 		baseURL: baseURL,
-		client:  &http.Client{Timeout: 30},
+		client:  &http.Client{Timeout: 60 * time.Second},
 	}
 }
 
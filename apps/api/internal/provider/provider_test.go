@@ -1,6 +1,10 @@
 package provider
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/ner-studio/api/internal/model"
@@ -42,6 +46,67 @@ func TestFactory_RegisterAndGet(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestKieAIProvider_GetTaskStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/v1/images/generations/task-done":
+			w.Write([]byte(`{"task_id":"task-done","status":"success","image_url":"https://tmp.kie.ai/1.png"}`))
+		case "/v1/images/generations/task-running":
+			w.Write([]byte(`{"task_id":"task-running","status":"running"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var p ImageGenerationProvider = NewKieAIProvider("kieai-seedream", "test-key", server.URL, "seedream-v1", model.ProviderConfig{})
+	poller, ok := p.(TaskStatusProvider)
+	assert.True(t, ok, "KieAIProvider should support task polling")
+
+	result, err := poller.GetTaskStatus(context.Background(), "task-done")
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", result.Status)
+	assert.Equal(t, "https://tmp.kie.ai/1.png", result.ImageURL)
+
+	result, err = poller.GetTaskStatus(context.Background(), "task-running")
+	assert.NoError(t, err)
+	assert.Equal(t, "processing", result.Status)
+
+	_, err = poller.GetTaskStatus(context.Background(), "missing")
+	assert.Error(t, err)
+}
+
+func TestKieAIProvider_GenerateImageSendsInputImages(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Write([]byte(`{"task_id":"task-1","status":"pending"}`))
+	}))
+	defer server.Close()
+
+	p := NewKieAIProvider("kieai-nano", "test-key", server.URL, "nano-banana-pro", model.ProviderConfig{})
+	result, err := p.GenerateImage(context.Background(), "product on a marble table", ImageGenConfig{
+		Model:          "nano-banana-pro",
+		Width:          1024,
+		Height:         1024,
+		InputImageURLs: []string{"https://cdn.example.com/product.png"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "task-1", result.TaskID)
+	assert.Equal(t, []interface{}{"https://cdn.example.com/product.png"}, body["image_urls"])
+	assert.NotContains(t, body, "reference_image_urls")
+
+	_, err = p.GenerateImage(context.Background(), "product in the brand style", ImageGenConfig{
+		Model:              "nano-banana-pro",
+		ReferenceImageURLs: []string{"https://cdn.example.com/style.png"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"https://cdn.example.com/style.png"}, body["reference_image_urls"])
+	assert.NotContains(t, body, "image_urls")
+}
+
 func TestCleanPrompts(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -234,3 +299,88 @@ func itoa(n int) string {
 	}
 	return string(buf[i:])
 }
+
+func TestBuildFactory(t *testing.T) {
+	providers := []*model.Provider{
+		{Slug: "openai-gpt4o", Category: "vision", Driver: "openai", IsActive: true},
+		{Slug: "kieai-gemini25", Category: "llm", Driver: "kieai", Priority: 1, IsActive: true},
+		{Slug: "google-gemini", Category: "llm", Driver: "gemini", Priority: 0, IsActive: true},
+		{Slug: "kieai-seedream", Category: "image_generation", Driver: "kieai", IsActive: true},
+		{Slug: "kieai-nano", Category: "image_generation", Driver: "kieai", IsActive: false},
+		{Slug: "mystery", Category: "llm", Driver: "unknown", IsActive: true},
+		{Slug: "gemini-images", Category: "image_generation", Driver: "gemini", IsActive: true},
+	}
+
+	factory, errs := BuildFactory(providers)
+	assert.Len(t, errs, 2, "unknown driver and unsupported category should be reported")
+
+	_, err := factory.GetVisionProvider()
+	assert.NoError(t, err)
+
+	llms := factory.GetLLMProviders()
+	assert.Len(t, llms, 2)
+	assert.Equal(t, "google-gemini", llms[0].Provider.Slug)
+
+	_, err = factory.GetImageGenerationProvider("kieai-seedream")
+	assert.NoError(t, err)
+	_, err = factory.GetImageGenerationProvider("kieai-nano")
+	assert.Error(t, err, "inactive providers should not be registered")
+	_, err = factory.GetTaskProvider("kieai-nano")
+	assert.NoError(t, err, "tasks of inactive providers should still be handled")
+	_, err = factory.GetTaskProvider("kieai-seedream")
+	assert.NoError(t, err)
+	_, err = factory.GetTaskProvider("non-existent")
+	assert.Error(t, err)
+
+	// Replace swaps the whole provider set
+	live := NewFactory()
+	live.Replace(factory)
+	_, err = live.GetImageGenerationProvider("kieai-seedream")
+	assert.NoError(t, err)
+	_, err = live.GetTaskProvider("kieai-nano")
+	assert.NoError(t, err)
+	live.Replace(NewFactory())
+	_, err = live.GetImageGenerationProvider("kieai-seedream")
+	assert.Error(t, err)
+}
+
+func TestCheckHealthAndErrorCategory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good-key":
+			w.Write([]byte(`{"data":[]}`))
+		case "Bearer limited-key":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	tests := []struct {
+		apiKey   string
+		category string
+	}{
+		{"good-key", ""},
+		{"bad-key", ErrorCategoryAuth},
+		{"limited-key", ErrorCategoryRateLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiKey, func(t *testing.T) {
+			var p interface{} = NewKieAIProvider("kieai-test", tt.apiKey, server.URL, "seedream-v1", model.ProviderConfig{})
+			checker, ok := p.(HealthChecker)
+			assert.True(t, ok)
+			assert.Equal(t, tt.category, ErrorCategory(checker.CheckHealth(ctx)))
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		p := NewKieAIProvider("kieai-test", "good-key", "http://127.0.0.1:1", "seedream-v1", model.ProviderConfig{})
+		assert.Equal(t, ErrorCategoryNetwork, ErrorCategory(p.CheckHealth(ctx)))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		assert.Equal(t, ErrorCategoryTimeout, ErrorCategory(context.DeadlineExceeded))
+	})
+}
@@ -22,6 +22,12 @@ type ImageGenerationProvider interface {
 	ParseCallback(payload []byte) (*CallbackData, error)
 }
 
+// TaskStatusProvider is implemented by image generation providers whose tasks can be
+// polled, which lets the reconciler recover results whose callback never arrived
+type TaskStatusProvider interface {
+	GetTaskStatus(ctx context.Context, taskID string) (*CallbackData, error)
+}
+
 // LLMMessage represents a message in LLM conversation
 type LLMMessage struct {
 	Role    string `json:"role"`
@@ -44,10 +50,40 @@ type LLMResponse struct {
 
 // ImageGenConfig for image generation
 type ImageGenConfig struct {
-	Model        string
-	Width        int
-	Height       int
-	CallbackURL  string
+	Model          string
+	Width          int
+	Height         int
+	AspectRatio    string
+	Quality        string
+	Seed           *int64
+	NegativePrompt string
+	CallbackURL    string
+
+	// InputImageURLs are images the model edits or composes from (image-to-image)
+	InputImageURLs []string
+	// ReferenceImageURLs are style references the model may use for guidance
+	ReferenceImageURLs []string
+}
+
+// DefaultAspectRatio is used when a generation does not request one
+const DefaultAspectRatio = "1:1"
+
+// aspectRatioDimensions maps supported aspect ratios to output pixel sizes
+var aspectRatioDimensions = map[string][2]int{
+	"1:1":  {1024, 1024},
+	"4:3":  {1152, 864},
+	"3:4":  {864, 1152},
+	"3:2":  {1216, 832},
+	"2:3":  {832, 1216},
+	"16:9": {1344, 768},
+	"9:16": {768, 1344},
+	"21:9": {1536, 640},
+}
+
+// DimensionsForAspectRatio returns the width and height used for an aspect ratio
+func DimensionsForAspectRatio(ratio string) (width, height int, ok bool) {
+	dims, ok := aspectRatioDimensions[ratio]
+	return dims[0], dims[1], ok
 }
 
 // ImageGenResult from image generation request
@@ -59,7 +95,7 @@ type ImageGenResult struct {
 // CallbackData parsed from provider webhook
 type CallbackData struct {
 	TaskID      string
-	Status      string // completed, failed
+	Status      string // processing, completed, failed
 	ImageURL    string // temporary URL to download
 	ErrorCode   string
 	ErrorMessage string
@@ -70,6 +106,7 @@ type ProviderRegistry interface {
 	GetVisionProvider() (VisionProvider, error)
 	GetLLMProviders() []LLMProviderWithPriority
 	GetImageGenerationProvider(providerID string) (ImageGenerationProvider, error)
+	GetTaskProvider(providerID string) (ImageGenerationProvider, error)
 }
 
 // LLMProviderWithPriority wraps an LLM provider with its priority
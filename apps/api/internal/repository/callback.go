@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// RecordCallback stores a provider callback in the inbox. It returns nil when the task's
+// callback has already been processed, in which case the delivery is only counted.
+func (r *Repository) RecordCallback(ctx context.Context, providerSlug, taskID string, imageID uuid.UUID, payload []byte) (*model.CallbackInboxEntry, error) {
+	query := `
+		INSERT INTO callback_inbox (provider_slug, task_id, image_id, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider_slug, task_id) DO UPDATE
+		SET payload = CASE WHEN callback_inbox.status = 'processed'
+				THEN callback_inbox.payload ELSE EXCLUDED.payload END,
+			deliveries = callback_inbox.deliveries + 1,
+			last_received_at = NOW()
+		RETURNING id, provider_slug, task_id, image_id, status, deliveries,
+			received_at, last_received_at, processed_at
+	`
+
+	var e model.CallbackInboxEntry
+	err := r.pool.QueryRow(ctx, query, providerSlug, taskID, imageID, payload).Scan(
+		&e.ID,
+		&e.ProviderSlug,
+		&e.TaskID,
+		&e.ImageID,
+		&e.Status,
+		&e.Deliveries,
+		&e.ReceivedAt,
+		&e.LastReceivedAt,
+		&e.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if e.Status == "processed" {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// MarkCallbackProcessed records that a callback's result has been applied
+func (r *Repository) MarkCallbackProcessed(ctx context.Context, id uuid.UUID) error {
+	return r.setCallbackStatus(ctx, id, "processed", "")
+}
+
+// MarkCallbackFailed records why a callback could not be applied; a redelivery retries it
+func (r *Repository) MarkCallbackFailed(ctx context.Context, id uuid.UUID, errorMsg string) error {
+	return r.setCallbackStatus(ctx, id, "failed", errorMsg)
+}
+
+func (r *Repository) setCallbackStatus(ctx context.Context, id uuid.UUID, status, errorMsg string) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE callback_inbox
+		SET status = $2, error_message = NULLIF($3, ''),
+			processed_at = CASE WHEN $2 = 'processed' THEN NOW() ELSE processed_at END
+		WHERE id = $1
+	`, id, status, errorMsg)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
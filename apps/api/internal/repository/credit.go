@@ -0,0 +1,437 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// ErrInsufficientCredits is returned when a debit would take the balance below zero
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// ErrRefundExceedsCharge is returned when a refund is larger than what is left to refund
+var ErrRefundExceedsCharge = errors.New("refund exceeds the amount charged for the generation")
+
+// LedgerEntry is a balance change to record
+type LedgerEntry struct {
+	OrganizationID uuid.UUID
+	UserID         *uuid.UUID
+	Amount         int64 // positive = add, negative = deduct
+	Type           string
+	Description    string
+	GenerationID   *uuid.UUID
+}
+
+// LedgerFilter narrows a ledger history query; zero values are ignored
+type LedgerFilter struct {
+	UserID       *uuid.UUID
+	Type         string
+	GenerationID *uuid.UUID
+	From         *time.Time // inclusive
+	To           *time.Time // exclusive
+	Limit        int
+	Offset       int
+}
+
+// ApplyLedgerEntry records a balance change and applies it to the organization in one transaction
+func (r *Repository) ApplyLedgerEntry(ctx context.Context, entry LedgerEntry) (*model.CreditLedger, error) {
+	var recorded *model.CreditLedger
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		recorded, err = applyLedgerEntry(ctx, tx, entry)
+		return err
+	})
+	return recorded, err
+}
+
+// DeductCredits atomically deducts credits from an organization
+func (r *Repository) DeductCredits(ctx context.Context, orgID uuid.UUID, amount int64, description string, userID uuid.UUID, generationID *uuid.UUID) error {
+	_, err := r.ApplyLedgerEntry(ctx, LedgerEntry{
+		OrganizationID: orgID,
+		UserID:         &userID,
+		Amount:         -amount,
+		Type:           "generation",
+		Description:    description,
+		GenerationID:   generationID,
+	})
+	return err
+}
+
+// RefundGeneration credits back part or all of what a generation was charged. A zero amount
+// refunds everything not yet refunded.
+func (r *Repository) RefundGeneration(ctx context.Context, orgID, generationID uuid.UUID, amount int64, userID *uuid.UUID, description string) (*model.CreditLedger, error) {
+	var recorded *model.CreditLedger
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		// Lock the organization first so concurrent refunds see each other's entries
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE`, orgID); err != nil {
+			return fmt.Errorf("failed to lock organization: %w", err)
+		}
+
+		var refundable int64
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(-SUM(amount), 0) FROM credit_ledger
+			WHERE organization_id = $1 AND generation_id = $2 AND type IN ('generation', 'refund')
+		`, orgID, generationID).Scan(&refundable)
+		if err != nil {
+			return err
+		}
+
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount <= 0 || amount > refundable {
+			return fmt.Errorf("%w: %d refundable", ErrRefundExceedsCharge, refundable)
+		}
+
+		recorded, err = applyLedgerEntry(ctx, tx, LedgerEntry{
+			OrganizationID: orgID,
+			UserID:         userID,
+			Amount:         amount,
+			Type:           "refund",
+			Description:    description,
+			GenerationID:   &generationID,
+		})
+		return err
+	})
+	return recorded, err
+}
+
+// ListLedgerEntries returns a page of an organization's ledger, newest first, and the total count
+func (r *Repository) ListLedgerEntries(ctx context.Context, orgID uuid.UUID, filter LedgerFilter) ([]*model.CreditLedger, int, error) {
+	where := `WHERE organization_id = $1
+		AND ($2::uuid IS NULL OR user_id = $2)
+		AND ($3 = '' OR type = $3)
+		AND ($4::uuid IS NULL OR generation_id = $4)
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)`
+	args := []interface{}{orgID, filter.UserID, filter.Type, filter.GenerationID, filter.From, filter.To}
+
+	query := `
+		SELECT id, organization_id, user_id, amount, type, description, generation_id, balance_after, created_at
+		FROM credit_ledger ` + where + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT $7 OFFSET $8
+	`
+
+	var total int
+	entries := []*model.CreditLedger{}
+	err := r.scoped(ctx, func(q querier) error {
+		if err := q.QueryRow(ctx, `SELECT COUNT(*) FROM credit_ledger `+where, args...).Scan(&total); err != nil {
+			return err
+		}
+
+		rows, err := q.Query(ctx, query, append(args, filter.Limit, filter.Offset)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e model.CreditLedger
+			err := rows.Scan(
+				&e.ID,
+				&e.OrganizationID,
+				&e.UserID,
+				&e.Amount,
+				&e.Type,
+				&e.Description,
+				&e.GenerationID,
+				&e.BalanceAfter,
+				&e.CreatedAt,
+			)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+const creditHoldColumns = `
+	id, organization_id, generation_id, user_id, amount, status,
+	settled_amount, expires_at, created_at, updated_at
+`
+
+func scanCreditHold(row pgx.Row) (*model.CreditHold, error) {
+	var h model.CreditHold
+	err := row.Scan(
+		&h.ID,
+		&h.OrganizationID,
+		&h.GenerationID,
+		&h.UserID,
+		&h.Amount,
+		&h.Status,
+		&h.SettledAmount,
+		&h.ExpiresAt,
+		&h.CreatedAt,
+		&h.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// GetCreditHold returns the hold placed for a generation
+func (r *Repository) GetCreditHold(ctx context.Context, generationID uuid.UUID) (*model.CreditHold, error) {
+	query := `SELECT ` + creditHoldColumns + ` FROM credit_holds WHERE generation_id = $1`
+	return scanCreditHold(r.pool.QueryRow(ctx, query, generationID))
+}
+
+// GetHeldCredits returns the total of an organization's open holds
+func (r *Repository) GetHeldCredits(ctx context.Context, orgID uuid.UUID) (int64, error) {
+	var held int64
+	err := r.scoped(ctx, func(q querier) error {
+		return q.QueryRow(ctx,
+			`SELECT COALESCE(SUM(amount), 0) FROM credit_holds WHERE organization_id = $1 AND status = 'held'`,
+			orgID,
+		).Scan(&held)
+	})
+	return held, err
+}
+
+// SettleCreditHold charges a generation its actual cost and returns the rest of its hold.
+// Settling an already closed hold is a no-op, so completion can safely be reported twice.
+// It returns pgx.ErrNoRows when the generation has no hold.
+func (r *Repository) SettleCreditHold(ctx context.Context, generationID uuid.UUID, actualCost int64, description string) (*model.CreditHold, error) {
+	var hold *model.CreditHold
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		hold, err = scanCreditHold(tx.QueryRow(ctx,
+			`SELECT `+creditHoldColumns+` FROM credit_holds WHERE generation_id = $1 FOR UPDATE`,
+			generationID,
+		))
+		if err != nil {
+			return err
+		}
+		if hold.Status != "held" {
+			return nil
+		}
+
+		// The hold is the most the generation can be charged
+		if actualCost > hold.Amount {
+			actualCost = hold.Amount
+		}
+		if actualCost < 0 {
+			actualCost = 0
+		}
+
+		status := "settled"
+		if actualCost == 0 {
+			status = "released"
+		}
+		return closeCreditHold(ctx, tx, hold, status, actualCost, description)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ExpireCreditHolds closes holds that are past their expiry without being settled and returns
+// how many it closed. Only holds of finished generations are closed, which covers a
+// settlement that failed at completion: completed generations are charged their actual
+// cost and failed ones released. Holds of generations still running are kept, so images
+// that finish late are still charged.
+func (r *Repository) ExpireCreditHolds(ctx context.Context, limit int) (int, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT h.generation_id FROM credit_holds h
+		JOIN generations g ON g.id = h.generation_id
+		WHERE h.status = 'held' AND h.expires_at < NOW()
+		AND g.status IN ('completed', 'failed')
+		ORDER BY h.expires_at ASC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	var generationIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		generationIDs = append(generationIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, generationID := range generationIDs {
+		err := r.WithTx(ctx, func(tx pgx.Tx) error {
+			// Re-check under the lock; the generation may have settled in the meantime
+			hold, err := scanCreditHold(tx.QueryRow(ctx,
+				`SELECT `+creditHoldColumns+` FROM credit_holds
+				 WHERE generation_id = $1 AND status = 'held' AND expires_at < NOW()
+				 FOR UPDATE SKIP LOCKED`,
+				generationID,
+			))
+			if err != nil {
+				return err
+			}
+
+			var status string
+			var actualCost int64
+			err = tx.QueryRow(ctx,
+				"SELECT status, COALESCE(actual_cost, 0) FROM generations WHERE id = $1",
+				generationID,
+			).Scan(&status, &actualCost)
+			if err != nil {
+				return fmt.Errorf("failed to get generation: %w", err)
+			}
+			if status != "completed" && status != "failed" {
+				return pgx.ErrNoRows
+			}
+
+			if status == "completed" && actualCost > 0 {
+				actualCost = min(actualCost, hold.Amount)
+				description := fmt.Sprintf("Image generation %s", generationID)
+				return closeCreditHold(ctx, tx, hold, "settled", actualCost, description)
+			}
+			return closeCreditHold(ctx, tx, hold, "expired", 0, "")
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return expired, fmt.Errorf("failed to expire hold for generation %s: %w", generationID, err)
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// reserveCredits takes a generation's estimated cost out of the available balance and
+// records the hold. It fails with ErrInsufficientCredits when the balance is too low.
+func reserveCredits(ctx context.Context, tx pgx.Tx, gen *model.Generation, ttl time.Duration) error {
+	_, err := applyLedgerEntry(ctx, tx, LedgerEntry{
+		OrganizationID: gen.OrganizationID,
+		UserID:         &gen.UserID,
+		Amount:         -gen.EstimatedCost,
+		Type:           "hold",
+		Description:    fmt.Sprintf("Hold for generation %s", gen.ID),
+		GenerationID:   &gen.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO credit_holds (organization_id, generation_id, user_id, amount, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, gen.OrganizationID, gen.ID, gen.UserID, gen.EstimatedCost, time.Now().Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to record credit hold: %w", err)
+	}
+	return nil
+}
+
+// closeCreditHold returns the held amount, charges the given cost against the generation
+// and marks the hold closed with the given status
+func closeCreditHold(ctx context.Context, tx pgx.Tx, hold *model.CreditHold, status string, charge int64, description string) error {
+	_, err := applyLedgerEntry(ctx, tx, LedgerEntry{
+		OrganizationID: hold.OrganizationID,
+		UserID:         hold.UserID,
+		Amount:         hold.Amount,
+		Type:           "release",
+		Description:    fmt.Sprintf("Release hold for generation %s", hold.GenerationID),
+		GenerationID:   &hold.GenerationID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if charge > 0 {
+		_, err = applyLedgerEntry(ctx, tx, LedgerEntry{
+			OrganizationID: hold.OrganizationID,
+			UserID:         hold.UserID,
+			Amount:         -charge,
+			Type:           "generation",
+			Description:    description,
+			GenerationID:   &hold.GenerationID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE credit_holds SET status = $1, settled_amount = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING updated_at
+	`, status, charge, hold.ID).Scan(&hold.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to close credit hold: %w", err)
+	}
+	hold.Status = status
+	hold.SettledAmount = &charge
+	return nil
+}
+
+// applyLedgerEntry is the only code path that changes organizations.credits. It locks the
+// organization row, rejects debits that would overdraw the balance, updates the balance and
+// appends the ledger entry, so the balance always equals the sum of the ledger.
+func applyLedgerEntry(ctx context.Context, tx pgx.Tx, entry LedgerEntry) (*model.CreditLedger, error) {
+	if entry.Amount == 0 {
+		return nil, fmt.Errorf("ledger entry amount must not be zero")
+	}
+
+	var balance int64
+	err := tx.QueryRow(ctx,
+		"SELECT credits FROM organizations WHERE id = $1 FOR UPDATE",
+		entry.OrganizationID,
+	).Scan(&balance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock organization: %w", err)
+	}
+
+	if entry.Amount < 0 && balance+entry.Amount < 0 {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrInsufficientCredits, balance, -entry.Amount)
+	}
+	balance += entry.Amount
+
+	_, err = tx.Exec(ctx,
+		"UPDATE organizations SET credits = $1, updated_at = NOW() WHERE id = $2",
+		balance, entry.OrganizationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	recorded := &model.CreditLedger{
+		ID:             uuid.New(),
+		OrganizationID: entry.OrganizationID,
+		UserID:         entry.UserID,
+		Amount:         entry.Amount,
+		Type:           entry.Type,
+		Description:    entry.Description,
+		GenerationID:   entry.GenerationID,
+		BalanceAfter:   &balance,
+	}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO credit_ledger (id, organization_id, user_id, amount, type, description, generation_id, balance_after, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		 RETURNING created_at`,
+		recorded.ID, recorded.OrganizationID, recorded.UserID, recorded.Amount, recorded.Type,
+		recorded.Description, recorded.GenerationID, balance,
+	).Scan(&recorded.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record ledger entry: %w", err)
+	}
+
+	return recorded, nil
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// generationEventsChannel is the NOTIFY channel fed by the generation_events insert trigger
+const generationEventsChannel = "generation_events"
+
+const generationEventColumns = `id, generation_id, type, image_id, data, created_at`
+
+func scanGenerationEvent(row pgx.Row) (*model.GenerationEvent, error) {
+	var e model.GenerationEvent
+	err := row.Scan(
+		&e.ID,
+		&e.GenerationID,
+		&e.Type,
+		&e.ImageID,
+		&e.Data,
+		&e.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// AppendGenerationEvent records a progress event; the insert trigger notifies listeners.
+// Events of a generation are appended one at a time under a lock on the generation, so
+// they commit in ID order and a reader resuming after an ID never misses one committed later.
+func (r *Repository) AppendGenerationEvent(ctx context.Context, generationID uuid.UUID, eventType string, imageID *uuid.UUID, data interface{}) (*model.GenerationEvent, error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event data: %w", err)
+	}
+
+	query := `
+		INSERT INTO generation_events (generation_id, type, image_id, data)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + generationEventColumns
+
+	var event *model.GenerationEvent
+	err = r.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM generations WHERE id = $1 FOR NO KEY UPDATE`, generationID); err != nil {
+			return fmt.Errorf("failed to lock generation: %w", err)
+		}
+		event, err = scanGenerationEvent(tx.QueryRow(ctx, query, generationID, eventType, imageID, dataJSON))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// ListGenerationEvents returns up to limit events of a generation with an ID above afterID, oldest first.
+// Events of another organization than the one ctx is scoped to are left out.
+func (r *Repository) ListGenerationEvents(ctx context.Context, generationID uuid.UUID, afterID int64, limit int) ([]*model.GenerationEvent, error) {
+	query := `SELECT ` + generationEventColumns + `
+		FROM generation_events
+		WHERE generation_id = $1 AND id > $2
+		AND ($4::uuid IS NULL OR generation_id IN (SELECT id FROM generations WHERE organization_id = $4))
+		ORDER BY id ASC
+		LIMIT $3
+	`
+
+	events := []*model.GenerationEvent{}
+	err := r.scoped(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, query, generationID, afterID, limit, orgScope(ctx))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e, err := scanGenerationEvent(rows)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// ListenGenerationEvents holds a dedicated connection listening for new generation events
+// and calls fn for each one until ctx is cancelled or the connection fails.
+// Notifications are only a wake-up signal; the events themselves are read from the table.
+func (r *Repository) ListenGenerationEvents(ctx context.Context, fn func(generationID uuid.UUID, eventID int64)) error {
+	pooled, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	// The connection stays subscribed, so take it out of the pool instead of releasing it
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+generationEventsChannel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		generationID, eventID, ok := parseEventNotification(n.Payload)
+		if !ok {
+			continue
+		}
+		fn(generationID, eventID)
+	}
+}
+
+func parseEventNotification(payload string) (uuid.UUID, int64, bool) {
+	idPart, eventPart, found := strings.Cut(payload, ":")
+	if !found {
+		return uuid.Nil, 0, false
+	}
+	generationID, err := uuid.Parse(idPart)
+	if err != nil {
+		return uuid.Nil, 0, false
+	}
+	eventID, err := strconv.ParseInt(eventPart, 10, 64)
+	if err != nil {
+		return uuid.Nil, 0, false
+	}
+	return generationID, eventID, true
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// GalleryFilter narrows a gallery listing; zero values are ignored
+type GalleryFilter struct {
+	ViewerID      uuid.UUID // whose favorites are reported
+	UserID        *uuid.UUID
+	ProviderID    *uuid.UUID
+	From          *time.Time // inclusive
+	To            *time.Time // exclusive
+	Query         string     // case-insensitive substring of the image or base prompt
+	Tag           string
+	FavoritesOnly bool
+	After         *Cursor
+	Limit         int
+}
+
+// galleryColumns selects a model.GalleryImage; $1 is always the viewer's user ID
+const galleryColumns = `
+	i.id, i.generation_id, g.user_id, COALESCE(g.provider_id, '00000000-0000-0000-0000-000000000000'),
+	i.prompt, g.base_prompt, COALESCE(i.image_url, ''), COALESCE(i.r2_key, ''),
+	COALESCE(i.content_type, ''), COALESCE(i.size_bytes, 0),
+	EXISTS (SELECT 1 FROM image_favorites f WHERE f.image_id = i.id AND f.user_id = $1),
+	ARRAY(SELECT t.tag FROM image_tags t WHERE t.image_id = i.id ORDER BY t.tag),
+	i.created_at, i.completed_at, i.renditions
+`
+
+// galleryFrom joins images to their generation and keeps only visible images
+const galleryFrom = `
+	FROM generation_images i
+	JOIN generations g ON g.id = i.generation_id
+	WHERE i.status = 'completed' AND i.deleted_at IS NULL
+`
+
+func scanGalleryImage(row pgx.Row) (*model.GalleryImage, error) {
+	var img model.GalleryImage
+	err := row.Scan(
+		&img.ID,
+		&img.GenerationID,
+		&img.UserID,
+		&img.ProviderID,
+		&img.Prompt,
+		&img.BasePrompt,
+		&img.ImageURL,
+		&img.R2Key,
+		&img.ContentType,
+		&img.SizeBytes,
+		&img.Favorite,
+		&img.Tags,
+		&img.CreatedAt,
+		&img.CompletedAt,
+		&img.RenditionKeys,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// ListGalleryImages lists an organization's completed images newest first, using keyset
+// pagination on the image's (created_at, id)
+func (r *Repository) ListGalleryImages(ctx context.Context, orgID uuid.UUID, filter GalleryFilter) ([]*model.GalleryImage, error) {
+	var afterCreatedAt *time.Time
+	var afterID *uuid.UUID
+	if filter.After != nil {
+		afterCreatedAt, afterID = &filter.After.CreatedAt, &filter.After.ID
+	}
+
+	query := `SELECT ` + galleryColumns + galleryFrom + `
+		AND g.organization_id = $2
+		AND ($3::uuid IS NULL OR g.user_id = $3)
+		AND ($4::uuid IS NULL OR g.provider_id = $4)
+		AND ($5::timestamptz IS NULL OR i.created_at >= $5)
+		AND ($6::timestamptz IS NULL OR i.created_at < $6)
+		AND ($7 = '' OR i.prompt ILIKE '%' || $7 || '%' OR g.base_prompt ILIKE '%' || $7 || '%')
+		AND ($8 = '' OR EXISTS (SELECT 1 FROM image_tags t WHERE t.image_id = i.id AND t.tag = $8))
+		AND (NOT $9 OR EXISTS (SELECT 1 FROM image_favorites f WHERE f.image_id = i.id AND f.user_id = $1))
+		AND ($10::timestamptz IS NULL OR (i.created_at, i.id) < ($10, $11::uuid))
+		ORDER BY i.created_at DESC, i.id DESC
+		LIMIT $12
+	`
+
+	return r.queryGalleryImages(ctx, query,
+		filter.ViewerID, orgID, filter.UserID, filter.ProviderID, filter.From, filter.To,
+		escapeLike(filter.Query), filter.Tag, filter.FavoritesOnly, afterCreatedAt, afterID, filter.Limit,
+	)
+}
+
+// GetGalleryImages returns the visible images among ids that belong to the organization,
+// in no particular order. Unknown, deleted and foreign IDs are left out.
+func (r *Repository) GetGalleryImages(ctx context.Context, orgID, viewerID uuid.UUID, ids []uuid.UUID) ([]*model.GalleryImage, error) {
+	query := `SELECT ` + galleryColumns + galleryFrom + `
+		AND g.organization_id = $2
+		AND i.id = ANY($3)
+	`
+
+	return r.queryGalleryImages(ctx, query, viewerID, orgID, ids)
+}
+
+// queryGalleryImages runs a gallery query in the organization scope of ctx
+func (r *Repository) queryGalleryImages(ctx context.Context, query string, args ...any) ([]*model.GalleryImage, error) {
+	images := []*model.GalleryImage{}
+	err := r.scoped(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			img, err := scanGalleryImage(rows)
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// SetImageFavorite adds or removes an image from a user's favorites
+func (r *Repository) SetImageFavorite(ctx context.Context, userID, imageID uuid.UUID, favorite bool) error {
+	return r.scoped(ctx, func(q querier) error {
+		var err error
+		if favorite {
+			_, err = q.Exec(ctx,
+				`INSERT INTO image_favorites (user_id, image_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+				userID, imageID,
+			)
+		} else {
+			_, err = q.Exec(ctx,
+				`DELETE FROM image_favorites WHERE user_id = $1 AND image_id = $2`,
+				userID, imageID,
+			)
+		}
+		return err
+	})
+}
+
+// SetImageTags replaces an image's tags
+func (r *Repository) SetImageTags(ctx context.Context, imageID uuid.UUID, tags []string) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM image_tags WHERE image_id = $1`, imageID); err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		_, err := tx.Exec(ctx,
+			`INSERT INTO image_tags (image_id, tag) SELECT $1, UNNEST($2::text[])`,
+			imageID, tags,
+		)
+		return err
+	})
+}
+
+// MarkGalleryImagesDeleted hides images whose stored objects have been removed.
+// Images of another organization than the one ctx is scoped to are left alone.
+func (r *Repository) MarkGalleryImagesDeleted(ctx context.Context, ids []uuid.UUID) error {
+	return r.scoped(ctx, func(q querier) error {
+		_, err := q.Exec(ctx, `
+			UPDATE generation_images
+			SET deleted_at = NOW(), image_url = NULL, updated_at = NOW()
+			WHERE id = ANY($1) AND deleted_at IS NULL
+			AND ($2::uuid IS NULL OR generation_id IN (SELECT id FROM generations WHERE organization_id = $2))
+		`, ids, orgScope(ctx))
+		return err
+	})
+}
@@ -2,7 +2,10 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -35,106 +38,175 @@ func (r *Repository) CreateGeneration(ctx context.Context, gen *model.Generation
 	).Scan(&gen.CreatedAt, &gen.UpdatedAt)
 }
 
-// GetGeneration retrieves a generation by ID
+// GetGeneration retrieves a generation by ID. A generation of another organization than
+// the one ctx is scoped to is reported as pgx.ErrNoRows.
 func (r *Repository) GetGeneration(ctx context.Context, id uuid.UUID) (*model.Generation, error) {
-	query := `
-		SELECT id, organization_id, user_id, status, base_prompt,
-			reference_images, product_images, provider_id,
-			estimated_cost, actual_cost, error_message,
-			created_at, updated_at, completed_at
+	query := `SELECT ` + generationColumns + `
 		FROM generations
-		WHERE id = $1
+		WHERE id = $1 AND ($2::uuid IS NULL OR organization_id = $2)
 	`
 
-	var gen model.Generation
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&gen.ID,
-		&gen.OrganizationID,
-		&gen.UserID,
-		&gen.Status,
-		&gen.BasePrompt,
-		&gen.ReferenceImages,
-		&gen.ProductImages,
-		&gen.ProviderID,
-		&gen.EstimatedCost,
-		&gen.ActualCost,
-		&gen.ErrorMessage,
-		&gen.CreatedAt,
-		&gen.UpdatedAt,
-		&gen.CompletedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
+	var gen *model.Generation
+	err := r.scoped(ctx, func(q querier) error {
+		var err error
+		gen, err = scanGeneration(q.QueryRow(ctx, query, id, orgScope(ctx)))
+		return err
+	})
+	return gen, err
+}
 
-	return &gen, nil
+// thumbnailsPerGeneration caps the completed images embedded in each listed generation
+const thumbnailsPerGeneration = 4
+
+// Cursor marks the last row of a page ordered by (created_at, id) descending; the next
+// page starts after it
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
 }
 
-// ListGenerations lists generations for an organization
-func (r *Repository) ListGenerations(ctx context.Context, orgID uuid.UUID, limit, offset int) ([]*model.Generation, error) {
-	query := `
-		SELECT id, organization_id, user_id, status, base_prompt,
-			reference_images, product_images, provider_id,
-			estimated_cost, actual_cost, error_message,
-			created_at, updated_at, completed_at
+// GenerationFilter narrows a generation listing; zero values are ignored
+type GenerationFilter struct {
+	UserID     *uuid.UUID
+	Status     string
+	ProviderID *uuid.UUID
+	From       *time.Time // inclusive
+	To         *time.Time // exclusive
+	Query      string     // case-insensitive substring of the prompt
+	After      *Cursor
+	Limit      int
+}
+
+// ListGenerations lists an organization's generations newest first, using keyset pagination
+// on (created_at, id). Each generation carries thumbnails of its first completed images.
+func (r *Repository) ListGenerations(ctx context.Context, orgID uuid.UUID, filter GenerationFilter) ([]*model.Generation, error) {
+	var afterCreatedAt *time.Time
+	var afterID *uuid.UUID
+	if filter.After != nil {
+		afterCreatedAt, afterID = &filter.After.CreatedAt, &filter.After.ID
+	}
+
+	query := `SELECT ` + generationColumns + `
 		FROM generations
 		WHERE organization_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
+		AND ($2::uuid IS NULL OR user_id = $2)
+		AND ($3 = '' OR status = $3)
+		AND ($4::uuid IS NULL OR provider_id = $4)
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		AND ($7 = '' OR base_prompt ILIKE '%' || $7 || '%')
+		AND ($8::timestamptz IS NULL OR (created_at, id) < ($8, $9::uuid))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $10
 	`
 
-	rows, err := r.pool.Query(ctx, query, orgID, limit, offset)
+	generations := []*model.Generation{}
+	err := r.scoped(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, query,
+			orgID, filter.UserID, filter.Status, filter.ProviderID, filter.From, filter.To,
+			escapeLike(filter.Query), afterCreatedAt, afterID, filter.Limit,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			gen, err := scanGeneration(rows)
+			if err != nil {
+				return err
+			}
+			generations = append(generations, gen)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if err := attachThumbnails(ctx, q, generations); err != nil {
+			return fmt.Errorf("failed to load thumbnails: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return generations, nil
+}
+
+// attachThumbnails loads the keys of the first completed images of each generation in one
+// query: their thumbnail rendition when it has been rendered, or else the original
+func attachThumbnails(ctx context.Context, q querier, generations []*model.Generation) error {
+	if len(generations) == 0 {
+		return nil
+	}
+	byID := make(map[uuid.UUID]*model.Generation, len(generations))
+	ids := make([]uuid.UUID, 0, len(generations))
+	for _, gen := range generations {
+		byID[gen.ID] = gen
+		ids = append(ids, gen.ID)
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT generation_id, thumbnail FROM (
+			SELECT generation_id, COALESCE(NULLIF(renditions->>'thumbnail', ''), r2_key) AS thumbnail,
+				ROW_NUMBER() OVER (PARTITION BY generation_id ORDER BY created_at, id) AS n
+			FROM generation_images
+			WHERE generation_id = ANY($1) AND status = 'completed'
+			AND r2_key IS NOT NULL AND deleted_at IS NULL
+		) ranked
+		WHERE n <= $2
+		ORDER BY generation_id, n
+	`, ids, thumbnailsPerGeneration)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	var generations []*model.Generation
 	for rows.Next() {
-		var gen model.Generation
-		err := rows.Scan(
-			&gen.ID,
-			&gen.OrganizationID,
-			&gen.UserID,
-			&gen.Status,
-			&gen.BasePrompt,
-			&gen.ReferenceImages,
-			&gen.ProductImages,
-			&gen.ProviderID,
-			&gen.EstimatedCost,
-			&gen.ActualCost,
-			&gen.ErrorMessage,
-			&gen.CreatedAt,
-			&gen.UpdatedAt,
-			&gen.CompletedAt,
-		)
-		if err != nil {
-			return nil, err
+		var generationID uuid.UUID
+		var thumbnailKey string
+		if err := rows.Scan(&generationID, &thumbnailKey); err != nil {
+			return err
 		}
-		generations = append(generations, &gen)
+		gen := byID[generationID]
+		gen.ThumbnailKeys = append(gen.ThumbnailKeys, thumbnailKey)
 	}
+	return rows.Err()
+}
 
-	return generations, nil
+// escapeLike makes user input match literally inside a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
 
 // UpdateGenerationStatus updates generation status
 func (r *Repository) UpdateGenerationStatus(ctx context.Context, id uuid.UUID, status, errorMsg string) error {
-	var completedAt interface{}
-	if status == "completed" || status == "failed" {
-		completedAt = "NOW()"
-	} else {
-		completedAt = nil
-	}
-
 	query := `
 		UPDATE generations
-		SET status = $2, error_message = $3, completed_at = $4, updated_at = NOW()
+		SET status = $2, error_message = $3,
+			completed_at = CASE WHEN $2 IN ('completed', 'failed') THEN NOW() ELSE NULL END,
+			updated_at = NOW()
 		WHERE id = $1
 	`
-	_, err := r.pool.Exec(ctx, query, id, status, errorMsg, completedAt)
+	_, err := r.pool.Exec(ctx, query, id, status, errorMsg)
 	return err
 }
 
+// FinishGeneration moves a generation to completed or failed. It reports false when the
+// generation had already finished, so only one caller goes on to settle its credits.
+func (r *Repository) FinishGeneration(ctx context.Context, id uuid.UUID, status, errorMsg string) (bool, error) {
+	query := `
+		UPDATE generations
+		SET status = $2, error_message = $3, completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status NOT IN ('completed', 'failed')
+	`
+	tag, err := r.pool.Exec(ctx, query, id, status, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
 // CreateGenerationImage creates a generation image record
 func (r *Repository) CreateGenerationImage(ctx context.Context, img *model.GenerationImage) error {
 	query := `
@@ -156,69 +228,85 @@ func (r *Repository) CreateGenerationImage(ctx context.Context, img *model.Gener
 
 // GetGenerationImageByTaskID retrieves an image by task ID
 func (r *Repository) GetGenerationImageByTaskID(ctx context.Context, taskID string) (*model.GenerationImage, error) {
-	query := `
-		SELECT id, generation_id, prompt, image_url, r2_key, status, task_id,
-			error_message, created_at, updated_at, completed_at
+	query := `SELECT ` + generationImageColumns + `
 		FROM generation_images
 		WHERE task_id = $1
 	`
 
-	var img model.GenerationImage
-	err := r.pool.QueryRow(ctx, query, taskID).Scan(
-		&img.ID,
-		&img.GenerationID,
-		&img.Prompt,
-		&img.ImageURL,
-		&img.R2Key,
-		&img.Status,
-		&img.TaskID,
-		&img.ErrorMessage,
-		&img.CreatedAt,
-		&img.UpdatedAt,
-		&img.CompletedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
+	return scanGenerationImage(r.pool.QueryRow(ctx, query, taskID))
+}
 
-	return &img, nil
+// GetGenerationImageByCallbackToken retrieves the image a callback token was issued for
+func (r *Repository) GetGenerationImageByCallbackToken(ctx context.Context, tokenHash string) (*model.GenerationImage, error) {
+	query := `SELECT ` + generationImageColumns + `
+		FROM generation_images
+		WHERE callback_token_hash = $1
+	`
+
+	return scanGenerationImage(r.pool.QueryRow(ctx, query, tokenHash))
+}
+
+// SetGenerationImageCallbackToken stores the hash of the token the provider must echo back
+// in its callback. It is set before submission because a callback can arrive before the
+// task ID has been recorded.
+func (r *Repository) SetGenerationImageCallbackToken(ctx context.Context, id uuid.UUID, tokenHash string) error {
+	_, err := r.pool.Exec(ctx,
+		"UPDATE generation_images SET callback_token_hash = $2, updated_at = NOW() WHERE id = $1",
+		id, tokenHash,
+	)
+	return err
 }
 
-// UpdateGenerationImageComplete updates image on callback
-func (r *Repository) UpdateGenerationImageComplete(ctx context.Context, id uuid.UUID, imageURL, r2Key string) error {
+// UpdateGenerationImageComplete records the stored object once an image has been persisted.
+// It reports false without changing anything when the image has already finished.
+func (r *Repository) UpdateGenerationImageComplete(ctx context.Context, id uuid.UUID, obj StoredObject) (bool, error) {
 	query := `
 		UPDATE generation_images
-		SET status = 'completed', image_url = $2, r2_key = $3,
+		SET status = 'completed', image_url = NULLIF($2, ''), r2_key = $3,
+			content_type = $4, size_bytes = $5, checksum_sha256 = $6,
 			completed_at = NOW(), updated_at = NOW()
-		WHERE id = $1
+		WHERE id = $1 AND status IN ('pending', 'processing')
 	`
-	_, err := r.pool.Exec(ctx, query, id, imageURL, r2Key)
-	return err
+	tag, err := r.pool.Exec(ctx, query, id, obj.URL, obj.Key, obj.ContentType, obj.Size, obj.Checksum)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
 
-// UpdateGenerationImageFailed marks image as failed
-func (r *Repository) UpdateGenerationImageFailed(ctx context.Context, id uuid.UUID, errorMsg string) error {
+// UpdateGenerationImageSubmitted stores the provider task ID once an image job is accepted.
+// An image whose callback already finished it keeps its final status.
+func (r *Repository) UpdateGenerationImageSubmitted(ctx context.Context, id uuid.UUID, providerSlug, taskID string) error {
 	query := `
 		UPDATE generation_images
-		SET status = 'failed', error_message = $2,
-			completed_at = NOW(), updated_at = NOW()
+		SET status = CASE WHEN status = 'pending' THEN 'processing' ELSE status END,
+			provider_slug = $2, task_id = $3,
+			submitted_at = NOW(), updated_at = NOW()
 		WHERE id = $1
 	`
-	_, err := r.pool.Exec(ctx, query, id, errorMsg)
+	_, err := r.pool.Exec(ctx, query, id, providerSlug, taskID)
 	return err
 }
 
-// ListGenerationImages retrieves images for a generation
-func (r *Repository) ListGenerationImages(ctx context.Context, generationID uuid.UUID) ([]*model.GenerationImage, error) {
+// ClaimImagesAwaitingCallback returns up to limit processing images submitted more than
+// olderThan ago and not polled within pollInterval. Claimed rows are stamped with
+// last_polled_at, so concurrent reconcilers on other instances skip them.
+func (r *Repository) ClaimImagesAwaitingCallback(ctx context.Context, olderThan, pollInterval time.Duration, limit int) ([]*model.GenerationImage, error) {
 	query := `
-		SELECT id, generation_id, prompt, image_url, r2_key, status, task_id,
-			error_message, created_at, updated_at, completed_at
-		FROM generation_images
-		WHERE generation_id = $1
-		ORDER BY created_at ASC
-	`
+		UPDATE generation_images
+		SET last_polled_at = NOW()
+		WHERE id IN (
+			SELECT id FROM generation_images
+			WHERE status = 'processing'
+			AND submitted_at < NOW() - make_interval(secs => $1)
+			AND (last_polled_at IS NULL OR last_polled_at < NOW() - make_interval(secs => $2))
+			ORDER BY submitted_at ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + generationImageColumns
 
-	rows, err := r.pool.Query(ctx, query, generationID)
+	rows, err := r.pool.Query(ctx, query, olderThan.Seconds(), pollInterval.Seconds(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -226,27 +314,60 @@ func (r *Repository) ListGenerationImages(ctx context.Context, generationID uuid
 
 	var images []*model.GenerationImage
 	for rows.Next() {
-		var img model.GenerationImage
-		err := rows.Scan(
-			&img.ID,
-			&img.GenerationID,
-			&img.Prompt,
-			&img.ImageURL,
-			&img.R2Key,
-			&img.Status,
-			&img.TaskID,
-			&img.ErrorMessage,
-			&img.CreatedAt,
-			&img.UpdatedAt,
-			&img.CompletedAt,
-		)
+		img, err := scanGenerationImage(rows)
 		if err != nil {
 			return nil, err
 		}
-		images = append(images, &img)
+		images = append(images, img)
 	}
 
-	return images, nil
+	return images, rows.Err()
+}
+
+// UpdateGenerationImageFailed marks image as failed.
+// It reports false without changing anything when the image has already finished.
+func (r *Repository) UpdateGenerationImageFailed(ctx context.Context, id uuid.UUID, errorMsg string) (bool, error) {
+	query := `
+		UPDATE generation_images
+		SET status = 'failed', error_message = $2,
+			completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status IN ('pending', 'processing')
+	`
+	tag, err := r.pool.Exec(ctx, query, id, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ListGenerationImages retrieves images for a generation; none when the generation
+// belongs to another organization than the one ctx is scoped to
+func (r *Repository) ListGenerationImages(ctx context.Context, generationID uuid.UUID) ([]*model.GenerationImage, error) {
+	query := `SELECT ` + generationImageColumns + `
+		FROM generation_images
+		WHERE generation_id = $1
+		AND ($2::uuid IS NULL OR generation_id IN (SELECT id FROM generations WHERE organization_id = $2))
+		ORDER BY created_at ASC
+	`
+
+	var images []*model.GenerationImage
+	err := r.scoped(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, query, generationID, orgScope(ctx))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			img, err := scanGenerationImage(rows)
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+		}
+		return rows.Err()
+	})
+	return images, err
 }
 
 // CountCompletedImages counts completed images for a generation
@@ -321,3 +442,116 @@ func (r *Repository) CreateGenerationWithImages(ctx context.Context, gen *model.
 		return nil
 	})
 }
+
+// CreateGenerationImages inserts all image records for a generation in one transaction
+func (r *Repository) CreateGenerationImages(ctx context.Context, images []*model.GenerationImage) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		query := `
+			INSERT INTO generation_images (
+				id, generation_id, prompt, status, task_id, created_at, updated_at
+			)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			RETURNING created_at, updated_at
+		`
+		for _, img := range images {
+			err := tx.QueryRow(ctx, query,
+				img.ID, img.GenerationID, img.Prompt, img.Status, img.TaskID,
+			).Scan(&img.CreatedAt, &img.UpdatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to insert image: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+const generationColumns = `
+	id, organization_id, user_id, status, base_prompt,
+	reference_images, product_images, reference_upload_ids, product_upload_ids,
+	provider_id, options, estimated_cost, actual_cost, num_images, COALESCE(error_message, ''),
+	created_at, updated_at, completed_at
+`
+
+func scanGeneration(row pgx.Row) (*model.Generation, error) {
+	var gen model.Generation
+	var optionsJSON []byte
+	err := row.Scan(
+		&gen.ID,
+		&gen.OrganizationID,
+		&gen.UserID,
+		&gen.Status,
+		&gen.BasePrompt,
+		&gen.ReferenceImages,
+		&gen.ProductImages,
+		&gen.ReferenceUploadIDs,
+		&gen.ProductUploadIDs,
+		&gen.ProviderID,
+		&optionsJSON,
+		&gen.EstimatedCost,
+		&gen.ActualCost,
+		&gen.NumImages,
+		&gen.ErrorMessage,
+		&gen.CreatedAt,
+		&gen.UpdatedAt,
+		&gen.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(optionsJSON) > 0 {
+		if err := json.Unmarshal(optionsJSON, &gen.Options); err != nil {
+			return nil, fmt.Errorf("failed to decode generation options: %w", err)
+		}
+	}
+
+	return &gen, nil
+}
+
+// StoredObject describes a file persisted to object storage
+type StoredObject struct {
+	URL         string // permanent public URL; empty for a private bucket
+	Key         string
+	ContentType string
+	Size        int64
+	Checksum    string
+}
+
+const generationImageColumns = `
+	id, generation_id, prompt, COALESCE(image_url, ''), COALESCE(r2_key, ''), status,
+	COALESCE(task_id, ''), COALESCE(provider_slug, ''), submitted_at,
+	COALESCE(content_type, ''), COALESCE(size_bytes, 0),
+	COALESCE(checksum_sha256, ''), COALESCE(error_message, ''),
+	created_at, updated_at, completed_at, renditions, COALESCE(renditions_version, ''),
+	deleted_at
+`
+
+func scanGenerationImage(row pgx.Row) (*model.GenerationImage, error) {
+	var img model.GenerationImage
+	err := row.Scan(
+		&img.ID,
+		&img.GenerationID,
+		&img.Prompt,
+		&img.ImageURL,
+		&img.R2Key,
+		&img.Status,
+		&img.TaskID,
+		&img.ProviderSlug,
+		&img.SubmittedAt,
+		&img.ContentType,
+		&img.SizeBytes,
+		&img.Checksum,
+		&img.ErrorMessage,
+		&img.CreatedAt,
+		&img.UpdatedAt,
+		&img.CompletedAt,
+		&img.RenditionKeys,
+		&img.RenditionsVersion,
+		&img.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &img, nil
+}
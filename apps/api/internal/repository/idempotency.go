@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+const idempotencyKeyColumns = `organization_id, key, request_hash, user_id, status,
+	COALESCE(response_status, 0), COALESCE(response_content_type, ''), response_body,
+	created_at, completed_at, expires_at`
+
+func scanIdempotencyKey(row pgx.Row) (*model.IdempotencyKey, error) {
+	var k model.IdempotencyKey
+	err := row.Scan(
+		&k.OrganizationID,
+		&k.Key,
+		&k.RequestHash,
+		&k.UserID,
+		&k.Status,
+		&k.ResponseStatus,
+		&k.ResponseContentType,
+		&k.ResponseBody,
+		&k.CreatedAt,
+		&k.CompletedAt,
+		&k.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// ClaimIdempotencyKey reserves an idempotency key of an organization for a request. It
+// returns nil when the key is new or has expired, and the caller runs the request;
+// otherwise it returns the key as recorded by the first request, which may still be in
+// progress.
+func (r *Repository) ClaimIdempotencyKey(ctx context.Context, orgID uuid.UUID, key, requestHash string, userID *uuid.UUID, ttl time.Duration) (*model.IdempotencyKey, error) {
+	var existing *model.IdempotencyKey
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		// The conflicting row is locked even when it is not taken over, so it can be read
+		// consistently below
+		var claimed bool
+		err := tx.QueryRow(ctx, `
+			INSERT INTO idempotency_keys (organization_id, key, request_hash, user_id, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (organization_id, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash, user_id = EXCLUDED.user_id,
+				status = 'in_progress', response_status = NULL,
+				response_content_type = NULL, response_body = NULL,
+				created_at = NOW(), completed_at = NULL, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= NOW()
+			RETURNING true
+		`, orgID, key, requestHash, userID, time.Now().Add(ttl)).Scan(&claimed)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+
+		existing, err = scanIdempotencyKey(tx.QueryRow(ctx,
+			`SELECT `+idempotencyKeyColumns+` FROM idempotency_keys WHERE organization_id = $1 AND key = $2`,
+			orgID, key,
+		))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding an idempotency key,
+// to be replayed to retries until the key expires
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, orgID uuid.UUID, key string, status int, contentType string, body []byte) error {
+	_, err := r.pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status = 'completed', response_status = $3, response_content_type = $4,
+			response_body = $5, completed_at = NOW()
+		WHERE organization_id = $1 AND key = $2 AND status = 'in_progress'
+	`, orgID, key, status, contentType, body)
+	return err
+}
+
+// ReleaseIdempotencyKey gives up an idempotency key whose request had no lasting effect,
+// so a retry runs the request again
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, orgID uuid.UUID, key string) error {
+	_, err := r.pool.Exec(ctx,
+		"DELETE FROM idempotency_keys WHERE organization_id = $1 AND key = $2 AND status = 'in_progress'",
+		orgID, key,
+	)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes keys past their expiry
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
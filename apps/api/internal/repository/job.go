@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// ErrJobLeaseLost is returned when a worker updates a job it no longer holds the lock on
+var ErrJobLeaseLost = errors.New("job is no longer locked by this worker")
+
+const generationJobColumns = `
+	id, generation_id, status, stage, state, attempts, max_attempts,
+	run_at, locked_by, locked_at, last_error, created_at, updated_at
+`
+
+// CreateGenerationWithJob inserts a generation, reserves its estimated cost and enqueues its
+// workflow job atomically. The hold expires after holdTTL unless it is settled first. It
+// fails with a LimitError when the generation would exceed the organization's concurrency
+// or daily image limit.
+func (r *Repository) CreateGenerationWithJob(ctx context.Context, gen *model.Generation, holdTTL time.Duration, limits model.OrganizationLimits) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := checkGenerationLimits(ctx, tx, gen, limits); err != nil {
+			return err
+		}
+
+		optionsJSON, err := json.Marshal(gen.Options)
+		if err != nil {
+			return fmt.Errorf("failed to encode generation options: %w", err)
+		}
+
+		query := `
+			INSERT INTO generations (
+				id, organization_id, user_id, status, base_prompt,
+				reference_images, product_images, reference_upload_ids, product_upload_ids,
+				provider_id, options, estimated_cost, actual_cost, num_images,
+				created_at, updated_at
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NOW(), NOW())
+			RETURNING created_at, updated_at
+		`
+		err = tx.QueryRow(ctx, query,
+			gen.ID, gen.OrganizationID, gen.UserID, gen.Status,
+			gen.BasePrompt, gen.ReferenceImages, gen.ProductImages,
+			uploadIDs(gen.ReferenceUploadIDs), uploadIDs(gen.ProductUploadIDs),
+			gen.ProviderID, optionsJSON, gen.EstimatedCost, gen.ActualCost, gen.NumImages,
+		).Scan(&gen.CreatedAt, &gen.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert generation: %w", err)
+		}
+
+		if gen.EstimatedCost > 0 {
+			if err := reserveCredits(ctx, tx, gen, holdTTL); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO generation_jobs (generation_id) VALUES ($1)`,
+			gen.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue generation job: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ClaimGenerationJob locks the next runnable job for a worker.
+// It returns nil without an error when the queue is empty.
+func (r *Repository) ClaimGenerationJob(ctx context.Context, workerID string) (*model.GenerationJob, error) {
+	query := `
+		UPDATE generation_jobs
+		SET status = 'running', locked_by = $1, locked_at = NOW(),
+			attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM generation_jobs
+			WHERE status = 'queued' AND run_at <= NOW()
+			ORDER BY run_at ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + generationJobColumns
+
+	job, err := scanGenerationJob(r.pool.QueryRow(ctx, query, workerID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// UpdateGenerationJobProgress checkpoints the stage and intermediate state of a job and
+// renews the lease of the worker running it. It fails with ErrJobLeaseLost when the job
+// is no longer locked by lockedBy, e.g. because it was recovered after the lease expired.
+func (r *Repository) UpdateGenerationJobProgress(ctx context.Context, id uuid.UUID, lockedBy, stage string, state model.GenerationJobState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode job state: %w", err)
+	}
+
+	query := `
+		UPDATE generation_jobs
+		SET stage = $3, state = $4, locked_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`
+	tag, err := r.pool.Exec(ctx, query, id, lockedBy, stage, stateJSON)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// CompleteGenerationJob marks a job as finished.
+// It reports false without changing anything when the job is no longer locked by lockedBy.
+func (r *Repository) CompleteGenerationJob(ctx context.Context, id uuid.UUID, lockedBy string) (bool, error) {
+	query := `
+		UPDATE generation_jobs
+		SET status = 'completed', stage = 'done', locked_by = NULL, locked_at = NULL,
+			last_error = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`
+	tag, err := r.pool.Exec(ctx, query, id, lockedBy)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RetryGenerationJob releases a job back to the queue to run again at runAt.
+// It reports false without changing anything when the job is no longer locked by lockedBy.
+func (r *Repository) RetryGenerationJob(ctx context.Context, id uuid.UUID, lockedBy string, runAt time.Time, errorMsg string) (bool, error) {
+	query := `
+		UPDATE generation_jobs
+		SET status = 'queued', run_at = $3, last_error = $4,
+			locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`
+	tag, err := r.pool.Exec(ctx, query, id, lockedBy, runAt, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// FailGenerationJob marks a job as permanently failed.
+// It reports false without changing anything when the job is no longer locked by lockedBy.
+func (r *Repository) FailGenerationJob(ctx context.Context, id uuid.UUID, lockedBy, errorMsg string) (bool, error) {
+	query := `
+		UPDATE generation_jobs
+		SET status = 'failed', last_error = $3,
+			locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`
+	tag, err := r.pool.Exec(ctx, query, id, lockedBy, errorMsg)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecoverStaleGenerationJobs requeues running jobs whose lock is older than staleAfter,
+// e.g. because the worker holding them was killed mid-run. Jobs locked by one of lockIDs
+// are requeued regardless of age, which lets a restarted worker reclaim its own jobs at once.
+func (r *Repository) RecoverStaleGenerationJobs(ctx context.Context, staleAfter time.Duration, lockIDs []string) (int64, error) {
+	query := `
+		UPDATE generation_jobs
+		SET status = 'queued', run_at = NOW(), locked_by = NULL, locked_at = NULL,
+			last_error = 'recovered after worker lease expired', updated_at = NOW()
+		WHERE status = 'running'
+		AND (locked_at < NOW() - make_interval(secs => $1) OR locked_by = ANY($2))
+	`
+	tag, err := r.pool.Exec(ctx, query, staleAfter.Seconds(), lockIDs)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// EnqueueOrphanedGenerations creates jobs for unfinished generations that have none
+func (r *Repository) EnqueueOrphanedGenerations(ctx context.Context) (int64, error) {
+	query := `
+		INSERT INTO generation_jobs (generation_id)
+		SELECT g.id FROM generations g
+		WHERE g.status IN ('pending', 'processing')
+		AND NOT EXISTS (SELECT 1 FROM generation_jobs j WHERE j.generation_id = g.id)
+		ON CONFLICT (generation_id) DO NOTHING
+	`
+	tag, err := r.pool.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func scanGenerationJob(row pgx.Row) (*model.GenerationJob, error) {
+	var job model.GenerationJob
+	var stateJSON []byte
+	var lockedBy, lastError *string
+	err := row.Scan(
+		&job.ID,
+		&job.GenerationID,
+		&job.Status,
+		&job.Stage,
+		&stateJSON,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&lockedBy,
+		&job.LockedAt,
+		&lastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedBy != nil {
+		job.LockedBy = *lockedBy
+	}
+	if lastError != nil {
+		job.LastError = *lastError
+	}
+	if len(stateJSON) > 0 {
+		if err := json.Unmarshal(stateJSON, &job.State); err != nil {
+			return nil, fmt.Errorf("failed to decode job state: %w", err)
+		}
+	}
+
+	return &job, nil
+}
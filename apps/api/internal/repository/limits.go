@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// Limits reported in a LimitError
+const (
+	LimitRequestsPerMinute        = "requests_per_minute"
+	LimitUserRequestsPerMinute    = "user_requests_per_minute"
+	LimitMaxConcurrentGenerations = "max_concurrent_generations"
+	LimitMaxImagesPerDay          = "max_images_per_day"
+)
+
+// LimitError is returned when a usage limit of the organization rejects a request
+type LimitError struct {
+	Limit      string        // one of the Limit* constants
+	Max        int           // the configured limit
+	RetryAfter time.Duration // when the request may succeed again
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d reached", e.Limit, e.Max)
+}
+
+// GetOrganizationLimits returns the limits of an organization, taking each limit it has no
+// setting for from defaults
+func (r *Repository) GetOrganizationLimits(ctx context.Context, orgID uuid.UUID, defaults model.OrganizationLimits) (model.OrganizationLimits, error) {
+	var rpm, userRPM, concurrent, daily *int
+	err := r.pool.QueryRow(ctx, `
+		SELECT requests_per_minute, user_requests_per_minute,
+			max_concurrent_generations, max_images_per_day
+		FROM organization_limits
+		WHERE organization_id = $1
+	`, orgID).Scan(&rpm, &userRPM, &concurrent, &daily)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return defaults, nil
+	}
+	if err != nil {
+		return defaults, err
+	}
+
+	limits := defaults
+	if rpm != nil {
+		limits.RequestsPerMinute = *rpm
+	}
+	if userRPM != nil {
+		limits.UserRequestsPerMinute = *userRPM
+	}
+	if concurrent != nil {
+		limits.MaxConcurrentGenerations = *concurrent
+	}
+	if daily != nil {
+		limits.MaxImagesPerDay = *daily
+	}
+	return limits, nil
+}
+
+// RateBucket is a token bucket holding up to PerMinute tokens and refilling continuously at
+// PerMinute tokens a minute. Limit names the limit reported when it is empty.
+type RateBucket struct {
+	Key       string
+	PerMinute int
+	Limit     string
+}
+
+// TakeRateTokens takes a token from each bucket, or none of them when any bucket is empty.
+// Buckets live in the database so the limits hold across API instances. It fails with a
+// LimitError for the bucket that has to refill longest.
+func (r *Repository) TakeRateTokens(ctx context.Context, buckets []RateBucket) error {
+	var active []RateBucket
+	for _, b := range buckets {
+		if b.PerMinute > 0 {
+			active = append(active, b)
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	// Lock the buckets in a fixed order, so concurrent requests cannot deadlock
+	sort.Slice(active, func(i, j int) bool { return active[i].Key < active[j].Key })
+
+	var limitErr *LimitError
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		keys := make([]string, 0, len(active))
+		for _, b := range active {
+			// Refill the bucket for the time since it was last used, locking its row. The
+			// clock is read after the lock is acquired, so waiting never counts twice.
+			var tokens float64
+			err := tx.QueryRow(ctx, `
+				INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at)
+				VALUES ($1, $2, clock_timestamp())
+				ON CONFLICT (key) DO UPDATE
+				SET tokens = LEAST($2, b.tokens + $2 / 60.0 *
+						GREATEST(0, EXTRACT(EPOCH FROM clock_timestamp() - b.updated_at)::float8)),
+					updated_at = GREATEST(b.updated_at, clock_timestamp())
+				RETURNING tokens
+			`, b.Key, float64(b.PerMinute)).Scan(&tokens)
+			if err != nil {
+				return fmt.Errorf("failed to refill rate limit bucket: %w", err)
+			}
+
+			if tokens < 1 {
+				wait := time.Duration((1 - tokens) * 60 / float64(b.PerMinute) * float64(time.Second))
+				if limitErr == nil || wait > limitErr.RetryAfter {
+					limitErr = &LimitError{Limit: b.Limit, Max: b.PerMinute, RetryAfter: wait}
+				}
+			}
+			keys = append(keys, b.Key)
+		}
+		if limitErr != nil {
+			// Keep the refill; only a granted request takes tokens
+			return nil
+		}
+
+		_, err := tx.Exec(ctx,
+			"UPDATE rate_limit_buckets SET tokens = tokens - 1 WHERE key = ANY($1)",
+			keys,
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if limitErr != nil {
+		return limitErr
+	}
+	return nil
+}
+
+// DeleteIdleRateLimitBuckets removes buckets unused since before cutoff. A bucket idle for a
+// minute is full, which is the same as having no bucket.
+func (r *Repository) DeleteIdleRateLimitBuckets(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// checkGenerationLimits enforces the concurrency and daily image limits for a new
+// generation. It locks the organization row, so concurrent submissions are counted one
+// after another.
+func checkGenerationLimits(ctx context.Context, tx pgx.Tx, gen *model.Generation, limits model.OrganizationLimits) error {
+	if limits.MaxConcurrentGenerations <= 0 && limits.MaxImagesPerDay <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, "SELECT 1 FROM organizations WHERE id = $1 FOR UPDATE", gen.OrganizationID)
+	if err != nil {
+		return fmt.Errorf("failed to lock organization: %w", err)
+	}
+
+	if limits.MaxConcurrentGenerations > 0 {
+		var inFlight int
+		err := tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM generations
+			WHERE organization_id = $1 AND status IN ('pending', 'processing')
+		`, gen.OrganizationID).Scan(&inFlight)
+		if err != nil {
+			return fmt.Errorf("failed to count generations in progress: %w", err)
+		}
+		if inFlight >= limits.MaxConcurrentGenerations {
+			return &LimitError{
+				Limit:      LimitMaxConcurrentGenerations,
+				Max:        limits.MaxConcurrentGenerations,
+				RetryAfter: concurrencyRetryAfter,
+			}
+		}
+	}
+
+	if limits.MaxImagesPerDay > 0 {
+		now := time.Now().UTC()
+		dayStart := now.Truncate(24 * time.Hour)
+
+		// Failed generations produced nothing and do not count
+		var requested int
+		err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(num_images), 0) FROM generations
+			WHERE organization_id = $1 AND created_at >= $2 AND status <> 'failed'
+		`, gen.OrganizationID, dayStart).Scan(&requested)
+		if err != nil {
+			return fmt.Errorf("failed to count images requested today: %w", err)
+		}
+		if requested+gen.NumImages > limits.MaxImagesPerDay {
+			return &LimitError{
+				Limit:      LimitMaxImagesPerDay,
+				Max:        limits.MaxImagesPerDay,
+				RetryAfter: dayStart.Add(24 * time.Hour).Sub(now),
+			}
+		}
+	}
+
+	return nil
+}
+
+// concurrencyRetryAfter is suggested to clients waiting for a generation to finish, which
+// has no predictable end
+const concurrencyRetryAfter = 30 * time.Second
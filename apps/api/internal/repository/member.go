@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+var (
+	// ErrInvalidInvitation is returned when an invitation token is unknown, used, revoked or expired
+	ErrInvalidInvitation = errors.New("invitation is invalid or has expired")
+	// ErrInvitationEmailMismatch is returned when an invitation is accepted with a different email
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+	// ErrAlreadyMember is returned when the user already belongs to the organization
+	ErrAlreadyMember = errors.New("user is already a member of this organization")
+	// ErrLastAdmin is returned when a change would leave an organization without an admin
+	ErrLastAdmin = errors.New("organization must keep at least one admin")
+)
+
+// invitationColumns is the column list scanned by scanInvitation
+const invitationColumns = `
+	id, organization_id, email, role, invited_by, token_hash, expires_at,
+	used_at, revoked_at, accepted_by, created_at`
+
+// GetProfileInOrganization retrieves a user's profile within a specific organization
+func (r *Repository) GetProfileInOrganization(ctx context.Context, userID, orgID uuid.UUID) (*model.Profile, error) {
+	query := `
+		SELECT id, user_id, organization_id, COALESCE(full_name, ''), COALESCE(avatar_url, ''), role, created_at, updated_at
+		FROM profiles
+		WHERE user_id = $1 AND organization_id = $2
+	`
+
+	var profile model.Profile
+	err := r.pool.QueryRow(ctx, query, userID, orgID).Scan(
+		&profile.ID,
+		&profile.UserID,
+		&profile.OrganizationID,
+		&profile.FullName,
+		&profile.AvatarURL,
+		&profile.Role,
+		&profile.CreatedAt,
+		&profile.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// ListMembers lists the profiles of an organization with their email addresses
+func (r *Repository) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*model.Member, error) {
+	query := `
+		SELECT p.id, p.user_id, u.email, COALESCE(p.full_name, ''), COALESCE(p.avatar_url, ''), p.role, p.created_at
+		FROM profiles p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.organization_id = $1
+		ORDER BY p.created_at ASC
+	`
+
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*model.Member{}
+	for rows.Next() {
+		var m model.Member
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Email, &m.FullName, &m.AvatarURL, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+
+	return members, rows.Err()
+}
+
+// IsMemberByEmail reports whether a user with the given email belongs to the organization
+func (r *Repository) IsMemberByEmail(ctx context.Context, orgID uuid.UUID, email string) (bool, error) {
+	var exists bool
+	err := r.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM profiles p
+			JOIN users u ON u.id = p.user_id
+			WHERE p.organization_id = $1 AND lower(u.email) = lower($2)
+		)
+	`, orgID, email).Scan(&exists)
+	return exists, err
+}
+
+// UpdateMemberRole changes a member's role. Demoting the last admin returns ErrLastAdmin.
+func (r *Repository) UpdateMemberRole(ctx context.Context, orgID, profileID uuid.UUID, role string) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		current, err := lockMember(ctx, tx, orgID, profileID)
+		if err != nil {
+			return err
+		}
+		if current == "admin" && role != "admin" {
+			if err := ensureAnotherAdmin(ctx, tx, orgID, profileID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE profiles SET role = $1, updated_at = NOW() WHERE id = $2`, role, profileID)
+		return err
+	})
+}
+
+// RemoveMember deletes a member's profile. Removing the last admin returns ErrLastAdmin.
+func (r *Repository) RemoveMember(ctx context.Context, orgID, profileID uuid.UUID) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		current, err := lockMember(ctx, tx, orgID, profileID)
+		if err != nil {
+			return err
+		}
+		if current == "admin" {
+			if err := ensureAnotherAdmin(ctx, tx, orgID, profileID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM profiles WHERE id = $1`, profileID)
+		return err
+	})
+}
+
+// lockMember locks the organization's admin rows and the target profile, returning its role.
+// Locking every admin serializes concurrent demotions so two admins cannot demote each other.
+func lockMember(ctx context.Context, tx pgx.Tx, orgID, profileID uuid.UUID) (string, error) {
+	if _, err := tx.Exec(ctx, `
+		SELECT id FROM profiles
+		WHERE organization_id = $1 AND role = 'admin'
+		ORDER BY id
+		FOR UPDATE
+	`, orgID); err != nil {
+		return "", fmt.Errorf("failed to lock admins: %w", err)
+	}
+
+	var role string
+	err := tx.QueryRow(ctx, `
+		SELECT role FROM profiles
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE
+	`, profileID, orgID).Scan(&role)
+	return role, err
+}
+
+func ensureAnotherAdmin(ctx context.Context, tx pgx.Tx, orgID, profileID uuid.UUID) error {
+	var others int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM profiles
+		WHERE organization_id = $1 AND role = 'admin' AND id <> $2
+	`, orgID, profileID).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// CreateInvitation stores a new invitation, revoking any open invitation for the same email
+func (r *Repository) CreateInvitation(ctx context.Context, inv *model.Invitation) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE invitations SET revoked_at = NOW()
+			WHERE organization_id = $1 AND lower(email) = lower($2)
+			AND used_at IS NULL AND revoked_at IS NULL
+		`, inv.OrganizationID, inv.Email)
+		if err != nil {
+			return fmt.Errorf("failed to revoke previous invitations: %w", err)
+		}
+
+		return tx.QueryRow(ctx, `
+			INSERT INTO invitations (id, organization_id, email, role, invited_by, token_hash, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			RETURNING created_at
+		`, inv.ID, inv.OrganizationID, inv.Email, inv.Role, inv.InvitedBy, inv.TokenHash, inv.ExpiresAt).Scan(&inv.CreatedAt)
+	})
+}
+
+// ListInvitations lists an organization's invitations, newest first
+func (r *Repository) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]*model.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE organization_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*model.Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, rows.Err()
+}
+
+// RevokeInvitation revokes an open invitation of an organization
+func (r *Repository) RevokeInvitation(ctx context.Context, orgID, id uuid.UUID) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE invitations SET revoked_at = NOW()
+		WHERE id = $1 AND organization_id = $2 AND used_at IS NULL AND revoked_at IS NULL
+	`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CreateUserWithInvitation registers a new user and adds them to the inviting organization in
+// one transaction, consuming the invitation
+func (r *Repository) CreateUserWithInvitation(ctx context.Context, tokenHash, email, passwordHash, fullName string) (*model.User, *model.Profile, error) {
+	var user *model.User
+	var profile *model.Profile
+
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		inv, err := claimInvitation(ctx, tx, tokenHash, email)
+		if err != nil {
+			return err
+		}
+
+		user = &model.User{ID: uuid.New(), Email: email, Password: passwordHash}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO users (id, email, password_hash, created_at, updated_at)
+			VALUES ($1, $2, $3, NOW(), NOW())
+			RETURNING created_at, updated_at
+		`, user.ID, user.Email, passwordHash).Scan(&user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		profile, err = acceptInvitation(ctx, tx, inv, user.ID, fullName)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, profile, nil
+}
+
+// AcceptInvitation adds an existing user to the inviting organization, consuming the invitation
+func (r *Repository) AcceptInvitation(ctx context.Context, tokenHash string, userID uuid.UUID, fullName string) (*model.Profile, error) {
+	var profile *model.Profile
+
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		var email string
+		if err := tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		inv, err := claimInvitation(ctx, tx, tokenHash, email)
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		err = tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM profiles WHERE user_id = $1 AND organization_id = $2)
+		`, userID, inv.OrganizationID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyMember
+		}
+
+		profile, err = acceptInvitation(ctx, tx, inv, userID, fullName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// claimInvitation locks an open invitation by token hash and checks it was sent to email
+func claimInvitation(ctx context.Context, tx pgx.Tx, tokenHash, email string) (*model.Invitation, error) {
+	inv, err := scanInvitation(tx.QueryRow(ctx, `
+		SELECT `+invitationColumns+` FROM invitations
+		WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(inv.Email, email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+	return inv, nil
+}
+
+// acceptInvitation creates the member profile and marks the invitation used
+func acceptInvitation(ctx context.Context, tx pgx.Tx, inv *model.Invitation, userID uuid.UUID, fullName string) (*model.Profile, error) {
+	profile := &model.Profile{
+		ID:             uuid.New(),
+		UserID:         userID,
+		OrganizationID: inv.OrganizationID,
+		FullName:       fullName,
+		Role:           inv.Role,
+	}
+	err := tx.QueryRow(ctx, `
+		INSERT INTO profiles (id, user_id, organization_id, full_name, avatar_url, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, '', $5, NOW(), NOW())
+		RETURNING created_at, updated_at
+	`, profile.ID, profile.UserID, profile.OrganizationID, profile.FullName, profile.Role).Scan(&profile.CreatedAt, &profile.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE invitations SET used_at = NOW(), accepted_by = $1 WHERE id = $2
+	`, userID, inv.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark invitation used: %w", err)
+	}
+
+	return profile, nil
+}
+
+// scanInvitation scans a row selected with invitationColumns
+func scanInvitation(row pgx.Row) (*model.Invitation, error) {
+	var inv model.Invitation
+	err := row.Scan(
+		&inv.ID,
+		&inv.OrganizationID,
+		&inv.Email,
+		&inv.Role,
+		&inv.InvitedBy,
+		&inv.TokenHash,
+		&inv.ExpiresAt,
+		&inv.UsedAt,
+		&inv.RevokedAt,
+		&inv.AcceptedBy,
+		&inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// CreateOIDCLoginState stores a login started with an OIDC provider
+func (r *Repository) CreateOIDCLoginState(ctx context.Context, st *model.OIDCLoginState) error {
+	return r.pool.QueryRow(ctx, `
+		INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, invite_token_hash, org_name, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		RETURNING created_at
+	`, st.StateHash, st.Provider, st.Nonce, st.CodeVerifier, st.InviteTokenHash, st.OrgName, st.ExpiresAt,
+	).Scan(&st.CreatedAt)
+}
+
+// ConsumeOIDCLoginState deletes and returns an unexpired login state, so each state is
+// used at most once. Unknown and expired states return pgx.ErrNoRows.
+func (r *Repository) ConsumeOIDCLoginState(ctx context.Context, stateHash string) (*model.OIDCLoginState, error) {
+	var st model.OIDCLoginState
+	err := r.pool.QueryRow(ctx, `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING state_hash, provider, nonce, code_verifier, COALESCE(invite_token_hash, ''),
+			COALESCE(org_name, ''), created_at, expires_at
+	`, stateHash).Scan(
+		&st.StateHash,
+		&st.Provider,
+		&st.Nonce,
+		&st.CodeVerifier,
+		&st.InviteTokenHash,
+		&st.OrgName,
+		&st.CreatedAt,
+		&st.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// DeleteExpiredOIDCLoginStates removes logins that were abandoned before the callback
+func (r *Repository) DeleteExpiredOIDCLoginStates(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetUserByIdentity returns the user linked to a provider identity and records the login
+func (r *Repository) GetUserByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	var user model.User
+	err := r.pool.QueryRow(ctx, `
+		WITH identity AS (
+			UPDATE user_identities SET last_login_at = NOW()
+			WHERE provider = $1 AND subject = $2
+			RETURNING user_id
+		)
+		SELECT u.id, u.email, u.password_hash, u.created_at, u.updated_at
+		FROM users u JOIN identity i ON i.user_id = u.id
+	`, provider, subject).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindUserByEmail looks a user up by email, ignoring case
+func (r *Repository) FindUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	var user model.User
+	err := r.pool.QueryRow(ctx, `
+		SELECT id, email, password_hash, created_at, updated_at
+		FROM users
+		WHERE lower(email) = lower($1)
+		ORDER BY created_at
+		LIMIT 1
+	`, email).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkUserIdentity links a provider identity to a user; linking it again is a no-op
+func (r *Repository) LinkUserIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error {
+	_, err := r.pool.Exec(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO NOTHING
+	`, provider, subject, userID, email)
+	return err
+}
@@ -4,90 +4,44 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/secret"
 )
 
+// providerColumns is the column list scanned by scanProvider
+const providerColumns = `
+	id, slug, name, category, driver, COALESCE(api_key, ''), COALESCE(base_url, ''), model, config,
+	priority, is_active, cost_per_use, created_at, updated_at`
+
 // GetProvider retrieves a provider by ID
 func (r *Repository) GetProvider(ctx context.Context, id uuid.UUID) (*model.Provider, error) {
-	query := `
-		SELECT id, slug, name, category, api_key, base_url, model, config,
-			priority, is_active, cost_per_use, created_at, updated_at
-		FROM providers
-		WHERE id = $1
-	`
-
-	var provider model.Provider
-	var configJSON []byte
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&provider.ID,
-		&provider.Slug,
-		&provider.Name,
-		&provider.Category,
-		&provider.APIKey,
-		&provider.BaseURL,
-		&provider.Model,
-		&configJSON,
-		&provider.Priority,
-		&provider.IsActive,
-		&provider.CostPerUse,
-		&provider.CreatedAt,
-		&provider.UpdatedAt,
-	)
+	query := `SELECT ` + providerColumns + ` FROM providers WHERE id = $1`
+	provider, err := scanProvider(r.pool.QueryRow(ctx, query, id))
 	if err != nil {
 		return nil, err
 	}
-
-	if len(configJSON) > 0 {
-		json.Unmarshal(configJSON, &provider.Config)
-	}
-
-	return &provider, nil
+	return provider, r.decryptAPIKey(provider)
 }
 
 // GetProviderBySlug retrieves a provider by slug
 func (r *Repository) GetProviderBySlug(ctx context.Context, slug string) (*model.Provider, error) {
-	query := `
-		SELECT id, slug, name, category, api_key, base_url, model, config,
-			priority, is_active, cost_per_use, created_at, updated_at
-		FROM providers
-		WHERE slug = $1
-	`
-
-	var provider model.Provider
-	var configJSON []byte
-	err := r.pool.QueryRow(ctx, query, slug).Scan(
-		&provider.ID,
-		&provider.Slug,
-		&provider.Name,
-		&provider.Category,
-		&provider.APIKey,
-		&provider.BaseURL,
-		&provider.Model,
-		&configJSON,
-		&provider.Priority,
-		&provider.IsActive,
-		&provider.CostPerUse,
-		&provider.CreatedAt,
-		&provider.UpdatedAt,
-	)
+	query := `SELECT ` + providerColumns + ` FROM providers WHERE slug = $1`
+	provider, err := scanProvider(r.pool.QueryRow(ctx, query, slug))
 	if err != nil {
 		return nil, err
 	}
-
-	if len(configJSON) > 0 {
-		json.Unmarshal(configJSON, &provider.Config)
-	}
-
-	return &provider, nil
+	return provider, r.decryptAPIKey(provider)
 }
 
-// ListProviders lists providers by category
-func (r *Repository) ListProviders(ctx context.Context, category string, onlyActive bool) ([]*model.Provider, error) {
+// ListProviders lists providers by category. Rows whose API key cannot be decrypted are
+// skipped and reported so one bad entry cannot hide the rest.
+func (r *Repository) ListProviders(ctx context.Context, category string, onlyActive bool) ([]*model.Provider, []error, error) {
 	query := `
-		SELECT id, slug, name, category, api_key, base_url, model, config,
-			priority, is_active, cost_per_use, created_at, updated_at
+		SELECT ` + providerColumns + `
 		FROM providers
 		WHERE ($1 = '' OR category = $1)
 		AND ($2 = false OR is_active = true)
@@ -96,59 +50,50 @@ func (r *Repository) ListProviders(ctx context.Context, category string, onlyAct
 
 	rows, err := r.pool.Query(ctx, query, category, onlyActive)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var providers []*model.Provider
+	var skipped []error
 	for rows.Next() {
-		var provider model.Provider
-		var configJSON []byte
-		err := rows.Scan(
-			&provider.ID,
-			&provider.Slug,
-			&provider.Name,
-			&provider.Category,
-			&provider.APIKey,
-			&provider.BaseURL,
-			&provider.Model,
-			&configJSON,
-			&provider.Priority,
-			&provider.IsActive,
-			&provider.CostPerUse,
-			&provider.CreatedAt,
-			&provider.UpdatedAt,
-		)
+		provider, err := scanProvider(rows)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		if len(configJSON) > 0 {
-			json.Unmarshal(configJSON, &provider.Config)
+		if err := r.decryptAPIKey(provider); err != nil {
+			skipped = append(skipped, err)
+			continue
 		}
-		providers = append(providers, &provider)
+		providers = append(providers, provider)
 	}
 
-	return providers, nil
+	return providers, skipped, rows.Err()
 }
 
 // CreateProvider creates a new provider
 func (r *Repository) CreateProvider(ctx context.Context, provider *model.Provider) error {
 	configJSON, _ := json.Marshal(provider.Config)
+	apiKey, err := r.encryptAPIKey(provider.APIKey)
+	if err != nil {
+		return err
+	}
 
 	query := `
 		INSERT INTO providers (
-			slug, name, category, api_key, base_url, model, config,
+			slug, name, category, driver, api_key, base_url, model, config,
 			priority, is_active, cost_per_use, created_at, updated_at
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW())
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), NOW())
 		RETURNING id, created_at, updated_at
 	`
 
-	return r.pool.QueryRow(ctx, query,
+	err = r.pool.QueryRow(ctx, query,
 		provider.Slug,
 		provider.Name,
 		provider.Category,
-		provider.APIKey,
+		provider.Driver,
+		apiKey,
 		provider.BaseURL,
 		provider.Model,
 		configJSON,
@@ -156,39 +101,222 @@ func (r *Repository) CreateProvider(ctx context.Context, provider *model.Provide
 		provider.IsActive,
 		provider.CostPerUse,
 	).Scan(&provider.ID, &provider.CreatedAt, &provider.UpdatedAt)
+	if err == nil {
+		provider.APIKeyFingerprint = secret.Fingerprint(provider.APIKey)
+	}
+	return err
 }
 
-// UpdateProvider updates a provider
+// updatableProviderColumns whitelists the columns UpdateProvider may change. Slug and
+// category are immutable because callbacks and stored images reference them.
+var updatableProviderColumns = map[string]bool{
+	"name":         true,
+	"driver":       true,
+	"api_key":      true,
+	"base_url":     true,
+	"model":        true,
+	"config":       true,
+	"priority":     true,
+	"is_active":    true,
+	"cost_per_use": true,
+}
+
+// UpdateProvider updates the given columns of a provider. Unknown columns are rejected.
 func (r *Repository) UpdateProvider(ctx context.Context, id uuid.UUID, updates map[string]interface{}) error {
-	// Build dynamic query
+	fields := make([]string, 0, len(updates))
+	for field := range updates {
+		if !updatableProviderColumns[field] {
+			return fmt.Errorf("provider field %q cannot be updated", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// Build dynamic query from whitelisted column names only
 	query := `UPDATE providers SET `
 	args := []interface{}{}
 	argCount := 0
 
-	for field, value := range updates {
-		if field == "config" {
-			configJSON, _ := json.Marshal(value)
-			argCount++
-			query += fmt.Sprintf("%s = $%d, ", field, argCount)
-			args = append(args, configJSON)
-		} else {
-			argCount++
-			query += fmt.Sprintf("%s = $%d, ", field, argCount)
-			args = append(args, value)
+	for _, field := range fields {
+		value := updates[field]
+		switch field {
+		case "api_key":
+			plain, _ := value.(string)
+			encrypted, err := r.encryptAPIKey(plain)
+			if err != nil {
+				return err
+			}
+			value = encrypted
+		case "config":
+			configJSON, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("invalid provider config: %w", err)
+			}
+			value = configJSON
 		}
+		argCount++
+		query += fmt.Sprintf("%s = $%d, ", field, argCount)
+		args = append(args, value)
 	}
 
 	argCount++
 	query += fmt.Sprintf("updated_at = NOW() WHERE id = $%d", argCount)
 	args = append(args, id)
 
-	_, err := r.pool.Exec(ctx, query, args...)
-	return err
+	tag, err := r.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
 }
 
 // DeleteProvider deletes a provider
 func (r *Repository) DeleteProvider(ctx context.Context, id uuid.UUID) error {
 	query := `DELETE FROM providers WHERE id = $1`
-	_, err := r.pool.Exec(ctx, query, id)
-	return err
+	tag, err := r.pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CountGenerationsForProvider counts the generations that were created for a provider or
+// had images generated by it, finished or not
+func (r *Repository) CountGenerationsForProvider(ctx context.Context, providerID uuid.UUID, slug string) (int, error) {
+	var count int
+	err := r.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM generations
+		WHERE provider_id = $1
+		OR id IN (SELECT generation_id FROM generation_images WHERE provider_slug = $2)
+	`, providerID, slug).Scan(&count)
+	return count, err
+}
+
+// RotateProviderKeys re-encrypts every stored provider API key with the keyring's current key,
+// including keys still stored as plaintext. It returns the number of rows rewritten.
+func (r *Repository) RotateProviderKeys(ctx context.Context) (int, error) {
+	if r.keyring == nil {
+		return 0, fmt.Errorf("no encryption keyring configured")
+	}
+
+	rotated := 0
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT id, slug, api_key FROM providers
+			WHERE api_key IS NOT NULL AND api_key <> ''
+			FOR UPDATE
+		`)
+		if err != nil {
+			return err
+		}
+
+		type storedKey struct {
+			id    uuid.UUID
+			slug  string
+			value string
+		}
+		var keys []storedKey
+		for rows.Next() {
+			var k storedKey
+			if err := rows.Scan(&k.id, &k.slug, &k.value); err != nil {
+				rows.Close()
+				return err
+			}
+			keys = append(keys, k)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if !r.keyring.NeedsRotation(k.value) {
+				continue
+			}
+			plain, err := r.keyring.Decrypt(k.value)
+			if err != nil {
+				return fmt.Errorf("provider %s: %w", k.slug, err)
+			}
+			encrypted, err := r.keyring.Encrypt(plain)
+			if err != nil {
+				return fmt.Errorf("provider %s: %w", k.slug, err)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE providers SET api_key = $1, updated_at = NOW() WHERE id = $2`, encrypted, k.id); err != nil {
+				return fmt.Errorf("provider %s: %w", k.slug, err)
+			}
+			rotated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rotated, nil
+}
+
+// encryptAPIKey prepares a plaintext API key for storage
+func (r *Repository) encryptAPIKey(plain string) (string, error) {
+	if r.keyring == nil {
+		return plain, nil
+	}
+	encrypted, err := r.keyring.Encrypt(plain)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt API key: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptAPIKey replaces the stored API key with its plaintext and sets the masked fingerprint
+func (r *Repository) decryptAPIKey(provider *model.Provider) error {
+	if secret.IsEncrypted(provider.APIKey) {
+		if r.keyring == nil {
+			return fmt.Errorf("API key for provider %s is encrypted but no keyring is configured", provider.Slug)
+		}
+		plain, err := r.keyring.Decrypt(provider.APIKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt API key for provider %s: %w", provider.Slug, err)
+		}
+		provider.APIKey = plain
+	}
+	provider.APIKeyFingerprint = secret.Fingerprint(provider.APIKey)
+	return nil
+}
+
+// scanProvider scans a row selected with providerColumns
+func scanProvider(row pgx.Row) (*model.Provider, error) {
+	var provider model.Provider
+	var configJSON []byte
+	err := row.Scan(
+		&provider.ID,
+		&provider.Slug,
+		&provider.Name,
+		&provider.Category,
+		&provider.Driver,
+		&provider.APIKey,
+		&provider.BaseURL,
+		&provider.Model,
+		&configJSON,
+		&provider.Priority,
+		&provider.IsActive,
+		&provider.CostPerUse,
+		&provider.CreatedAt,
+		&provider.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(configJSON) > 0 {
+		if err := json.Unmarshal(configJSON, &provider.Config); err != nil {
+			return nil, fmt.Errorf("invalid config for provider %s: %w", provider.Slug, err)
+		}
+	}
+
+	return &provider, nil
 }
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/model"
+)
+
+// SetGenerationImageRenditions records the renditions stored for a generated image and the
+// version of the specs they were rendered with
+func (r *Repository) SetGenerationImageRenditions(ctx context.Context, id uuid.UUID, keys map[string]string, version string) error {
+	_, err := r.pool.Exec(ctx,
+		"UPDATE generation_images SET renditions = $2, renditions_version = $3, updated_at = NOW() WHERE id = $1",
+		id, keys, version,
+	)
+	return err
+}
+
+// SetUploadRenditions records the renditions stored for an upload and the version of the
+// specs they were rendered with
+func (r *Repository) SetUploadRenditions(ctx context.Context, id uuid.UUID, keys map[string]string, version string) error {
+	return r.scoped(ctx, func(q querier) error {
+		_, err := q.Exec(ctx,
+			"UPDATE uploads SET renditions = $2, renditions_version = $3 WHERE id = $1",
+			id, keys, version,
+		)
+		return err
+	})
+}
+
+// ListStaleGenerationImages returns up to limit stored images, after the given ID in ID
+// order, whose renditions were not rendered with version
+func (r *Repository) ListStaleGenerationImages(ctx context.Context, version string, after uuid.UUID, limit int) ([]*model.GenerationImage, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+generationImageColumns+` FROM generation_images
+		WHERE status = 'completed' AND r2_key IS NOT NULL AND deleted_at IS NULL
+		AND renditions_version IS DISTINCT FROM $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, version, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []*model.GenerationImage
+	for rows.Next() {
+		img, err := scanGenerationImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+// ListStaleUploads returns up to limit completed uploads, after the given ID in ID order,
+// whose renditions were not rendered with version
+func (r *Repository) ListStaleUploads(ctx context.Context, version string, after uuid.UUID, limit int) ([]*model.Upload, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+uploadColumns+` FROM uploads
+		WHERE status = 'completed' AND renditions_version IS DISTINCT FROM $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, version, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []*model.Upload
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
@@ -8,16 +8,31 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/secret"
 )
 
 // Repository provides data access layer
 type Repository struct {
 	pool *pgxpool.Pool
+
+	// keyring encrypts provider API keys at rest; nil stores them as plaintext
+	keyring *secret.Keyring
 }
 
 // NewRepository creates a new repository
 func NewRepository(databaseURL string) (*Repository, error) {
-	pool, err := pgxpool.New(context.Background(), databaseURL)
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	// Work without an organization scope (workers, sweepers, provider callbacks) passes the
+	// tenant policies explicitly; scoped transactions turn this off again
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, `SELECT set_config('app.rls_bypass', 'on', false)`)
+		return err
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
@@ -25,6 +40,11 @@ func NewRepository(databaseURL string) (*Repository, error) {
 	return &Repository{pool: pool}, nil
 }
 
+// SetKeyring enables encryption of provider API keys with k
+func (r *Repository) SetKeyring(k *secret.Keyring) {
+	r.keyring = k
+}
+
 // Close closes the connection pool
 func (r *Repository) Close() {
 	r.pool.Close()
@@ -35,7 +55,7 @@ func (r *Repository) Ping(ctx context.Context) error {
 	return r.pool.Ping(ctx)
 }
 
-// WithTx executes a function within a transaction
+// WithTx executes a function within a transaction, scoped to the organization of ctx if any
 func (r *Repository) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
@@ -43,6 +63,9 @@ func (r *Repository) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 	}
 	defer tx.Rollback(ctx)
 
+	if err := applyOrgScope(ctx, tx); err != nil {
+		return err
+	}
 	if err := fn(tx); err != nil {
 		return err
 	}
@@ -131,44 +154,3 @@ func (r *Repository) GetOrganization(ctx context.Context, id uuid.UUID) (*model.
 
 	return &org, nil
 }
-
-// DeductCredits atomically deducts credits from an organization
-func (r *Repository) DeductCredits(ctx context.Context, orgID uuid.UUID, amount int64, description string, userID uuid.UUID, generationID *uuid.UUID) error {
-	return r.WithTx(ctx, func(tx pgx.Tx) error {
-		// Lock the organization row
-		var currentCredits int64
-		err := tx.QueryRow(ctx, 
-			"SELECT credits FROM organizations WHERE id = $1 FOR UPDATE",
-			orgID,
-		).Scan(&currentCredits)
-		if err != nil {
-			return fmt.Errorf("failed to lock organization: %w", err)
-		}
-
-		if currentCredits < amount {
-			return fmt.Errorf("insufficient credits: have %d, need %d", currentCredits, amount)
-		}
-
-		// Deduct credits
-		_, err = tx.Exec(ctx,
-			"UPDATE organizations SET credits = credits - $1, updated_at = NOW() WHERE id = $2",
-			amount, orgID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to deduct credits: %w", err)
-		}
-
-		// Record in ledger
-		ledgerID := uuid.New()
-		_, err = tx.Exec(ctx,
-			`INSERT INTO credit_ledger (id, organization_id, user_id, amount, type, description, generation_id, created_at)
-			 VALUES ($1, $2, $3, $4, 'generation', $5, $6, NOW())`,
-			ledgerID, orgID, userID, -amount, description, generationID,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to record ledger entry: %w", err)
-		}
-
-		return nil
-	})
-}
@@ -2,11 +2,15 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/secret"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -66,11 +70,22 @@ func (s *RepositoryTestSuite) cleanupTestData() {
 	for _, id := range testOrgIDs {
 		uuidID, _ := uuid.Parse(id)
 		s.repo.pool.Exec(s.ctx, "DELETE FROM credit_ledger WHERE organization_id = $1", uuidID)
+		s.repo.pool.Exec(s.ctx, "DELETE FROM generation_jobs WHERE generation_id IN (SELECT id FROM generations WHERE organization_id = $1)", uuidID)
 		s.repo.pool.Exec(s.ctx, "DELETE FROM generation_images WHERE generation_id IN (SELECT id FROM generations WHERE organization_id = $1)", uuidID)
 		s.repo.pool.Exec(s.ctx, "DELETE FROM generations WHERE organization_id = $1", uuidID)
 		s.repo.pool.Exec(s.ctx, "DELETE FROM profiles WHERE organization_id = $1", uuidID)
 		s.repo.pool.Exec(s.ctx, "DELETE FROM organizations WHERE id = $1", uuidID)
 	}
+	s.repo.pool.Exec(s.ctx, "DELETE FROM users WHERE email LIKE '%@members.test'")
+	s.repo.pool.Exec(s.ctx, "DELETE FROM callback_inbox WHERE provider_slug = 'test-provider'")
+	s.repo.pool.Exec(s.ctx, "DELETE FROM rate_limit_buckets WHERE key LIKE 'test:%'")
+}
+
+// createTestUser creates a user that cleanupTestData removes again
+func (s *RepositoryTestSuite) createTestUser(email string) uuid.UUID {
+	user, err := s.repo.CreateUser(s.ctx, email, "hash")
+	require.NoError(s.T(), err)
+	return user.ID
 }
 
 func (s *RepositoryTestSuite) TestCreateAndGetOrganization() {
@@ -200,7 +215,13 @@ func (s *RepositoryTestSuite) TestGenerationWorkflow() {
 	assert.Len(s.T(), retrievedImages, 2)
 	
 	// Update image status to completed
-	err = s.repo.UpdateGenerationImageComplete(s.ctx, images[0].ID, "https://bucket.tansil.pro/image1.jpg", "gen/image1.jpg")
+	_, err = s.repo.UpdateGenerationImageComplete(s.ctx, images[0].ID, StoredObject{
+		URL:         "https://bucket.tansil.pro/image1.jpg",
+		Key:         "gen/image1.jpg",
+		ContentType: "image/jpeg",
+		Size:        1024,
+		Checksum:    "abc123",
+	})
 	require.NoError(s.T(), err)
 	
 	// Check stats
@@ -215,6 +236,8 @@ func (s *RepositoryTestSuite) TestGenerationWorkflow() {
 	require.NoError(s.T(), err)
 	assert.Equal(s.T(), images[0].ID, imgByTask.ID)
 	assert.Equal(s.T(), "completed", imgByTask.Status)
+	assert.Equal(s.T(), "image/jpeg", imgByTask.ContentType)
+	assert.Equal(s.T(), int64(1024), imgByTask.SizeBytes)
 }
 
 func (s *RepositoryTestSuite) TestCreditDeduction() {
@@ -255,7 +278,7 @@ func (s *RepositoryTestSuite) TestProviderOperations() {
 	}
 	
 	// Note: Providers table may be seeded, so we'll test retrieval
-	providers, err := s.repo.ListProviders(s.ctx, "llm", true)
+	providers, _, err := s.repo.ListProviders(s.ctx, "llm", true)
 	require.NoError(s.T(), err)
 	
 	// Should have at least the seeded providers
@@ -268,7 +291,1080 @@ func (s *RepositoryTestSuite) TestProviderOperations() {
 	}
 }
 
+func (s *RepositoryTestSuite) TestGenerationJobQueue() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:      uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name:    "Test Org",
+		Slug:    "test-org",
+		Credits: 1000,
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         uuid.MustParse("22222222-2222-2222-2222-222222222222"),
+		Status:         "pending",
+		BasePrompt:     "A beautiful sunset",
+		ProviderID:     uuid.MustParse("33333333-3333-3333-3333-333333333333"),
+	}
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, gen, time.Hour, model.OrganizationLimits{}))
+
+	// Claim the job
+	job, err := s.repo.ClaimGenerationJob(s.ctx, "worker-a")
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), job)
+	assert.Equal(s.T(), gen.ID, job.GenerationID)
+	assert.Equal(s.T(), "running", job.Status)
+	assert.Equal(s.T(), 1, job.Attempts)
+
+	// A running job cannot be claimed twice
+	other, err := s.repo.ClaimGenerationJob(s.ctx, "worker-b")
+	require.NoError(s.T(), err)
+	if other != nil {
+		assert.NotEqual(s.T(), job.ID, other.ID)
+	}
+
+	// Checkpoint progress, then simulate a restart of worker-a
+	state := model.GenerationJobState{
+		VisionResults: []*model.VisionAnalysisResult{{Description: "sunset", StyleNotes: "warm"}},
+	}
+	require.NoError(s.T(), s.repo.UpdateGenerationJobProgress(s.ctx, job.ID, "worker-a", "prompt_generation", state))
+
+	// Only the worker holding the lock can record progress or an outcome
+	assert.ErrorIs(s.T(), s.repo.UpdateGenerationJobProgress(s.ctx, job.ID, "worker-b", "submission", state), ErrJobLeaseLost)
+	completed, err := s.repo.CompleteGenerationJob(s.ctx, job.ID, "worker-b")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), completed)
+
+	recovered, err := s.repo.RecoverStaleGenerationJobs(s.ctx, time.Hour, []string{"worker-a"})
+	require.NoError(s.T(), err)
+	assert.GreaterOrEqual(s.T(), recovered, int64(1))
+
+	// The interrupted run lost its lease and cannot fail the job any more
+	failed, err := s.repo.FailGenerationJob(s.ctx, job.ID, "worker-a", "interrupted")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), failed)
+
+	resumed, err := s.repo.ClaimGenerationJob(s.ctx, "worker-a")
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), resumed)
+	assert.Equal(s.T(), "prompt_generation", resumed.Stage)
+	assert.Equal(s.T(), 2, resumed.Attempts)
+	require.Len(s.T(), resumed.State.VisionResults, 1)
+	assert.Equal(s.T(), "sunset", resumed.State.VisionResults[0].Description)
+
+	completed, err = s.repo.CompleteGenerationJob(s.ctx, resumed.ID, "worker-a")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), completed)
+}
+
+func (s *RepositoryTestSuite) TestCountGenerationsForProvider() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{ID: uuid.MustParse("11111111-1111-1111-1111-111111111111"), Name: "Test Org", Slug: "test-org"}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("providers@members.test")
+
+	prov := &model.Provider{
+		Slug:     "test-used-provider",
+		Name:     "Test Used Provider",
+		Category: "image_generation",
+		Driver:   "kieai",
+		Model:    "test-model",
+	}
+	s.repo.pool.Exec(s.ctx, "DELETE FROM providers WHERE slug = $1", prov.Slug)
+	require.NoError(s.T(), s.repo.CreateProvider(s.ctx, prov))
+	defer s.repo.DeleteProvider(s.ctx, prov.ID)
+
+	count, err := s.repo.CountGenerationsForProvider(s.ctx, prov.ID, prov.Slug)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), count)
+
+	// Finished generations still refer to the provider
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Status:         "completed",
+		BasePrompt:     "Test",
+		ProviderID:     prov.ID,
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+	count, err = s.repo.CountGenerationsForProvider(s.ctx, prov.ID, prov.Slug)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+}
+
+func (s *RepositoryTestSuite) TestProviderKeyEncryption() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	keyring, err := secret.NewKeyring("v1", "first-secret-0123456789", nil)
+	require.NoError(s.T(), err)
+	s.repo.SetKeyring(keyring)
+	defer s.repo.SetKeyring(nil)
+
+	prov := &model.Provider{
+		Slug:     "test-encrypted-provider",
+		Name:     "Test Encrypted Provider",
+		Category: "llm",
+		Driver:   "kieai",
+		APIKey:   "sk-test-abcdefghijklmnop",
+		Model:    "test-model",
+	}
+	s.repo.pool.Exec(s.ctx, "DELETE FROM providers WHERE slug = $1", prov.Slug)
+	require.NoError(s.T(), s.repo.CreateProvider(s.ctx, prov))
+	defer s.repo.DeleteProvider(s.ctx, prov.ID)
+
+	var stored string
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx, "SELECT api_key FROM providers WHERE id = $1", prov.ID).Scan(&stored))
+	assert.True(s.T(), secret.IsEncrypted(stored))
+	assert.NotContains(s.T(), stored, "abcdefghijklmnop")
+
+	fetched, err := s.repo.GetProvider(s.ctx, prov.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), prov.APIKey, fetched.APIKey)
+	assert.Equal(s.T(), secret.Fingerprint(prov.APIKey), fetched.APIKeyFingerprint)
+
+	// Rotate to a new key, keeping the old one for decryption
+	rotated, err := secret.NewKeyring("v2", "second-secret-0123456789", map[string]string{"v1": "first-secret-0123456789"})
+	require.NoError(s.T(), err)
+	s.repo.SetKeyring(rotated)
+	n, err := s.repo.RotateProviderKeys(s.ctx)
+	require.NoError(s.T(), err)
+	assert.GreaterOrEqual(s.T(), n, 1)
+
+	onlyNew, err := secret.NewKeyring("v2", "second-secret-0123456789", nil)
+	require.NoError(s.T(), err)
+	s.repo.SetKeyring(onlyNew)
+	fetched, err = s.repo.GetProvider(s.ctx, prov.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), prov.APIKey, fetched.APIKey)
+}
+
+func (s *RepositoryTestSuite) TestInvitationsAndLastAdminGuard() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+
+	owner, err := s.repo.CreateUser(s.ctx, "owner@members.test", "hash")
+	require.NoError(s.T(), err)
+	ownerProfile := &model.Profile{ID: uuid.New(), UserID: owner.ID, OrganizationID: org.ID, Role: "admin"}
+	require.NoError(s.T(), s.repo.CreateProfile(s.ctx, ownerProfile))
+
+	inv := &model.Invitation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		Email:          "invitee@members.test",
+		Role:           "member",
+		InvitedBy:      owner.ID,
+		TokenHash:      secret.HashToken("invite-token"),
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+	require.NoError(s.T(), s.repo.CreateInvitation(s.ctx, inv))
+
+	// Wrong email and wrong token are rejected
+	_, _, err = s.repo.CreateUserWithInvitation(s.ctx, inv.TokenHash, "someone@members.test", "hash", "Someone")
+	assert.ErrorIs(s.T(), err, ErrInvitationEmailMismatch)
+	_, _, err = s.repo.CreateUserWithInvitation(s.ctx, secret.HashToken("wrong"), inv.Email, "hash", "Invitee")
+	assert.ErrorIs(s.T(), err, ErrInvalidInvitation)
+
+	user, profile, err := s.repo.CreateUserWithInvitation(s.ctx, inv.TokenHash, inv.Email, "hash", "Invitee")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), org.ID, profile.OrganizationID)
+	assert.Equal(s.T(), "member", profile.Role)
+
+	// Tokens are single use
+	_, err = s.repo.AcceptInvitation(s.ctx, inv.TokenHash, user.ID, "Invitee")
+	assert.ErrorIs(s.T(), err, ErrInvalidInvitation)
+
+	members, err := s.repo.ListMembers(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), members, 2)
+
+	// The only admin cannot be demoted or removed
+	assert.ErrorIs(s.T(), s.repo.UpdateMemberRole(s.ctx, org.ID, ownerProfile.ID, "member"), ErrLastAdmin)
+	assert.ErrorIs(s.T(), s.repo.RemoveMember(s.ctx, org.ID, ownerProfile.ID), ErrLastAdmin)
+
+	// Once there is a second admin, the first can step down
+	require.NoError(s.T(), s.repo.UpdateMemberRole(s.ctx, org.ID, profile.ID, "admin"))
+	require.NoError(s.T(), s.repo.UpdateMemberRole(s.ctx, org.ID, ownerProfile.ID, "member"))
+}
+
+func (s *RepositoryTestSuite) TestCreditLedger() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	admin, err := s.repo.CreateUser(s.ctx, "ledger-admin@members.test", "hash")
+	require.NoError(s.T(), err)
+
+	_, err = s.repo.ApplyLedgerEntry(s.ctx, LedgerEntry{OrganizationID: org.ID, UserID: &admin.ID, Amount: 100, Type: "topup", Description: "Top-up"})
+	require.NoError(s.T(), err)
+
+	_, err = s.repo.ApplyLedgerEntry(s.ctx, LedgerEntry{OrganizationID: org.ID, UserID: &admin.ID, Amount: -150, Type: "adjustment", Description: "Too much"})
+	assert.ErrorIs(s.T(), err, ErrInsufficientCredits)
+
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         admin.ID,
+		Status:         "completed",
+		BasePrompt:     "Test",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+	require.NoError(s.T(), s.repo.DeductCredits(s.ctx, org.ID, 40, "Generation", admin.ID, &gen.ID))
+
+	_, err = s.repo.RefundGeneration(s.ctx, org.ID, gen.ID, 50, &admin.ID, "Refund")
+	assert.ErrorIs(s.T(), err, ErrRefundExceedsCharge)
+	refund, err := s.repo.RefundGeneration(s.ctx, org.ID, gen.ID, 0, &admin.ID, "Refund")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(40), refund.Amount)
+	assert.Equal(s.T(), int64(100), *refund.BalanceAfter)
+
+	// The balance always equals the ledger sum
+	var sum int64
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx, "SELECT COALESCE(SUM(amount), 0) FROM credit_ledger WHERE organization_id = $1", org.ID).Scan(&sum))
+	retrieved, err := s.repo.GetOrganization(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), sum, retrieved.Credits)
+
+	entries, total, err := s.repo.ListLedgerEntries(s.ctx, org.ID, LedgerFilter{Type: "refund", Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, total)
+	assert.Len(s.T(), entries, 1)
+
+	entries, total, err = s.repo.ListLedgerEntries(s.ctx, org.ID, LedgerFilter{GenerationID: &gen.ID, Limit: 1})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, total)
+	assert.Len(s.T(), entries, 1)
+}
+
+func (s *RepositoryTestSuite) TestCreditHolds() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	user, err := s.repo.CreateUser(s.ctx, "holds@members.test", "hash")
+	require.NoError(s.T(), err)
+	_, err = s.repo.ApplyLedgerEntry(s.ctx, LedgerEntry{OrganizationID: org.ID, Amount: 200, Type: "topup", Description: "Top-up"})
+	require.NoError(s.T(), err)
+
+	newGeneration := func(cost int64) *model.Generation {
+		return &model.Generation{
+			ID:             uuid.New(),
+			OrganizationID: org.ID,
+			UserID:         user.ID,
+			Status:         "pending",
+			BasePrompt:     "Test",
+			ProviderID:     uuid.New(),
+			EstimatedCost:  cost,
+		}
+	}
+
+	// The hold comes out of the available balance when the generation is created
+	gen := newGeneration(120)
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, gen, time.Hour, model.OrganizationLimits{}))
+	held, err := s.repo.GetHeldCredits(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(120), held)
+
+	// A second submission cannot overdraw what is left, and leaves nothing behind
+	rejected := newGeneration(120)
+	err = s.repo.CreateGenerationWithJob(s.ctx, rejected, time.Hour, model.OrganizationLimits{})
+	assert.ErrorIs(s.T(), err, ErrInsufficientCredits)
+	_, err = s.repo.GetGeneration(s.ctx, rejected.ID)
+	assert.ErrorIs(s.T(), err, pgx.ErrNoRows)
+
+	// Settling charges the actual cost and returns the rest; settling twice changes nothing
+	hold, err := s.repo.SettleCreditHold(s.ctx, gen.ID, 90, "Generation")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "settled", hold.Status)
+	_, err = s.repo.SettleCreditHold(s.ctx, gen.ID, 90, "Generation")
+	require.NoError(s.T(), err)
+
+	retrieved, err := s.repo.GetOrganization(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(110), retrieved.Credits)
+
+	// Stale holds are released
+	stale := newGeneration(50)
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, stale, -time.Minute, model.OrganizationLimits{}))
+	n, err := s.repo.ExpireCreditHolds(s.ctx, 10)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, n)
+	hold, err = s.repo.GetCreditHold(s.ctx, stale.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "expired", hold.Status)
+
+	held, err = s.repo.GetHeldCredits(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Zero(s.T(), held)
+
+	var sum int64
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx, "SELECT COALESCE(SUM(amount), 0) FROM credit_ledger WHERE organization_id = $1", org.ID).Scan(&sum))
+	retrieved, err = s.repo.GetOrganization(s.ctx, org.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), sum, retrieved.Credits)
+	assert.Equal(s.T(), int64(110), retrieved.Credits)
+}
+
+func (s *RepositoryTestSuite) TestCallbackInboxAndTransitions() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("inbox@members.test")
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Status:         "processing",
+		BasePrompt:     "Test",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+	img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: "Test", Status: "pending"}
+	require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+
+	token, tokenHash, err := secret.NewToken()
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.repo.SetGenerationImageCallbackToken(s.ctx, img.ID, tokenHash))
+	found, err := s.repo.GetGenerationImageByCallbackToken(s.ctx, secret.HashToken(token))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), img.ID, found.ID)
+
+	// The first delivery is recorded; a replay after processing is only counted
+	entry, err := s.repo.RecordCallback(s.ctx, "test-provider", "task-inbox", img.ID, []byte(`{"state":"success"}`))
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), entry)
+	require.NoError(s.T(), s.repo.MarkCallbackProcessed(s.ctx, entry.ID))
+
+	replay, err := s.repo.RecordCallback(s.ctx, "test-provider", "task-inbox", img.ID, []byte(`{"state":"success"}`))
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), replay)
+
+	var deliveries int
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx, "SELECT deliveries FROM callback_inbox WHERE id = $1", entry.ID).Scan(&deliveries))
+	assert.Equal(s.T(), 2, deliveries)
+
+	// Image and generation transitions apply once
+	applied, err := s.repo.UpdateGenerationImageFailed(s.ctx, img.ID, "boom")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), applied)
+	applied, err = s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{URL: "https://example.com/a.png", Key: "a.png"})
+	require.NoError(s.T(), err)
+	assert.False(s.T(), applied)
+
+	// A late submission record does not reopen a finished image
+	require.NoError(s.T(), s.repo.UpdateGenerationImageSubmitted(s.ctx, img.ID, "test-provider", "task-inbox"))
+	found, err = s.repo.GetGenerationImageByTaskID(s.ctx, "task-inbox")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "failed", found.Status)
+
+	finished, err := s.repo.FinishGeneration(s.ctx, gen.ID, "failed", "")
+	require.NoError(s.T(), err)
+	assert.True(s.T(), finished)
+	finished, err = s.repo.FinishGeneration(s.ctx, gen.ID, "completed", "")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), finished)
+}
+
+func (s *RepositoryTestSuite) TestGenerationEvents() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("events@members.test")
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Status:         "processing",
+		BasePrompt:     "Test",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+
+	// Listen first, so the insert below is observed through NOTIFY
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	notified := make(chan int64, 1)
+	go s.repo.ListenGenerationEvents(ctx, func(generationID uuid.UUID, eventID int64) {
+		if generationID == gen.ID {
+			notified <- eventID
+		}
+	})
+	time.Sleep(200 * time.Millisecond)
+
+	first, err := s.repo.AppendGenerationEvent(s.ctx, gen.ID, model.EventStage, nil, map[string]string{"stage": "vision_analysis"})
+	require.NoError(s.T(), err)
+	select {
+	case id := <-notified:
+		assert.Equal(s.T(), first.ID, id)
+	case <-ctx.Done():
+		s.T().Fatal("no notification received")
+	}
+
+	second, err := s.repo.AppendGenerationEvent(s.ctx, gen.ID, model.EventGenerationCompleted, nil, map[string]string{"status": "completed"})
+	require.NoError(s.T(), err)
+	assert.True(s.T(), second.IsFinal())
+
+	// Resuming after the first event only returns the second
+	events, err := s.repo.ListGenerationEvents(s.ctx, gen.ID, first.ID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), events, 1)
+	assert.Equal(s.T(), second.ID, events[0].ID)
+	assert.JSONEq(s.T(), `{"status":"completed"}`, string(events[0].Data))
+
+	// An append waits for one in flight, so events commit in ID order
+	tx, err := s.repo.pool.Begin(s.ctx)
+	require.NoError(s.T(), err)
+	defer tx.Rollback(s.ctx)
+	_, err = tx.Exec(s.ctx, `SELECT 1 FROM generations WHERE id = $1 FOR NO KEY UPDATE`, gen.ID)
+	require.NoError(s.T(), err)
+	var inFlight int64
+	require.NoError(s.T(), tx.QueryRow(s.ctx,
+		`INSERT INTO generation_events (generation_id, type) VALUES ($1, 'stage') RETURNING id`, gen.ID).Scan(&inFlight))
+
+	appended := make(chan *model.GenerationEvent, 1)
+	go func() {
+		event, _ := s.repo.AppendGenerationEvent(s.ctx, gen.ID, model.EventStage, nil, nil)
+		appended <- event
+	}()
+	select {
+	case <-appended:
+		s.T().Fatal("append did not wait for the event in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+	require.NoError(s.T(), tx.Commit(s.ctx))
+	third := <-appended
+	require.NotNil(s.T(), third)
+	assert.Greater(s.T(), third.ID, inFlight)
+}
+
+func (s *RepositoryTestSuite) TestListGenerationsKeyset() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	alice, bob := s.createTestUser("alice@members.test"), s.createTestUser("bob@members.test")
+
+	for i, userID := range []uuid.UUID{alice, bob, alice, alice} {
+		gen := &model.Generation{
+			ID:             uuid.New(),
+			OrganizationID: org.ID,
+			UserID:         userID,
+			Status:         "completed",
+			BasePrompt:     fmt.Sprintf("Red sneaker 100%% leather #%d", i),
+			ProviderID:     uuid.New(),
+		}
+		require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+		img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: "p", Status: "pending"}
+		require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+		_, err := s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{URL: fmt.Sprintf("https://cdn.example.com/%d.png", i), Key: "k"})
+		require.NoError(s.T(), err)
+	}
+
+	// Page through alice's generations two at a time
+	first, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{UserID: &alice, Limit: 2})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), first, 2)
+	assert.Equal(s.T(), []string{"k"}, first[0].ThumbnailKeys)
+
+	last := first[1]
+	second, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{
+		UserID: &alice,
+		After:  &Cursor{CreatedAt: last.CreatedAt, ID: last.ID},
+		Limit:  2,
+	})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), second, 1)
+	assert.NotEqual(s.T(), first[0].ID, second[0].ID)
+	assert.NotEqual(s.T(), first[1].ID, second[0].ID)
+
+	// Prompt search matches literally, so % is not a wildcard
+	found, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{Query: "100% LEATHER #1", Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), found, 1)
+	assert.Equal(s.T(), bob, found[0].UserID)
+
+	none, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{Query: "100_", Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), none)
+}
+
+func (s *RepositoryTestSuite) TestGalleryFavoritesTagsAndDelete() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("gallery@members.test")
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Status:         "completed",
+		BasePrompt:     "Studio shot of a watch",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+
+	var ids []uuid.UUID
+	for i := 0; i < 2; i++ {
+		img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: fmt.Sprintf("Angle %d", i), Status: "pending"}
+		require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+		_, err := s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{URL: "https://cdn.example.com/x.png", Key: "x.png"})
+		require.NoError(s.T(), err)
+		ids = append(ids, img.ID)
+	}
+
+	require.NoError(s.T(), s.repo.SetImageFavorite(s.ctx, userID, ids[0], true))
+	require.NoError(s.T(), s.repo.SetImageFavorite(s.ctx, userID, ids[0], true))
+	require.NoError(s.T(), s.repo.SetImageTags(s.ctx, ids[0], []string{"hero", "watch"}))
+
+	favorites, err := s.repo.ListGalleryImages(s.ctx, org.ID, GalleryFilter{ViewerID: userID, FavoritesOnly: true, Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), favorites, 1)
+	assert.True(s.T(), favorites[0].Favorite)
+	assert.Equal(s.T(), []string{"hero", "watch"}, favorites[0].Tags)
+	assert.Equal(s.T(), "Studio shot of a watch", favorites[0].BasePrompt)
+
+	tagged, err := s.repo.ListGalleryImages(s.ctx, org.ID, GalleryFilter{ViewerID: userID, Tag: "hero", Query: "angle 0", Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), tagged, 1)
+
+	// Deleted images disappear from the gallery
+	require.NoError(s.T(), s.repo.MarkGalleryImagesDeleted(s.ctx, ids[:1]))
+	remaining, err := s.repo.GetGalleryImages(s.ctx, org.ID, userID, ids)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), remaining, 1)
+	assert.Equal(s.T(), ids[1], remaining[0].ID)
+
+	// and keep their key, marked deleted, so no URL is minted for them
+	images, err := s.repo.ListGenerationImages(s.ctx, gen.ID)
+	require.NoError(s.T(), err)
+	for _, img := range images {
+		assert.Equal(s.T(), "x.png", img.R2Key)
+		assert.Equal(s.T(), img.ID == ids[0], img.DeletedAt != nil)
+	}
+	listed, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), listed, 1)
+	assert.Len(s.T(), listed[0].ThumbnailKeys, 1)
+
+	// A private bucket records only the key
+	img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: "Angle 2", Status: "pending"}
+	require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+	_, err = s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{Key: "y.png"})
+	require.NoError(s.T(), err)
+	var imageURL *string
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx, "SELECT image_url FROM generation_images WHERE id = $1", img.ID).Scan(&imageURL))
+	assert.Nil(s.T(), imageURL)
+}
+
+func (s *RepositoryTestSuite) TestTenantIsolation() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	orgA := &model.Organization{ID: uuid.MustParse("11111111-1111-1111-1111-111111111111"), Name: "Org A", Slug: "test-org"}
+	orgB := &model.Organization{ID: uuid.MustParse("22222222-2222-2222-2222-222222222222"), Name: "Org B", Slug: "test-org-b"}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, orgA))
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, orgB))
+	userID := s.createTestUser("tenant@members.test")
+
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: orgA.ID,
+		UserID:         userID,
+		Status:         "completed",
+		BasePrompt:     "Org A product shot",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+	img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: "Front", Status: "pending"}
+	require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+	_, err := s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{URL: "https://cdn.example.com/a.png", Key: "a.png"})
+	require.NoError(s.T(), err)
+	_, err = s.repo.AppendGenerationEvent(s.ctx, gen.ID, model.EventGenerationCompleted, nil, nil)
+	require.NoError(s.T(), err)
+
+	ctxA := WithOrganization(s.ctx, orgA.ID)
+	ctxB := WithOrganization(s.ctx, orgB.ID)
+
+	// The owning organization sees everything
+	found, err := s.repo.GetGeneration(ctxA, gen.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), gen.ID, found.ID)
+	images, err := s.repo.ListGenerationImages(ctxA, gen.ID)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), images, 1)
+
+	// Another organization gets not found and empty listings
+	_, err = s.repo.GetGeneration(ctxB, gen.ID)
+	assert.ErrorIs(s.T(), err, pgx.ErrNoRows)
+	images, err = s.repo.ListGenerationImages(ctxB, gen.ID)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), images)
+	events, err := s.repo.ListGenerationEvents(ctxB, gen.ID, 0, 10)
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), events)
+	generations, err := s.repo.ListGenerations(ctxB, orgB.ID, GenerationFilter{Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), generations)
+	gallery, err := s.repo.GetGalleryImages(ctxB, orgB.ID, userID, []uuid.UUID{img.ID})
+	require.NoError(s.T(), err)
+	assert.Empty(s.T(), gallery)
+	require.NoError(s.T(), s.repo.MarkGalleryImagesDeleted(ctxB, []uuid.UUID{img.ID}))
+	gallery, err = s.repo.GetGalleryImages(ctxA, orgA.ID, userID, []uuid.UUID{img.ID})
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), gallery, 1, "a foreign organization must not delete the image")
+
+	// Unscoped background work still sees the generation
+	_, err = s.repo.GetGeneration(s.ctx, gen.ID)
+	require.NoError(s.T(), err)
+
+	// Row level security hides the rows even from a query without an organization filter,
+	// unless the test role bypasses it
+	var bypass bool
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx,
+		`SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`).Scan(&bypass))
+	if bypass {
+		return
+	}
+	var visible int
+	require.NoError(s.T(), s.repo.WithTx(ctxB, func(tx pgx.Tx) error {
+		return tx.QueryRow(s.ctx, `SELECT COUNT(*) FROM generations WHERE id = $1`, gen.ID).Scan(&visible)
+	}))
+	assert.Zero(s.T(), visible)
+}
+
+func (s *RepositoryTestSuite) TestSessionRotationAndReuse() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	userID := s.createTestUser("sessions@members.test")
+	session := &model.Session{ID: uuid.New(), UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(s.T(), s.repo.CreateSession(s.ctx, session, "hash-1"))
+
+	rotated, err := s.repo.RotateSession(s.ctx, "hash-1", "hash-2", 2*time.Hour)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), session.ID, rotated.ID)
+	assert.True(s.T(), rotated.ExpiresAt.After(session.ExpiresAt))
+
+	// Replaying the rotated token revokes the session, so the current token stops working too
+	_, err = s.repo.RotateSession(s.ctx, "hash-1", "hash-3", time.Hour)
+	assert.ErrorIs(s.T(), err, ErrRefreshTokenReused)
+	_, err = s.repo.RotateSession(s.ctx, "hash-2", "hash-4", time.Hour)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+	_, err = s.repo.RotateSession(s.ctx, "unknown", "hash-5", time.Hour)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+
+	// Log out everywhere
+	other := &model.Session{ID: uuid.New(), UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(s.T(), s.repo.CreateSession(s.ctx, other, "hash-6"))
+	active, err := s.repo.ListActiveSessions(s.ctx, userID)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), active, 1)
+	revoked, err := s.repo.RevokeUserSessions(s.ctx, userID, SessionRevokedLogoutAll)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(1), revoked)
+	_, err = s.repo.RotateSession(s.ctx, "hash-6", "hash-7", time.Hour)
+	assert.ErrorIs(s.T(), err, ErrInvalidSession)
+}
+
 // Run the test suite
+func (s *RepositoryTestSuite) TestRateLimitBuckets() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	user := RateBucket{Key: "test:user", PerMinute: 2, Limit: LimitUserRequestsPerMinute}
+	org := RateBucket{Key: "test:org", PerMinute: 60, Limit: LimitRequestsPerMinute}
+
+	// A full bucket grants a burst of PerMinute requests
+	require.NoError(s.T(), s.repo.TakeRateTokens(s.ctx, []RateBucket{org, user}))
+	require.NoError(s.T(), s.repo.TakeRateTokens(s.ctx, []RateBucket{org, user}))
+
+	err := s.repo.TakeRateTokens(s.ctx, []RateBucket{org, user})
+	var limitErr *LimitError
+	require.ErrorAs(s.T(), err, &limitErr)
+	assert.Equal(s.T(), LimitUserRequestsPerMinute, limitErr.Limit)
+	// One token refills every 30 seconds
+	assert.InDelta(s.T(), 30*time.Second, limitErr.RetryAfter, float64(time.Second))
+
+	// A rejected request takes no token from the other buckets
+	var tokens float64
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx,
+		"SELECT tokens FROM rate_limit_buckets WHERE key = 'test:org'").Scan(&tokens))
+	assert.InDelta(s.T(), 58, tokens, 0.5)
+
+	// Unlimited buckets are not stored
+	require.NoError(s.T(), s.repo.TakeRateTokens(s.ctx, []RateBucket{{Key: "test:unlimited"}}))
+	var n int
+	require.NoError(s.T(), s.repo.pool.QueryRow(s.ctx,
+		"SELECT COUNT(*) FROM rate_limit_buckets WHERE key = 'test:unlimited'").Scan(&n))
+	assert.Zero(s.T(), n)
+}
+
+func (s *RepositoryTestSuite) TestGenerationLimits() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("limits@members.test")
+
+	// Settings of the organization override the defaults they are set for
+	defaults := model.OrganizationLimits{RequestsPerMinute: 600, UserRequestsPerMinute: 120, MaxConcurrentGenerations: 5, MaxImagesPerDay: 500}
+	limits, err := s.repo.GetOrganizationLimits(s.ctx, org.ID, defaults)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), defaults, limits)
+
+	_, err = s.repo.pool.Exec(s.ctx, `
+		INSERT INTO organization_limits (organization_id, max_concurrent_generations, max_images_per_day)
+		VALUES ($1, 2, 10)
+	`, org.ID)
+	require.NoError(s.T(), err)
+	limits, err = s.repo.GetOrganizationLimits(s.ctx, org.ID, defaults)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), model.OrganizationLimits{RequestsPerMinute: 600, UserRequestsPerMinute: 120, MaxConcurrentGenerations: 2, MaxImagesPerDay: 10}, limits)
+
+	newGeneration := func(images int) *model.Generation {
+		return &model.Generation{
+			ID:             uuid.New(),
+			OrganizationID: org.ID,
+			UserID:         userID,
+			Status:         "pending",
+			BasePrompt:     "Test",
+			ProviderID:     uuid.New(),
+			NumImages:      images,
+		}
+	}
+
+	// Only two generations may be in progress at once
+	first := newGeneration(4)
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, first, time.Hour, limits))
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, newGeneration(4), time.Hour, limits))
+	err = s.repo.CreateGenerationWithJob(s.ctx, newGeneration(1), time.Hour, limits)
+	var limitErr *LimitError
+	require.ErrorAs(s.T(), err, &limitErr)
+	assert.Equal(s.T(), LimitMaxConcurrentGenerations, limitErr.Limit)
+
+	// A finished generation frees its slot but still counts towards the daily images
+	_, err = s.repo.FinishGeneration(s.ctx, first.ID, "completed", "")
+	require.NoError(s.T(), err)
+	err = s.repo.CreateGenerationWithJob(s.ctx, newGeneration(4), time.Hour, limits)
+	require.ErrorAs(s.T(), err, &limitErr)
+	assert.Equal(s.T(), LimitMaxImagesPerDay, limitErr.Limit)
+	assert.LessOrEqual(s.T(), limitErr.RetryAfter, 24*time.Hour)
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, newGeneration(2), time.Hour, limits))
+}
+
+func (s *RepositoryTestSuite) TestIdempotencyKeys() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{
+		ID:   uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		Name: "Test Org",
+		Slug: "test-org",
+	}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("idempotency@members.test")
+
+	// The first request claims the key; a retry finds it in progress
+	existing, err := s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-1", "hash-a", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), existing)
+	existing, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-1", "hash-a", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), existing)
+	assert.Equal(s.T(), "in_progress", existing.Status)
+
+	// Once completed, retries get the stored response
+	require.NoError(s.T(), s.repo.CompleteIdempotencyKey(s.ctx, org.ID, "key-1", 202, "application/json", []byte(`{"id":"x"}`)))
+	existing, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-1", "hash-b", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), existing)
+	assert.Equal(s.T(), "completed", existing.Status)
+	assert.Equal(s.T(), "hash-a", existing.RequestHash)
+	assert.Equal(s.T(), 202, existing.ResponseStatus)
+	assert.Equal(s.T(), "application/json", existing.ResponseContentType)
+	assert.JSONEq(s.T(), `{"id":"x"}`, string(existing.ResponseBody))
+
+	// A completed key cannot be released
+	require.NoError(s.T(), s.repo.ReleaseIdempotencyKey(s.ctx, org.ID, "key-1"))
+	existing, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-1", "hash-a", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	assert.NotNil(s.T(), existing)
+
+	// A released key runs the request again
+	_, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-2", "hash-a", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), s.repo.ReleaseIdempotencyKey(s.ctx, org.ID, "key-2"))
+	existing, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-2", "hash-a", &userID, time.Hour)
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), existing)
+
+	// An expired key is claimed afresh, and removed by the cleanup
+	_, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-3", "hash-a", &userID, -time.Minute)
+	require.NoError(s.T(), err)
+	existing, err = s.repo.ClaimIdempotencyKey(s.ctx, org.ID, "key-3", "hash-b", &userID, -time.Minute)
+	require.NoError(s.T(), err)
+	assert.Nil(s.T(), existing)
+	n, err := s.repo.DeleteExpiredIdempotencyKeys(s.ctx)
+	require.NoError(s.T(), err)
+	assert.GreaterOrEqual(s.T(), n, int64(1))
+}
+
+func (s *RepositoryTestSuite) TestUploads() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{ID: uuid.MustParse("11111111-1111-1111-1111-111111111111"), Name: "Test Org", Slug: "test-org"}
+	other := &model.Organization{ID: uuid.MustParse("22222222-2222-2222-2222-222222222222"), Name: "Other Org", Slug: "other-org"}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, other))
+	userID := s.createTestUser("uploads@members.test")
+
+	newUpload := func(orgID uuid.UUID, key string, expires time.Time) *model.Upload {
+		u := &model.Upload{
+			OrganizationID: orgID,
+			UserID:         &userID,
+			Key:            key,
+			Folder:         "products",
+			Filename:       "shoe.png",
+			ContentType:    "image/png",
+			SizeBytes:      100,
+			ExpiresAt:      expires,
+		}
+		require.NoError(s.T(), s.repo.CreateUpload(s.ctx, u))
+		return u
+	}
+	pending := newUpload(org.ID, org.ID.String()+"/products/1_shoe.png", time.Now().Add(time.Hour))
+	foreign := newUpload(other.ID, other.ID.String()+"/products/2_shoe.png", time.Now().Add(time.Hour))
+	abandoned := newUpload(org.ID, org.ID.String()+"/products/3_shoe.png", time.Now().Add(-2*time.Hour))
+	assert.Equal(s.T(), "pending", pending.Status)
+	assert.Nil(s.T(), pending.CompletedAt)
+
+	// Uploads are looked up within the organization
+	_, err := s.repo.GetUpload(s.ctx, org.ID, foreign.ID)
+	assert.ErrorIs(s.T(), err, pgx.ErrNoRows)
+	got, err := s.repo.GetUpload(s.ctx, org.ID, pending.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), pending.ID, got.ID)
+
+	// Only pending uploads complete, once, moving to the key of the normalized image
+	sourceKey := got.Key
+	normalizedKey := org.ID.String() + "/products/4_shoe.jpg"
+	got.SizeBytes = 120
+	got.ContentType = "image/jpeg"
+	got.Width, got.Height, got.Format, got.PHash = 640, 480, "jpeg", "00ff00ff00ff00ff"
+	applied, err := s.repo.CompleteUpload(s.ctx, got, normalizedKey)
+	require.NoError(s.T(), err)
+	assert.True(s.T(), applied)
+	assert.Equal(s.T(), normalizedKey, got.Key)
+	require.NotNil(s.T(), got.SourceKey)
+	assert.Equal(s.T(), sourceKey, *got.SourceKey)
+	applied, err = s.repo.CompleteUpload(s.ctx, got, org.ID.String()+"/products/5_shoe.jpg")
+	require.NoError(s.T(), err)
+	assert.False(s.T(), applied)
+
+	reloaded, err := s.repo.GetUpload(s.ctx, org.ID, pending.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "completed", reloaded.Status)
+	assert.Equal(s.T(), normalizedKey, reloaded.Key)
+
+	// Generations can only use completed uploads of their organization
+	foreign.SizeBytes = 100
+	_, err = s.repo.CompleteUpload(s.ctx, foreign, other.ID.String()+"/products/6_shoe.png")
+	require.NoError(s.T(), err)
+	uploads, err := s.repo.ListCompletedUploads(s.ctx, org.ID, []uuid.UUID{pending.ID, foreign.ID, abandoned.ID})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), uploads, 1)
+	assert.Equal(s.T(), pending.ID, uploads[0].ID)
+	assert.Equal(s.T(), int64(120), uploads[0].SizeBytes)
+	assert.Equal(s.T(), 640, uploads[0].Width)
+	assert.Equal(s.T(), 480, uploads[0].Height)
+	assert.Equal(s.T(), "jpeg", uploads[0].Format)
+	assert.Equal(s.T(), "00ff00ff00ff00ff", uploads[0].PHash)
+
+	abandonedUploads, err := s.repo.ListAbandonedUploads(s.ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(s.T(), err)
+	var abandonedIDs []uuid.UUID
+	for _, u := range abandonedUploads {
+		abandonedIDs = append(abandonedIDs, u.ID)
+	}
+	assert.Contains(s.T(), abandonedIDs, abandoned.ID)
+	assert.NotContains(s.T(), abandonedIDs, pending.ID)
+
+	// Generations keep the uploads they were created from
+	gen := &model.Generation{
+		ID:               uuid.New(),
+		OrganizationID:   org.ID,
+		UserID:           userID,
+		Status:           "pending",
+		BasePrompt:       "Test",
+		ProductImages:    []string{pending.Key},
+		ProductUploadIDs: []uuid.UUID{pending.ID},
+		ProviderID:       uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGenerationWithJob(s.ctx, gen, time.Hour, model.OrganizationLimits{}))
+	stored, err := s.repo.GetGeneration(s.ctx, gen.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []uuid.UUID{pending.ID}, stored.ProductUploadIDs)
+	assert.Empty(s.T(), stored.ReferenceUploadIDs)
+
+	require.NoError(s.T(), s.repo.DeleteUpload(s.ctx, abandoned.ID))
+	_, err = s.repo.GetUpload(s.ctx, org.ID, abandoned.ID)
+	assert.ErrorIs(s.T(), err, pgx.ErrNoRows)
+}
+
+func (s *RepositoryTestSuite) TestRenditions() {
+	if s.repo == nil {
+		s.T().Skip("Database not available")
+	}
+
+	org := &model.Organization{ID: uuid.MustParse("11111111-1111-1111-1111-111111111111"), Name: "Test Org", Slug: "test-org"}
+	require.NoError(s.T(), s.repo.CreateOrganization(s.ctx, org))
+	userID := s.createTestUser("renditions@members.test")
+
+	gen := &model.Generation{
+		ID:             uuid.New(),
+		OrganizationID: org.ID,
+		UserID:         userID,
+		Status:         "completed",
+		BasePrompt:     "Test",
+		ProviderID:     uuid.New(),
+	}
+	require.NoError(s.T(), s.repo.CreateGeneration(s.ctx, gen))
+	img := &model.GenerationImage{ID: uuid.New(), GenerationID: gen.ID, Prompt: "p", Status: "pending"}
+	require.NoError(s.T(), s.repo.CreateGenerationImage(s.ctx, img))
+	_, err := s.repo.UpdateGenerationImageComplete(s.ctx, img.ID, StoredObject{URL: "https://cdn.example.com/a.png", Key: "a.png"})
+	require.NoError(s.T(), err)
+
+	staleIDs := func(version string) []uuid.UUID {
+		images, err := s.repo.ListStaleGenerationImages(s.ctx, version, uuid.Nil, 1000)
+		require.NoError(s.T(), err)
+		var ids []uuid.UUID
+		for _, i := range images {
+			ids = append(ids, i.ID)
+		}
+		return ids
+	}
+
+	// Images without renditions are stale, and listed with their original as thumbnail
+	assert.Contains(s.T(), staleIDs("v1"), img.ID)
+	listed, err := s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{Limit: 10})
+	require.NoError(s.T(), err)
+	require.Len(s.T(), listed, 1)
+	assert.Equal(s.T(), []string{"a.png"}, listed[0].ThumbnailKeys)
+
+	keys := map[string]string{"thumbnail": "a.thumbnail-320-q80.jpg", "medium": "a.medium-1280-q85.jpg"}
+	require.NoError(s.T(), s.repo.SetGenerationImageRenditions(s.ctx, img.ID, keys, "v1"))
+	assert.NotContains(s.T(), staleIDs("v1"), img.ID)
+	assert.Contains(s.T(), staleIDs("v2"), img.ID, "a new spec version makes them stale again")
+
+	images, err := s.repo.ListGenerationImages(s.ctx, gen.ID)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), images, 1)
+	assert.Equal(s.T(), keys, images[0].RenditionKeys)
+	assert.Equal(s.T(), "v1", images[0].RenditionsVersion)
+
+	listed, err = s.repo.ListGenerations(s.ctx, org.ID, GenerationFilter{Limit: 10})
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"a.thumbnail-320-q80.jpg"}, listed[0].ThumbnailKeys)
+
+	// Uploads
+	upload := &model.Upload{
+		OrganizationID: org.ID,
+		UserID:         &userID,
+		Key:            org.ID.String() + "/uploads/1_shoe.png",
+		Folder:         "uploads",
+		Filename:       "shoe.png",
+		ContentType:    "image/png",
+		SizeBytes:      100,
+		Status:         "completed",
+		ExpiresAt:      time.Now(),
+	}
+	require.NoError(s.T(), s.repo.CreateUpload(s.ctx, upload))
+	stale, err := s.repo.ListStaleUploads(s.ctx, "v1", uuid.Nil, 1000)
+	require.NoError(s.T(), err)
+	var uploadIDs []uuid.UUID
+	for _, u := range stale {
+		uploadIDs = append(uploadIDs, u.ID)
+	}
+	assert.Contains(s.T(), uploadIDs, upload.ID)
+
+	require.NoError(s.T(), s.repo.SetUploadRenditions(s.ctx, upload.ID, keys, "v1"))
+	got, err := s.repo.GetUpload(s.ctx, org.ID, upload.ID)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), keys, got.RenditionKeys)
+	assert.Equal(s.T(), "v1", got.RenditionsVersion)
+}
+
 func TestRepositorySuite(t *testing.T) {
 	if os.Getenv("SKIP_DB_TESTS") != "" {
 		t.Skip("Skipping database tests")
@@ -297,3 +1393,30 @@ func TestModelValidation(t *testing.T) {
 	assert.NotNil(t, gen)
 	assert.Equal(t, "pending", gen.Status)
 }
+
+func TestParseEventNotification(t *testing.T) {
+	id := uuid.New()
+	generationID, eventID, ok := parseEventNotification(id.String() + ":42")
+	assert.True(t, ok)
+	assert.Equal(t, id, generationID)
+	assert.Equal(t, int64(42), eventID)
+
+	for _, payload := range []string{"", id.String(), "not-a-uuid:1", id.String() + ":x"} {
+		_, _, ok := parseEventNotification(payload)
+		assert.False(t, ok, payload)
+	}
+}
+
+func TestOrganizationScope(t *testing.T) {
+	ctx := context.Background()
+	_, ok := OrganizationFromContext(ctx)
+	assert.False(t, ok)
+	assert.Nil(t, orgScope(ctx))
+
+	orgID := uuid.New()
+	scoped := WithOrganization(ctx, orgID)
+	got, ok := OrganizationFromContext(scoped)
+	assert.True(t, ok)
+	assert.Equal(t, orgID, got)
+	assert.Equal(t, &orgID, orgScope(scoped))
+}
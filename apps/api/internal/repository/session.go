@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+var (
+	// ErrInvalidSession is returned for unknown refresh tokens and revoked or expired sessions
+	ErrInvalidSession = errors.New("session is invalid or has expired")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented;
+	// the session is revoked
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// Reasons recorded when a session is revoked
+const (
+	SessionRevokedLogout    = "logout"
+	SessionRevokedLogoutAll = "logout_all"
+	SessionRevokedReuse     = "refresh_token_reused"
+)
+
+const sessionColumns = `id, user_id, organization_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+	created_at, last_used_at, expires_at, revoked_at, COALESCE(revoked_reason, '')`
+
+func scanSession(row pgx.Row) (*model.Session, error) {
+	var s model.Session
+	err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.OrganizationID,
+		&s.UserAgent,
+		&s.IPAddress,
+		&s.CreatedAt,
+		&s.LastUsedAt,
+		&s.ExpiresAt,
+		&s.RevokedAt,
+		&s.RevokedReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CreateSession stores a new session with its first refresh token
+func (r *Repository) CreateSession(ctx context.Context, session *model.Session, tokenHash string) error {
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO sessions (id, user_id, organization_id, user_agent, ip_address, expires_at)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+			RETURNING created_at, last_used_at
+		`, session.ID, session.UserID, session.OrganizationID, session.UserAgent, session.IPAddress, session.ExpiresAt,
+		).Scan(&session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO session_tokens (token_hash, session_id) VALUES ($1, $2)`, tokenHash, session.ID)
+		return err
+	})
+}
+
+// RotateSession exchanges the refresh token with hash oldHash for newHash and extends the
+// session to ttl from now. Presenting a token that was already rotated revokes the session
+// and returns ErrRefreshTokenReused.
+func (r *Repository) RotateSession(ctx context.Context, oldHash, newHash string, ttl time.Duration) (*model.Session, error) {
+	var session *model.Session
+	reused := false
+	err := r.WithTx(ctx, func(tx pgx.Tx) error {
+		var sessionID uuid.UUID
+		var rotatedAt *time.Time
+		err := tx.QueryRow(ctx,
+			`SELECT session_id, rotated_at FROM session_tokens WHERE token_hash = $1 FOR UPDATE`,
+			oldHash,
+		).Scan(&sessionID, &rotatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidSession
+		}
+		if err != nil {
+			return err
+		}
+
+		session, err = scanSession(tx.QueryRow(ctx,
+			`SELECT `+sessionColumns+` FROM sessions WHERE id = $1 FOR UPDATE`, sessionID))
+		if err != nil {
+			return err
+		}
+		if !session.Active(time.Now()) {
+			return ErrInvalidSession
+		}
+
+		if rotatedAt != nil {
+			reused = true
+			_, err := tx.Exec(ctx, `
+				UPDATE sessions SET revoked_at = NOW(), revoked_reason = $2 WHERE id = $1
+			`, sessionID, SessionRevokedReuse)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE session_tokens SET rotated_at = NOW() WHERE token_hash = $1`, oldHash); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO session_tokens (token_hash, session_id) VALUES ($1, $2)`, newHash, sessionID); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+			UPDATE sessions SET last_used_at = NOW(), expires_at = $2
+			WHERE id = $1
+			RETURNING last_used_at, expires_at
+		`, sessionID, time.Now().Add(ttl)).Scan(&session.LastUsedAt, &session.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return session, nil
+}
+
+// RevokeSessionByToken revokes the session a refresh token belongs to, whether or not the
+// token is still current
+func (r *Repository) RevokeSessionByToken(ctx context.Context, tokenHash, reason string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = $2
+		WHERE id = (SELECT session_id FROM session_tokens WHERE token_hash = $1)
+		AND revoked_at IS NULL
+	`, tokenHash, reason)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeSession revokes one of a user's sessions
+func (r *Repository) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID, reason string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID, reason)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeUserSessions revokes every active session of a user and returns how many were revoked
+func (r *Repository) RevokeUserSessions(ctx context.Context, userID uuid.UUID, reason string) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW(), revoked_reason = $2
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID, reason)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListActiveSessions returns a user's sessions that are neither revoked nor expired, most recently used first
+func (r *Repository) ListActiveSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+sessionColumns+`
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*model.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteStaleSessions removes sessions that expired or were revoked before cutoff
+func (r *Repository) DeleteStaleSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `
+		DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// orgScopeKey is the context key of the organization a request acts in
+type orgScopeKey struct{}
+
+// WithOrganization scopes repository calls made with the returned context to orgID.
+// Scoped reads only return the organization's rows, and scoped transactions set
+// app.current_org_id so the row level security policies apply as well.
+func WithOrganization(ctx context.Context, orgID uuid.UUID) context.Context {
+	return context.WithValue(ctx, orgScopeKey{}, orgID)
+}
+
+// OrganizationFromContext returns the organization ctx is scoped to, if any.
+// Background work such as workers and provider callbacks runs unscoped, and passes the
+// row level security policies through the app.rls_bypass setting of the pool's connections.
+func OrganizationFromContext(ctx context.Context) (uuid.UUID, bool) {
+	orgID, ok := ctx.Value(orgScopeKey{}).(uuid.UUID)
+	return orgID, ok
+}
+
+// orgScope returns the organization scope of ctx as a query argument; nil matches every organization
+func orgScope(ctx context.Context) *uuid.UUID {
+	if orgID, ok := OrganizationFromContext(ctx); ok {
+		return &orgID
+	}
+	return nil
+}
+
+// querier is implemented by both the pool and a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// scoped runs fn against the pool, or, when ctx carries an organization scope, inside a
+// transaction with app.current_org_id set so row level security covers every statement
+func (r *Repository) scoped(ctx context.Context, fn func(q querier) error) error {
+	if _, ok := OrganizationFromContext(ctx); !ok {
+		return fn(r.pool)
+	}
+	return r.WithTx(ctx, func(tx pgx.Tx) error {
+		return fn(tx)
+	})
+}
+
+// applyOrgScope sets app.current_org_id and lifts the connection's policy bypass for the
+// rest of tx when ctx is scoped
+func applyOrgScope(ctx context.Context, tx pgx.Tx) error {
+	orgID, ok := OrganizationFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	_, err := tx.Exec(ctx,
+		`SELECT set_config('app.current_org_id', $1, true), set_config('app.rls_bypass', 'off', true)`,
+		orgID.String(),
+	)
+	return err
+}
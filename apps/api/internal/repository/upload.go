@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+)
+
+const uploadColumns = `id, organization_id, user_id, key, folder, filename, content_type,
+	size_bytes, status, created_at, expires_at, completed_at, source_key,
+	COALESCE(width, 0), COALESCE(height, 0), COALESCE(format, ''), COALESCE(phash, ''),
+	renditions, COALESCE(renditions_version, '')`
+
+func scanUpload(row pgx.Row) (*model.Upload, error) {
+	var u model.Upload
+	err := row.Scan(
+		&u.ID,
+		&u.OrganizationID,
+		&u.UserID,
+		&u.Key,
+		&u.Folder,
+		&u.Filename,
+		&u.ContentType,
+		&u.SizeBytes,
+		&u.Status,
+		&u.CreatedAt,
+		&u.ExpiresAt,
+		&u.CompletedAt,
+		&u.SourceKey,
+		&u.Width,
+		&u.Height,
+		&u.Format,
+		&u.PHash,
+		&u.RenditionKeys,
+		&u.RenditionsVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// CreateUpload records an upload; pending uploads wait for their object to be stored
+func (r *Repository) CreateUpload(ctx context.Context, u *model.Upload) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	if u.Status == "" {
+		u.Status = "pending"
+	}
+	return r.scoped(ctx, func(q querier) error {
+		return q.QueryRow(ctx, `
+			INSERT INTO uploads (
+				id, organization_id, user_id, key, folder, filename,
+				content_type, size_bytes, status, expires_at, completed_at,
+				width, height, format, phash
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+				CASE WHEN $9 = 'completed' THEN NOW() END,
+				NULLIF($11, 0), NULLIF($12, 0), NULLIF($13, ''), NULLIF($14, ''))
+			RETURNING created_at, completed_at
+		`,
+			u.ID, u.OrganizationID, u.UserID, u.Key, u.Folder, u.Filename,
+			u.ContentType, u.SizeBytes, u.Status, u.ExpiresAt,
+			u.Width, u.Height, u.Format, u.PHash,
+		).Scan(&u.CreatedAt, &u.CompletedAt)
+	})
+}
+
+// GetUpload returns an upload of an organization
+func (r *Repository) GetUpload(ctx context.Context, orgID, id uuid.UUID) (*model.Upload, error) {
+	var u *model.Upload
+	err := r.scoped(ctx, func(q querier) error {
+		var err error
+		u, err = scanUpload(q.QueryRow(ctx,
+			`SELECT `+uploadColumns+` FROM uploads WHERE organization_id = $1 AND id = $2`,
+			orgID, id,
+		))
+		return err
+	})
+	return u, err
+}
+
+// CompleteUpload marks a pending upload completed with the normalized image stored for it
+// under key. The key it was pending under is kept as its source key. It reports false when
+// the upload is not pending.
+func (r *Repository) CompleteUpload(ctx context.Context, u *model.Upload, key string) (bool, error) {
+	var applied bool
+	err := r.scoped(ctx, func(q querier) error {
+		err := q.QueryRow(ctx, `
+			UPDATE uploads
+			SET status = 'completed', source_key = key, key = $2, size_bytes = $3,
+				content_type = $4, width = $5, height = $6, format = $7, phash = $8,
+				completed_at = NOW()
+			WHERE id = $1 AND status = 'pending'
+			RETURNING source_key, completed_at
+		`, u.ID, key, u.SizeBytes, u.ContentType, u.Width, u.Height, u.Format, u.PHash,
+		).Scan(&u.SourceKey, &u.CompletedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		applied = true
+		u.Key = key
+		u.Status = "completed"
+		return nil
+	})
+	return applied, err
+}
+
+// DeleteUpload removes an upload record
+func (r *Repository) DeleteUpload(ctx context.Context, id uuid.UUID) error {
+	return r.scoped(ctx, func(q querier) error {
+		_, err := q.Exec(ctx, "DELETE FROM uploads WHERE id = $1", id)
+		return err
+	})
+}
+
+// ListCompletedUploads returns the completed uploads of an organization among ids, in no
+// particular order. Unknown, pending and foreign uploads are left out.
+func (r *Repository) ListCompletedUploads(ctx context.Context, orgID uuid.UUID, ids []uuid.UUID) ([]*model.Upload, error) {
+	var uploads []*model.Upload
+	err := r.scoped(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT `+uploadColumns+` FROM uploads
+			WHERE organization_id = $1 AND id = ANY($2) AND status = 'completed'
+		`, orgID, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			u, err := scanUpload(rows)
+			if err != nil {
+				return err
+			}
+			uploads = append(uploads, u)
+		}
+		return rows.Err()
+	})
+	return uploads, err
+}
+
+// ListAbandonedUploads returns up to limit pending uploads whose presigned URL expired
+// before cutoff
+func (r *Repository) ListAbandonedUploads(ctx context.Context, cutoff time.Time, limit int) ([]*model.Upload, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+uploadColumns+` FROM uploads
+		WHERE status = 'pending' AND expires_at < $1
+		ORDER BY expires_at
+		LIMIT $2
+	`, cutoff, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list abandoned uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []*model.Upload
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// uploadIDs stores a missing list of uploads as an empty array
+func uploadIDs(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
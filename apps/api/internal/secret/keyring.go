@@ -0,0 +1,217 @@
+// Package secret encrypts small secrets such as provider API keys for storage at rest.
+//
+// Values are envelope encrypted: each value gets a fresh random data key that encrypts the
+// plaintext with AES-256-GCM, and the data key itself is wrapped with a key-encryption key
+// derived from a configured secret. The key ID of that secret is stored alongside the value
+// so secrets can be rotated without losing access to rows written under older keys.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks encrypted values; the version allows the format to change later
+const prefix = "enc:v1:"
+
+// ErrUnknownKey is returned when a value was encrypted with a key that is not in the keyring
+var ErrUnknownKey = errors.New("secret: unknown encryption key")
+
+// Keyring holds the current key-encryption key and any previous keys still needed for decryption
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewKeyring creates a keyring that encrypts with currentSecret under currentID. previous maps
+// older key IDs to their secrets and is only used to decrypt.
+func NewKeyring(currentID, currentSecret string, previous map[string]string) (*Keyring, error) {
+	k := &Keyring{currentID: currentID, keys: make(map[string][]byte)}
+	if err := k.add(currentID, currentSecret); err != nil {
+		return nil, err
+	}
+	for id, secret := range previous {
+		if id == currentID {
+			return nil, fmt.Errorf("secret: key ID %q is both current and previous", id)
+		}
+		if err := k.add(id, secret); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// ParsePrevious parses previous keys in the form "id1:secret1,id2:secret2"
+func ParsePrevious(s string) (map[string]string, error) {
+	previous := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("secret: previous key %q must be in the form id:secret", id)
+		}
+		previous[id] = secret
+	}
+	return previous, nil
+}
+
+func (k *Keyring) add(id, secret string) error {
+	if id == "" || strings.ContainsAny(id, ":,") {
+		return fmt.Errorf("secret: invalid key ID %q", id)
+	}
+	if len(secret) < 16 {
+		return fmt.Errorf("secret: key %q must be at least 16 characters", id)
+	}
+	kek, err := hkdf.Key(sha256.New, []byte(secret), nil, "ner-studio provider keys", 32)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = kek
+	return nil
+}
+
+// CurrentKeyID returns the ID of the key used for new encryptions
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentID
+}
+
+// Encrypt envelope-encrypts plaintext with the current key. Empty input stays empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.keys[k.currentID], dataKey, []byte(k.currentID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(k.currentID))
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return prefix + k.currentID + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext), nil
+}
+
+// Decrypt reverses Encrypt. Values without the encryption prefix are returned unchanged so rows
+// written before encryption was enabled keep working until they are rotated.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	keyID, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	dataKey, err := open(kek, wrapped, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("secret: failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dataKey, ciphertext, []byte(keyID))
+	if err != nil {
+		return "", fmt.Errorf("secret: failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether value is plaintext or encrypted with a key other than the current one
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	keyID, _, _, err := parse(value)
+	return err != nil || keyID != k.currentID
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Fingerprint returns a masked identifier for a plaintext secret that is safe to show in
+// admin responses: the last four characters and a short hash, e.g. "••••wxyz (3f9a1c2e)"
+func Fingerprint(plaintext string) string {
+	if plaintext == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(plaintext))
+	hash := hex.EncodeToString(sum[:4])
+	if len(plaintext) < 12 {
+		return "••••" + " (" + hash + ")"
+	}
+	return "••••" + plaintext[len(plaintext)-4:] + " (" + hash + ")"
+}
+
+func parse(value string) (keyID string, wrapped, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("secret: malformed encrypted value")
+	}
+	enc := base64.RawStdEncoding
+	if wrapped, err = enc.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("secret: malformed encrypted value: %w", err)
+	}
+	if ciphertext, err = enc.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("secret: malformed encrypted value: %w", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
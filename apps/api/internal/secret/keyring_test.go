@@ -0,0 +1,106 @@
+package secret
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	k, err := NewKeyring("v1", "first-secret-0123456789", nil)
+	require.NoError(t, err)
+
+	enc, err := k.Encrypt("sk-live-abcdefghijklmnop")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(enc))
+	assert.NotContains(t, enc, "abcdefghijklmnop")
+
+	again, err := k.Encrypt("sk-live-abcdefghijklmnop")
+	require.NoError(t, err)
+	assert.NotEqual(t, enc, again, "each value should use a fresh data key and nonce")
+
+	plain, err := k.Decrypt(enc)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-live-abcdefghijklmnop", plain)
+
+	// Legacy plaintext passes through
+	plain, err = k.Decrypt("legacy-key")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-key", plain)
+
+	empty, err := k.Encrypt("")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldRing, err := NewKeyring("v1", "first-secret-0123456789", nil)
+	require.NoError(t, err)
+	enc, err := oldRing.Encrypt("provider-key")
+	require.NoError(t, err)
+
+	newRing, err := NewKeyring("v2", "second-secret-0123456789", map[string]string{"v1": "first-secret-0123456789"})
+	require.NoError(t, err)
+	assert.True(t, newRing.NeedsRotation(enc))
+	assert.True(t, newRing.NeedsRotation("plaintext"))
+
+	plain, err := newRing.Decrypt(enc)
+	require.NoError(t, err)
+	assert.Equal(t, "provider-key", plain)
+
+	rotated, err := newRing.Encrypt(plain)
+	require.NoError(t, err)
+	assert.False(t, newRing.NeedsRotation(rotated))
+
+	// Once the old key is dropped, values it wrote cannot be read
+	_, err = oldRing.Decrypt(rotated)
+	assert.True(t, errors.Is(err, ErrUnknownKey))
+}
+
+func TestKeyring_RejectsTampering(t *testing.T) {
+	k, err := NewKeyring("v1", "first-secret-0123456789", nil)
+	require.NoError(t, err)
+	enc, err := k.Encrypt("provider-key")
+	require.NoError(t, err)
+
+	tampered := enc[:len(enc)-2] + "AA"
+	_, err = k.Decrypt(tampered)
+	assert.Error(t, err)
+
+	wrongSecret, err := NewKeyring("v1", "another-secret-0123456789", nil)
+	require.NoError(t, err)
+	_, err = wrongSecret.Decrypt(enc)
+	assert.Error(t, err)
+}
+
+func TestParsePrevious(t *testing.T) {
+	previous, err := ParsePrevious("v1:first-secret, v0:zero:with-colon")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1": "first-secret", "v0": "zero:with-colon"}, previous)
+
+	_, err = ParsePrevious("missing-separator")
+	assert.Error(t, err)
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Empty(t, Fingerprint(""))
+	fp := Fingerprint("sk-live-abcdefghijklmnop")
+	assert.Contains(t, fp, "mnop")
+	assert.NotContains(t, fp, "abcdefghijkl")
+	assert.Equal(t, fp, Fingerprint("sk-live-abcdefghijklmnop"))
+	assert.NotContains(t, Fingerprint("short"), "short")
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := NewToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+	assert.Equal(t, HashToken(token), hash)
+	assert.NotEqual(t, token, hash)
+
+	other, _, err := NewToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
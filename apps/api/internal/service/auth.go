@@ -2,23 +2,30 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ner-studio/api/internal/model"
 	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/secret"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthService handles authentication and user management
 type AuthService struct {
 	repo *repository.Repository
+
+	// refreshTokenTTL is how long a session stays valid without being refreshed
+	refreshTokenTTL time.Duration
 }
 
 // NewAuthService creates a new auth service
-func NewAuthService(repo *repository.Repository) *AuthService {
+func NewAuthService(repo *repository.Repository, refreshTokenTTL time.Duration) *AuthService {
 	return &AuthService{
-		repo: repo,
+		repo:            repo,
+		refreshTokenTTL: refreshTokenTTL,
 	}
 }
 
@@ -65,6 +72,11 @@ func (s *AuthService) CreateOrganizationAndProfile(ctx context.Context, email, f
 		return nil, nil, err
 	}
 
+	return s.createOrganizationForUser(ctx, user.ID, fullName, orgName)
+}
+
+// createOrganizationForUser creates an organization with userID as its admin
+func (s *AuthService) createOrganizationForUser(ctx context.Context, userID uuid.UUID, fullName, orgName string) (*model.Organization, *model.Profile, error) {
 	// Create organization
 	org := &model.Organization{
 		ID:      uuid.New(),
@@ -80,7 +92,7 @@ func (s *AuthService) CreateOrganizationAndProfile(ctx context.Context, email, f
 	// Create profile as admin
 	profile := &model.Profile{
 		ID:             uuid.New(),
-		UserID:         user.ID,
+		UserID:         userID,
 		OrganizationID: org.ID,
 		FullName:       fullName,
 		Role:           "admin",
@@ -93,11 +105,57 @@ func (s *AuthService) CreateOrganizationAndProfile(ctx context.Context, email, f
 	return org, profile, nil
 }
 
+// RegisterWithInvitation creates a user and adds them to the inviting organization with the
+// invited role. The invitation must be open and addressed to email.
+func (s *AuthService) RegisterWithInvitation(ctx context.Context, email, fullName, password, inviteToken string) (*model.Organization, *model.Profile, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	_, profile, err := s.repo.CreateUserWithInvitation(ctx, secret.HashToken(inviteToken), email, string(hashedPassword), fullName)
+	if err != nil {
+		return nil, nil, invitationError(err)
+	}
+
+	org, err := s.repo.GetOrganization(ctx, profile.OrganizationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return org, profile, nil
+}
+
 // JoinOrganization joins user to existing org via invitation
 func (s *AuthService) JoinOrganization(ctx context.Context, userID, fullName, inviteToken string) (*model.Organization, *model.Profile, error) {
-	// TODO: Validate invitation token
-	// TODO: Create profile in organization
-	return nil, nil, fmt.Errorf("not implemented")
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	profile, err := s.repo.AcceptInvitation(ctx, secret.HashToken(inviteToken), uid, fullName)
+	if err != nil {
+		return nil, nil, invitationError(err)
+	}
+
+	org, err := s.repo.GetOrganization(ctx, profile.OrganizationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	return org, profile, nil
+}
+
+// invitationError turns invitation failures into validation errors the caller can show
+func invitationError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrInvalidInvitation),
+		errors.Is(err, repository.ErrInvitationEmailMismatch),
+		errors.Is(err, repository.ErrAlreadyMember):
+		return invalidf("%v", err)
+	default:
+		return err
+	}
 }
 
 func generateSlug(name string) string {
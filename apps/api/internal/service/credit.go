@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+const (
+	defaultLedgerPageSize = 50
+	maxLedgerPageSize     = 200
+)
+
+var ledgerTypes = map[string]bool{
+	"generation": true,
+	"refund":     true,
+	"purchase":   true,
+	"adjustment": true,
+	"topup":      true,
+	"hold":       true,
+	"release":    true,
+}
+
+// CreditService handles credit balance administration
+type CreditService struct {
+	repo *repository.Repository
+}
+
+// NewCreditService creates a new credit service
+func NewCreditService(repo *repository.Repository) *CreditService {
+	return &CreditService{
+		repo: repo,
+	}
+}
+
+// CreditBalance splits an organization's credits into what can be spent and what is
+// reserved for generations still in progress
+type CreditBalance struct {
+	Available int64 `json:"available"`
+	Held      int64 `json:"held"`
+	Total     int64 `json:"total"`
+}
+
+// GetBalance returns an organization's available and held credits
+func (s *CreditService) GetBalance(ctx context.Context, orgID uuid.UUID) (*CreditBalance, error) {
+	org, err := s.repo.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+	held, err := s.repo.GetHeldCredits(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get held credits: %w", err)
+	}
+	return &CreditBalance{
+		Available: org.Credits,
+		Held:      held,
+		Total:     org.Credits + held,
+	}, nil
+}
+
+// TopUp adds credits to an organization
+func (s *CreditService) TopUp(ctx context.Context, orgID, userID uuid.UUID, amount int64, reason string) (*model.CreditLedger, error) {
+	if amount <= 0 {
+		return nil, invalidf("top-up amount must be positive")
+	}
+	description := strings.TrimSpace(reason)
+	if description == "" {
+		description = "Admin top-up"
+	}
+
+	return s.apply(ctx, repository.LedgerEntry{
+		OrganizationID: orgID,
+		UserID:         &userID,
+		Amount:         amount,
+		Type:           "topup",
+		Description:    description,
+	})
+}
+
+// Adjust corrects an organization's balance by a positive or negative amount
+func (s *CreditService) Adjust(ctx context.Context, orgID, userID uuid.UUID, amount int64, reason string) (*model.CreditLedger, error) {
+	if amount == 0 {
+		return nil, invalidf("adjustment amount must not be zero")
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, invalidf("a reason is required for adjustments")
+	}
+
+	return s.apply(ctx, repository.LedgerEntry{
+		OrganizationID: orgID,
+		UserID:         &userID,
+		Amount:         amount,
+		Type:           "adjustment",
+		Description:    reason,
+	})
+}
+
+// RefundGeneration credits back what a generation was charged; amount 0 refunds the remainder
+func (s *CreditService) RefundGeneration(ctx context.Context, orgID, userID, generationID uuid.UUID, amount int64, reason string) (*model.CreditLedger, error) {
+	if amount < 0 {
+		return nil, invalidf("refund amount must not be negative")
+	}
+
+	gen, err := s.repo.GetGeneration(ctx, generationID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && gen.OrganizationID != orgID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generation: %w", err)
+	}
+
+	description := fmt.Sprintf("Refund for generation %s", generationID)
+	if reason = strings.TrimSpace(reason); reason != "" {
+		description += ": " + reason
+	}
+
+	entry, err := s.repo.RefundGeneration(ctx, orgID, generationID, amount, &userID, description)
+	if errors.Is(err, repository.ErrRefundExceedsCharge) {
+		return nil, invalidf("%v", err)
+	}
+	return entry, err
+}
+
+// LedgerQuery holds history filters as received from the API
+type LedgerQuery struct {
+	UserID       string
+	Type         string
+	GenerationID string
+	From         string // RFC 3339 or YYYY-MM-DD, inclusive
+	To           string // RFC 3339 or YYYY-MM-DD, exclusive
+	Limit        int
+	Offset       int
+}
+
+// ListHistory returns a filtered page of the ledger and the total number of matching entries
+func (s *CreditService) ListHistory(ctx context.Context, orgID uuid.UUID, q LedgerQuery) ([]*model.CreditLedger, int, error) {
+	filter := repository.LedgerFilter{
+		Type:   q.Type,
+		Limit:  q.Limit,
+		Offset: q.Offset,
+	}
+	if filter.Type != "" && !ledgerTypes[filter.Type] {
+		return nil, 0, invalidf("unknown ledger type %s", filter.Type)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultLedgerPageSize
+	}
+	if filter.Limit > maxLedgerPageSize {
+		filter.Limit = maxLedgerPageSize
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	var err error
+	if filter.UserID, err = parseOptionalUUID(q.UserID, "user_id"); err != nil {
+		return nil, 0, err
+	}
+	if filter.GenerationID, err = parseOptionalUUID(q.GenerationID, "generation_id"); err != nil {
+		return nil, 0, err
+	}
+	if filter.From, err = parseOptionalTime(q.From, "from"); err != nil {
+		return nil, 0, err
+	}
+	if filter.To, err = parseOptionalTime(q.To, "to"); err != nil {
+		return nil, 0, err
+	}
+
+	return s.repo.ListLedgerEntries(ctx, orgID, filter)
+}
+
+func (s *CreditService) apply(ctx context.Context, entry repository.LedgerEntry) (*model.CreditLedger, error) {
+	return s.repo.ApplyLedgerEntry(ctx, entry)
+}
+
+func parseOptionalUUID(value, field string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, invalidf("%s must be a UUID", field)
+	}
+	return &id, nil
+}
+
+func parseOptionalTime(value, field string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, invalidf("%s must be an RFC 3339 timestamp or YYYY-MM-DD date", field)
+}
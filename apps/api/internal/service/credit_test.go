@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLedgerFilters(t *testing.T) {
+	from, err := parseOptionalTime("2026-01-31", "from")
+	require.NoError(t, err)
+	assert.Equal(t, 31, from.Day())
+
+	to, err := parseOptionalTime("2026-02-01T12:00:00Z", "to")
+	require.NoError(t, err)
+	assert.Equal(t, 12, to.Hour())
+
+	none, err := parseOptionalTime("", "from")
+	assert.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = parseOptionalTime("last tuesday", "from")
+	assert.True(t, IsValidationError(err))
+
+	_, err = parseOptionalUUID("not-a-uuid", "user_id")
+	assert.True(t, IsValidationError(err))
+}
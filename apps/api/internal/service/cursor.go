@@ -0,0 +1,39 @@
+package service
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// encodeCursor makes an opaque page token from the last row of a page
+func encodeCursor(c repository.Cursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*repository.Cursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidf("invalid cursor")
+	}
+	ts, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return nil, invalidf("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, invalidf("invalid cursor")
+	}
+	genID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, invalidf("invalid cursor")
+	}
+	return &repository.Cursor{CreatedAt: createdAt, ID: genID}, nil
+}
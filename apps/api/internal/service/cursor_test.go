@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := repository.Cursor{
+		CreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+	got, err := decodeCursor(encodeCursor(want))
+	require.NoError(t, err)
+	assert.True(t, want.CreatedAt.Equal(got.CreatedAt))
+	assert.Equal(t, want.ID, got.ID)
+
+	none, err := decodeCursor("")
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	_, err = decodeCursor("not a cursor")
+	assert.True(t, IsValidationError(err))
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// ValidationError reports invalid caller input; handlers map it to 400 Bad Request
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// invalidf returns a ValidationError with a formatted message
+func invalidf(format string, args ...any) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// IsValidationError reports whether err is caused by invalid input
+func IsValidationError(err error) bool {
+	var v *ValidationError
+	return errors.As(err, &v)
+}
+
+// RateLimitError reports that a usage limit rejected a request; handlers map it to
+// 429 Too Many Requests with a Retry-After header
+type RateLimitError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Message
+}
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a change conflicts with the current state
+	ErrConflict = errors.New("conflict")
+	// ErrUnauthorized is returned when a caller cannot prove it is allowed to make a request
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the caller's role does not allow the request
+	ErrForbidden = errors.New("forbidden")
+	// ErrInsufficientCredits is returned when the organization cannot pay for a request
+	ErrInsufficientCredits = repository.ErrInsufficientCredits
+)
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/imaging"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/storage"
+)
+
+const (
+	defaultGalleryPageSize = 30
+	maxGalleryPageSize     = 100
+	// maxBulkImages caps bulk delete and ZIP download requests
+	maxBulkImages   = 100
+	maxTagsPerImage = 20
+	maxTagLength    = 40
+)
+
+// GalleryService handles browsing and managing completed images
+type GalleryService struct {
+	repo       *repository.Repository
+	store      storage.BlobStore
+	renditions *RenditionService
+}
+
+// NewGalleryService creates a new gallery service
+func NewGalleryService(repo *repository.Repository, store storage.BlobStore, renditions *RenditionService) *GalleryService {
+	return &GalleryService{
+		repo:       repo,
+		store:      store,
+		renditions: renditions,
+	}
+}
+
+// Viewer identifies who is browsing the gallery
+type Viewer struct {
+	OrganizationID uuid.UUID
+	UserID         uuid.UUID
+	Role           string
+}
+
+func (v Viewer) isAdmin() bool {
+	return v.Role == "admin"
+}
+
+// GalleryQuery holds gallery filters as received from the API
+type GalleryQuery struct {
+	UserID        string // admins only
+	ProviderID    string
+	From          string // RFC 3339 or YYYY-MM-DD, inclusive
+	To            string // RFC 3339 or YYYY-MM-DD, exclusive
+	Query         string
+	Tag           string
+	FavoritesOnly bool
+	Cursor        string
+	Limit         int
+}
+
+// ListImages returns a page of completed images and the cursor of the next page, which is
+// empty on the last page. Admins see the whole organization, members their own images.
+func (s *GalleryService) ListImages(ctx context.Context, viewer Viewer, q GalleryQuery) ([]*model.GalleryImage, string, error) {
+	filter := repository.GalleryFilter{
+		ViewerID:      viewer.UserID,
+		Query:         strings.TrimSpace(q.Query),
+		Tag:           normalizeTag(q.Tag),
+		FavoritesOnly: q.FavoritesOnly,
+		Limit:         q.Limit,
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultGalleryPageSize
+	}
+	if filter.Limit > maxGalleryPageSize {
+		filter.Limit = maxGalleryPageSize
+	}
+
+	var err error
+	if filter.UserID, err = parseOptionalUUID(q.UserID, "user_id"); err != nil {
+		return nil, "", err
+	}
+	if filter.ProviderID, err = parseOptionalUUID(q.ProviderID, "provider_id"); err != nil {
+		return nil, "", err
+	}
+	if filter.From, err = parseOptionalTime(q.From, "from"); err != nil {
+		return nil, "", err
+	}
+	if filter.To, err = parseOptionalTime(q.To, "to"); err != nil {
+		return nil, "", err
+	}
+	if filter.After, err = decodeCursor(q.Cursor); err != nil {
+		return nil, "", err
+	}
+
+	if !viewer.isAdmin() {
+		if filter.UserID != nil && *filter.UserID != viewer.UserID {
+			return nil, "", ErrForbidden
+		}
+		filter.UserID = &viewer.UserID
+	}
+
+	// Fetch one extra row to know whether another page follows
+	limit := filter.Limit
+	filter.Limit++
+	images, err := s.repo.ListGalleryImages(ctx, viewer.OrganizationID, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(images) > limit {
+		images = images[:limit]
+		last := images[limit-1]
+		next = encodeCursor(repository.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	for _, img := range images {
+		img.Renditions = s.renditions.URLs(ctx, img.R2Key, img.RenditionKeys)
+		img.ImageURL = img.Renditions[imaging.RenditionOriginal]
+	}
+	return images, next, nil
+}
+
+// SetFavorite adds or removes an image from the viewer's favorites
+func (s *GalleryService) SetFavorite(ctx context.Context, viewer Viewer, imageID uuid.UUID, favorite bool) error {
+	if _, err := s.getImage(ctx, viewer, imageID); err != nil {
+		return err
+	}
+	return s.repo.SetImageFavorite(ctx, viewer.UserID, imageID, favorite)
+}
+
+// SetTags replaces an image's tags. Tags are trimmed, lowercased and deduplicated.
+func (s *GalleryService) SetTags(ctx context.Context, viewer Viewer, imageID uuid.UUID, tags []string) ([]string, error) {
+	normalized, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.getImage(ctx, viewer, imageID); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetImageTags(ctx, imageID, normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// BulkDeleteResult reports which images were deleted
+type BulkDeleteResult struct {
+	Deleted []uuid.UUID `json:"deleted"`
+	Failed  []uuid.UUID `json:"failed"` // not found or not allowed
+}
+
+// DeleteImages hides the given images from the gallery and then removes their stored
+// objects and renditions, so URLs handed out for them stop working. The rows are marked
+// first so a storage failure never leaves a visible image without its object; objects
+// that could not be removed are logged.
+func (s *GalleryService) DeleteImages(ctx context.Context, viewer Viewer, ids []uuid.UUID) (*BulkDeleteResult, error) {
+	images, err := s.bulkImages(ctx, viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkDeleteResult{Deleted: []uuid.UUID{}, Failed: []uuid.UUID{}}
+	found := make(map[uuid.UUID]bool, len(images))
+	for _, img := range images {
+		found[img.ID] = true
+		result.Deleted = append(result.Deleted, img.ID)
+	}
+	for _, id := range ids {
+		if !found[id] {
+			result.Failed = append(result.Failed, id)
+		}
+	}
+	if len(result.Deleted) == 0 {
+		return result, nil
+	}
+
+	if err := s.repo.MarkGalleryImagesDeleted(ctx, result.Deleted); err != nil {
+		return nil, fmt.Errorf("failed to mark images deleted: %w", err)
+	}
+	for _, img := range images {
+		if img.R2Key != "" {
+			if err := s.store.Delete(ctx, img.R2Key); err != nil {
+				log.Printf("Failed to delete object %s of deleted image %s: %v", img.R2Key, img.ID, err)
+			}
+		}
+		if err := s.renditions.removeAll(ctx, img.RenditionKeys); err != nil {
+			log.Printf("Failed to delete renditions of deleted image %s: %v", img.ID, err)
+		}
+	}
+	return result, nil
+}
+
+// PrepareDownload checks access to the given images and returns those that can be zipped
+func (s *GalleryService) PrepareDownload(ctx context.Context, viewer Viewer, ids []uuid.UUID) ([]*model.GalleryImage, error) {
+	images, err := s.bulkImages(ctx, viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	return images, nil
+}
+
+// WriteZip streams the images into a ZIP archive, copying one object at a time from storage
+// so the archive is never held in memory. Images are already compressed, so entries are
+// stored.
+func (s *GalleryService) WriteZip(ctx context.Context, w io.Writer, images []*model.GalleryImage) error {
+	zw := zip.NewWriter(w)
+	for i, img := range images {
+		if img.R2Key == "" {
+			continue
+		}
+		header := &zip.FileHeader{
+			Name:     zipEntryName(i, img),
+			Method:   zip.Store,
+			Modified: img.CreatedAt,
+		}
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		body, err := s.store.Get(ctx, img.R2Key)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", img.ID, err)
+		}
+		_, err = io.Copy(entry, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("image %s: %w", img.ID, err)
+		}
+	}
+	return zw.Close()
+}
+
+// zipEntryName numbers entries so they sort in creation order and never collide
+func zipEntryName(i int, img *model.GalleryImage) string {
+	ext := path.Ext(img.R2Key)
+	if ext == "" {
+		ext = ".png"
+	}
+	return fmt.Sprintf("%03d_%s%s", i+1, img.ID, ext)
+}
+
+// getImage returns an image the viewer may change: admins any in the organization,
+// members only their own
+func (s *GalleryService) getImage(ctx context.Context, viewer Viewer, imageID uuid.UUID) (*model.GalleryImage, error) {
+	images, err := s.bulkImages(ctx, viewer, []uuid.UUID{imageID})
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, ErrNotFound
+	}
+	return images[0], nil
+}
+
+func (s *GalleryService) bulkImages(ctx context.Context, viewer Viewer, ids []uuid.UUID) ([]*model.GalleryImage, error) {
+	if len(ids) == 0 {
+		return nil, invalidf("image_ids must not be empty")
+	}
+	if len(ids) > maxBulkImages {
+		return nil, invalidf("at most %d images per request", maxBulkImages)
+	}
+
+	images, err := s.repo.GetGalleryImages(ctx, viewer.OrganizationID, viewer.UserID, ids)
+	if err != nil {
+		return nil, err
+	}
+	if viewer.isAdmin() {
+		return images, nil
+	}
+
+	own := images[:0]
+	for _, img := range images {
+		if img.UserID == viewer.UserID {
+			own = append(own, img)
+		}
+	}
+	return own, nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, invalidf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTagsPerImage {
+		return nil, invalidf("at most %d tags per image", maxTagsPerImage)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
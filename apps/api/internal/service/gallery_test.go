@@ -0,0 +1,67 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" Summer ", "shoes", "summer", "", "Red"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"red", "shoes", "summer"}, tags)
+
+	_, err = normalizeTags([]string{"this tag is far too long to be accepted as a tag"})
+	assert.True(t, IsValidationError(err))
+
+	many := make([]string, maxTagsPerImage+1)
+	for i := range many {
+		many[i] = uuid.NewString()[:8]
+	}
+	_, err = normalizeTags(many)
+	assert.True(t, IsValidationError(err))
+}
+
+func TestGalleryMembersOnlySeeTheirOwnImages(t *testing.T) {
+	s := &GalleryService{}
+	viewer := Viewer{OrganizationID: uuid.New(), UserID: uuid.New(), Role: "member"}
+
+	_, _, err := s.ListImages(context.Background(), viewer, GalleryQuery{UserID: uuid.NewString()})
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestBulkImagesLimits(t *testing.T) {
+	s := &GalleryService{}
+	viewer := Viewer{OrganizationID: uuid.New(), UserID: uuid.New(), Role: "admin"}
+
+	_, err := s.DeleteImages(context.Background(), viewer, nil)
+	assert.True(t, IsValidationError(err))
+
+	_, err = s.PrepareDownload(context.Background(), viewer, make([]uuid.UUID, maxBulkImages+1))
+	assert.True(t, IsValidationError(err))
+}
+
+func TestWriteZipSkipsImagesWithoutObjects(t *testing.T) {
+	s := &GalleryService{}
+	var buf bytes.Buffer
+	err := s.WriteZip(context.Background(), &buf, []*model.GalleryImage{{ID: uuid.New()}})
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Empty(t, zr.File)
+}
+
+func TestZipEntryName(t *testing.T) {
+	img := &model.GalleryImage{ID: uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8"), R2Key: "org/generations/gen/img.webp"}
+	assert.Equal(t, "002_6ba7b810-9dad-11d1-80b4-00c04fd430c8.webp", zipEntryName(1, img))
+
+	img.R2Key = "org/generations/gen/img"
+	assert.Equal(t, "001_6ba7b810-9dad-11d1-80b4-00c04fd430c8.png", zipEntryName(0, img))
+}
@@ -2,68 +2,130 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/external"
+	"github.com/ner-studio/api/internal/imaging"
 	"github.com/ner-studio/api/internal/model"
 	"github.com/ner-studio/api/internal/provider"
 	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/secret"
+	"github.com/ner-studio/api/internal/storage"
+	"github.com/ner-studio/api/internal/worker"
+)
+
+const (
+	// downloadAttempts is how often a provider result download is tried before giving up
+	downloadAttempts = 3
+	// maxGeneratedImageBytes caps the size of a single provider result
+	maxGeneratedImageBytes = 50 * 1024 * 1024
+	// inputImageURLTTL is how long providers can read input images of a private bucket
+	inputImageURLTTL = 24 * time.Hour
+	// defaultNumVariations is how many images a generation makes unless asked otherwise
+	defaultNumVariations = 4
 )
 
 // GenerationService handles image generation workflow
 type GenerationService struct {
 	repo            *repository.Repository
 	factory         *provider.Factory
+	store           storage.BlobStore
+	links           *storage.Links
+	httpClient      *http.Client
 	callbackBaseURL string
+	creditHoldTTL   time.Duration
+	defaultLimits   model.OrganizationLimits
+	renditions      *RenditionService
 }
 
-// NewGenerationService creates a new generation service
-func NewGenerationService(repo *repository.Repository, factory *provider.Factory, callbackBaseURL string) *GenerationService {
+// NewGenerationService creates a new generation service. defaultLimits apply to
+// organizations without limits of their own.
+func NewGenerationService(repo *repository.Repository, factory *provider.Factory, store storage.BlobStore, links *storage.Links, callbackBaseURL string, creditHoldTTL time.Duration, defaultLimits model.OrganizationLimits, renditions *RenditionService) *GenerationService {
 	return &GenerationService{
 		repo:            repo,
 		factory:         factory,
+		store:           store,
+		links:           links,
+		httpClient:      &http.Client{Timeout: 2 * time.Minute},
 		callbackBaseURL: callbackBaseURL,
+		creditHoldTTL:   creditHoldTTL,
+		defaultLimits:   defaultLimits,
+		renditions:      renditions,
 	}
 }
 
 // CreateGenerationRequest holds parameters for creating a generation
 type CreateGenerationRequest struct {
-	UserID          string
-	OrganizationID  string
-	BasePrompt      string
-	ReferenceImages []string
-	ProductImages   []string
-	ProviderID      string
-	NumVariations   int
+	UserID             string
+	OrganizationID     string
+	BasePrompt         string
+	ReferenceUploadIDs []string
+	ProductUploadIDs   []string
+	ProviderID         string
+	NumVariations      int
+	Options            model.GenerationOptions
 }
 
 // CreateGeneration starts the image generation workflow
 func (s *GenerationService) CreateGeneration(ctx context.Context, req CreateGenerationRequest) (*model.Generation, error) {
 	orgID, err := uuid.Parse(req.OrganizationID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid organization ID: %w", err)
+		return nil, fmt.Errorf("%w: invalid organization ID", ErrForbidden)
 	}
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid user ID: %w", err)
+		return nil, fmt.Errorf("%w: invalid user ID", ErrUnauthorized)
 	}
 	providerID, err := uuid.Parse(req.ProviderID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid provider ID: %w", err)
+		return nil, invalidf("provider_id must be a provider ID")
 	}
 
 	// Get provider to calculate cost
 	prov, err := s.repo.GetProvider(ctx, providerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, invalidf("provider %s was not found", providerID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get provider: %w", err)
 	}
+	if prov.Category != "image_generation" || !prov.IsActive {
+		return nil, invalidf("provider %s is not an active image generation provider", prov.Slug)
+	}
+
+	// Validate requested options against what the model supports
+	options, err := normalizeImageOptions(prov, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Input images are uploads of the organization; only their keys are stored, and the
+	// workflow hands providers URLs to them
+	referenceIDs, referenceImages, err := s.resolveUploads(ctx, orgID, "reference_upload_ids", req.ReferenceUploadIDs)
+	if err != nil {
+		return nil, err
+	}
+	productIDs, productImages, err := s.resolveUploads(ctx, orgID, "product_upload_ids", req.ProductUploadIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateInputImages(prov, productImages); err != nil {
+		return nil, err
+	}
 
 	// Set default variations
 	numVariations := req.NumVariations
 	if numVariations < 1 {
-		numVariations = 4
+		numVariations = defaultNumVariations
 	}
 	if numVariations > 10 {
 		numVariations = 10
@@ -72,135 +134,440 @@ func (s *GenerationService) CreateGeneration(ctx context.Context, req CreateGene
 	// Calculate estimated cost
 	estimatedCost := prov.CostPerUse * int64(numVariations)
 
-	// Check credits
-	org, err := s.repo.GetOrganization(ctx, orgID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get organization: %w", err)
+	// Create generation record
+	gen := &model.Generation{
+		ID:                 uuid.New(),
+		OrganizationID:     orgID,
+		UserID:             userID,
+		Status:             "pending",
+		BasePrompt:         req.BasePrompt,
+		ReferenceImages:    referenceImages,
+		ProductImages:      productImages,
+		ReferenceUploadIDs: referenceIDs,
+		ProductUploadIDs:   productIDs,
+		ProviderID:         providerID,
+		Options:            options,
+		EstimatedCost:      estimatedCost,
+		NumImages:          numVariations,
 	}
-	if org.Credits < estimatedCost {
-		return nil, fmt.Errorf("insufficient credits: have %d, need %d", org.Credits, estimatedCost)
+
+	limits, err := s.repo.GetOrganizationLimits(ctx, orgID, s.defaultLimits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization limits: %w", err)
 	}
 
-	// Create generation record
-	gen := &model.Generation{
-		ID:              uuid.New(),
-		OrganizationID:  orgID,
-		UserID:          userID,
-		Status:          "pending",
-		BasePrompt:      req.BasePrompt,
-		ReferenceImages: req.ReferenceImages,
-		ProductImages:   req.ProductImages,
-		ProviderID:      providerID,
-		EstimatedCost:   estimatedCost,
-	}
-
-	// Save to database
-	if err := s.repo.CreateGeneration(ctx, gen); err != nil {
+	// Save to database, reserve the estimated cost and enqueue the workflow job in one
+	// transaction, so concurrent submissions cannot overdraw the organization or exceed
+	// its limits, and the generation is never left without a job if the process dies
+	if err := s.repo.CreateGenerationWithJob(ctx, gen, s.creditHoldTTL, limits); err != nil {
+		var limitErr *repository.LimitError
+		if errors.As(err, &limitErr) {
+			return nil, generationLimitError(limitErr)
+		}
+		if errors.Is(err, repository.ErrInsufficientCredits) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to create generation: %w", err)
 	}
 
-	// Start async workflow
-	go func() {
-		// Create background context
-		bgCtx := context.Background()
-		if err := s.runGenerationWorkflow(bgCtx, gen.ID); err != nil {
-			log.Printf("Generation workflow failed for %s: %v", gen.ID, err)
+	return gen, nil
+}
+
+// resolveUploads looks up completed uploads of the organization and returns their IDs and
+// storage keys in the order given
+func (s *GenerationService) resolveUploads(ctx context.Context, orgID uuid.UUID, field string, rawIDs []string) ([]uuid.UUID, []string, error) {
+	if len(rawIDs) == 0 {
+		return nil, nil, nil
+	}
+	ids := make([]uuid.UUID, 0, len(rawIDs))
+	for _, raw := range rawIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, nil, invalidf("%s must contain upload IDs", field)
 		}
-	}()
+		ids = append(ids, id)
+	}
 
-	return gen, nil
+	uploads, err := s.repo.ListCompletedUploads(ctx, orgID, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get uploads: %w", err)
+	}
+	byID := make(map[uuid.UUID]*model.Upload, len(uploads))
+	for _, u := range uploads {
+		byID[u.ID] = u
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		u, ok := byID[id]
+		if !ok {
+			return nil, nil, invalidf("upload %s was not found or is not completed", id)
+		}
+		keys = append(keys, u.Key)
+	}
+	return ids, keys, nil
 }
 
-// runGenerationWorkflow executes the full generation pipeline
-func (s *GenerationService) runGenerationWorkflow(ctx context.Context, genID uuid.UUID) error {
-	// Update status to processing
-	if err := s.repo.UpdateGenerationStatus(ctx, genID, "processing", ""); err != nil {
-		return fmt.Errorf("failed to update status: %w", err)
+// generationLimitError explains which limit rejected a new generation
+func generationLimitError(err *repository.LimitError) *RateLimitError {
+	var message string
+	switch err.Limit {
+	case repository.LimitMaxConcurrentGenerations:
+		message = fmt.Sprintf("%d generations are already in progress; wait for one to finish", err.Max)
+	case repository.LimitMaxImagesPerDay:
+		message = fmt.Sprintf("the daily limit of %d images would be exceeded", err.Max)
+	default:
+		message = err.Error()
 	}
+	return &RateLimitError{Message: message, RetryAfter: err.RetryAfter}
+}
 
-	// Get generation details
-	gen, err := s.repo.GetGeneration(ctx, genID)
+// Workflow stages, checkpointed on the job so a retried job resumes where it stopped
+const (
+	stageVisionAnalysis   = "vision_analysis"
+	stagePromptGeneration = "prompt_generation"
+	stageSubmission       = "submission"
+	stageDone             = "done"
+)
+
+// ProcessJob runs or resumes the generation workflow for a claimed job
+func (s *GenerationService) ProcessJob(ctx context.Context, job *model.GenerationJob) error {
+	gen, err := s.repo.GetGeneration(ctx, job.GenerationID)
 	if err != nil {
 		return fmt.Errorf("failed to get generation: %w", err)
 	}
+	if gen.Status == "completed" || gen.Status == "failed" {
+		return nil
+	}
+
+	if gen.Status == "pending" {
+		if err := s.repo.UpdateGenerationStatus(ctx, gen.ID, "processing", ""); err != nil {
+			return fmt.Errorf("failed to update status: %w", err)
+		}
+	}
+
+	stage := job.Stage
+	state := job.State
 
 	// Step 1: Analyze reference images (if any)
-	var visionResults []*model.VisionAnalysisResult
-	if len(gen.ReferenceImages) > 0 {
-		visionResults, err = s.analyzeReferenceImages(ctx, gen.ReferenceImages)
-		if err != nil {
-			s.repo.UpdateGenerationStatus(ctx, genID, "failed", err.Error())
-			return fmt.Errorf("vision analysis failed: %w", err)
+	if stage == stageVisionAnalysis {
+		s.emitStage(ctx, gen.ID, stage)
+		if len(gen.ReferenceImages) > 0 {
+			state.VisionResults, err = s.analyzeReferenceImages(ctx, s.inputURLs(ctx, gen.ReferenceImages))
+			if err != nil {
+				return fmt.Errorf("vision analysis failed: %w", err)
+			}
 		}
+		stage = stagePromptGeneration
+		if err := s.repo.UpdateGenerationJobProgress(ctx, job.ID, job.LockedBy, stage, state); err != nil {
+			return fmt.Errorf("failed to checkpoint job: %w", err)
+		}
+	}
+
+	// Step 2: Generate prompt variations and create image records
+	if stage == stagePromptGeneration {
+		s.emitStage(ctx, gen.ID, stage)
+		if err := s.createPromptImages(ctx, gen, state.VisionResults); err != nil {
+			return err
+		}
+		stage = stageSubmission
+		if err := s.repo.UpdateGenerationJobProgress(ctx, job.ID, job.LockedBy, stage, state); err != nil {
+			return fmt.Errorf("failed to checkpoint job: %w", err)
+		}
+	}
+
+	// Step 3: Submit image generation jobs
+	if stage == stageSubmission {
+		s.emitStage(ctx, gen.ID, stage)
+		if err := s.submitImages(ctx, gen); err != nil {
+			return err
+		}
+		if err := s.repo.UpdateGenerationJobProgress(ctx, job.ID, job.LockedBy, stageDone, state); err != nil {
+			return fmt.Errorf("failed to checkpoint job: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// FailJob fails the generation once its job has exhausted all retries. Images whose task was
+// never submitted are failed; tasks already running at the provider still report back, and
+// the last one to finish settles the generation, so images that arrive late are charged.
+func (s *GenerationService) FailJob(ctx context.Context, job *model.GenerationJob, jobErr error) {
+	images, err := s.repo.ListGenerationImages(ctx, job.GenerationID)
+	if err != nil {
+		log.Printf("Failed to list images of failed generation %s: %v", job.GenerationID, err)
+		return
+	}
+	if len(images) > 0 {
+		running := 0
+		for _, img := range images {
+			if img.Status == "completed" || img.Status == "failed" {
+				continue
+			}
+			if img.TaskID != "" {
+				running++
+				continue
+			}
+			if applied, _ := s.repo.UpdateGenerationImageFailed(ctx, img.ID, jobErr.Error()); applied {
+				s.emit(ctx, job.GenerationID, model.EventImageFailed, &img.ID, map[string]interface{}{"error": jobErr.Error()})
+			}
+		}
+		if running > 0 {
+			log.Printf("Generation %s failed with %d provider tasks still running; it is settled once they finish", job.GenerationID, running)
+			return
+		}
+		if err := s.checkGenerationComplete(ctx, job.GenerationID, jobErr.Error()); err != nil {
+			log.Printf("Failed to finish generation %s: %v", job.GenerationID, err)
+		}
+		return
+	}
+
+	// Nothing was created, so nothing can be charged
+	finished, err := s.repo.FinishGeneration(ctx, job.GenerationID, "failed", jobErr.Error())
+	if err != nil {
+		log.Printf("Failed to mark generation %s failed: %v", job.GenerationID, err)
+	}
+	if finished {
+		s.emit(ctx, job.GenerationID, model.EventGenerationFailed, nil, map[string]interface{}{
+			"status": "failed",
+			"error":  jobErr.Error(),
+		})
+	}
+	if err := s.settleCredits(ctx, job.GenerationID, 0, ""); err != nil {
+		log.Printf("Failed to release credit hold for generation %s: %v", job.GenerationID, err)
 	}
+}
 
-	// Step 2: Build LLM messages
-	messages := s.buildLLMMessages(gen.BasePrompt, visionResults)
+// createPromptImages asks the LLM for prompt variations and stores one image record per prompt,
+// at most as many as the generation was created (and limited and charged) for. Images already
+// created by a previous attempt are kept as they are.
+func (s *GenerationService) createPromptImages(ctx context.Context, gen *model.Generation, visionResults []*model.VisionAnalysisResult) error {
+	existing, err := s.repo.ListGenerationImages(ctx, gen.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	// Generations created before num_images was recorded asked for the default
+	numImages := gen.NumImages
+	if numImages < 1 {
+		numImages = defaultNumVariations
+	}
+	messages := s.buildLLMMessages(gen.BasePrompt, numImages, visionResults)
 
-	// Step 3: Generate prompts with fallback
 	llmResp, err := s.generatePromptsWithFallback(ctx, messages)
 	if err != nil {
-		s.repo.UpdateGenerationStatus(ctx, genID, "failed", err.Error())
 		return fmt.Errorf("LLM generation failed: %w", err)
 	}
 
-	// Step 4: Split prompts into variations
 	prompts := s.splitPrompts(llmResp.Content)
 	if len(prompts) == 0 {
-		s.repo.UpdateGenerationStatus(ctx, genID, "failed", "no prompts generated")
 		return fmt.Errorf("no prompts generated")
 	}
+	if len(prompts) > numImages {
+		prompts = prompts[:numImages]
+	}
 
-	// Step 5: Create generation image records
 	images := make([]*model.GenerationImage, 0, len(prompts))
 	for _, prompt := range prompts {
-		img := &model.GenerationImage{
+		images = append(images, &model.GenerationImage{
 			ID:           uuid.New(),
-			GenerationID: genID,
+			GenerationID: gen.ID,
 			Prompt:       prompt,
 			Status:       "pending",
-		}
-		images = append(images, img)
+		})
 	}
 
-	// Save images to database
-	for _, img := range images {
-		if err := s.repo.CreateGenerationImage(ctx, img); err != nil {
-			return fmt.Errorf("failed to create image record: %w", err)
+	if err := s.repo.CreateGenerationImages(ctx, images); err != nil {
+		return fmt.Errorf("failed to create image records: %w", err)
+	}
+
+	return nil
+}
+
+// addInputImages hands the provider the generation's input images it can use: product
+// images to models that edit images, reference images to models that take style references.
+// Reference images are otherwise only used through the vision analysis.
+func (s *GenerationService) addInputImages(ctx context.Context, cfg *provider.ImageGenConfig, prov *model.Provider, gen *model.Generation) {
+	if prov.Config.SupportsImageToImage {
+		cfg.InputImageURLs = s.inputURLs(ctx, gen.ProductImages)
+	}
+	if prov.Config.SupportsStyleReference {
+		cfg.ReferenceImageURLs = s.inputURLs(ctx, gen.ReferenceImages)
+	}
+}
+
+// inputURLs turns the stored keys of input images into URLs providers can fetch. Signed
+// URLs stay valid for inputImageURLTTL, as providers may queue a task before reading its
+// inputs. Generations created before keys were stored hold URLs, which are kept.
+func (s *GenerationService) inputURLs(ctx context.Context, keys []string) []string {
+	urls := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.Contains(key, "://") {
+			urls = append(urls, key)
+			continue
 		}
+		urls = append(urls, s.links.URLFor(ctx, key, inputImageURLTTL))
 	}
+	return urls
+}
 
-	// Step 6: Submit image generation jobs
-	providerSlug := "kieai-seedream" // Default, should come from provider config
-	callbackURL := fmt.Sprintf("%s/api/v1/callbacks/%s", s.callbackBaseURL, providerSlug)
+// submitImages sends every image that has not been submitted yet to the selected image provider
+func (s *GenerationService) submitImages(ctx context.Context, gen *model.Generation) error {
+	prov, err := s.repo.GetProvider(ctx, gen.ProviderID)
+	if err != nil {
+		return fmt.Errorf("failed to get provider: %w", err)
+	}
+	providerSlug := prov.Slug
 
 	imgProvider, err := s.factory.GetImageGenerationProvider(providerSlug)
 	if err != nil {
-		s.repo.UpdateGenerationStatus(ctx, genID, "failed", err.Error())
-		return fmt.Errorf("failed to get image provider: %w", err)
+		return worker.Permanent(fmt.Errorf("failed to get image provider: %w", err))
+	}
+
+	genConfig, err := buildImageGenConfig(prov, gen.Options)
+	if err != nil {
+		return worker.Permanent(err)
+	}
+	s.addInputImages(ctx, &genConfig, prov, gen)
+
+	images, err := s.repo.ListGenerationImages(ctx, gen.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
 	}
 
 	for _, img := range images {
-		result, err := imgProvider.GenerateImage(ctx, img.Prompt, provider.ImageGenConfig{
-			Model:       "seedream-v1",
-			Width:       1024,
-			Height:      1024,
-			CallbackURL: callbackURL,
-		})
+		if img.Status != "pending" || img.TaskID != "" {
+			continue
+		}
+
+		// Each task gets its own callback token, so a callback can only finish the image it was issued for
+		token, tokenHash, err := secret.NewToken()
+		if err != nil {
+			return fmt.Errorf("failed to create callback token: %w", err)
+		}
+		if err := s.repo.SetGenerationImageCallbackToken(ctx, img.ID, tokenHash); err != nil {
+			return fmt.Errorf("failed to store callback token for image %s: %w", img.ID, err)
+		}
+		imgConfig := genConfig
+		imgConfig.CallbackURL = s.callbackURL(providerSlug, token)
+
+		result, err := imgProvider.GenerateImage(ctx, img.Prompt, imgConfig)
 		if err != nil {
 			log.Printf("Failed to submit image job for %s: %v", img.ID, err)
-			s.repo.UpdateGenerationImageFailed(ctx, img.ID, err.Error())
+			if applied, _ := s.repo.UpdateGenerationImageFailed(ctx, img.ID, err.Error()); applied {
+				s.emit(ctx, gen.ID, model.EventImageFailed, &img.ID, map[string]interface{}{"error": err.Error()})
+			}
 			continue
 		}
 
-		// Update with task ID
-		img.TaskID = result.TaskID
-		img.Status = "processing"
+		// Persist the task ID so callbacks and the reconciler can find this image
+		if err := s.repo.UpdateGenerationImageSubmitted(ctx, img.ID, providerSlug, result.TaskID); err != nil {
+			return fmt.Errorf("failed to record task %s for image %s: %w", result.TaskID, img.ID, err)
+		}
+	}
+
+	// Every submission may have failed, in which case no callback will ever finish the generation
+	if err := s.checkGenerationComplete(ctx, gen.ID, ""); err != nil {
+		log.Printf("Failed to check generation status: %v", err)
 	}
 
 	return nil
 }
 
+// callbackURL is where a provider reports the result of one task
+func (s *GenerationService) callbackURL(providerSlug, token string) string {
+	return fmt.Sprintf("%s/api/v1/callbacks/%s?token=%s", s.callbackBaseURL, url.PathEscape(providerSlug), url.QueryEscape(token))
+}
+
+// normalizeImageOptions validates requested options against the capabilities declared in
+// the provider's config and fills in defaults
+func normalizeImageOptions(prov *model.Provider, opts model.GenerationOptions) (model.GenerationOptions, error) {
+	caps := prov.Config
+
+	if opts.AspectRatio == "" {
+		opts.AspectRatio = provider.DefaultAspectRatio
+	}
+	supportedRatios := caps.AspectRatios
+	if len(supportedRatios) == 0 {
+		supportedRatios = []string{provider.DefaultAspectRatio}
+	}
+	if !containsString(supportedRatios, opts.AspectRatio) {
+		return opts, invalidf("aspect ratio %q is not supported by %s (supported: %s)",
+			opts.AspectRatio, prov.Name, strings.Join(supportedRatios, ", "))
+	}
+	if _, _, ok := provider.DimensionsForAspectRatio(opts.AspectRatio); !ok {
+		return opts, invalidf("unknown aspect ratio %q", opts.AspectRatio)
+	}
+
+	if opts.Quality != "" && !containsString(caps.Qualities, opts.Quality) {
+		if len(caps.Qualities) == 0 {
+			return opts, invalidf("%s does not support quality settings", prov.Name)
+		}
+		return opts, invalidf("quality %q is not supported by %s (supported: %s)",
+			opts.Quality, prov.Name, strings.Join(caps.Qualities, ", "))
+	}
+
+	if opts.Seed != nil && !caps.SupportsSeed {
+		return opts, invalidf("%s does not support seeds", prov.Name)
+	}
+
+	opts.NegativePrompt = strings.TrimSpace(opts.NegativePrompt)
+	if opts.NegativePrompt != "" && !caps.SupportsNegativePrompt {
+		return opts, invalidf("%s does not support negative prompts", prov.Name)
+	}
+
+	return opts, nil
+}
+
+// validateInputImages rejects product images for models that cannot take image input
+func validateInputImages(prov *model.Provider, productImages []string) error {
+	if len(productImages) == 0 {
+		return nil
+	}
+	if !prov.Config.SupportsImageToImage {
+		return invalidf("%s does not accept product images; choose an image-to-image model", prov.Name)
+	}
+	if max := prov.Config.MaxInputImages; max > 0 && len(productImages) > max {
+		return invalidf("%s accepts at most %d product images", prov.Name, max)
+	}
+	return nil
+}
+
+// buildImageGenConfig turns a provider row and generation options into a provider request config
+func buildImageGenConfig(prov *model.Provider, opts model.GenerationOptions) (provider.ImageGenConfig, error) {
+	ratio := opts.AspectRatio
+	if ratio == "" {
+		ratio = provider.DefaultAspectRatio
+	}
+	width, height, ok := provider.DimensionsForAspectRatio(ratio)
+	if !ok {
+		return provider.ImageGenConfig{}, fmt.Errorf("unknown aspect ratio %q", ratio)
+	}
+
+	return provider.ImageGenConfig{
+		Model:          prov.Model,
+		Width:          width,
+		Height:         height,
+		AspectRatio:    ratio,
+		Quality:        opts.Quality,
+		Seed:           opts.Seed,
+		NegativePrompt: opts.NegativePrompt,
+	}, nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
 // analyzeReferenceImages analyzes uploaded reference images
 func (s *GenerationService) analyzeReferenceImages(ctx context.Context, imageURLs []string) ([]*model.VisionAnalysisResult, error) {
 	visionProvider, err := s.factory.GetVisionProvider()
@@ -221,11 +588,11 @@ func (s *GenerationService) analyzeReferenceImages(ctx context.Context, imageURL
 }
 
 // buildLLMMessages builds the prompt for LLM
-func (s *GenerationService) buildLLMMessages(basePrompt string, visionResults []*model.VisionAnalysisResult) []provider.LLMMessage {
+func (s *GenerationService) buildLLMMessages(basePrompt string, numPrompts int, visionResults []*model.VisionAnalysisResult) []provider.LLMMessage {
 	var systemPrompt strings.Builder
 	systemPrompt.WriteString("You are a creative prompt engineer for AI image generation. ")
 	systemPrompt.WriteString("Given a base prompt and optional reference image analysis, ")
-	systemPrompt.WriteString("generate 4-6 detailed, creative variations of prompts. ")
+	systemPrompt.WriteString(fmt.Sprintf("generate exactly %d detailed, creative variations of prompts. ", numPrompts))
 	systemPrompt.WriteString("Each prompt should be unique and optimized for image generation.\n\n")
 	systemPrompt.WriteString("Format: Separate each prompt with a blank line (double newline).")
 
@@ -238,7 +605,7 @@ func (s *GenerationService) buildLLMMessages(basePrompt string, visionResults []
 		}
 	}
 
-	userPrompt := fmt.Sprintf("Base Prompt: %s\n\nGenerate 4-6 creative variations:", basePrompt)
+	userPrompt := fmt.Sprintf("Base Prompt: %s\n\nGenerate %d creative variations:", basePrompt, numPrompts)
 
 	return []provider.LLMMessage{
 		{Role: "system", Content: systemPrompt.String()},
@@ -344,10 +711,26 @@ func cleanPrompt(prompt string) string {
 	return prompt
 }
 
-// HandleCallback processes provider callbacks
-func (s *GenerationService) HandleCallback(ctx context.Context, providerSlug string, payload []byte) error {
-	// Get provider to parse callback
-	imgProvider, err := s.factory.GetImageGenerationProvider(providerSlug)
+// HandleCallback authenticates a provider callback by the token in its URL, records it in
+// the callback inbox and applies its result. Replays of an already processed task are
+// acknowledged without being applied again.
+func (s *GenerationService) HandleCallback(ctx context.Context, providerSlug, token string, payload []byte) error {
+	if token == "" {
+		return ErrUnauthorized
+	}
+	img, err := s.repo.GetGenerationImageByCallbackToken(ctx, secret.HashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUnauthorized
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up callback token: %w", err)
+	}
+	if img.ProviderSlug != "" && img.ProviderSlug != providerSlug {
+		return ErrUnauthorized
+	}
+
+	// Get provider to parse callback; it may have been deactivated since the task was submitted
+	imgProvider, err := s.factory.GetTaskProvider(providerSlug)
 	if err != nil {
 		return fmt.Errorf("provider not found: %w", err)
 	}
@@ -355,42 +738,270 @@ func (s *GenerationService) HandleCallback(ctx context.Context, providerSlug str
 	// Parse callback data
 	callbackData, err := imgProvider.ParseCallback(payload)
 	if err != nil {
-		return fmt.Errorf("failed to parse callback: %w", err)
+		return invalidf("failed to parse callback: %v", err)
+	}
+
+	// The token belongs to one task; the task ID may not be recorded yet if the
+	// callback arrived before the submission returned
+	if img.TaskID != "" && img.TaskID != callbackData.TaskID {
+		return ErrUnauthorized
 	}
 
-	// Find image by task ID
-	img, err := s.repo.GetGenerationImageByTaskID(ctx, callbackData.TaskID)
+	entry, err := s.repo.RecordCallback(ctx, providerSlug, callbackData.TaskID, img.ID, payload)
 	if err != nil {
-		return fmt.Errorf("image not found for task %s: %w", callbackData.TaskID, err)
+		return fmt.Errorf("failed to record callback: %w", err)
+	}
+	if entry == nil {
+		return nil
 	}
 
-	// Handle success/failure
-	if callbackData.Status == "completed" {
-		// Download image and upload to R2
-		// TODO: Implement actual download and re-upload
-		r2Key := fmt.Sprintf("generations/%s/%s.jpg", img.GenerationID, img.ID)
-		r2URL := fmt.Sprintf("https://bucket.tansil.pro/%s", r2Key)
+	if err := s.applyTaskResult(ctx, img, callbackData); err != nil {
+		if markErr := s.repo.MarkCallbackFailed(ctx, entry.ID, err.Error()); markErr != nil {
+			log.Printf("Failed to record callback failure for task %s: %v", callbackData.TaskID, markErr)
+		}
+		return err
+	}
 
-		if err := s.repo.UpdateGenerationImageComplete(ctx, img.ID, r2URL, r2Key); err != nil {
-			return fmt.Errorf("failed to update image: %w", err)
+	// Progress notifications stay open so the final callback for the task is still applied
+	if callbackData.Status == "processing" {
+		return nil
+	}
+	if err := s.repo.MarkCallbackProcessed(ctx, entry.ID); err != nil {
+		log.Printf("Failed to mark callback for task %s processed: %v", callbackData.TaskID, err)
+	}
+	return nil
+}
+
+// applyTaskResult records the outcome of a provider task. Callbacks and the
+// reconciler both finish images through this path; an image is only finished once.
+func (s *GenerationService) applyTaskResult(ctx context.Context, img *model.GenerationImage, result *provider.CallbackData) error {
+	if img.Status == "completed" || img.Status == "failed" {
+		return nil
+	}
+
+	var applied bool
+	var event string
+	var data interface{}
+	switch result.Status {
+	case "processing":
+		// Progress notification, nothing to record yet
+		return nil
+
+	case "completed":
+		// Copy the image off the provider's temporary URL before it expires
+		stored, err := s.persistGeneratedImage(ctx, img, result.ImageURL)
+		if isPermanentDownloadError(err) {
+			// The result can never be stored; fail the image rather than leave it processing
+			msg := fmt.Sprintf("failed to store image: %v", err)
+			applied, err = s.repo.UpdateGenerationImageFailed(ctx, img.ID, msg)
+			if err != nil {
+				return fmt.Errorf("failed to update image: %w", err)
+			}
+			event, data = model.EventImageFailed, map[string]interface{}{"error": msg}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store image: %w", err)
 		}
 
-		// Check if all images are done
-		if err := s.checkGenerationComplete(ctx, img.GenerationID); err != nil {
-			log.Printf("Failed to check generation status: %v", err)
+		applied, err = s.repo.UpdateGenerationImageComplete(ctx, img.ID, *stored)
+		if err != nil {
+			return fmt.Errorf("failed to update image: %w", err)
+		}
+		if applied {
+			img.R2Key = stored.Key
+			if err := s.renditions.RenderGenerationImage(ctx, img, nil); err != nil {
+				log.Printf("Failed to render image %s: %v", img.ID, err)
+			}
 		}
-	} else {
+		// Events keep keys; ListEvents turns them into URLs when they are read
+		event, data = model.EventImageCompleted, imageCompletedData{
+			Key:           stored.Key,
+			RenditionKeys: img.RenditionKeys,
+		}
+
+	default:
 		// Failed
-		if err := s.repo.UpdateGenerationImageFailed(ctx, img.ID, callbackData.ErrorMessage); err != nil {
+		var err error
+		applied, err = s.repo.UpdateGenerationImageFailed(ctx, img.ID, result.ErrorMessage)
+		if err != nil {
 			return fmt.Errorf("failed to update image: %w", err)
 		}
+		event, data = model.EventImageFailed, map[string]interface{}{"error": result.ErrorMessage}
+	}
+
+	// Another callback or the reconciler finished the image first
+	if !applied {
+		return nil
+	}
+	s.emit(ctx, img.GenerationID, event, &img.ID, data)
+
+	// Check if all images are done
+	if err := s.checkGenerationComplete(ctx, img.GenerationID, ""); err != nil {
+		log.Printf("Failed to check generation status: %v", err)
 	}
 
 	return nil
 }
 
-// checkGenerationComplete checks if all images are done and updates generation status
-func (s *GenerationService) checkGenerationComplete(ctx context.Context, generationID uuid.UUID) error {
+// ReconcileConfig controls how images with missing callbacks are polled
+type ReconcileConfig struct {
+	// CallbackDeadline is how long to wait for a callback before polling the provider
+	CallbackDeadline time.Duration
+	// PollInterval is the minimum time between two polls of the same task
+	PollInterval time.Duration
+	// TaskTimeout fails tasks that are still unfinished this long after submission
+	TaskTimeout time.Duration
+	// BatchSize limits how many images are polled per run
+	BatchSize int
+}
+
+// ReconcileImages polls providers for submitted images whose callback is overdue
+// and finishes them through the same path as HandleCallback
+func (s *GenerationService) ReconcileImages(ctx context.Context, cfg ReconcileConfig) (int, error) {
+	images, err := s.repo.ClaimImagesAwaitingCallback(ctx, cfg.CallbackDeadline, cfg.PollInterval, cfg.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load images awaiting callback: %w", err)
+	}
+
+	reconciled := 0
+	for _, img := range images {
+		done, err := s.reconcileImage(ctx, img, cfg.TaskTimeout)
+		if err != nil {
+			log.Printf("Failed to reconcile image %s (task %s): %v", img.ID, img.TaskID, err)
+			continue
+		}
+		if done {
+			reconciled++
+		}
+	}
+
+	return reconciled, nil
+}
+
+func (s *GenerationService) reconcileImage(ctx context.Context, img *model.GenerationImage, taskTimeout time.Duration) (bool, error) {
+	timedOut := img.SubmittedAt != nil && taskTimeout > 0 && time.Since(*img.SubmittedAt) > taskTimeout
+
+	result := &provider.CallbackData{TaskID: img.TaskID, Status: "processing"}
+	imgProvider, err := s.factory.GetTaskProvider(img.ProviderSlug)
+	if err == nil {
+		if poller, ok := imgProvider.(provider.TaskStatusProvider); ok {
+			result, err = poller.GetTaskStatus(ctx, img.TaskID)
+			if err != nil && !timedOut {
+				return false, err
+			}
+		}
+	}
+
+	if (result == nil || result.Status == "processing") && timedOut {
+		result = &provider.CallbackData{
+			TaskID:       img.TaskID,
+			Status:       "failed",
+			ErrorMessage: fmt.Sprintf("no result from provider after %s", taskTimeout),
+		}
+	}
+	if result == nil || result.Status == "processing" {
+		return false, nil
+	}
+
+	err = s.applyTaskResult(ctx, img, result)
+	if err != nil && timedOut && result.Status != "failed" {
+		// Past the timeout an image whose result cannot be stored is not retried again
+		err = s.applyTaskResult(ctx, img, &provider.CallbackData{
+			TaskID:       img.TaskID,
+			Status:       "failed",
+			ErrorMessage: fmt.Sprintf("no stored result after %s: %v", taskTimeout, err),
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// persistGeneratedImage downloads a provider result and stores it, retrying failed downloads
+func (s *GenerationService) persistGeneratedImage(ctx context.Context, img *model.GenerationImage, sourceURL string) (*repository.StoredObject, error) {
+	if sourceURL == "" {
+		return nil, errNoImageURL
+	}
+
+	gen, err := s.repo.GetGeneration(ctx, img.GenerationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generation: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(worker.Backoff(attempt-1, time.Second, 10*time.Second)):
+			}
+		}
+
+		stored, err := s.copyToStorage(ctx, gen.OrganizationID, img, sourceURL)
+		if err == nil {
+			return stored, nil
+		}
+		lastErr = err
+		if isPermanentDownloadError(err) {
+			break
+		}
+		log.Printf("Failed to persist image %s (attempt %d/%d): %v", img.ID, attempt, downloadAttempts, err)
+	}
+
+	return nil, lastErr
+}
+
+// errNoImageURL is returned for a completed task that reports no image to download
+var errNoImageURL = errors.New("callback has no image URL")
+
+// isPermanentDownloadError reports whether a provider result can never be stored: the
+// task has no image, the link is gone or invalid (a client error), or the file is not
+// an image we accept. Retrying such a download will not help.
+func isPermanentDownloadError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *external.DownloadStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 &&
+			statusErr.StatusCode != http.StatusTooManyRequests
+	}
+	return errors.Is(err, errNoImageURL) || errors.Is(err, external.ErrTooLarge) || errors.Is(err, external.ErrNotImage)
+}
+
+// copyToStorage streams a single provider image into storage under a deterministic key
+func (s *GenerationService) copyToStorage(ctx context.Context, orgID uuid.UUID, img *model.GenerationImage, sourceURL string) (*repository.StoredObject, error) {
+	file, err := external.DownloadImage(ctx, s.httpClient, sourceURL, maxGeneratedImageBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	key := fmt.Sprintf("%s/generations/%s/%s%s", orgID, img.GenerationID, img.ID, file.Extension)
+	url, err := s.store.Put(ctx, key, file.File, file.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	// Objects of a private bucket have no permanent URL; only the key is recorded
+	if s.links.Private() {
+		url = ""
+	}
+
+	return &repository.StoredObject{
+		URL:         url,
+		Key:         key,
+		ContentType: file.ContentType,
+		Size:        file.Size,
+		Checksum:    file.SHA256,
+	}, nil
+}
+
+// checkGenerationComplete checks if all images are done and updates generation status,
+// recording errorMsg on the generation
+func (s *GenerationService) checkGenerationComplete(ctx context.Context, generationID uuid.UUID, errorMsg string) error {
 	total, completed, failed, err := s.repo.GetGenerationStats(ctx, generationID)
 	if err != nil {
 		return err
@@ -407,32 +1018,120 @@ func (s *GenerationService) checkGenerationComplete(ctx context.Context, generat
 			status = "failed"
 		}
 
-		if err := s.repo.UpdateGenerationStatus(ctx, generationID, status, ""); err != nil {
+		finished, err := s.repo.FinishGeneration(ctx, generationID, status, errorMsg)
+		if err != nil {
 			return err
 		}
+		if !finished {
+			return nil
+		}
 
-		// Deduct actual credits
+		// Charge for the completed images and return the rest of the hold
 		gen, err := s.repo.GetGeneration(ctx, generationID)
 		if err != nil {
 			return err
 		}
 
 		actualCost := gen.EstimatedCost / int64(total) * int64(completed)
-		s.repo.UpdateGenerationActualCost(ctx, generationID, actualCost)
+		// Settle even if the cost was not recorded; the charge is what matters
+		costErr := s.repo.UpdateGenerationActualCost(ctx, generationID, actualCost)
 
-		// Deduct credits
 		description := fmt.Sprintf("Image generation %s (%d/%d completed)", generationID, completed, total)
-		if err := s.repo.DeductCredits(ctx, gen.OrganizationID, actualCost, description, gen.UserID, &generationID); err != nil {
-			log.Printf("Failed to deduct credits: %v", err)
+		settleErr := s.settleCredits(ctx, generationID, actualCost, description)
+
+		event := model.EventGenerationCompleted
+		if status == "failed" {
+			event = model.EventGenerationFailed
+		}
+		s.emit(ctx, generationID, event, nil, map[string]interface{}{
+			"status":      status,
+			"completed":   completed,
+			"failed":      failed,
+			"actual_cost": actualCost,
+		})
+
+		if settleErr != nil {
+			return fmt.Errorf("failed to settle credits: %w", settleErr)
+		}
+		if costErr != nil {
+			return fmt.Errorf("failed to record actual cost: %w", costErr)
 		}
 	}
 
 	return nil
 }
 
-// GetGeneration retrieves a generation with its images
+// emit records a progress event for clients streaming the generation. Events are best effort:
+// a failure is logged and never fails the workflow.
+func (s *GenerationService) emit(ctx context.Context, generationID uuid.UUID, eventType string, imageID *uuid.UUID, data interface{}) {
+	if _, err := s.repo.AppendGenerationEvent(ctx, generationID, eventType, imageID, data); err != nil {
+		log.Printf("Failed to record %s event for generation %s: %v", eventType, generationID, err)
+	}
+}
+
+func (s *GenerationService) emitStage(ctx context.Context, generationID uuid.UUID, stage string) {
+	s.emit(ctx, generationID, model.EventStage, nil, map[string]string{"stage": stage})
+}
+
+// imageCompletedData is how an image.completed event is recorded
+type imageCompletedData struct {
+	Key           string            `json:"key"`
+	RenditionKeys map[string]string `json:"rendition_keys"`
+}
+
+// ListEvents returns a generation's events after afterID, oldest first. Completed images
+// get their URLs as the events are read, so signed URLs are fresh on every replay.
+func (s *GenerationService) ListEvents(ctx context.Context, generationID uuid.UUID, afterID int64, limit int) ([]*model.GenerationEvent, error) {
+	events, err := s.repo.ListGenerationEvents(ctx, generationID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		if e.Type != model.EventImageCompleted {
+			continue
+		}
+		var stored imageCompletedData
+		// Events recorded before keys were stored already hold URLs
+		if err := json.Unmarshal(e.Data, &stored); err != nil || stored.Key == "" {
+			continue
+		}
+		renditions := s.renditions.URLs(ctx, stored.Key, stored.RenditionKeys)
+		e.Data, err = json.Marshal(map[string]interface{}{
+			"image_url":  renditions[imaging.RenditionOriginal],
+			"renditions": renditions,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// settleCredits settles a generation's credit hold to its actual cost. Generations created
+// before holds existed are charged directly instead.
+func (s *GenerationService) settleCredits(ctx context.Context, generationID uuid.UUID, actualCost int64, description string) error {
+	_, err := s.repo.SettleCreditHold(ctx, generationID, actualCost, description)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if actualCost <= 0 {
+		return nil
+	}
+
+	gen, err := s.repo.GetGeneration(ctx, generationID)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeductCredits(ctx, gen.OrganizationID, actualCost, description, gen.UserID, &generationID)
+}
+
+// GetGeneration retrieves a generation with its images. Generations outside the
+// organization scope of ctx are reported as ErrNotFound.
 func (s *GenerationService) GetGeneration(ctx context.Context, id uuid.UUID) (*model.Generation, []*model.GenerationImage, error) {
 	gen, err := s.repo.GetGeneration(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -441,11 +1140,119 @@ func (s *GenerationService) GetGeneration(ctx context.Context, id uuid.UUID) (*m
 	if err != nil {
 		return nil, nil, err
 	}
+	// URLs are minted for this response; deleted images get none
+	for _, img := range images {
+		img.ImageURL = ""
+		if img.Status == "completed" && img.DeletedAt == nil && img.R2Key != "" {
+			img.Renditions = s.renditions.URLs(ctx, img.R2Key, img.RenditionKeys)
+			img.ImageURL = img.Renditions[imaging.RenditionOriginal]
+		}
+	}
+	gen.ReferenceImages = s.inputURLs(ctx, gen.ReferenceImages)
+	gen.ProductImages = s.inputURLs(ctx, gen.ProductImages)
 
 	return gen, images, nil
 }
 
-// ListGenerations lists generations for an organization
-func (s *GenerationService) ListGenerations(ctx context.Context, orgID uuid.UUID, limit, offset int) ([]*model.Generation, error) {
-	return s.repo.ListGenerations(ctx, orgID, limit, offset)
+const (
+	defaultGenerationPageSize = 20
+	maxGenerationPageSize     = 100
+)
+
+var generationStatuses = map[string]bool{
+	"pending":    true,
+	"processing": true,
+	"completed":  true,
+	"failed":     true,
+}
+
+// GenerationQuery holds listing filters as received from the API
+type GenerationQuery struct {
+	Scope      string // mine or org; members can only list their own
+	UserID     string
+	Status     string
+	ProviderID string
+	From       string // RFC 3339 or YYYY-MM-DD, inclusive
+	To         string // RFC 3339 or YYYY-MM-DD, exclusive
+	Query      string
+	Cursor     string
+	Limit      int
+}
+
+// ListGenerations returns a page of generations visible to the caller and the cursor of the
+// next page, which is empty on the last page. Admins see the whole organization by default,
+// members only their own generations.
+func (s *GenerationService) ListGenerations(ctx context.Context, orgID, userID uuid.UUID, role string, q GenerationQuery) ([]*model.Generation, string, error) {
+	filter := repository.GenerationFilter{
+		Status: q.Status,
+		Query:  strings.TrimSpace(q.Query),
+		Limit:  q.Limit,
+	}
+	if filter.Status != "" && !generationStatuses[filter.Status] {
+		return nil, "", invalidf("unknown status %s", filter.Status)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultGenerationPageSize
+	}
+	if filter.Limit > maxGenerationPageSize {
+		filter.Limit = maxGenerationPageSize
+	}
+
+	var err error
+	if filter.UserID, err = parseOptionalUUID(q.UserID, "user_id"); err != nil {
+		return nil, "", err
+	}
+	if filter.ProviderID, err = parseOptionalUUID(q.ProviderID, "provider_id"); err != nil {
+		return nil, "", err
+	}
+	if filter.From, err = parseOptionalTime(q.From, "from"); err != nil {
+		return nil, "", err
+	}
+	if filter.To, err = parseOptionalTime(q.To, "to"); err != nil {
+		return nil, "", err
+	}
+	if filter.After, err = decodeCursor(q.Cursor); err != nil {
+		return nil, "", err
+	}
+
+	switch q.Scope {
+	case "", "org":
+		if role != "admin" {
+			if filter.UserID != nil && *filter.UserID != userID {
+				return nil, "", ErrForbidden
+			}
+			filter.UserID = &userID
+		}
+	case "mine":
+		if filter.UserID != nil && *filter.UserID != userID {
+			return nil, "", invalidf("user_id cannot be combined with scope=mine")
+		}
+		filter.UserID = &userID
+	default:
+		return nil, "", invalidf("scope must be mine or org")
+	}
+
+	// Fetch one extra row to know whether another page follows
+	limit := filter.Limit
+	filter.Limit++
+	generations, err := s.repo.ListGenerations(ctx, orgID, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(generations) > limit {
+		generations = generations[:limit]
+		last := generations[limit-1]
+		next = encodeCursor(repository.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	// Listings show thumbnails rather than originals where they have been rendered
+	for _, gen := range generations {
+		gen.Thumbnails = make([]string, len(gen.ThumbnailKeys))
+		for i, key := range gen.ThumbnailKeys {
+			gen.Thumbnails[i] = s.links.URL(ctx, key)
+		}
+	}
+	return generations, next, nil
 }
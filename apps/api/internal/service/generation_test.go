@@ -1,9 +1,20 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/external"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/provider"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSplitPrompts(t *testing.T) {
@@ -42,6 +53,168 @@ func TestSplitPrompts(t *testing.T) {
 	}
 }
 
+func TestBuildLLMMessagesAsksForNumImages(t *testing.T) {
+	s := &GenerationService{}
+	messages := s.buildLLMMessages("A sunset", 3, []*model.VisionAnalysisResult{{Description: "beach", StyleNotes: "warm"}})
+
+	require.Len(t, messages, 2)
+	assert.Contains(t, messages[0].Content, "exactly 3 ")
+	assert.Contains(t, messages[0].Content, "Image 1: beach")
+	assert.Contains(t, messages[1].Content, "Generate 3 creative variations")
+}
+
+func TestNormalizeImageOptions(t *testing.T) {
+	seed := int64(42)
+	seedream := &model.Provider{
+		Name: "Seedream",
+		Config: model.ProviderConfig{
+			AspectRatios:           []string{"1:1", "16:9"},
+			Qualities:              []string{"standard", "hd"},
+			SupportsSeed:           true,
+			SupportsNegativePrompt: true,
+		},
+	}
+	basic := &model.Provider{Name: "Basic"}
+
+	t.Run("Defaults aspect ratio", func(t *testing.T) {
+		opts, err := normalizeImageOptions(basic, model.GenerationOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "1:1", opts.AspectRatio)
+	})
+
+	t.Run("Accepts supported options", func(t *testing.T) {
+		opts, err := normalizeImageOptions(seedream, model.GenerationOptions{
+			AspectRatio:    "16:9",
+			Quality:        "hd",
+			Seed:           &seed,
+			NegativePrompt: "  blurry ",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "blurry", opts.NegativePrompt)
+
+		cfg, err := buildImageGenConfig(seedream, opts)
+		require.NoError(t, err)
+		assert.Greater(t, cfg.Width, cfg.Height)
+		assert.Equal(t, &seed, cfg.Seed)
+	})
+
+	rejected := []struct {
+		name     string
+		provider *model.Provider
+		opts     model.GenerationOptions
+	}{
+		{"Unsupported aspect ratio", seedream, model.GenerationOptions{AspectRatio: "3:4"}},
+		{"Unsupported quality", seedream, model.GenerationOptions{Quality: "ultra"}},
+		{"Quality without support", basic, model.GenerationOptions{Quality: "hd"}},
+		{"Seed without support", basic, model.GenerationOptions{Seed: &seed}},
+		{"Negative prompt without support", basic, model.GenerationOptions{NegativePrompt: "text"}},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := normalizeImageOptions(tt.provider, tt.opts)
+			assert.True(t, IsValidationError(err))
+		})
+	}
+}
+
+func TestValidateInputImages(t *testing.T) {
+	editModel := &model.Provider{Name: "Edit", Config: model.ProviderConfig{SupportsImageToImage: true, MaxInputImages: 2}}
+	textModel := &model.Provider{Name: "Text only"}
+
+	assert.NoError(t, validateInputImages(textModel, nil))
+	assert.NoError(t, validateInputImages(editModel, []string{"a.png", "b.png"}))
+	assert.True(t, IsValidationError(validateInputImages(textModel, []string{"a.png"})))
+	assert.True(t, IsValidationError(validateInputImages(editModel, []string{"a.png", "b.png", "c.png"})))
+}
+
+func TestCallbackURLCarriesToken(t *testing.T) {
+	s := &GenerationService{callbackBaseURL: "https://api.example.com"}
+	assert.Equal(t,
+		"https://api.example.com/api/v1/callbacks/kieai-nano-banana?token=abc-_123",
+		s.callbackURL("kieai-nano-banana", "abc-_123"),
+	)
+}
+
+func TestInputURLs(t *testing.T) {
+	store, err := storage.NewR2Store("account", "key", "secret", "bucket", "https://cdn.example.com")
+	require.NoError(t, err)
+	ctx := context.Background()
+	inputs := []string{"org/uploads/1_a.png", "https://cdn.example.com/org/uploads/0_b.png"}
+
+	s := &GenerationService{links: storage.NewLinks(store, false, time.Hour)}
+	assert.Equal(t, []string{
+		"https://cdn.example.com/org/uploads/1_a.png",
+		"https://cdn.example.com/org/uploads/0_b.png", // stored before keys were
+	}, s.inputURLs(ctx, inputs))
+
+	// Providers get signed URLs to a private bucket, valid long enough to queue the task
+	s = &GenerationService{links: storage.NewLinks(store, true, time.Hour)}
+	urls := s.inputURLs(ctx, inputs)
+	require.Len(t, urls, 2)
+	assert.Contains(t, urls[0], "X-Amz-Expires=86400")
+	assert.Equal(t, inputs[1], urls[1])
+}
+
+func TestAddInputImages(t *testing.T) {
+	store, err := storage.NewR2Store("account", "key", "secret", "bucket", "https://cdn.example.com")
+	require.NoError(t, err)
+	ctx := context.Background()
+	s := &GenerationService{links: storage.NewLinks(store, false, time.Hour)}
+	gen := &model.Generation{
+		ReferenceImages: []string{"org/uploads/1_style.png"},
+		ProductImages:   []string{"org/uploads/2_product.png"},
+	}
+
+	var cfg provider.ImageGenConfig
+	s.addInputImages(ctx, &cfg, &model.Provider{}, gen)
+	assert.Empty(t, cfg.InputImageURLs)
+	assert.Empty(t, cfg.ReferenceImageURLs)
+
+	s.addInputImages(ctx, &cfg, &model.Provider{Config: model.ProviderConfig{
+		SupportsImageToImage:   true,
+		SupportsStyleReference: true,
+	}}, gen)
+	assert.Equal(t, []string{"https://cdn.example.com/org/uploads/2_product.png"}, cfg.InputImageURLs)
+	assert.Equal(t, []string{"https://cdn.example.com/org/uploads/1_style.png"}, cfg.ReferenceImageURLs)
+}
+
+func TestHandleCallbackRequiresToken(t *testing.T) {
+	s := &GenerationService{}
+	err := s.HandleCallback(context.Background(), "kieai-nano-banana", "", []byte(`{}`))
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestListGenerationsRejectsInvalidQueries(t *testing.T) {
+	s := &GenerationService{}
+	ctx := context.Background()
+	orgID, userID := uuid.New(), uuid.New()
+	other := uuid.New().String()
+
+	tests := []struct {
+		name  string
+		role  string
+		query GenerationQuery
+		want  error
+	}{
+		{"unknown status", "admin", GenerationQuery{Status: "done"}, nil},
+		{"bad provider", "admin", GenerationQuery{ProviderID: "x"}, nil},
+		{"bad date", "admin", GenerationQuery{From: "yesterday"}, nil},
+		{"bad scope", "admin", GenerationQuery{Scope: "all"}, nil},
+		{"mine with another user", "admin", GenerationQuery{Scope: "mine", UserID: other}, nil},
+		{"member filtering by another user", "member", GenerationQuery{UserID: other}, ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.ListGenerations(ctx, orgID, userID, tt.role, tt.query)
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			} else {
+				assert.True(t, IsValidationError(err), "got %v", err)
+			}
+		})
+	}
+}
+
 func TestCleanPrompt(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -62,6 +235,30 @@ func TestCleanPrompt(t *testing.T) {
 	}
 }
 
+func TestIsPermanentDownloadError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"No error", nil, false},
+		{"No image URL", errNoImageURL, true},
+		{"Expired link", &external.DownloadStatusError{StatusCode: http.StatusForbidden}, true},
+		{"Missing image", fmt.Errorf("wrapped: %w", &external.DownloadStatusError{StatusCode: http.StatusNotFound}), true},
+		{"Rate limited", &external.DownloadStatusError{StatusCode: http.StatusTooManyRequests}, false},
+		{"Server error", &external.DownloadStatusError{StatusCode: http.StatusBadGateway}, false},
+		{"Too large", fmt.Errorf("%w: exceeds 1 bytes", external.ErrTooLarge), true},
+		{"Not an image", fmt.Errorf("%w (detected text/plain)", external.ErrNotImage), true},
+		{"Network error", assert.AnError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.permanent, isPermanentDownloadError(tt.err))
+		})
+	}
+}
+
 func TestShouldFallback(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -241,3 +438,28 @@ func containsStr(s, substr string) bool {
 	}
 	return false
 }
+
+func TestGenerationLimitError(t *testing.T) {
+	err := generationLimitError(&repository.LimitError{
+		Limit:      repository.LimitMaxConcurrentGenerations,
+		Max:        3,
+		RetryAfter: 30 * time.Second,
+	})
+	assert.Equal(t, "3 generations are already in progress; wait for one to finish", err.Error())
+	assert.Equal(t, 30*time.Second, err.RetryAfter)
+
+	err = generationLimitError(&repository.LimitError{Limit: repository.LimitMaxImagesPerDay, Max: 500, RetryAfter: time.Hour})
+	assert.Equal(t, "the daily limit of 500 images would be exceeded", err.Error())
+}
+
+func TestCreateGenerationRejectsInvalidUploadIDs(t *testing.T) {
+	s := &GenerationService{}
+	_, _, err := s.resolveUploads(context.Background(), uuid.New(), "product_upload_ids", []string{"https://example.com/a.png"})
+	assert.True(t, IsValidationError(err))
+	assert.EqualError(t, err, "product_upload_ids must contain upload IDs")
+
+	ids, urls, err := s.resolveUploads(context.Background(), uuid.New(), "reference_upload_ids", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.Empty(t, urls)
+}
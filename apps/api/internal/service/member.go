@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/mailer"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/secret"
+)
+
+// MemberService manages organization members and invitations
+type MemberService struct {
+	repo       *repository.Repository
+	mailer     mailer.Mailer
+	appBaseURL string
+	inviteTTL  time.Duration
+}
+
+// NewMemberService creates a new member service. Invitation links point at appBaseURL and
+// expire after inviteTTL.
+func NewMemberService(repo *repository.Repository, m mailer.Mailer, appBaseURL string, inviteTTL time.Duration) *MemberService {
+	if inviteTTL <= 0 {
+		inviteTTL = 7 * 24 * time.Hour
+	}
+	return &MemberService{
+		repo:       repo,
+		mailer:     m,
+		appBaseURL: strings.TrimRight(appBaseURL, "/"),
+		inviteTTL:  inviteTTL,
+	}
+}
+
+// ListMembers lists the members of an organization
+func (s *MemberService) ListMembers(ctx context.Context, orgID uuid.UUID) ([]*model.Member, error) {
+	return s.repo.ListMembers(ctx, orgID)
+}
+
+// UpdateMemberRole changes a member's role, refusing to demote the last admin
+func (s *MemberService) UpdateMemberRole(ctx context.Context, orgID, profileID uuid.UUID, role string) error {
+	if !validRole(role) {
+		return invalidf("role must be admin or member")
+	}
+	return memberError(s.repo.UpdateMemberRole(ctx, orgID, profileID, role))
+}
+
+// RemoveMember removes a member from the organization, refusing to remove the last admin
+func (s *MemberService) RemoveMember(ctx context.Context, orgID, profileID uuid.UUID) error {
+	return memberError(s.repo.RemoveMember(ctx, orgID, profileID))
+}
+
+// InviteMember creates an invitation and emails its single-use link. Inviting an email that
+// already has an open invitation replaces it, which also serves as "resend".
+func (s *MemberService) InviteMember(ctx context.Context, orgID, invitedBy uuid.UUID, email, role string) (*model.Invitation, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return nil, invalidf("a valid email address is required")
+	}
+	email = strings.ToLower(addr.Address)
+	if role == "" {
+		role = "member"
+	}
+	if !validRole(role) {
+		return nil, invalidf("role must be admin or member")
+	}
+
+	isMember, err := s.repo.IsMemberByEmail(ctx, orgID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check membership: %w", err)
+	}
+	if isMember {
+		return nil, fmt.Errorf("%w: %s is already a member", ErrConflict, email)
+	}
+
+	org, err := s.repo.GetOrganization(ctx, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	token, tokenHash, err := secret.NewToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invitation token: %w", err)
+	}
+
+	inv := &model.Invitation{
+		ID:             uuid.New(),
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		InvitedBy:      invitedBy,
+		TokenHash:      tokenHash,
+		ExpiresAt:      time.Now().Add(s.inviteTTL),
+	}
+	if err := s.repo.CreateInvitation(ctx, inv); err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %w", err)
+	}
+
+	link := fmt.Sprintf("%s/invite?token=%s", s.appBaseURL, url.QueryEscape(token))
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You've been invited to join %s on NER Studio", org.Name),
+		Body: fmt.Sprintf(
+			"You've been invited to join %s on NER Studio as %s.\n\nAccept the invitation: %s\n\nThis link expires on %s.\n",
+			org.Name, role, link, inv.ExpiresAt.UTC().Format("2 Jan 2006 15:04 MST"),
+		),
+	})
+	if err != nil {
+		// The invitation is useless if the link never arrives
+		_ = s.repo.RevokeInvitation(ctx, orgID, inv.ID)
+		return nil, fmt.Errorf("failed to send invitation email: %w", err)
+	}
+
+	return inv, nil
+}
+
+// ListInvitations lists an organization's invitations
+func (s *MemberService) ListInvitations(ctx context.Context, orgID uuid.UUID) ([]*model.Invitation, error) {
+	return s.repo.ListInvitations(ctx, orgID)
+}
+
+// RevokeInvitation revokes an open invitation
+func (s *MemberService) RevokeInvitation(ctx context.Context, orgID, id uuid.UUID) error {
+	return memberError(s.repo.RevokeInvitation(ctx, orgID, id))
+}
+
+func validRole(role string) bool {
+	return role == "admin" || role == "member"
+}
+
+// memberError maps repository errors to service errors
+func memberError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, repository.ErrLastAdmin):
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/provider"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// providerTestTimeout bounds a connectivity check so a hung provider cannot hold the request
+const providerTestTimeout = 15 * time.Second
+
+var providerSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+var providerCategories = map[string]bool{
+	"image_generation": true,
+	"llm":              true,
+	"vision":           true,
+}
+
+// ProviderService keeps the provider factory in sync with the providers table
+type ProviderService struct {
+	repo    *repository.Repository
+	factory *provider.Factory
+
+	// fallbackKeys supplies an API key per driver for rows that have none stored
+	fallbackKeys map[string]string
+
+	reloadMu sync.Mutex
+}
+
+// NewProviderService creates a new provider service
+func NewProviderService(repo *repository.Repository, factory *provider.Factory, fallbackKeys map[string]string) *ProviderService {
+	return &ProviderService{
+		repo:         repo,
+		factory:      factory,
+		fallbackKeys: fallbackKeys,
+	}
+}
+
+// Reload rebuilds the provider factory from the providers table and swaps it in atomically.
+// Only active rows serve new work; inactive image providers still handle the tasks they are
+// running. In-flight requests keep the clients they already obtained.
+func (s *ProviderService) Reload(ctx context.Context) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	providers, skipped, err := s.repo.ListProviders(ctx, "", false)
+	if err != nil {
+		return fmt.Errorf("failed to list providers: %w", err)
+	}
+	for _, err := range skipped {
+		log.Printf("Skipping provider: %v", err)
+	}
+
+	for _, p := range providers {
+		if p.APIKey == "" {
+			p.APIKey = s.fallbackKeys[p.Driver]
+		}
+	}
+
+	next, errs := provider.BuildFactory(providers)
+	for _, err := range errs {
+		log.Printf("Skipping provider: %v", err)
+	}
+
+	s.factory.Replace(next)
+	return nil
+}
+
+// ListProviders lists providers, optionally filtered by category
+func (s *ProviderService) ListProviders(ctx context.Context, category string, onlyActive bool) ([]*model.Provider, error) {
+	providers, skipped, err := s.repo.ListProviders(ctx, category, onlyActive)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range skipped {
+		log.Printf("Leaving provider out of the list: %v", err)
+	}
+	if providers == nil {
+		providers = []*model.Provider{}
+	}
+	return providers, nil
+}
+
+// CreateProviderRequest holds the fields for a new provider
+type CreateProviderRequest struct {
+	Slug       string
+	Name       string
+	Category   string
+	Driver     string
+	APIKey     string
+	BaseURL    string
+	Model      string
+	Config     model.ProviderConfig
+	Priority   int
+	IsActive   bool
+	CostPerUse int64
+}
+
+// CreateProvider validates and stores a new provider, then reloads the factory
+func (s *ProviderService) CreateProvider(ctx context.Context, req CreateProviderRequest) (*model.Provider, error) {
+	p := &model.Provider{
+		Slug:       strings.TrimSpace(req.Slug),
+		Name:       strings.TrimSpace(req.Name),
+		Category:   req.Category,
+		Driver:     req.Driver,
+		APIKey:     strings.TrimSpace(req.APIKey),
+		BaseURL:    strings.TrimSpace(req.BaseURL),
+		Model:      strings.TrimSpace(req.Model),
+		Config:     req.Config,
+		Priority:   req.Priority,
+		IsActive:   req.IsActive,
+		CostPerUse: req.CostPerUse,
+	}
+	if !providerSlugPattern.MatchString(p.Slug) {
+		return nil, invalidf("slug must be 2-63 lowercase letters, digits or dashes")
+	}
+	if !providerCategories[p.Category] {
+		return nil, invalidf("category must be one of image_generation, llm, vision")
+	}
+	if err := validateProviderFields(p); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.CreateProvider(ctx, p); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, fmt.Errorf("%w: provider slug %s already exists", ErrConflict, p.Slug)
+		}
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	s.reloadAfterChange(ctx)
+	return p, nil
+}
+
+// UpdateProviderRequest holds optional provider changes; nil fields are left untouched
+type UpdateProviderRequest struct {
+	Name       *string
+	Driver     *string
+	APIKey     *string
+	BaseURL    *string
+	Model      *string
+	Config     *model.ProviderConfig
+	Priority   *int
+	IsActive   *bool
+	CostPerUse *int64
+}
+
+// UpdateProvider validates and applies changes to a provider, then reloads the factory
+func (s *ProviderService) UpdateProvider(ctx context.Context, slug string, req UpdateProviderRequest) (*model.Provider, error) {
+	p, err := s.getProviderBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		p.Name = strings.TrimSpace(*req.Name)
+		updates["name"] = p.Name
+	}
+	if req.Driver != nil {
+		p.Driver = *req.Driver
+		updates["driver"] = p.Driver
+	}
+	if req.APIKey != nil {
+		p.APIKey = strings.TrimSpace(*req.APIKey)
+		updates["api_key"] = p.APIKey
+	}
+	if req.BaseURL != nil {
+		p.BaseURL = strings.TrimSpace(*req.BaseURL)
+		updates["base_url"] = p.BaseURL
+	}
+	if req.Model != nil {
+		p.Model = strings.TrimSpace(*req.Model)
+		updates["model"] = p.Model
+	}
+	if req.Config != nil {
+		p.Config = *req.Config
+		updates["config"] = p.Config
+	}
+	if req.Priority != nil {
+		p.Priority = *req.Priority
+		updates["priority"] = p.Priority
+	}
+	if req.IsActive != nil {
+		p.IsActive = *req.IsActive
+		updates["is_active"] = p.IsActive
+	}
+	if req.CostPerUse != nil {
+		p.CostPerUse = *req.CostPerUse
+		updates["cost_per_use"] = p.CostPerUse
+	}
+	if len(updates) == 0 {
+		return nil, invalidf("no fields to update")
+	}
+	if err := validateProviderFields(p); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateProvider(ctx, p.ID, updates); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to update provider: %w", err)
+	}
+
+	s.reloadAfterChange(ctx)
+	return s.getProviderBySlug(ctx, slug)
+}
+
+// DeleteProvider removes a provider that was never used. Generations keep referring to the
+// providers they were made with, so a provider that was used can only be deactivated.
+func (s *ProviderService) DeleteProvider(ctx context.Context, slug string) error {
+	p, err := s.getProviderBySlug(ctx, slug)
+	if err != nil {
+		return err
+	}
+	inUse, err := s.repo.CountGenerationsForProvider(ctx, p.ID, p.Slug)
+	if err != nil {
+		return fmt.Errorf("failed to check provider usage: %w", err)
+	}
+	if inUse > 0 {
+		return fmt.Errorf("%w: provider has been used in %d generations; deactivate it instead", ErrConflict, inUse)
+	}
+
+	if err := s.repo.DeleteProvider(ctx, p.ID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to delete provider: %w", err)
+	}
+
+	s.reloadAfterChange(ctx)
+	return nil
+}
+
+// ProviderTestResult reports the outcome of a connectivity check
+type ProviderTestResult struct {
+	Success       bool   `json:"success"`
+	LatencyMs     int64  `json:"latency_ms"`
+	ErrorCategory string `json:"error_category,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// TestProvider runs a cheap request against a provider using its stored configuration. Inactive
+// providers can be tested too, so credentials can be checked before a provider is enabled.
+func (s *ProviderService) TestProvider(ctx context.Context, slug string) (*ProviderTestResult, error) {
+	p, err := s.getProviderBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	if p.APIKey == "" {
+		p.APIKey = s.fallbackKeys[p.Driver]
+	}
+
+	client, err := provider.CreateProviderFromConfig(*p)
+	if err != nil {
+		return &ProviderTestResult{ErrorCategory: "config", Error: err.Error()}, nil
+	}
+	checker, ok := client.(provider.HealthChecker)
+	if !ok {
+		return &ProviderTestResult{ErrorCategory: "config", Error: fmt.Sprintf("driver %s does not support connectivity checks", p.Driver)}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, providerTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err = checker.CheckHealth(ctx)
+	result := &ProviderTestResult{
+		Success:   err == nil,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.ErrorCategory = provider.ErrorCategory(err)
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+func (s *ProviderService) getProviderBySlug(ctx context.Context, slug string) (*model.Provider, error) {
+	p, err := s.repo.GetProviderBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get provider: %w", err)
+	}
+	return p, nil
+}
+
+// reloadAfterChange applies a committed change to this instance right away; other instances
+// pick it up on their next periodic reload
+func (s *ProviderService) reloadAfterChange(ctx context.Context) {
+	if err := s.Reload(ctx); err != nil {
+		log.Printf("Failed to reload providers after change: %v", err)
+	}
+}
+
+// validateProviderFields checks the fields shared by create and update
+func validateProviderFields(p *model.Provider) error {
+	if p.Name == "" {
+		return invalidf("name is required")
+	}
+	if p.Model == "" {
+		return invalidf("model is required")
+	}
+	if !containsString(provider.Drivers(), p.Driver) {
+		return invalidf("driver must be one of %s", strings.Join(provider.Drivers(), ", "))
+	}
+	if p.BaseURL != "" {
+		u, err := url.Parse(p.BaseURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return invalidf("base_url must be an http(s) URL")
+		}
+	}
+	if p.Priority < 0 {
+		return invalidf("priority must not be negative")
+	}
+	if p.CostPerUse < 0 {
+		return invalidf("cost_per_use must not be negative")
+	}
+	if p.Config.TimeoutMs < 0 || p.Config.MaxRetries < 0 || p.Config.MaxInputImages < 0 {
+		return invalidf("config values must not be negative")
+	}
+	for _, ratio := range p.Config.AspectRatios {
+		if _, _, ok := provider.DimensionsForAspectRatio(ratio); !ok {
+			return invalidf("unsupported aspect ratio %q in config", ratio)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/ner-studio/api/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProviderFields(t *testing.T) {
+	valid := func() *model.Provider {
+		return &model.Provider{Name: "Seedream", Driver: "kieai", Model: "seedream-v1", BaseURL: "https://api.kie.ai"}
+	}
+	assert.NoError(t, validateProviderFields(valid()))
+
+	tests := []struct {
+		name   string
+		mutate func(p *model.Provider)
+	}{
+		{"Missing name", func(p *model.Provider) { p.Name = "" }},
+		{"Missing model", func(p *model.Provider) { p.Model = "" }},
+		{"Unknown driver", func(p *model.Provider) { p.Driver = "acme" }},
+		{"Bad base URL", func(p *model.Provider) { p.BaseURL = "ftp://example.com" }},
+		{"Negative cost", func(p *model.Provider) { p.CostPerUse = -1 }},
+		{"Unknown aspect ratio", func(p *model.Provider) { p.Config.AspectRatios = []string{"5:7"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.mutate(p)
+			err := validateProviderFields(p)
+			assert.True(t, IsValidationError(err), "expected validation error, got %v", err)
+		})
+	}
+}
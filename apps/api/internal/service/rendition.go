@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/imaging"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/storage"
+)
+
+// maxRenditionSourceBytes caps how much of an original is read to render it
+const maxRenditionSourceBytes = maxGeneratedImageBytes
+
+// RenditionService stores smaller copies of images next to their original and resolves
+// them to URLs
+type RenditionService struct {
+	repo      *repository.Repository
+	store     storage.BlobStore
+	links     *storage.Links
+	specs     []imaging.RenditionSpec
+	version   string
+	maxPixels int
+}
+
+// NewRenditionService creates a rendition service for the given specs. Originals over
+// maxPixels are not rendered.
+func NewRenditionService(repo *repository.Repository, store storage.BlobStore, links *storage.Links, specs []imaging.RenditionSpec, maxPixels int) *RenditionService {
+	return &RenditionService{
+		repo:      repo,
+		store:     store,
+		links:     links,
+		specs:     specs,
+		version:   imaging.RenditionsVersion(specs),
+		maxPixels: maxPixels,
+	}
+}
+
+// render stores the renditions of the image at key, decoded from data or read from storage
+// when data is nil, and returns their keys by name
+func (s *RenditionService) render(ctx context.Context, key string, data []byte) (map[string]string, error) {
+	if data == nil {
+		var err error
+		if data, err = storage.ReadAll(ctx, s.store, key, maxRenditionSourceBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	renditions, err := imaging.Render(data, s.specs, s.maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]string, len(s.specs))
+	for _, spec := range s.specs {
+		img := renditions[spec.Name]
+		renditionKey := spec.Key(key)
+		if _, err := storage.PutBytes(ctx, s.store, renditionKey, img.Data, img.ContentType); err != nil {
+			return nil, fmt.Errorf("failed to store %s rendition: %w", spec.Name, err)
+		}
+		keys[spec.Name] = renditionKey
+	}
+	return keys, nil
+}
+
+// removeObsolete deletes renditions that were replaced by a new set
+func (s *RenditionService) removeObsolete(ctx context.Context, previous, current map[string]string) {
+	kept := make(map[string]bool, len(current))
+	for _, key := range current {
+		kept[key] = true
+	}
+	for _, key := range previous {
+		if kept[key] {
+			continue
+		}
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete obsolete rendition %s: %v", key, err)
+		}
+	}
+}
+
+// RenderGenerationImage stores the renditions of a completed image. data holds the stored
+// original, or is nil to read it back from storage.
+func (s *RenditionService) RenderGenerationImage(ctx context.Context, img *model.GenerationImage, data []byte) error {
+	keys, err := s.render(ctx, img.R2Key, data)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetGenerationImageRenditions(ctx, img.ID, keys, s.version); err != nil {
+		return fmt.Errorf("failed to record renditions: %w", err)
+	}
+	s.removeObsolete(ctx, img.RenditionKeys, keys)
+	img.RenditionKeys, img.RenditionsVersion = keys, s.version
+	return nil
+}
+
+// RenderUpload stores the renditions of a completed upload. data holds the stored image, or
+// is nil to read it back from storage.
+func (s *RenditionService) RenderUpload(ctx context.Context, upload *model.Upload, data []byte) error {
+	keys, err := s.render(ctx, upload.Key, data)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.SetUploadRenditions(ctx, upload.ID, keys, s.version); err != nil {
+		return fmt.Errorf("failed to record renditions: %w", err)
+	}
+	s.removeObsolete(ctx, upload.RenditionKeys, keys)
+	upload.RenditionKeys, upload.RenditionsVersion = keys, s.version
+	return nil
+}
+
+// URLs maps each rendition name, and "original", to the URL of the object stored under
+// originalKey or its rendition. Renditions that were not rendered yet fall back to the
+// original, so clients always find every name.
+func (s *RenditionService) URLs(ctx context.Context, originalKey string, keys map[string]string) map[string]string {
+	originalURL := s.links.URL(ctx, originalKey)
+	urls := make(map[string]string, len(s.specs)+1)
+	urls[imaging.RenditionOriginal] = originalURL
+	for _, spec := range s.specs {
+		if key := keys[spec.Name]; key != "" {
+			urls[spec.Name] = s.links.URL(ctx, key)
+		} else {
+			urls[spec.Name] = originalURL
+		}
+	}
+	return urls
+}
+
+// removeAll deletes every rendition in keys, so none stays readable once its original is
+// gone. It stops at the first failure.
+func (s *RenditionService) removeAll(ctx context.Context, keys map[string]string) error {
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegenerateStale renders every stored image and upload whose renditions were made with
+// other specs, batchSize at a time. Images that fail are logged and skipped. It returns
+// how many were rendered and how many failed.
+func (s *RenditionService) RegenerateStale(ctx context.Context, batchSize int) (int, int, error) {
+	rendered, failed := 0, 0
+
+	var after uuid.UUID
+	for {
+		images, err := s.repo.ListStaleGenerationImages(ctx, s.version, after, batchSize)
+		if err != nil {
+			return rendered, failed, err
+		}
+		for _, img := range images {
+			after = img.ID
+			if err := s.RenderGenerationImage(ctx, img, nil); err != nil {
+				log.Printf("Failed to render image %s: %v", img.ID, err)
+				failed++
+				continue
+			}
+			rendered++
+		}
+		if len(images) < batchSize {
+			break
+		}
+	}
+
+	after = uuid.Nil
+	for {
+		uploads, err := s.repo.ListStaleUploads(ctx, s.version, after, batchSize)
+		if err != nil {
+			return rendered, failed, err
+		}
+		for _, upload := range uploads {
+			after = upload.ID
+			if err := s.RenderUpload(ctx, upload, nil); err != nil {
+				log.Printf("Failed to render upload %s: %v", upload.ID, err)
+				failed++
+				continue
+			}
+			rendered++
+		}
+		if len(uploads) < batchSize {
+			break
+		}
+	}
+
+	return rendered, failed, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ner-studio/api/internal/imaging"
+	"github.com/ner-studio/api/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenditionURLs(t *testing.T) {
+	store, err := storage.NewR2Store("account", "key", "secret", "bucket", "https://cdn.example.com")
+	require.NoError(t, err)
+	s := NewRenditionService(nil, store, storage.NewLinks(store, false, time.Hour), imaging.DefaultRenditions(320, 1280), 0)
+	ctx := context.Background()
+
+	original := "https://cdn.example.com/org/generations/gen/img.png"
+	keys := map[string]string{
+		imaging.RenditionThumbnail: "org/generations/gen/img.thumbnail-320-q80.jpg",
+	}
+	urls := s.URLs(ctx, "org/generations/gen/img.png", keys)
+	assert.Equal(t, map[string]string{
+		imaging.RenditionOriginal:  original,
+		imaging.RenditionThumbnail: "https://cdn.example.com/org/generations/gen/img.thumbnail-320-q80.jpg",
+		imaging.RenditionMedium:    original, // not rendered yet
+	}, urls)
+
+	// Images without renditions still expose every name
+	assert.Len(t, s.URLs(ctx, "org/generations/gen/img.png", nil), 3)
+
+	// A private bucket gets expiring signed URLs rather than public ones
+	s = NewRenditionService(nil, store, storage.NewLinks(store, true, 10*time.Minute), imaging.DefaultRenditions(320, 1280), 0)
+	urls = s.URLs(ctx, "org/generations/gen/img.png", keys)
+	for name, signed := range urls {
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		assert.False(t, strings.HasPrefix(signed, "https://cdn.example.com"), name)
+		assert.Equal(t, "600", u.Query().Get("X-Amz-Expires"), name)
+	}
+	assert.Contains(t, urls[imaging.RenditionThumbnail], "img.thumbnail-320-q80.jpg")
+	assert.Contains(t, urls[imaging.RenditionMedium], "/img.png")
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/secret"
+)
+
+// SessionMeta describes the client a session is started from
+type SessionMeta struct {
+	UserAgent string
+	IPAddress string
+}
+
+// IssuedSession is a session with a fresh refresh token and the claims to put in its
+// access token. OrganizationID and Role are empty when the user has no membership in the
+// session's organization.
+type IssuedSession struct {
+	Session        *model.Session
+	RefreshToken   string
+	UserID         uuid.UUID
+	OrganizationID string
+	Role           string
+}
+
+// maxUserAgentLength caps the user agent stored with a session
+const maxUserAgentLength = 512
+
+// StartSession signs a user in to orgID, or to no organization when orgID is nil
+func (s *AuthService) StartSession(ctx context.Context, userID uuid.UUID, orgID *uuid.UUID, meta SessionMeta) (*IssuedSession, error) {
+	token, hash, err := secret.NewToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	userAgent := meta.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := &model.Session{
+		ID:             uuid.New(),
+		UserID:         userID,
+		OrganizationID: orgID,
+		UserAgent:      userAgent,
+		IPAddress:      meta.IPAddress,
+		ExpiresAt:      time.Now().Add(s.refreshTokenTTL),
+	}
+	if err := s.repo.CreateSession(ctx, session, hash); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return s.issue(ctx, session, token)
+}
+
+// RefreshSession rotates a refresh token and reloads the session's organization and role
+// from the database. Unknown, expired, revoked and reused tokens are ErrUnauthorized; a
+// reused token also revokes its session.
+func (s *AuthService) RefreshSession(ctx context.Context, refreshToken string) (*IssuedSession, error) {
+	if refreshToken == "" {
+		return nil, ErrUnauthorized
+	}
+	token, hash, err := secret.NewToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	session, err := s.repo.RotateSession(ctx, secret.HashToken(refreshToken), hash, s.refreshTokenTTL)
+	if errors.Is(err, repository.ErrInvalidSession) || errors.Is(err, repository.ErrRefreshTokenReused) {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issue(ctx, session, token)
+}
+
+// issue pairs a session with its refresh token and current claims
+func (s *AuthService) issue(ctx context.Context, session *model.Session, refreshToken string) (*IssuedSession, error) {
+	issued := &IssuedSession{
+		Session:      session,
+		RefreshToken: refreshToken,
+		UserID:       session.UserID,
+	}
+	if session.OrganizationID == nil {
+		return issued, nil
+	}
+
+	profile, err := s.repo.GetProfileInOrganization(ctx, session.UserID, *session.OrganizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Removed from the organization since signing in
+		return issued, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %w", err)
+	}
+	issued.OrganizationID = profile.OrganizationID.String()
+	issued.Role = profile.Role
+	return issued, nil
+}
+
+// Logout revokes the session a refresh token belongs to. Unknown tokens are ignored, so
+// logging out twice is harmless.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return invalidf("refresh_token is required")
+	}
+	_, err := s.repo.RevokeSessionByToken(ctx, secret.HashToken(refreshToken), repository.SessionRevokedLogout)
+	return err
+}
+
+// ListSessions returns a user's active sessions
+func (s *AuthService) ListSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+	return s.repo.ListActiveSessions(ctx, userID)
+}
+
+// RevokeSession signs a user out of one of their sessions
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	revoked, err := s.repo.RevokeSession(ctx, userID, sessionID, repository.SessionRevokedLogout)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions signs a user out everywhere and returns how many sessions were revoked.
+// Access tokens already issued stay valid until they expire.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.repo.RevokeUserSessions(ctx, userID, repository.SessionRevokedLogoutAll)
+}
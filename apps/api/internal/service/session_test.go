@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTokensAreRequired(t *testing.T) {
+	s := &AuthService{}
+
+	_, err := s.RefreshSession(context.Background(), "")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	err = s.Logout(context.Background(), "")
+	assert.True(t, IsValidationError(err))
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/oidc"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/secret"
+)
+
+// oidcLoginTTL is how long a user has to complete a login at the provider
+const oidcLoginTTL = 10 * time.Minute
+
+// SSOService signs users in with OpenID Connect providers
+type SSOService struct {
+	repo      *repository.Repository
+	auth      *AuthService
+	providers map[string]*oidc.Provider
+}
+
+// NewSSOService creates an SSO service for the given providers
+func NewSSOService(repo *repository.Repository, auth *AuthService, providers []*oidc.Provider) *SSOService {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+	return &SSOService{
+		repo:      repo,
+		auth:      auth,
+		providers: byName,
+	}
+}
+
+// Providers returns the names of the configured providers
+func (s *SSOService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SSOLoginOptions are applied when the login creates or onboards an account
+type SSOLoginOptions struct {
+	InviteToken string // join the inviting organization
+	OrgName     string // name of the organization created for a user without one
+}
+
+// StartLogin begins an authorization code login with PKCE and returns the provider URL
+// to send the user to
+func (s *SSOService) StartLogin(ctx context.Context, providerName string, opts SSOLoginOptions) (string, error) {
+	p, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	state, stateHash, err := secret.NewToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := secret.NewToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return "", fmt.Errorf("failed to build authorization URL: %w", err)
+	}
+
+	st := &model.OIDCLoginState{
+		StateHash:    stateHash,
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		OrgName:      strings.TrimSpace(opts.OrgName),
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	}
+	if opts.InviteToken != "" {
+		st.InviteTokenHash = secret.HashToken(opts.InviteToken)
+	}
+	if err := s.repo.CreateOIDCLoginState(ctx, st); err != nil {
+		return "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	return authURL, nil
+}
+
+// SSOLogin is the outcome of a completed login
+type SSOLogin struct {
+	Session      *IssuedSession
+	User         *model.User
+	Profile      *model.Profile
+	Organization *model.Organization
+	NewUser      bool
+}
+
+// CompleteLogin redeems the code the provider redirected back with. The user is found by
+// the provider identity, or linked to an existing account by verified email, or created.
+// A user without an organization joins the one that invited them or gets a new one.
+func (s *SSOService) CompleteLogin(ctx context.Context, code, state string, meta SessionMeta) (*SSOLogin, error) {
+	if code == "" || state == "" {
+		return nil, invalidf("code and state are required")
+	}
+
+	st, err := s.repo.ConsumeOIDCLoginState(ctx, secret.HashToken(state))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: login expired or already completed", ErrUnauthorized)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, ok := s.providers[st.Provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: provider %s is no longer configured", ErrUnauthorized, st.Provider)
+	}
+
+	rawIDToken, err := p.Exchange(ctx, code, st.CodeVerifier)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, st.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+
+	login, err := s.resolveUser(ctx, st, claims)
+	if err != nil {
+		return nil, err
+	}
+	if login.Profile == nil {
+		if err := s.onboard(ctx, st, claims, login); err != nil {
+			return nil, err
+		}
+	}
+	if login.Organization == nil {
+		login.Organization, err = s.repo.GetOrganization(ctx, login.Profile.OrganizationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get organization: %w", err)
+		}
+	}
+
+	login.Session, err = s.auth.StartSession(ctx, login.User.ID, &login.Profile.OrganizationID, meta)
+	if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// resolveUser finds or creates the user of a verified identity. A new user invited by
+// the login's invitation joins the organization right away.
+func (s *SSOService) resolveUser(ctx context.Context, st *model.OIDCLoginState, claims *oidc.Claims) (*SSOLogin, error) {
+	user, err := s.repo.GetUserByIdentity(ctx, st.Provider, claims.Subject)
+	if err == nil {
+		return &SSOLogin{User: user}, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	// Only a verified email may be linked to an account or used for a new one
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("%w: the provider did not confirm the email address", ErrUnauthorized)
+	}
+
+	login := &SSOLogin{}
+	login.User, err = s.repo.FindUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+	case errors.Is(err, pgx.ErrNoRows) && st.InviteTokenHash != "":
+		// Users without a password have an empty hash, which never matches one
+		login.User, login.Profile, err = s.repo.CreateUserWithInvitation(ctx, st.InviteTokenHash, claims.Email, "", claims.Name)
+		if err != nil {
+			return nil, invitationError(err)
+		}
+		st.InviteTokenHash = ""
+		login.NewUser = true
+	case errors.Is(err, pgx.ErrNoRows):
+		login.User, err = s.repo.CreateUser(ctx, claims.Email, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		login.NewUser = true
+	default:
+		return nil, err
+	}
+
+	if err := s.repo.LinkUserIdentity(ctx, login.User.ID, st.Provider, claims.Subject, claims.Email); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return login, nil
+}
+
+// onboard picks the organization the login acts in: the one of the login's invitation,
+// otherwise the user's existing one, otherwise a new one with the user as admin
+func (s *SSOService) onboard(ctx context.Context, st *model.OIDCLoginState, claims *oidc.Claims, login *SSOLogin) error {
+	var err error
+	if st.InviteTokenHash != "" {
+		login.Profile, err = s.repo.AcceptInvitation(ctx, st.InviteTokenHash, login.User.ID, claims.Name)
+		if err == nil || !errors.Is(err, repository.ErrAlreadyMember) {
+			return invitationError(err)
+		}
+	}
+
+	login.Profile, err = s.repo.GetProfileByUserID(ctx, login.User.ID.String())
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	orgName := st.OrgName
+	if orgName == "" {
+		orgName = defaultOrgName(claims)
+	}
+	login.Organization, login.Profile, err = s.auth.createOrganizationForUser(ctx, login.User.ID, claims.Name, orgName)
+	return err
+}
+
+// defaultOrgName names the organization of a user who signed up without choosing one
+func defaultOrgName(claims *oidc.Claims) string {
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	return name + "'s workspace"
+}
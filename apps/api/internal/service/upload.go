@@ -1,69 +1,376 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/ner-studio/api/internal/external"
+	"github.com/jackc/pgx/v5"
+	"github.com/ner-studio/api/internal/imaging"
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+	"github.com/ner-studio/api/internal/storage"
 )
 
-// UploadService handles file uploads to R2
+// imageTypesByExt maps the file extensions accepted for upload to their content types
+var imageTypesByExt = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+	".gif":  "image/gif",
+}
+
+// UploadService handles file uploads to storage
 type UploadService struct {
-	r2Client *external.R2Client
+	repo       *repository.Repository
+	store      storage.BlobStore
+	maxBytes   int64
+	urlTTL     time.Duration
+	images     imaging.Options
+	renditions *RenditionService
 }
 
-// NewUploadService creates a new upload service
-func NewUploadService(r2Client *external.R2Client) *UploadService {
+// NewUploadService creates a new upload service accepting images of up to maxBytes, with
+// presigned upload URLs valid for urlTTL. Images are normalized with the given options
+// before they are used.
+func NewUploadService(repo *repository.Repository, store storage.BlobStore, maxBytes int64, urlTTL time.Duration, images imaging.Options, renditions *RenditionService) *UploadService {
 	return &UploadService{
-		r2Client: r2Client,
+		repo:       repo,
+		store:      store,
+		maxBytes:   maxBytes,
+		urlTTL:     urlTTL,
+		images:     images,
+		renditions: renditions,
 	}
 }
 
 // UploadResult contains upload response
 type UploadResult struct {
-	URL      string `json:"url"`
-	Key      string `json:"key"`
-	Filename string `json:"filename"`
+	ID         uuid.UUID         `json:"id"`
+	URL        string            `json:"url"`
+	Key        string            `json:"key"`
+	Filename   string            `json:"filename"`
+	Renditions map[string]string `json:"renditions"`
 }
 
-// UploadImage uploads an image to R2
-func (s *UploadService) UploadImage(ctx context.Context, orgID string, folder string, filename string, data io.Reader, size int64) (*UploadResult, error) {
+// UploadImage normalizes an image sent through the API, stores it and registers it as a
+// completed upload
+func (s *UploadService) UploadImage(ctx context.Context, orgID, userID uuid.UUID, folder string, filename string, data io.Reader, size int64) (*UploadResult, error) {
 	// Validate file type
 	ext := strings.ToLower(filepath.Ext(filename))
-	validExts := map[string]string{
-		".jpg":  "image/jpeg",
-		".jpeg": "image/jpeg",
-		".png":  "image/png",
-		".webp": "image/webp",
-		".gif":  "image/gif",
+	contentType, valid := imageTypesByExt[ext]
+	if !valid {
+		return nil, invalidf("invalid file type: %s (allowed: jpg, png, webp, gif)", ext)
 	}
 
-	contentType, valid := validExts[ext]
-	if !valid {
-		return nil, fmt.Errorf("invalid file type: %s (allowed: jpg, png, webp, gif)", ext)
+	body, err := io.ReadAll(io.LimitReader(data, s.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(body)) > s.maxBytes {
+		return nil, invalidf("file too large (max %d bytes)", s.maxBytes)
+	}
+	if sniffed := sniffImageType(body); sniffed != contentType {
+		return nil, invalidf("file content does not match its %s extension", ext)
+	}
+	img, err := s.normalize(body)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate unique key
-	key := external.GenerateKey(orgID, folder, filename)
+	key := storage.GenerateKey(orgID.String(), folder, normalizedFilename(filename, img.Format))
 
-	// Upload to R2
-	url, err := s.r2Client.Upload(ctx, key, data, contentType)
-	if err != nil {
+	// Upload to storage
+	if _, err := storage.PutBytes(ctx, s.store, key, img.Data, img.ContentType); err != nil {
 		return nil, fmt.Errorf("failed to upload: %w", err)
 	}
 
+	upload := &model.Upload{
+		OrganizationID: orgID,
+		UserID:         &userID,
+		Key:            key,
+		Folder:         folder,
+		Filename:       filename,
+		ContentType:    img.ContentType,
+		SizeBytes:      int64(len(img.Data)),
+		Status:         "completed",
+		ExpiresAt:      time.Now(),
+		Width:          img.Width,
+		Height:         img.Height,
+		Format:         img.Format,
+		PHash:          img.PHash,
+	}
+	if err := s.repo.CreateUpload(ctx, upload); err != nil {
+		return nil, fmt.Errorf("failed to register upload: %w", err)
+	}
+	if err := s.renditions.RenderUpload(ctx, upload, img.Data); err != nil {
+		log.Printf("Failed to render upload %s: %v", upload.ID, err)
+	}
+
+	s.withURLs(ctx, upload)
 	return &UploadResult{
-		URL:      url,
-		Key:      key,
-		Filename: filename,
+		ID:         upload.ID,
+		URL:        upload.URL,
+		Key:        key,
+		Filename:   filename,
+		Renditions: upload.Renditions,
 	}, nil
 }
 
+// PresignUploadRequest describes an image a client is about to upload
+type PresignUploadRequest struct {
+	OrganizationID uuid.UUID
+	UserID         uuid.UUID
+	Folder         string
+	Filename       string
+	ContentType    string
+	Size           int64
+}
+
+// PresignedUpload tells a client where and how to PUT an image
+type PresignedUpload struct {
+	Upload    *model.Upload     `json:"upload"`
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// PresignUpload reserves a key under the organization's prefix and returns a URL the
+// client uploads the image to directly. The size and content type are part of the
+// signature; the upload is usable once CompleteUpload has checked the stored object.
+func (s *UploadService) PresignUpload(ctx context.Context, req PresignUploadRequest) (*PresignedUpload, error) {
+	folder := req.Folder
+	if folder == "" {
+		folder = "uploads"
+	}
+	if folder != "references" && folder != "products" && folder != "uploads" {
+		return nil, invalidf("folder must be references, products or uploads")
+	}
+	filename := filepath.Base(strings.TrimSpace(req.Filename))
+	if filename == "" || filename == "." || filename == "/" {
+		return nil, invalidf("filename is required")
+	}
+	if !isAllowedImageType(req.ContentType) {
+		return nil, invalidf("content_type must be image/jpeg, image/png, image/webp or image/gif")
+	}
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" && imageTypesByExt[ext] != req.ContentType {
+		return nil, invalidf("the %s extension does not match content_type %s", ext, req.ContentType)
+	}
+	if req.Size <= 0 || req.Size > s.maxBytes {
+		return nil, invalidf("size must be between 1 and %d bytes", s.maxBytes)
+	}
+
+	key := storage.GenerateKey(req.OrganizationID.String(), folder, sanitizeFilename(filename))
+	uploadURL, err := s.store.PresignPut(ctx, key, req.ContentType, req.Size, s.urlTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &model.Upload{
+		OrganizationID: req.OrganizationID,
+		UserID:         &req.UserID,
+		Key:            key,
+		Folder:         folder,
+		Filename:       filename,
+		ContentType:    req.ContentType,
+		SizeBytes:      req.Size,
+		ExpiresAt:      time.Now().Add(s.urlTTL),
+	}
+	if err := s.repo.CreateUpload(ctx, upload); err != nil {
+		return nil, fmt.Errorf("failed to register upload: %w", err)
+	}
+
+	return &PresignedUpload{
+		Upload:    upload,
+		UploadURL: uploadURL,
+		Method:    "PUT",
+		Headers:   map[string]string{"Content-Type": req.ContentType},
+		ExpiresAt: upload.ExpiresAt,
+	}, nil
+}
+
+// CompleteUpload checks the object a client uploaded with a presigned URL: it must exist,
+// have the announced size, start with the magic bytes of the announced image type and
+// decode within the pixel limits. A mismatching object is deleted. The normalized image is
+// stored under a new key, so the presigned URL cannot replace it. Completing an upload
+// twice returns it unchanged.
+func (s *UploadService) CompleteUpload(ctx context.Context, orgID, id uuid.UUID) (*model.Upload, error) {
+	upload, err := s.repo.GetUpload(ctx, orgID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status == "completed" {
+		return s.withURLs(ctx, upload), nil
+	}
+	// The key the presigned URL uploaded to
+	key := upload.Key
+
+	info, err := s.store.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, invalidf("the file has not been uploaded yet")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size != upload.SizeBytes {
+		return nil, s.reject(ctx, upload, fmt.Sprintf("uploaded file is %d bytes, expected %d", info.Size, upload.SizeBytes))
+	}
+
+	data, err := storage.ReadAll(ctx, s.store, key, upload.SizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	if sniffed := sniffImageType(data); sniffed != upload.ContentType {
+		return nil, s.reject(ctx, upload, fmt.Sprintf("uploaded file is not a valid %s image", upload.ContentType))
+	}
+	img, err := s.normalize(data)
+	if IsValidationError(err) {
+		return nil, s.reject(ctx, upload, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	normalizedKey := storage.GenerateKey(orgID.String(), upload.Folder, normalizedFilename(upload.Filename, img.Format))
+	if _, err := storage.PutBytes(ctx, s.store, normalizedKey, img.Data, img.ContentType); err != nil {
+		return nil, fmt.Errorf("failed to upload: %w", err)
+	}
+
+	upload.ContentType = img.ContentType
+	upload.SizeBytes = int64(len(img.Data))
+	upload.Width = img.Width
+	upload.Height = img.Height
+	upload.Format = img.Format
+	upload.PHash = img.PHash
+	applied, err := s.repo.CompleteUpload(ctx, upload, normalizedKey)
+	if err != nil || !applied {
+		if deleteErr := s.store.Delete(ctx, normalizedKey); deleteErr != nil {
+			log.Printf("Failed to delete unused upload %s: %v", normalizedKey, deleteErr)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete upload: %w", err)
+	}
+	if !applied {
+		// Completed concurrently
+		if upload, err = s.repo.GetUpload(ctx, orgID, id); err != nil {
+			return nil, err
+		}
+		return s.withURLs(ctx, upload), nil
+	}
+
+	if err := s.store.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete source of upload %s: %v", upload.ID, err)
+	}
+	if err := s.renditions.RenderUpload(ctx, upload, img.Data); err != nil {
+		log.Printf("Failed to render upload %s: %v", upload.ID, err)
+	}
+	return s.withURLs(ctx, upload), nil
+}
+
+// withURLs sets the URLs of a completed upload and its renditions
+func (s *UploadService) withURLs(ctx context.Context, upload *model.Upload) *model.Upload {
+	upload.Renditions = s.renditions.URLs(ctx, upload.Key, upload.RenditionKeys)
+	upload.URL = upload.Renditions[imaging.RenditionOriginal]
+	return upload
+}
+
+// normalize validates and re-encodes an uploaded image; images that cannot be used are
+// reported as validation errors
+func (s *UploadService) normalize(data []byte) (*imaging.Image, error) {
+	img, err := imaging.Normalize(data, s.images)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrInvalidImage) || errors.Is(err, imaging.ErrTooLarge) {
+		return nil, invalidf("%v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to process image: %w", err)
+	}
+	return img, nil
+}
+
+// normalizedFilename gives a filename the extension of the format it was stored in
+func normalizedFilename(filename, format string) string {
+	name := sanitizeFilename(filepath.Base(filename))
+	ext := ".png"
+	if format == imaging.FormatJPEG {
+		ext = ".jpg"
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+// reject deletes an upload whose object failed the checks, so its key cannot be completed
+// later, and returns the validation error to report
+func (s *UploadService) reject(ctx context.Context, upload *model.Upload, message string) error {
+	if err := s.store.Delete(ctx, upload.Key); err != nil {
+		log.Printf("Failed to delete rejected upload %s: %v", upload.Key, err)
+	}
+	if err := s.repo.DeleteUpload(ctx, upload.ID); err != nil {
+		log.Printf("Failed to delete rejected upload %s: %v", upload.ID, err)
+	}
+	return invalidf("%s", message)
+}
+
+// CleanupAbandonedUploads deletes pending uploads whose URL expired over an hour ago,
+// together with any object that was stored but never completed
+func (s *UploadService) CleanupAbandonedUploads(ctx context.Context) (int, error) {
+	uploads, err := s.repo.ListAbandonedUploads(ctx, time.Now().Add(-time.Hour), 100)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, upload := range uploads {
+		if err := s.store.Delete(ctx, upload.Key); err != nil {
+			return deleted, err
+		}
+		if err := s.repo.DeleteUpload(ctx, upload.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// isAllowedImageType reports whether images of contentType may be uploaded
+func isAllowedImageType(contentType string) bool {
+	for _, t := range imageTypesByExt {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffImageType recognizes the accepted image formats by their magic bytes and returns
+// the content type, or "" for anything else
+func sniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	}
+	return ""
+}
+
 // ValidateImageURL checks if URL is valid and from allowed domains
 func (s *UploadService) ValidateImageURL(url string) error {
 	if url == "" {
@@ -1,8 +1,15 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/ner-studio/api/internal/imaging"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -122,3 +129,88 @@ func getExt(filename string) string {
 	}
 	return ""
 }
+
+func TestSniffImageType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F'}, "image/jpeg"},
+		{"png", []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', 0, 0, 0, 0x0D}, "image/png"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"riff that is not webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ""},
+		{"html", []byte("<!DOCTYPE html><html>"), ""},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sniffImageType(tt.data))
+		})
+	}
+}
+
+func TestPresignUploadValidation(t *testing.T) {
+	// Invalid requests are rejected before storage or the database are used
+	s := NewUploadService(nil, nil, 1024, time.Minute, imaging.Options{}, nil)
+	base := PresignUploadRequest{
+		OrganizationID: uuid.New(),
+		UserID:         uuid.New(),
+		Filename:       "shoe.png",
+		ContentType:    "image/png",
+		Size:           100,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *PresignUploadRequest)
+	}{
+		{"unknown folder", func(r *PresignUploadRequest) { r.Folder = "../other-org" }},
+		{"missing filename", func(r *PresignUploadRequest) { r.Filename = " " }},
+		{"unsupported type", func(r *PresignUploadRequest) { r.ContentType = "image/svg+xml"; r.Filename = "logo.svg" }},
+		{"extension does not match type", func(r *PresignUploadRequest) { r.ContentType = "image/jpeg" }},
+		{"empty file", func(r *PresignUploadRequest) { r.Size = 0 }},
+		{"too large", func(r *PresignUploadRequest) { r.Size = 1025 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base
+			tt.modify(&req)
+			_, err := s.PresignUpload(context.Background(), req)
+			assert.True(t, IsValidationError(err), "got %v", err)
+		})
+	}
+}
+
+func TestUploadImageRejectsInvalidContent(t *testing.T) {
+	// Rejected images never reach storage or the database
+	s := NewUploadService(nil, nil, 1024, time.Minute, imaging.Options{MaxPixels: 40_000_000}, nil)
+
+	// A PNG header claiming 20000x20000 pixels
+	ihdr := []byte("\x00\x00\x00\x0dIHDR\x00\x00\x4e\x20\x00\x00\x4e\x20\x08\x06\x00\x00\x00")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, crc32.ChecksumIEEE(ihdr[4:]))
+	bomb := append([]byte("\x89PNG\r\n\x1a\n"), ihdr...)
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+	}{
+		{"renamed PDF", "shoe.png", []byte("%PDF-1.7\n1 0 obj")},
+		{"decompression bomb", "shoe.png", bomb},
+		{"truncated image", "shoe.png", bomb[:20]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.UploadImage(context.Background(), uuid.New(), uuid.New(), "products", tt.filename, bytes.NewReader(tt.data), int64(len(tt.data)))
+			assert.True(t, IsValidationError(err), "got %v", err)
+		})
+	}
+}
+
+func TestNormalizedFilename(t *testing.T) {
+	assert.Equal(t, "red_shoe.jpg", normalizedFilename("Red Shoe.JPEG", imaging.FormatJPEG))
+	assert.Equal(t, "logo.png", normalizedFilename("logo.webp", imaging.FormatPNG))
+	assert.Equal(t, "scan.png", normalizedFilename("scan", imaging.FormatPNG))
+}
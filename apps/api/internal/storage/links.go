@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Links turns object keys into the URLs clients read them from: permanent URLs for a
+// public bucket, or URLs signed for a limited time for a private one. Only keys are
+// stored, so a URL of a private object stops working once it expires or the object is
+// deleted.
+type Links struct {
+	store   BlobStore
+	private bool
+	ttl     time.Duration
+}
+
+// NewLinks creates links to objects of store; private ones are signed for ttl
+func NewLinks(store BlobStore, private bool, ttl time.Duration) *Links {
+	return &Links{
+		store:   store,
+		private: private,
+		ttl:     ttl,
+	}
+}
+
+// Private reports whether objects are only reachable through signed URLs
+func (l *Links) Private() bool {
+	return l.private
+}
+
+// URL returns the URL clients read an object from, or "" when key is empty or the URL
+// could not be signed
+func (l *Links) URL(ctx context.Context, key string) string {
+	return l.URLFor(ctx, key, l.ttl)
+}
+
+// URLFor is URL with signed URLs valid for ttl, for readers that fetch the object later
+// than a client would, such as image providers
+func (l *Links) URLFor(ctx context.Context, key string, ttl time.Duration) string {
+	if key == "" {
+		return ""
+	}
+	if !l.private {
+		return l.store.URL(key)
+	}
+	url, err := l.store.PresignGet(ctx, key, ttl)
+	if err != nil {
+		log.Printf("Failed to sign URL of %s: %v", key, err)
+		return ""
+	}
+	return url
+}
+
+// CacheControl lets clients keep an object for as long as the URL they read it from is
+// valid, without sharing it with other users of a cache
+func CacheControl(ttl time.Duration) string {
+	return fmt.Sprintf("private, max-age=%d", int(ttl.Seconds()))
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinks(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+	key := "org-1/generations/1_a.png"
+
+	public := NewLinks(store, false, time.Hour)
+	assert.Equal(t, store.URL(key), public.URL(ctx, key))
+	assert.Empty(t, public.URL(ctx, ""))
+
+	private := NewLinks(store, true, time.Hour)
+	u, err := url.Parse(private.URL(ctx, key))
+	require.NoError(t, err)
+	expires := u.Query().Get("expires")
+	assert.NotEmpty(t, expires)
+
+	u, err = url.Parse(private.URLFor(ctx, key, 24*time.Hour))
+	require.NoError(t, err)
+	assert.Greater(t, u.Query().Get("expires"), expires)
+	assert.Empty(t, private.URL(ctx, ""))
+}
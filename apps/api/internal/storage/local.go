@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalRoute is where the API serves objects of a LocalStore
+const LocalRoute = "/storage/"
+
+// Errors returned by LocalStore.Verify
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signed URL expired")
+)
+
+// LocalStore keeps objects in a directory. The API serves them at LocalRoute, behind URLs
+// signed with the store's secret.
+type LocalStore struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// NewLocalStore creates a store in dir, served under baseURL and signing its URLs with
+// secret
+func NewLocalStore(dir, baseURL, secret string) (*LocalStore, error) {
+	if dir == "" {
+		return nil, errors.New("local storage directory is required")
+	}
+	if secret == "" {
+		return nil, errors.New("local storage secret is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+// path maps a key to its file, refusing keys that would leave the directory
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes data under key and returns its URL. Readers never see a partial object.
+func (s *LocalStore) Put(ctx context.Context, key string, data io.Reader, contentType string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+	return s.URL(key), nil
+}
+
+// Get opens an object for reading. It fails with ErrNotFound when there is no object under
+// key.
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to download from storage: %w", err)
+	}
+	return f, nil
+}
+
+// Delete removes an object
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete from storage: %w", err)
+	}
+	return nil
+}
+
+// Stat returns the size and content type of an object. The content type is sniffed from
+// the data, as the store does not record it. It fails with ErrNotFound when there is no
+// object under key.
+func (s *LocalStore) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read object metadata from storage: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object metadata from storage: %w", err)
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read object metadata from storage: %w", err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  http.DetectContentType(head[:n]),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+// List returns the objects whose key starts with prefix
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return objects, nil
+}
+
+// URL returns a signed URL that reads an object. It does not expire.
+func (s *LocalStore) URL(key string) string {
+	return s.signedURL(http.MethodGet, key, url.Values{})
+}
+
+// PresignGet returns a URL that reads an object for ttl
+func (s *LocalStore) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	return s.signedURL(http.MethodGet, key, url.Values{
+		"expires": {strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)},
+	}), nil
+}
+
+// PresignPut returns a URL that lets a client PUT one object of exactly size bytes and the
+// given content type under key, valid for ttl
+func (s *LocalStore) PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	return s.signedURL(http.MethodPut, key, url.Values{
+		"expires":      {strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)},
+		"content_type": {contentType},
+		"size":         {strconv.FormatInt(size, 10)},
+	}), nil
+}
+
+// signedURL adds the signature of method, key and params to params and returns the URL
+func (s *LocalStore) signedURL(method, key string, params url.Values) string {
+	params.Set("signature", s.sign(method, key, params))
+	return s.baseURL + LocalRoute + escapeKey(key) + "?" + params.Encode()
+}
+
+// sign signs method, key and the signed params
+func (s *LocalStore) sign(method, key string, params url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, key,
+		params.Get("expires"), params.Get("content_type"), params.Get("size"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that params carry a valid, unexpired signature for method on key and
+// returns how long the URL stays valid, or 0 when it does not expire. A verified PUT still
+// has to match the signed content_type and size.
+func (s *LocalStore) Verify(method, key string, params url.Values, now time.Time) (time.Duration, error) {
+	want := s.sign(method, key, params)
+	if !hmac.Equal([]byte(params.Get("signature")), []byte(want)) {
+		return 0, ErrInvalidSignature
+	}
+	raw := params.Get("expires")
+	if raw == "" {
+		return 0, nil
+	}
+	expires, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	remaining := time.Unix(expires, 0).Sub(now)
+	if remaining <= 0 {
+		return 0, ErrExpired
+	}
+	return remaining, nil
+}
+
+// escapeKey escapes each segment of a key for use in a URL path
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8080", "secret")
+	require.NoError(t, err)
+	return store
+}
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+
+	_, err := PutBytes(ctx, store, "org-1/references/1_a.png", png, "image/png")
+	require.NoError(t, err)
+	_, err = PutBytes(ctx, store, "org-2/references/1_b.png", png, "image/png")
+	require.NoError(t, err)
+
+	data, err := ReadAll(ctx, store, "org-1/references/1_a.png", 4)
+	require.NoError(t, err)
+	assert.Equal(t, png[:4], data)
+
+	info, err := store.Stat(ctx, "org-1/references/1_a.png")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(png)), info.Size)
+	assert.Equal(t, "image/png", info.ContentType)
+
+	objects, err := store.List(ctx, "org-1/")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "org-1/references/1_a.png", objects[0].Key)
+
+	require.NoError(t, store.Delete(ctx, "org-1/references/1_a.png"))
+	require.NoError(t, store.Delete(ctx, "org-1/references/1_a.png"))
+	_, err = store.Stat(ctx, "org-1/references/1_a.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get(ctx, "org-1/references/1_a.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStoreRejectsKeysOutsideDirectory(t *testing.T) {
+	ctx := context.Background()
+	store := newLocalStore(t)
+
+	for _, key := range []string{"", "/etc/passwd", "../a.png", "org-1/../../a.png", "org-1//a.png"} {
+		_, err := PutBytes(ctx, store, key, []byte("x"), "image/png")
+		assert.Error(t, err, key)
+	}
+}
+
+func TestLocalStoreSignedURLs(t *testing.T) {
+	store := newLocalStore(t)
+	now := time.Now()
+	key := "org-1/references/1_a b.png"
+	verify := func(s *LocalStore, method, key string, params url.Values, now time.Time) error {
+		_, err := s.Verify(method, key, params, now)
+		return err
+	}
+
+	u, err := url.Parse(store.URL(key))
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:8080", u.Host)
+	assert.Equal(t, LocalRoute+key, u.Path)
+	assert.NoError(t, verify(store, http.MethodGet, key, u.Query(), now))
+	assert.ErrorIs(t, verify(store, http.MethodPut, key, u.Query(), now), ErrInvalidSignature)
+	assert.ErrorIs(t, verify(store, http.MethodGet, "org-1/references/other.png", u.Query(), now), ErrInvalidSignature)
+
+	signed, err := store.PresignPut(context.Background(), key, "image/png", 1234, 15*time.Minute)
+	require.NoError(t, err)
+	u, err = url.Parse(signed)
+	require.NoError(t, err)
+	params := u.Query()
+	assert.Equal(t, "image/png", params.Get("content_type"))
+	assert.Equal(t, "1234", params.Get("size"))
+	assert.NoError(t, verify(store, http.MethodPut, key, params, now))
+	assert.ErrorIs(t, verify(store, http.MethodPut, key, params, now.Add(time.Hour)), ErrExpired)
+
+	params.Set("size", "99999")
+	assert.ErrorIs(t, verify(store, http.MethodPut, key, params, now), ErrInvalidSignature)
+
+	other, err := NewLocalStore(t.TempDir(), "http://localhost:8080", "other-secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(other.URL(key), "http://localhost:8080"+LocalRoute))
+	assert.ErrorIs(t, verify(other, http.MethodPut, key, u.Query(), now), ErrInvalidSignature)
+}
+
+func TestLocalStorePresignGetExpires(t *testing.T) {
+	store := newLocalStore(t)
+	now := time.Now()
+	key := "org-1/generations/1_a.png"
+
+	signed, err := store.PresignGet(context.Background(), key, 10*time.Minute)
+	require.NoError(t, err)
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+
+	remaining, err := store.Verify(http.MethodGet, key, u.Query(), now)
+	require.NoError(t, err)
+	assert.InDelta(t, (10 * time.Minute).Seconds(), remaining.Seconds(), 2)
+	_, err = store.Verify(http.MethodGet, key, u.Query(), now.Add(11*time.Minute))
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// URLs of public objects do not expire
+	u, err = url.Parse(store.URL(key))
+	require.NoError(t, err)
+	remaining, err = store.Verify(http.MethodGet, key, u.Query(), now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+}
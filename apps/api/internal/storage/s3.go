@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// S3Store keeps objects in an S3-compatible bucket: AWS S3, Cloudflare R2 or MinIO
+type S3Store struct {
+	client     *s3.Client
+	presign    *s3.PresignClient
+	bucketName string
+	publicURL  string
+}
+
+// S3Config holds S3 configuration
+type S3Config struct {
+	Endpoint        string // empty for AWS S3
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Bucket          string
+	PublicURL       string
+	PathStyle       bool // address the bucket in the path, as MinIO expects
+}
+
+// NewS3Store creates a store for an S3-compatible bucket
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			cfg.AccessKeyID,
+			cfg.SecretAccessKey,
+			"")),
+		config.WithRegion(region),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+	})
+
+	return &S3Store{
+		client:     client,
+		presign:    s3.NewPresignClient(client),
+		bucketName: cfg.Bucket,
+		publicURL:  cfg.PublicURL,
+	}, nil
+}
+
+// NewR2Store creates a store for a Cloudflare R2 bucket
+func NewR2Store(accountID, accessKeyID, secretAccessKey, bucket, publicURL string) (*S3Store, error) {
+	// R2 uses S3-compatible API
+	return NewS3Store(S3Config{
+		Endpoint:        fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID),
+		Region:          "auto",
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		Bucket:          bucket,
+		PublicURL:       publicURL,
+	})
+}
+
+// Put uploads data to the bucket and returns the public URL
+func (s *S3Store) Put(ctx context.Context, key string, data io.Reader, contentType string) (string, error) {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucketName),
+		Key:         aws.String(key),
+		Body:        data,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to storage: %w", err)
+	}
+
+	return s.URL(key), nil
+}
+
+// URL returns the public URL of an object, or the key when no public URL is configured
+func (s *S3Store) URL(key string) string {
+	if s.publicURL != "" {
+		return fmt.Sprintf("%s/%s", s.publicURL, key)
+	}
+	return key
+}
+
+// PresignPut returns a URL that lets a client PUT one object of exactly size bytes and the
+// given content type under key, valid for ttl. Both are signed, so storage rejects an
+// upload that does not match.
+func (s *S3Store) PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, error) {
+	req, err := s.presign.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucketName),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign upload: %w", err)
+	}
+	return req.URL, nil
+}
+
+// PresignGet returns a URL that reads an object for ttl. Responses tell clients to cache
+// the object privately for no longer than that.
+func (s *S3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(s.bucketName),
+		Key:                  aws.String(key),
+		ResponseCacheControl: aws.String(CacheControl(ttl)),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download: %w", err)
+	}
+	return req.URL, nil
+}
+
+// Stat returns the size and content type of an object. It fails with ErrNotFound when
+// there is no object under key.
+func (s *S3Store) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read object metadata from storage: %w", err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(result.ContentLength),
+		ContentType:  aws.ToString(result.ContentType),
+		LastModified: aws.ToTime(result.LastModified),
+	}, nil
+}
+
+// Get opens an object for reading. It fails with ErrNotFound when there is no object under
+// key.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to download from storage: %w", err)
+	}
+	return result.Body, nil
+}
+
+// isNotFound reports whether the storage API answered that the object does not exist
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NotFound", "NoSuchKey":
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the objects whose key starts with prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Delete deletes an object from the bucket
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from storage: %w", err)
+	}
+	return nil
+}
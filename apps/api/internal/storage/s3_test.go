@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresignPutSignsSizeAndContentType(t *testing.T) {
+	client, err := NewR2Store("account", "key-id", "secret", "bucket", "")
+	assert.NoError(t, err)
+
+	// Presigning happens locally, without calling storage
+	signed, err := client.PresignPut(context.Background(), "org-1/references/1_a.png", "image/png", 1234, 15*time.Minute)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket.account.r2.cloudflarestorage.com", u.Host)
+	assert.Equal(t, "/org-1/references/1_a.png", u.Path)
+	assert.Equal(t, "900", u.Query().Get("X-Amz-Expires"))
+	signedHeaders := strings.Split(u.Query().Get("X-Amz-SignedHeaders"), ";")
+	assert.Contains(t, signedHeaders, "content-length")
+	assert.Contains(t, signedHeaders, "content-type")
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+}
+
+func TestURL(t *testing.T) {
+	client := &S3Store{publicURL: "https://cdn.example.com"}
+	assert.Equal(t, "https://cdn.example.com/org-1/a.png", client.URL("org-1/a.png"))
+
+	client = &S3Store{}
+	assert.Equal(t, "org-1/a.png", client.URL("org-1/a.png"))
+}
+
+func TestPresignPutUsesPathStyleEndpoint(t *testing.T) {
+	client, err := NewS3Store(S3Config{
+		Endpoint:        "http://localhost:9000",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+		Bucket:          "bucket",
+		PathStyle:       true,
+	})
+	assert.NoError(t, err)
+
+	signed, err := client.PresignPut(context.Background(), "org-1/references/1_a.png", "image/png", 1234, 15*time.Minute)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:9000", u.Host)
+	assert.Equal(t, "/bucket/org-1/references/1_a.png", u.Path)
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+}
+
+func TestPresignGetExpiresAndLimitsCaching(t *testing.T) {
+	client, err := NewR2Store("account", "key-id", "secret", "bucket", "https://cdn.example.com")
+	assert.NoError(t, err)
+
+	signed, err := client.PresignGet(context.Background(), "org-1/generations/1_a.png", 10*time.Minute)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket.account.r2.cloudflarestorage.com", u.Host)
+	assert.Equal(t, "600", u.Query().Get("X-Amz-Expires"))
+	assert.Equal(t, "private, max-age=600", u.Query().Get("response-cache-control"))
+	assert.NotEmpty(t, u.Query().Get("X-Amz-Signature"))
+}
@@ -0,0 +1,129 @@
+// Package storage keeps generated images and uploads in an object store. The store is
+// Cloudflare R2, any S3-compatible service or, for development and tests, a local
+// directory served by the API.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when there is no object under a key
+var ErrNotFound = errors.New("object not found")
+
+// Drivers
+const (
+	DriverR2    = "r2"
+	DriverS3    = "s3"
+	DriverLocal = "local"
+)
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore stores objects under keys such as "<org>/<folder>/<name>"
+type BlobStore interface {
+	// Put stores an object and returns its URL
+	Put(ctx context.Context, key string, data io.Reader, contentType string) (string, error)
+	// Get opens an object for reading. It fails with ErrNotFound when there is no object
+	// under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object; deleting a missing object succeeds
+	Delete(ctx context.Context, key string) error
+	// Stat returns an object's size and content type. It fails with ErrNotFound when there
+	// is no object under key.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// PresignPut returns a URL that lets a client PUT one object of exactly size bytes and
+	// the given content type under key, valid for ttl. Both are signed, so an upload that
+	// does not match is rejected.
+	PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (string, error)
+	// PresignGet returns a URL that reads an object for ttl, for buckets that are not
+	// public. Clients may cache the object for as long as the URL is valid.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// URL returns the permanent address clients read an object from when the bucket is
+	// public
+	URL(key string) string
+}
+
+// Config selects and configures the store
+type Config struct {
+	Driver string // r2, s3 or local
+
+	// r2 and s3
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string // base URL objects are served from; empty to use keys as URLs
+
+	// Private keeps objects out of public reach: clients get URLs signed for URLTTL
+	// instead of permanent ones
+	Private bool
+	URLTTL  time.Duration
+
+	// r2
+	R2AccountID string
+
+	// s3: an empty endpoint is AWS; MinIO needs path-style addressing
+	Endpoint  string
+	Region    string
+	PathStyle bool
+
+	// local
+	LocalDir     string
+	LocalBaseURL string // where the API serves LocalRoute
+	LocalSecret  string // signs local URLs
+}
+
+// New creates the store selected by cfg.Driver
+func New(cfg Config) (BlobStore, error) {
+	switch cfg.Driver {
+	case DriverR2, "":
+		return NewR2Store(cfg.R2AccountID, cfg.AccessKeyID, cfg.SecretAccessKey, cfg.Bucket, cfg.PublicURL)
+	case DriverS3:
+		return NewS3Store(S3Config{
+			Endpoint:        cfg.Endpoint,
+			Region:          cfg.Region,
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Bucket:          cfg.Bucket,
+			PublicURL:       cfg.PublicURL,
+			PathStyle:       cfg.PathStyle,
+		})
+	case DriverLocal:
+		return NewLocalStore(cfg.LocalDir, cfg.LocalBaseURL, cfg.LocalSecret)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q (use r2, s3 or local)", cfg.Driver)
+	}
+}
+
+// PutBytes stores data under key and returns its URL
+func PutBytes(ctx context.Context, store BlobStore, key string, data []byte, contentType string) (string, error) {
+	return store.Put(ctx, key, bytes.NewReader(data), contentType)
+}
+
+// ReadAll returns up to the first limit bytes of an object
+func ReadAll(ctx context.Context, store BlobStore, key string, limit int64) ([]byte, error) {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(io.LimitReader(body, limit))
+}
+
+// GenerateKey generates a unique key for storage
+func GenerateKey(orgID string, folder string, filename string) string {
+	timestamp := time.Now().UnixNano()
+	return fmt.Sprintf("%s/%s/%d_%s", orgID, folder, timestamp, filename)
+}
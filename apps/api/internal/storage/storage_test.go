@@ -1,4 +1,4 @@
-package external
+package storage
 
 import (
 	"strings"
@@ -9,10 +9,10 @@ import (
 
 func TestGenerateKey(t *testing.T) {
 	tests := []struct {
-		name     string
-		orgID    string
-		folder   string
-		filename string
+		name       string
+		orgID      string
+		folder     string
+		filename   string
 		wantPrefix string
 	}{
 		{
@@ -34,7 +34,7 @@ func TestGenerateKey(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			key := GenerateKey(tt.orgID, tt.folder, tt.filename)
-			
+
 			assert.True(t, strings.HasPrefix(key, tt.wantPrefix))
 			assert.True(t, strings.HasSuffix(key, tt.filename))
 			assert.Contains(t, key, "_") // Should have timestamp separator
@@ -42,4 +42,7 @@ func TestGenerateKey(t *testing.T) {
 	}
 }
 
-
+func TestNewRejectsUnknownDriver(t *testing.T) {
+	_, err := New(Config{Driver: "ftp"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ner-studio/api/internal/model"
+	"github.com/ner-studio/api/internal/repository"
+)
+
+// JobHandler executes generation jobs claimed from the queue
+type JobHandler interface {
+	// ProcessJob runs (or resumes) the workflow for a claimed job
+	ProcessJob(ctx context.Context, job *model.GenerationJob) error
+	// FailJob is called once a job has exhausted its retries
+	FailJob(ctx context.Context, job *model.GenerationJob, err error)
+}
+
+// Config holds worker pool settings
+type Config struct {
+	WorkerID     string
+	Concurrency  int
+	PollInterval time.Duration
+	LeaseTimeout time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+// Pool runs generation jobs from the Postgres-backed queue
+type Pool struct {
+	repo    *repository.Repository
+	handler JobHandler
+	cfg     Config
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPool creates a new worker pool
+func NewPool(repo *repository.Repository, handler JobHandler, cfg Config) *Pool {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.LeaseTimeout <= 0 {
+		cfg.LeaseTimeout = 10 * time.Minute
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = 5 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+	return &Pool{
+		repo:    repo,
+		handler: handler,
+		cfg:     cfg,
+	}
+}
+
+// Start recovers stuck jobs and launches the workers
+func (p *Pool) Start(ctx context.Context) {
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	// Anything still locked by one of this worker's slots was interrupted by a restart
+	lockIDs := make([]string, p.cfg.Concurrency)
+	for i := range lockIDs {
+		lockIDs[i] = fmt.Sprintf("%s-%d", p.cfg.WorkerID, i)
+	}
+	p.recover(ctx, lockIDs)
+	if n, err := p.repo.EnqueueOrphanedGenerations(ctx); err != nil {
+		log.Printf("Failed to enqueue orphaned generations: %v", err)
+	} else if n > 0 {
+		log.Printf("Enqueued %d orphaned generations", n)
+	}
+
+	for _, lockID := range lockIDs {
+		p.wg.Add(1)
+		go p.run(ctx, lockID)
+	}
+
+	p.wg.Add(1)
+	go p.recoverLoop(ctx)
+
+	log.Printf("Started %d generation workers", p.cfg.Concurrency)
+}
+
+// Stop signals the workers to exit and waits for in-flight jobs to return
+func (p *Pool) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+}
+
+func (p *Pool) run(ctx context.Context, lockID string) {
+	defer p.wg.Done()
+
+	for {
+		job, err := p.repo.ClaimGenerationJob(ctx, lockID)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim generation job: %v", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.cfg.PollInterval):
+			}
+			continue
+		}
+
+		p.execute(ctx, job)
+	}
+}
+
+func (p *Pool) execute(ctx context.Context, job *model.GenerationJob) {
+	// Never run past the lease, otherwise another worker may recover the job concurrently
+	jobCtx, cancel := context.WithTimeout(ctx, p.cfg.LeaseTimeout)
+	err := p.handler.ProcessJob(jobCtx, job)
+	cancel()
+
+	// Use a fresh context so results are recorded even while shutting down
+	bgCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Every update is refused once the lease was lost to recovery; the worker that
+	// claimed the job since then owns its outcome
+	if err == nil {
+		completed, err := p.repo.CompleteGenerationJob(bgCtx, job.ID, job.LockedBy)
+		if err != nil {
+			log.Printf("Failed to complete job %s: %v", job.ID, err)
+		} else if !completed {
+			log.Printf("Generation job %s finished after its lease was lost", job.ID)
+		}
+		return
+	}
+
+	if !IsPermanent(err) && job.Attempts < job.MaxAttempts {
+		delay := Backoff(job.Attempts, p.cfg.BaseBackoff, p.cfg.MaxBackoff)
+		log.Printf("Generation job %s failed (attempt %d/%d), retrying in %s: %v",
+			job.ID, job.Attempts, job.MaxAttempts, delay, err)
+		if _, err := p.repo.RetryGenerationJob(bgCtx, job.ID, job.LockedBy, time.Now().Add(delay), err.Error()); err != nil {
+			log.Printf("Failed to reschedule job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Generation job %s failed permanently: %v", job.ID, err)
+	failed, failErr := p.repo.FailGenerationJob(bgCtx, job.ID, job.LockedBy, err.Error())
+	if failErr != nil {
+		log.Printf("Failed to mark job %s failed: %v", job.ID, failErr)
+	}
+	if !failed {
+		return
+	}
+	p.handler.FailJob(bgCtx, job, err)
+}
+
+func (p *Pool) recoverLoop(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.LeaseTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.recover(ctx, nil)
+		}
+	}
+}
+
+func (p *Pool) recover(ctx context.Context, lockIDs []string) {
+	n, err := p.repo.RecoverStaleGenerationJobs(ctx, p.cfg.LeaseTimeout, lockIDs)
+	if err != nil {
+		log.Printf("Failed to recover stale generation jobs: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Recovered %d stale generation jobs", n)
+	}
+}
+
+// Backoff returns the exponential retry delay for the given attempt number (1-based)
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the pool fails the job without further retries
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base := 5 * time.Second
+	max := time.Minute
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 5 * time.Second},
+		{attempt: 1, expected: 5 * time.Second},
+		{attempt: 2, expected: 10 * time.Second},
+		{attempt: 3, expected: 20 * time.Second},
+		{attempt: 4, expected: 40 * time.Second},
+		{attempt: 5, expected: time.Minute},
+		{attempt: 50, expected: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			assert.Equal(t, tt.expected, Backoff(tt.attempt, base, max))
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	cause := errors.New("provider not found")
+
+	err := Permanent(cause)
+	assert.True(t, IsPermanent(err))
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, cause.Error(), err.Error())
+
+	// Wrapping keeps the marker
+	wrapped := fmt.Errorf("submit failed: %w", err)
+	assert.True(t, IsPermanent(wrapped))
+
+	assert.False(t, IsPermanent(cause))
+	assert.Nil(t, Permanent(nil))
+}
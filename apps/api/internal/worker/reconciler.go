@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ReconcileFunc performs one reconciliation pass and reports how many items it finished
+type ReconcileFunc func(ctx context.Context) (int, error)
+
+// Reconciler runs a ReconcileFunc periodically in the background
+type Reconciler struct {
+	name     string
+	fn       ReconcileFunc
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReconciler creates a reconciler that calls fn every interval
+func NewReconciler(name string, fn ReconcileFunc, interval time.Duration) *Reconciler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Reconciler{
+		name:     name,
+		fn:       fn,
+		interval: interval,
+	}
+}
+
+// Start launches the background loop
+func (r *Reconciler) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := r.fn(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("%s failed: %v", r.name, err)
+				} else if n > 0 {
+					log.Printf("%s finished %d items", r.name, n)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the loop and waits for the current pass to return
+func (r *Reconciler) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
@@ -123,17 +123,17 @@ export const adminApi = {
     config?: Record<string, any>;
   }) => api.post('/api/v1/admin/providers', data),
 
-  updateProvider: (id: string, data: Partial<{
+  updateProvider: (slug: string, data: Partial<{
     name: string;
     api_key?: string;
     model?: string;
     cost_per_use: number;
     is_active: boolean;
     config?: Record<string, any>;
-  }>) => api.patch(`/api/v1/admin/providers/${id}`, data),
+  }>) => api.patch(`/api/v1/admin/providers/${slug}`, data),
 
-  deleteProvider: (id: string) =>
-    api.delete(`/api/v1/admin/providers/${id}`),
+  deleteProvider: (slug: string) =>
+    api.delete(`/api/v1/admin/providers/${slug}`),
 
   testProvider: (slug: string) =>
     api.post(`/api/v1/admin/providers/${slug}/test`),
@@ -46,7 +46,7 @@ export function Providers() {
       };
 
       if (editingProvider) {
-        await adminApi.updateProvider(editingProvider.id, data);
+        await adminApi.updateProvider(editingProvider.slug, data);
         toast.success('Provider updated');
       } else {
         await adminApi.createProvider(data);
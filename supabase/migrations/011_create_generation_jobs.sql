@@ -0,0 +1,32 @@
+-- Create generation_jobs table (durable work queue for the generation workflow)
+CREATE TABLE generation_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    generation_id UUID NOT NULL UNIQUE REFERENCES generations(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    stage TEXT NOT NULL DEFAULT 'vision_analysis' CHECK (stage IN ('vision_analysis', 'prompt_generation', 'submission', 'done')),
+    state JSONB NOT NULL DEFAULT '{}',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_by TEXT,
+    locked_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Partial index used by workers when claiming the next job
+CREATE INDEX idx_generation_jobs_claim ON generation_jobs(run_at) WHERE status = 'queued';
+CREATE INDEX idx_generation_jobs_running ON generation_jobs(locked_at) WHERE status = 'running';
+
+-- Create trigger for updated_at
+CREATE TRIGGER update_generation_jobs_updated_at
+    BEFORE UPDATE ON generation_jobs
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Enqueue generations that were orphaned before the queue existed
+INSERT INTO generation_jobs (generation_id)
+SELECT id FROM generations
+WHERE status IN ('pending', 'processing')
+ON CONFLICT (generation_id) DO NOTHING;
@@ -0,0 +1,5 @@
+-- Record metadata of generated images persisted to R2
+ALTER TABLE generation_images
+    ADD COLUMN content_type TEXT,
+    ADD COLUMN size_bytes BIGINT,
+    ADD COLUMN checksum_sha256 TEXT;
@@ -0,0 +1,10 @@
+-- Track provider task submission so lost callbacks can be reconciled by polling
+ALTER TABLE generation_images
+    ADD COLUMN provider_slug TEXT,
+    ADD COLUMN submitted_at TIMESTAMPTZ,
+    ADD COLUMN last_polled_at TIMESTAMPTZ;
+
+-- Used by the reconciler to find submitted images still waiting for a callback
+CREATE INDEX idx_generation_images_awaiting_callback
+    ON generation_images(submitted_at)
+    WHERE status = 'processing';
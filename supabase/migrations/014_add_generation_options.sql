@@ -0,0 +1,21 @@
+-- Per-generation image options (aspect ratio, quality, seed, negative prompt)
+ALTER TABLE generations
+    ADD COLUMN options JSONB NOT NULL DEFAULT '{}';
+
+-- Declare image generation capabilities on the seeded providers
+UPDATE providers
+SET config = config || '{
+    "aspect_ratios": ["1:1", "4:3", "3:4", "16:9", "9:16"],
+    "qualities": ["standard", "hd"],
+    "supports_seed": true,
+    "supports_negative_prompt": true
+}'::jsonb
+WHERE slug = 'kieai-seedream';
+
+UPDATE providers
+SET config = config || '{
+    "aspect_ratios": ["1:1", "4:3", "3:4", "16:9", "9:16"],
+    "supports_seed": false,
+    "supports_negative_prompt": false
+}'::jsonb
+WHERE slug = 'kieai-nano';
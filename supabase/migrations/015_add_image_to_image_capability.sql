@@ -0,0 +1,8 @@
+-- Declare which image models accept product images as input (image-to-image)
+UPDATE providers
+SET config = config || '{"supports_image_to_image": true, "max_input_images": 10}'::jsonb
+WHERE slug = 'kieai-seedream';
+
+UPDATE providers
+SET config = config || '{"supports_image_to_image": true, "max_input_images": 5}'::jsonb
+WHERE slug = 'kieai-nano';
@@ -0,0 +1,10 @@
+-- Select the client implementation for a provider by driver instead of by slug
+ALTER TABLE providers
+    ADD COLUMN driver TEXT NOT NULL DEFAULT '';
+
+UPDATE providers SET driver = 'kieai' WHERE slug LIKE 'kieai-%';
+UPDATE providers SET driver = 'gemini' WHERE slug = 'google-gemini';
+UPDATE providers SET driver = 'openai' WHERE slug = 'openai-gpt4o';
+
+ALTER TABLE providers
+    ALTER COLUMN driver DROP DEFAULT;
@@ -0,0 +1,26 @@
+-- Store only a SHA-256 hash of invitation tokens and track revocation/acceptance
+ALTER TABLE invitations RENAME COLUMN token TO token_hash;
+
+UPDATE invitations SET token_hash = encode(sha256(token_hash::bytea), 'hex');
+
+ALTER INDEX idx_invitations_token RENAME TO idx_invitations_token_hash;
+
+ALTER TABLE invitations
+    ADD COLUMN revoked_at TIMESTAMPTZ,
+    ADD COLUMN accepted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+-- At most one open invitation per email and organization
+UPDATE invitations i
+SET revoked_at = NOW()
+WHERE used_at IS NULL
+  AND EXISTS (
+      SELECT 1 FROM invitations newer
+      WHERE newer.organization_id = i.organization_id
+        AND lower(newer.email) = lower(i.email)
+        AND newer.used_at IS NULL
+        AND newer.created_at > i.created_at
+  );
+
+CREATE UNIQUE INDEX idx_invitations_open_email
+    ON invitations(organization_id, lower(email))
+    WHERE used_at IS NULL AND revoked_at IS NULL;
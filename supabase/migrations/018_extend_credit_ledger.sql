@@ -0,0 +1,22 @@
+-- Admin top-ups, system entries and running balances on the credit ledger
+ALTER TABLE credit_ledger DROP CONSTRAINT credit_ledger_type_check;
+ALTER TABLE credit_ledger
+    ADD CONSTRAINT credit_ledger_type_check
+    CHECK (type IN ('generation', 'refund', 'purchase', 'adjustment', 'topup'));
+
+-- System entries (e.g. opening balances) have no acting user
+ALTER TABLE credit_ledger ALTER COLUMN user_id DROP NOT NULL;
+
+ALTER TABLE credit_ledger ADD COLUMN balance_after BIGINT;
+
+CREATE INDEX idx_credit_ledger_org_created_at ON credit_ledger(organization_id, created_at DESC);
+CREATE INDEX idx_credit_ledger_generation_id ON credit_ledger(generation_id) WHERE generation_id IS NOT NULL;
+
+-- Record balances that were set outside the ledger so credits always equal the ledger sum
+INSERT INTO credit_ledger (organization_id, user_id, amount, type, description, balance_after)
+SELECT o.id, NULL, o.credits - COALESCE(l.total, 0), 'adjustment', 'Opening balance', o.credits
+FROM organizations o
+LEFT JOIN (
+    SELECT organization_id, SUM(amount) AS total FROM credit_ledger GROUP BY organization_id
+) l ON l.organization_id = o.id
+WHERE o.credits <> COALESCE(l.total, 0);
@@ -0,0 +1,23 @@
+-- Reserve credits when a generation is submitted and settle them when it finishes
+ALTER TABLE credit_ledger DROP CONSTRAINT credit_ledger_type_check;
+ALTER TABLE credit_ledger
+    ADD CONSTRAINT credit_ledger_type_check
+    CHECK (type IN ('generation', 'refund', 'purchase', 'adjustment', 'topup', 'hold', 'release'));
+
+-- One hold per generation. organizations.credits is the available balance, so held credits
+-- have already been taken out of it and are returned by a 'release' entry on settlement.
+CREATE TABLE credit_holds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    generation_id UUID NOT NULL UNIQUE REFERENCES generations(id) ON DELETE CASCADE,
+    user_id UUID,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    status TEXT NOT NULL DEFAULT 'held' CHECK (status IN ('held', 'settled', 'released', 'expired')),
+    settled_amount BIGINT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_credit_holds_org_held ON credit_holds(organization_id) WHERE status = 'held';
+CREATE INDEX idx_credit_holds_expires_at ON credit_holds(expires_at) WHERE status = 'held';
@@ -0,0 +1,21 @@
+-- Each submitted image gets a secret callback token; only its SHA-256 is stored
+ALTER TABLE generation_images ADD COLUMN callback_token_hash TEXT;
+CREATE UNIQUE INDEX idx_generation_images_callback_token_hash
+    ON generation_images(callback_token_hash) WHERE callback_token_hash IS NOT NULL;
+
+-- Raw provider callbacks, one row per provider task. Replays of a processed task are
+-- counted but not applied again.
+CREATE TABLE callback_inbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider_slug TEXT NOT NULL,
+    task_id TEXT NOT NULL,
+    image_id UUID REFERENCES generation_images(id) ON DELETE SET NULL,
+    payload BYTEA NOT NULL,
+    status TEXT NOT NULL DEFAULT 'received' CHECK (status IN ('received', 'processed', 'failed')),
+    error_message TEXT,
+    deliveries INTEGER NOT NULL DEFAULT 1,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMPTZ,
+    UNIQUE (provider_slug, task_id)
+);
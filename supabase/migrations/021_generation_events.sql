@@ -0,0 +1,24 @@
+-- Progress events for a generation, streamed to clients over SSE. The id doubles as the
+-- SSE event id, so clients resume with Last-Event-ID.
+CREATE TABLE generation_events (
+    id BIGSERIAL PRIMARY KEY,
+    generation_id UUID NOT NULL REFERENCES generations(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    image_id UUID,
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_generation_events_generation_id ON generation_events(generation_id, id);
+
+-- Wake up API instances streaming this generation; the payload is "<generation_id>:<event_id>"
+CREATE OR REPLACE FUNCTION notify_generation_event() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('generation_events', NEW.generation_id::text || ':' || NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER generation_events_notify
+    AFTER INSERT ON generation_events
+    FOR EACH ROW EXECUTE FUNCTION notify_generation_event();
@@ -0,0 +1,10 @@
+-- Keyset pagination on (created_at, id), per organization and per user
+CREATE INDEX idx_generations_org_created_id ON generations(organization_id, created_at DESC, id DESC);
+CREATE INDEX idx_generations_org_user_created_id ON generations(organization_id, user_id, created_at DESC, id DESC);
+
+-- Prompt text search (ILIKE)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_generations_base_prompt_trgm ON generations USING GIN (base_prompt gin_trgm_ops);
+
+-- Thumbnails of completed images
+CREATE INDEX idx_generation_images_completed ON generation_images(generation_id, created_at) WHERE status = 'completed';
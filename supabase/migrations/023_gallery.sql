@@ -0,0 +1,21 @@
+-- Gallery: per-user favorites, per-image tags and soft deletion of images whose objects were removed
+ALTER TABLE generation_images ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE TABLE image_favorites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    image_id UUID NOT NULL REFERENCES generation_images(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, image_id)
+);
+
+CREATE TABLE image_tags (
+    image_id UUID NOT NULL REFERENCES generation_images(id) ON DELETE CASCADE,
+    tag TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (image_id, tag)
+);
+
+CREATE INDEX idx_image_tags_tag ON image_tags(tag);
+CREATE INDEX idx_generation_images_gallery ON generation_images(created_at DESC, id DESC)
+    WHERE status = 'completed' AND deleted_at IS NULL;
+CREATE INDEX idx_generation_images_prompt_trgm ON generation_images USING GIN (prompt gin_trgm_ops);
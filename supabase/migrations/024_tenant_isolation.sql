@@ -0,0 +1,77 @@
+-- Tenant isolation policies
+-- The API sets app.current_org_id per transaction for requests made on behalf of a
+-- user (see repository.WithOrganization). These policies restrict such transactions to
+-- the organization's rows as defense in depth; the queries filter by organization too.
+-- Work without an organization scope (workers, provider callbacks, migrations) sees every row.
+-- Note: superusers and roles with BYPASSRLS are never subject to these policies.
+
+CREATE OR REPLACE FUNCTION app_current_org_id() RETURNS UUID
+LANGUAGE sql STABLE AS $$
+    SELECT NULLIF(current_setting('app.current_org_id', true), '')::uuid
+$$;
+
+-- Tables carrying organization_id
+ALTER TABLE generations FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON generations
+    USING (app_current_org_id() IS NULL OR organization_id = app_current_org_id())
+    WITH CHECK (app_current_org_id() IS NULL OR organization_id = app_current_org_id());
+
+ALTER TABLE credit_ledger FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON credit_ledger
+    USING (app_current_org_id() IS NULL OR organization_id = app_current_org_id())
+    WITH CHECK (app_current_org_id() IS NULL OR organization_id = app_current_org_id());
+
+ALTER TABLE credit_holds ENABLE ROW LEVEL SECURITY;
+ALTER TABLE credit_holds FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON credit_holds
+    USING (app_current_org_id() IS NULL OR organization_id = app_current_org_id())
+    WITH CHECK (app_current_org_id() IS NULL OR organization_id = app_current_org_id());
+
+-- Tables owned through their generation
+ALTER TABLE generation_images FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON generation_images
+    USING (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_images.generation_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_images.generation_id AND g.organization_id = app_current_org_id()
+    ));
+
+ALTER TABLE generation_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE generation_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON generation_events
+    USING (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_events.generation_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_events.generation_id AND g.organization_id = app_current_org_id()
+    ));
+
+-- Tables owned through their image
+ALTER TABLE image_tags ENABLE ROW LEVEL SECURITY;
+ALTER TABLE image_tags FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON image_tags
+    USING (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_tags.image_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_tags.image_id AND g.organization_id = app_current_org_id()
+    ));
+
+ALTER TABLE image_favorites ENABLE ROW LEVEL SECURITY;
+ALTER TABLE image_favorites FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON image_favorites
+    USING (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_favorites.image_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_current_org_id() IS NULL OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_favorites.image_id AND g.organization_id = app_current_org_id()
+    ));
@@ -0,0 +1,28 @@
+-- Sign-in sessions backing long-lived refresh tokens
+-- Each refresh rotates the session's token; presenting a token that was already rotated
+-- revokes the whole session, since it means the token was copied.
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The organization the session acts in; claims are reloaded from its membership on refresh
+    organization_id UUID REFERENCES organizations(id) ON DELETE SET NULL,
+    user_agent TEXT,
+    ip_address TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    revoked_reason TEXT
+);
+
+CREATE INDEX idx_sessions_user_active ON sessions(user_id) WHERE revoked_at IS NULL;
+
+-- Every refresh token issued for a session; only the hash is stored
+CREATE TABLE session_tokens (
+    token_hash TEXT PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_session_tokens_session ON session_tokens(session_id);
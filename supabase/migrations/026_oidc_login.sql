@@ -0,0 +1,30 @@
+-- Sign-in with OpenID Connect providers such as Google
+
+-- External identities linked to users; users who only sign in with a provider have an
+-- empty password_hash, which never matches a password
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities(user_id);
+
+-- Logins in progress, keyed by the hash of the state parameter. Each holds the nonce and
+-- PKCE verifier of the authorization request and is consumed by the callback.
+CREATE TABLE oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    invite_token_hash TEXT,
+    org_name TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_oidc_login_states_expires ON oidc_login_states(expires_at);
@@ -0,0 +1,33 @@
+-- Usage limits per organization
+-- A NULL column falls back to the server default (RATE_LIMIT_* settings); 0 means unlimited.
+-- Limits are set by operators, e.g.
+--   INSERT INTO organization_limits (organization_id, max_images_per_day) VALUES ('…', 2000)
+--   ON CONFLICT (organization_id) DO UPDATE SET max_images_per_day = EXCLUDED.max_images_per_day;
+CREATE TABLE organization_limits (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    -- API requests per minute across all members, and per member
+    requests_per_minute INTEGER CHECK (requests_per_minute >= 0),
+    user_requests_per_minute INTEGER CHECK (user_requests_per_minute >= 0),
+    -- Generations pending or processing at the same time
+    max_concurrent_generations INTEGER CHECK (max_concurrent_generations >= 0),
+    -- Images requested per UTC day
+    max_images_per_day INTEGER CHECK (max_images_per_day >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Token buckets shared by all API instances. A bucket holds up to one minute's worth of
+-- requests and refills continuously; idle buckets are full and can be deleted.
+CREATE TABLE rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_rate_limit_buckets_updated ON rate_limit_buckets(updated_at);
+
+-- Number of images a generation asked for, counted against the daily limit
+ALTER TABLE generations ADD COLUMN num_images INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_generations_org_in_flight ON generations(organization_id)
+    WHERE status IN ('pending', 'processing');
@@ -0,0 +1,21 @@
+-- Responses to requests sent with an Idempotency-Key header, per organization
+-- A retry with the same key and request gets the stored response instead of running the
+-- request again; the same key with a different request is rejected.
+CREATE TABLE idempotency_keys (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    -- SHA-256 of the method, path and body of the first request
+    request_hash TEXT NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- in_progress until the first request has a response to replay
+    status TEXT NOT NULL DEFAULT 'in_progress' CHECK (status IN ('in_progress', 'completed')),
+    response_status INTEGER,
+    response_content_type TEXT,
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (organization_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
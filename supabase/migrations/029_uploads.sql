@@ -0,0 +1,33 @@
+-- Images uploaded by users, for use as reference and product images of generations
+-- Clients PUT the object straight to storage with a presigned URL, then complete the
+-- upload; only completed uploads, whose content was checked, can be used.
+CREATE TABLE uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    key TEXT NOT NULL UNIQUE,
+    folder TEXT NOT NULL CHECK (folder IN ('references', 'products', 'uploads')),
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL CHECK (size_bytes > 0),
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'completed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Until when the presigned URL of a pending upload is valid
+    expires_at TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_uploads_org_created ON uploads(organization_id, created_at DESC);
+CREATE INDEX idx_uploads_pending_expires ON uploads(expires_at) WHERE status = 'pending';
+
+ALTER TABLE uploads ENABLE ROW LEVEL SECURITY;
+ALTER TABLE uploads FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON uploads
+    USING (app_current_org_id() IS NULL OR organization_id = app_current_org_id())
+    WITH CHECK (app_current_org_id() IS NULL OR organization_id = app_current_org_id());
+
+-- Generations reference their input images by upload; the URL columns keep the address
+-- the workflow reads them from
+ALTER TABLE generations
+    ADD COLUMN reference_upload_ids UUID[] NOT NULL DEFAULT '{}',
+    ADD COLUMN product_upload_ids UUID[] NOT NULL DEFAULT '{}';
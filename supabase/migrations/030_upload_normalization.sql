@@ -0,0 +1,10 @@
+-- Uploads are decoded and re-encoded before use; the record keeps what was stored.
+-- A completed presigned upload is moved to a new key, so the presigned URL cannot replace
+-- the checked image; source_key keeps the key the client uploaded to.
+ALTER TABLE uploads
+    ADD COLUMN source_key TEXT UNIQUE,
+    ADD COLUMN width INTEGER CHECK (width > 0),
+    ADD COLUMN height INTEGER CHECK (height > 0),
+    ADD COLUMN format TEXT,
+    -- 64-bit difference hash, as 16 hex digits
+    ADD COLUMN phash TEXT;
@@ -0,0 +1,10 @@
+-- Smaller copies of stored images, stored next to the original. renditions maps each
+-- rendition name to its object key; renditions_version fingerprints the specs they were
+-- rendered with, so images rendered with older specs can be found and regenerated.
+ALTER TABLE generation_images
+    ADD COLUMN renditions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN renditions_version TEXT;
+
+ALTER TABLE uploads
+    ADD COLUMN renditions JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN renditions_version TEXT;
@@ -0,0 +1,79 @@
+-- Strict tenant isolation
+-- The policies of 024 and 029 let every row through when app.current_org_id is unset, so
+-- any session that forgot to scope itself (or a client connecting through the Data API)
+-- saw every organization. A missing organization now matches nothing; work that needs
+-- every row (workers, sweepers, provider callbacks) sets app.rls_bypass explicitly. The
+-- API turns it on for its connections and off again in transactions scoped with
+-- repository.WithOrganization.
+
+CREATE OR REPLACE FUNCTION app_rls_bypass() RETURNS BOOLEAN
+LANGUAGE sql STABLE AS $$
+    SELECT COALESCE(current_setting('app.rls_bypass', true), '') = 'on'
+$$;
+
+-- Tables carrying organization_id
+DROP POLICY tenant_isolation ON generations;
+CREATE POLICY tenant_isolation ON generations
+    USING (app_rls_bypass() OR organization_id = app_current_org_id())
+    WITH CHECK (app_rls_bypass() OR organization_id = app_current_org_id());
+
+DROP POLICY tenant_isolation ON credit_ledger;
+CREATE POLICY tenant_isolation ON credit_ledger
+    USING (app_rls_bypass() OR organization_id = app_current_org_id())
+    WITH CHECK (app_rls_bypass() OR organization_id = app_current_org_id());
+
+DROP POLICY tenant_isolation ON credit_holds;
+CREATE POLICY tenant_isolation ON credit_holds
+    USING (app_rls_bypass() OR organization_id = app_current_org_id())
+    WITH CHECK (app_rls_bypass() OR organization_id = app_current_org_id());
+
+DROP POLICY tenant_isolation ON uploads;
+CREATE POLICY tenant_isolation ON uploads
+    USING (app_rls_bypass() OR organization_id = app_current_org_id())
+    WITH CHECK (app_rls_bypass() OR organization_id = app_current_org_id());
+
+-- Tables owned through their generation
+DROP POLICY tenant_isolation ON generation_images;
+CREATE POLICY tenant_isolation ON generation_images
+    USING (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_images.generation_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_images.generation_id AND g.organization_id = app_current_org_id()
+    ));
+
+DROP POLICY tenant_isolation ON generation_events;
+CREATE POLICY tenant_isolation ON generation_events
+    USING (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_events.generation_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generations g
+        WHERE g.id = generation_events.generation_id AND g.organization_id = app_current_org_id()
+    ));
+
+-- Tables owned through their image
+DROP POLICY tenant_isolation ON image_tags;
+CREATE POLICY tenant_isolation ON image_tags
+    USING (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_tags.image_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_tags.image_id AND g.organization_id = app_current_org_id()
+    ));
+
+DROP POLICY tenant_isolation ON image_favorites;
+CREATE POLICY tenant_isolation ON image_favorites
+    USING (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_favorites.image_id AND g.organization_id = app_current_org_id()
+    ))
+    WITH CHECK (app_rls_bypass() OR EXISTS (
+        SELECT 1 FROM generation_images i JOIN generations g ON g.id = i.generation_id
+        WHERE i.id = image_favorites.image_id AND g.organization_id = app_current_org_id()
+    ));